@@ -0,0 +1,494 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with `pisim22 analyze model`, which answers questions about a
+// single model rather than comparing two: its deadlocks, i.e. the states
+// without transitions, its tau-livelocks, i.e. the cycles of tau transitions,
+// and whether an action on a channel can be reached. Each finding comes with a
+// shortest path from state 0, printed with the names of the model.
+
+type ltsAnalysis struct {
+	lts pifra.Lts
+	adj map[int][]pifra.Transition
+	// The states reachable from state 0, in the order of a breadth-first
+	// search, and the transition each of them was first reached by.
+	order  []int
+	parent map[int]pifra.Transition
+}
+
+func newLtsAnalysis(lts pifra.Lts) *ltsAnalysis {
+	a := &ltsAnalysis{lts: lts, adj: ToAdjacency(lts), parent: make(map[int]pifra.Transition)}
+	if _, ok := lts.States[0]; !ok {
+		return a
+	}
+	seen := map[int]bool{0: true}
+	a.order = []int{0}
+	for i := 0; i < len(a.order); i++ {
+		for _, trans := range a.adj[a.order[i]] {
+			if !seen[trans.Destination] {
+				seen[trans.Destination] = true
+				a.parent[trans.Destination] = trans
+				a.order = append(a.order, trans.Destination)
+			}
+		}
+	}
+	return a
+}
+
+func (a *ltsAnalysis) reachable(id int) bool {
+	_, ok := a.parent[id]
+	return ok || (id == 0 && len(a.order) > 0)
+}
+
+// A shortest path from state 0 to a reachable state.
+func (a *ltsAnalysis) pathTo(id int) []pifra.Transition {
+	var path []pifra.Transition
+	for id != 0 {
+		trans := a.parent[id]
+		path = append([]pifra.Transition{trans}, path...)
+		id = trans.Source
+	}
+	return path
+}
+
+// Whether pifra looked for the transitions of a state. It stops at
+// -max-states, and does not go on from a state whose registers are full.
+func (a *ltsAnalysis) explored(id int) bool {
+	if a.lts.RegSizeReached[id] {
+		return false
+	}
+	return a.lts.StatesExplored == 0 || a.lts.StatesExplored >= len(a.lts.States) || id < a.lts.StatesExplored
+}
+
+// The reachable states without transitions, and the ones among them that were
+// not explored, in the order of their paths.
+func (a *ltsAnalysis) deadlocks() (deadlocks []int, unexplored []int) {
+	for _, id := range a.order {
+		if len(a.adj[id]) > 0 {
+			continue
+		}
+		if a.explored(id) {
+			deadlocks = append(deadlocks, id)
+		} else {
+			unexplored = append(unexplored, id)
+		}
+	}
+	return deadlocks, unexplored
+}
+
+// A set of states that can reach each other by tau transitions, with a cycle
+// of tau transitions from its first state back to it.
+type tauLivelock struct {
+	states []int
+	cycle  []pifra.Transition
+}
+
+// The reachable tau-livelocks, by the tau closure of dfsClosure.
+func (a *ltsAnalysis) livelocks() []tauLivelock {
+	M, dict, revDict := dfsClosure(a.lts)
+	// The states of the livelock of a state, by the first of them.
+	groups := make(map[int][]int)
+	var firsts []int
+	for _, id := range a.order {
+		i := revDict[id]
+		onCycle := false
+		for _, trans := range a.adj[id] {
+			if trans.Label.Symbol.Type == pifra.SymbolTypTau && M[revDict[trans.Destination]][i] {
+				onCycle = true
+				break
+			}
+		}
+		if !onCycle {
+			continue
+		}
+		first := id
+		for j := range M[i] {
+			if M[i][j] && M[j][i] && a.reachable(dict[j]) && a.pathLess(dict[j], first) {
+				first = dict[j]
+			}
+		}
+		if _, ok := groups[first]; !ok {
+			firsts = append(firsts, first)
+		}
+		groups[first] = append(groups[first], id)
+	}
+
+	var livelocks []tauLivelock
+	for _, first := range firsts {
+		states := groups[first]
+		sort.Ints(states)
+		in := make(map[int]bool)
+		for _, id := range states {
+			in[id] = true
+		}
+		livelocks = append(livelocks, tauLivelock{states, a.tauCycle(first, in)})
+	}
+	return livelocks
+}
+
+// Whether the path to state p is shorter than the one to q, i.e. p comes
+// first in the search.
+func (a *ltsAnalysis) pathLess(p int, q int) bool {
+	return len(a.pathTo(p)) < len(a.pathTo(q)) || (len(a.pathTo(p)) == len(a.pathTo(q)) && p < q)
+}
+
+// A shortest cycle of tau transitions from a state back to it, through the
+// given states.
+func (a *ltsAnalysis) tauCycle(start int, in map[int]bool) []pifra.Transition {
+	parent := make(map[int]pifra.Transition)
+	queue := []int{start}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, trans := range a.adj[id] {
+			if trans.Label.Symbol.Type != pifra.SymbolTypTau || !in[trans.Destination] {
+				continue
+			}
+			if trans.Destination == start {
+				cycle := []pifra.Transition{trans}
+				for id != start {
+					cycle = append([]pifra.Transition{parent[id]}, cycle...)
+					id = parent[id].Source
+				}
+				return cycle
+			}
+			if _, ok := parent[trans.Destination]; !ok {
+				parent[trans.Destination] = trans
+				queue = append(queue, trans.Destination)
+			}
+		}
+	}
+	return nil
+}
+
+// A shortest path from state 0 that ends with an action on a channel, by its
+// name in the model. A name ending with ' only matches outputs. As a register
+// may hold a name of the model on one path to a state and a fresh name on
+// another, the search is over the states together with the names of the model
+// in their registers.
+func (a *ltsAnalysis) reach(channel string) ([]pifra.Transition, bool) {
+	outputOnly := strings.HasSuffix(channel, "'")
+	channel = strings.TrimSuffix(channel, "'")
+	if len(a.order) == 0 {
+		return nil, false
+	}
+	type node struct {
+		id    int
+		names *pathNames
+		path  []pifra.Transition
+	}
+	start := node{0, newPathNames(&a.lts), nil}
+	seen := map[string]bool{start.names.key(0): true}
+	queue := []node{start}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, trans := range a.adj[n.id] {
+			path := append(append([]pifra.Transition{}, n.path...), trans)
+			sym := trans.Label.Symbol
+			if sym.Type != pifra.SymbolTypTau && (!outputOnly || sym.Type == pifra.SymbolTypOutput) &&
+				n.names.name(sym.Value) == channel {
+				return path, true
+			}
+			next := n.names.clone()
+			next.step(trans)
+			if key := next.key(trans.Destination); !seen[key] {
+				seen[key] = true
+				queue = append(queue, node{trans.Destination, next, path})
+			}
+		}
+	}
+	return nil, false
+}
+
+// ####
+// Names.
+// ####
+
+// The names of the model held by the registers along a path. pifra reuses the
+// names of its registers once a name is no longer used, so that the name of a
+// register has to be followed from state 0: a known name is read from the
+// registers of the source of a transition, and a fresh name is written to the
+// registers of its destination. A fresh name is given a new name, n1, n2 and
+// so on.
+type pathNames struct {
+	lts   *pifra.Lts
+	regs  map[int]string
+	fresh int
+}
+
+func newPathNames(lts *pifra.Lts) *pathNames {
+	p := &pathNames{lts: lts, regs: make(map[int]string)}
+	for idx, name := range lts.States[0].Registers.Registers {
+		if orig, ok := lts.FreeNamesMap[name]; ok {
+			name = orig
+		}
+		p.regs[idx] = name
+	}
+	return p
+}
+
+func (p *pathNames) clone() *pathNames {
+	c := *p
+	c.regs = make(map[int]string, len(p.regs))
+	for idx, name := range p.regs {
+		c.regs[idx] = name
+	}
+	return &c
+}
+
+func (p *pathNames) name(idx int) string {
+	return p.regs[idx]
+}
+
+func (p *pathNames) freshName() string {
+	for {
+		p.fresh++
+		name := fmt.Sprintf("n%d", p.fresh)
+		if !isLtsFreeName(*p.lts, name) {
+			return name
+		}
+	}
+}
+
+// Follow a transition, and return it as a prefix with the names of the model.
+// A fresh name is marked with *.
+func (p *pathNames) step(trans pifra.Transition) string {
+	channel, object := p.follow(trans)
+	return prefixString(trans.Label.Symbol.Type, channel, []string{object})
+}
+
+// Follow the transitions of the session of a polyadic prefix (see
+// sessionTransition), and return them as one prefix with the names of the
+// model.
+func (p *pathNames) session(session []pifra.Transition) string {
+	if len(session) == 1 {
+		return p.step(session[0])
+	}
+	// The session channel is not printed, so its name is given again.
+	fresh := p.fresh
+	channel, _ := p.follow(session[0])
+	p.fresh = fresh
+	var objects []string
+	for _, trans := range session[1:] {
+		_, object := p.follow(trans)
+		objects = append(objects, object)
+	}
+	return prefixString(session[0].Label.Symbol.Type, channel, objects)
+}
+
+func prefixString(typ pifra.SymbolType, channel string, objects []string) string {
+	switch typ {
+	case pifra.SymbolTypTau:
+		return "τ"
+	case pifra.SymbolTypOutput:
+		return fmt.Sprintf("%s'<%s>", channel, strings.Join(objects, ","))
+	}
+	return fmt.Sprintf("%s(%s)", channel, strings.Join(objects, ","))
+}
+
+// Follow a transition, and return its channel and object with the names of the
+// model.
+func (p *pathNames) follow(trans pifra.Transition) (string, string) {
+	l := trans.Label
+	fresh := isFreshLabel(l)
+	var channel, name, object string
+	if l.Symbol.Type != pifra.SymbolTypTau {
+		channel = p.name(l.Symbol.Value)
+		if fresh {
+			name = p.freshName()
+			object = name + "*"
+		} else {
+			object = p.name(l.Symbol2.Value)
+		}
+	}
+	regs := make(map[int]string)
+	for idx, internal := range p.lts.States[trans.Destination].Registers.Registers {
+		if n, ok := p.regs[idx]; ok {
+			regs[idx] = n
+		} else {
+			regs[idx] = internal
+		}
+	}
+	if fresh {
+		regs[l.Symbol2.Value] = name
+	}
+	p.regs = regs
+	return channel, object
+}
+
+// The state together with the registers that hold names of the model.
+func (p *pathNames) key(id int) string {
+	var idxs []int
+	for idx, name := range p.regs {
+		if isLtsFreeName(*p.lts, name) {
+			idxs = append(idxs, idx)
+		}
+	}
+	sort.Ints(idxs)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d", id)
+	for _, idx := range idxs {
+		fmt.Fprintf(&sb, " %d:%s", idx, p.regs[idx])
+	}
+	return sb.String()
+}
+
+// Whether a name is a free name of the model.
+func isLtsFreeName(lts pifra.Lts, name string) bool {
+	for _, orig := range lts.FreeNamesMap {
+		if orig == name {
+			return true
+		}
+	}
+	return false
+}
+
+var ltsInternalNameRegexp = regexp.MustCompile(`#[0-9]+`)
+
+// The process of the state at the end of a path with the names of the model.
+func (p *pathNames) process(id int) string {
+	rename := make(map[string]string)
+	for idx, internal := range p.lts.States[id].Registers.Registers {
+		rename[internal] = p.regs[idx]
+	}
+	return ltsInternalNameRegexp.ReplaceAllStringFunc(pifra.PrettyPrintAst(p.lts.States[id].Process), func(name string) string {
+		if n, ok := rename[name]; ok {
+			return n
+		}
+		return name
+	})
+}
+
+// Follow a path, and print one step of it per line.
+func (p *pathNames) pathToString(path []pifra.Transition, indent string) string {
+	if len(path) == 0 {
+		return indent + "It is the start state.\n"
+	}
+	var sb strings.Builder
+	for i, trans := range path {
+		sb.WriteString(fmt.Sprintf("%s%d. %d -%s-> %d\n", indent, i+1, trans.Source, p.step(trans), trans.Destination))
+	}
+	return sb.String()
+}
+
+// ####
+// The command.
+// ####
+
+func plural(n int, word string) string {
+	switch {
+	case n == 1:
+		return fmt.Sprintf("%d %s", n, word)
+	case strings.HasSuffix(word, "s"):
+		return fmt.Sprintf("%d %ses", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// Print the analysis of an LTS, with the witnesses of at most limit deadlocks
+// and livelocks, or of all of them if limit is 0.
+func printLtsAnalysis(lts pifra.Lts, channels []string, limit int) {
+	a := newLtsAnalysis(lts)
+	fmt.Printf("%s and %s, %d reachable.\n", plural(len(lts.States), "state"),
+		plural(len(lts.Transitions), "transition"), len(a.order))
+	shown := func(i int) bool {
+		return limit == 0 || i < limit
+	}
+	more := func(n int) {
+		if limit != 0 && n > limit {
+			fmt.Printf("  ... and %d more.\n", n-limit)
+		}
+	}
+
+	deadlocks, unexplored := a.deadlocks()
+	fmt.Printf("\nDeadlocks: %s.\n", plural(len(deadlocks), "state"))
+	for i, id := range deadlocks {
+		if !shown(i) {
+			break
+		}
+		names := newPathNames(&lts)
+		path := names.pathToString(a.pathTo(id), "    ")
+		fmt.Printf("  State %d: %s\n%s", id, names.process(id), path)
+	}
+	more(len(deadlocks))
+	if len(unexplored) > 0 {
+		fmt.Printf("%s without transitions were not explored, because of -max-states or the register size, and are not counted.\n",
+			plural(len(unexplored), "state"))
+	}
+
+	livelocks := a.livelocks()
+	fmt.Printf("\nTau-livelocks: %d.\n", len(livelocks))
+	for i, l := range livelocks {
+		if !shown(i) {
+			break
+		}
+		names := newPathNames(&lts)
+		fmt.Printf("  States %v, reached by:\n", l.states)
+		fmt.Print(names.pathToString(a.pathTo(l.states[0]), "    "))
+		fmt.Printf("  with the cycle:\n")
+		fmt.Print(names.pathToString(l.cycle, "    "))
+	}
+	more(len(livelocks))
+
+	if len(channels) > 0 {
+		fmt.Printf("\nReachability:\n")
+	}
+	for _, channel := range channels {
+		if !isLtsFreeName(lts, strings.TrimSuffix(channel, "'")) {
+			fmt.Printf("  %s: not a free name of the model.\n", channel)
+			continue
+		}
+		path, ok := a.reach(channel)
+		if !ok {
+			fmt.Printf("  %s: not reachable.\n", channel)
+			continue
+		}
+		fmt.Printf("  %s: reachable in %s.\n", channel, plural(len(path), "step"))
+		fmt.Print(newPathNames(&lts).pathToString(path, "    "))
+	}
+}
+
+// The analyze command.
+func analyzeCommand(args []string) {
+	fs := newCommandFlagSet("analyze", "[flags] model",
+		"Find the deadlocks and the tau-livelocks of a model or an LTS file, and whether actions on channels can be reached.")
+	reachFlag := fs.String("reach", "", "Comma-separated names of channels to find an action on. A name ending with ' only matches outputs.")
+	limitFlag := fs.Int("limit", 10, "The number of deadlocks and livelocks to give paths for, or 0 for all of them.")
+	verboseFlag := fs.Bool("v", false, "Whether to be verbose.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if len(args) != 1 {
+		usageError(fs, fmt.Errorf("expected one model or LTS file as argument, not %d", len(args)))
+	}
+	if *limitFlag < 0 {
+		usageError(fs, fmt.Errorf("-limit cannot be negative, not %d", *limitFlag))
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+	verbose = *verboseFlag
+	var channels []string
+	for _, channel := range strings.Split(*reachFlag, ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "pisim22-analyze")
+	check(err)
+	defer os.RemoveAll(dir)
+	lts, _, err := loadOrGenerateLts(args[0], dir, pf.flags())
+	check(err)
+	printLtsAnalysis(lts, channels, *limitFlag)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yungene/pifra"
+)
+
+func analyzeTestLts(t *testing.T, src string, maxStates int) pifra.Lts {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "model.pi")
+	if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	flags_ := flags
+	flags_.MaxStates = maxStates
+	lts, _, err := loadOrGenerateLts(name, dir, flags_)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lts
+}
+
+func pathLabels(names *pathNames, path []pifra.Transition) string {
+	var labels []string
+	for _, trans := range path {
+		labels = append(labels, names.step(trans))
+	}
+	return strings.Join(labels, " ")
+}
+
+func TestAnalyze(t *testing.T) {
+	lts := analyzeTestLts(t, "P(a,b) = a(x).b'<x>.P(a,b) + $c.a'<c>.0 + T(a)\nT(a) = $c.(c'<a>.T(a) | c(y).0)\nP(a,b)\n", 3000)
+	a := newLtsAnalysis(lts)
+
+	deadlocks, unexplored := a.deadlocks()
+	if len(deadlocks) == 0 || len(unexplored) != 0 {
+		t.Fatalf("The deadlocks are %v and the unexplored states %v.", deadlocks, unexplored)
+	}
+	if labels := pathLabels(newPathNames(&lts), a.pathTo(deadlocks[0])); labels != "a'<n1*>" {
+		t.Errorf("The path to the first deadlock is %s.", labels)
+	}
+
+	livelocks := a.livelocks()
+	if len(livelocks) == 0 {
+		t.Fatalf("There are no tau-livelocks.")
+	}
+	names := newPathNames(&lts)
+	if labels := pathLabels(names, append(a.pathTo(livelocks[0].states[0]), livelocks[0].cycle...)); labels != "τ τ" {
+		t.Errorf("The path to the first tau-livelock and its cycle are %s.", labels)
+	}
+	for _, l := range livelocks {
+		last := l.cycle[len(l.cycle)-1]
+		if l.cycle[0].Source != l.states[0] || last.Destination != l.states[0] {
+			t.Errorf("The cycle %v of the livelock %v is not a cycle.", l.cycle, l.states)
+		}
+	}
+
+	cases := []struct {
+		channel string
+		labels  string
+	}{
+		{"a", "a(a)"},
+		{"b'", "a(a) b'<a>"},
+		{"c", ""},
+	}
+	for _, c := range cases {
+		path, ok := a.reach(c.channel)
+		if labels := pathLabels(newPathNames(&lts), path); ok != (c.labels != "") || labels != c.labels {
+			t.Errorf("The path to %s is %q, expected %q.", c.channel, labels, c.labels)
+		}
+	}
+}
+
+// pifra reuses the name of a register once it is no longer used, here #1 for
+// a and then for the fresh name sent on it.
+func TestAnalyzeNames(t *testing.T) {
+	lts := analyzeTestLts(t, "$c.a'<c>.c(x).b'<x>.0\n", 3000)
+	a := newLtsAnalysis(lts)
+	// The shortest path receives the fresh name on itself before b is used.
+	path, ok := a.reach("b")
+	names := newPathNames(&lts)
+	if labels := pathLabels(names, path); !ok || labels != "a'<n1*> n1(n1) b'<n1>" {
+		t.Errorf("The path to b is %q.", labels)
+	}
+	if p := names.process(path[len(path)-1].Destination); p != "0" {
+		t.Errorf("The process at the end of the path is %s.", p)
+	}
+	names = newPathNames(&lts)
+	names.step(path[0])
+	if p := names.process(path[0].Destination); !strings.HasPrefix(p, "n1(") {
+		t.Errorf("The process after the fresh output is %s.", p)
+	}
+}
+
+// The states pifra stopped at are not deadlocks.
+func TestAnalyzeUnexplored(t *testing.T) {
+	lts := analyzeTestLts(t, "P(a) = $c.a'<c>.(P(a) | P(c))\nP(a)\n", 3)
+	deadlocks, unexplored := newLtsAnalysis(lts).deadlocks()
+	if len(deadlocks) != 0 || len(unexplored) == 0 {
+		t.Errorf("The deadlocks are %v and the unexplored states %v, expected only unexplored ones.", deadlocks, unexplored)
+	}
+}
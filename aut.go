@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the import and export of LTSs in the Aldebaran (.aut)
+// format:
+//
+//	des (<initial state>, <number of transitions>, <number of states>)
+//	(<source>, "<label>", <destination>)
+//	...
+//
+// Two kinds of files are supported.
+//
+// A plain LTS has no registers, and its labels are action names. The internal
+// action is written as "i", and "tau" and "τ" are accepted as well. On import,
+// every action name is put into a register of its own, and every action becomes
+// an output of that register on itself. Hence the actions of the two sides of a
+// check are matched by their names, just like the free names of pi-calculus
+// models.
+//
+// A FRA LTS keeps the labels of pifra, written with register indices only:
+//
+//	1(2)    input of the known name in register 2 on the channel in register 1
+//	1(2*)   input of a fresh name into register 2
+//	1<2>    output of the known name in register 2
+//	1<2*>   output of a fresh name, stored in register 2
+//
+// The registers of the states are not part of the Aldebaran format, so that
+// other tools only see the transitions of the LTS. They are written to a
+// sidecar file <name>.aut.regs instead, with a line per state:
+//
+//	<state> 1=a 2=#3
+//
+// which lists the register indices and their names. The free names of the
+// model are written with their original names, all other names keep the names
+// given to them by pifra, which start with '#'. A .aut file is read as a FRA
+// LTS if its sidecar file exists.
+
+const autTauLabel = "i"
+
+const autExt = ".aut"
+const autRegsExt = ".regs"
+
+// Whether the file name refers to an Aldebaran file.
+func isAutFile(name string) bool {
+	return strings.HasSuffix(name, autExt)
+}
+
+// Whether the LTS can be written as a plain LTS, i.e. whether it is one that
+// was read from a plain .aut file: all the states have the same registers, and
+// every visible transition outputs a register on itself.
+func isPlainLts(lts pifra.Lts) bool {
+	regs := fmt.Sprint(lts.States[0].Registers.Registers)
+	for id := range lts.States {
+		if fmt.Sprint(lts.States[id].Registers.Registers) != regs {
+			return false
+		}
+	}
+	for _, trans := range lts.Transitions {
+		l := trans.Label
+		if l.Symbol.Type == pifra.SymbolTypTau {
+			continue
+		}
+		if l.Symbol.Type != pifra.SymbolTypOutput || l.Symbol2.Type != pifra.SymbolTypKnown ||
+			l.Symbol.Value != l.Symbol2.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// The name of a register as written in a .aut file.
+func autRegisterName(lts *pifra.Lts, name string) string {
+	if orig, ok := lts.FreeNamesMap[name]; ok {
+		return orig
+	}
+	return name
+}
+
+func autFraLabel(label pifra.Label) string {
+	s1 := label.Symbol
+	s2 := label.Symbol2
+	switch {
+	case s1.Type == pifra.SymbolTypTau:
+		return autTauLabel
+	case s1.Type == pifra.SymbolTypInput && s2.Type == pifra.SymbolTypKnown:
+		return fmt.Sprintf("%d(%d)", s1.Value, s2.Value)
+	case s1.Type == pifra.SymbolTypInput && s2.Type == pifra.SymbolTypFreshInput:
+		return fmt.Sprintf("%d(%d*)", s1.Value, s2.Value)
+	case s1.Type == pifra.SymbolTypOutput && s2.Type == pifra.SymbolTypKnown:
+		return fmt.Sprintf("%d<%d>", s1.Value, s2.Value)
+	case s1.Type == pifra.SymbolTypOutput && s2.Type == pifra.SymbolTypFreshOutput:
+		return fmt.Sprintf("%d<%d*>", s1.Value, s2.Value)
+	}
+	return label.PrettyPrintGraph()
+}
+
+func autRegsString(lts *pifra.Lts, regs pifra.Registers) string {
+	var idxs []int
+	for idx := range regs.Registers {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	var fields []string
+	for _, idx := range idxs {
+		fields = append(fields, fmt.Sprintf("%d=%s", idx, autRegisterName(lts, regs.Registers[idx])))
+	}
+	return strings.Join(fields, " ")
+}
+
+// The states in the order of their ids, and their numbers in a .aut file. The
+// start state 0 stays the initial state.
+func autStateIds(lts pifra.Lts) ([]int, map[int]int) {
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	autId := make(map[int]int, len(ids))
+	for i, id := range ids {
+		autId[id] = i
+	}
+	return ids, autId
+}
+
+// Generate the Aldebaran representation of an LTS.
+func generateAutFile(lts pifra.Lts) []byte {
+	plain := isPlainLts(lts)
+	ids, autId := autStateIds(lts)
+
+	type autTrans struct {
+		src   int
+		label string
+		dst   int
+	}
+	var trans []autTrans
+	for _, t := range lts.Transitions {
+		var label string
+		if plain && t.Label.Symbol.Type != pifra.SymbolTypTau {
+			name := lts.States[0].Registers.Registers[t.Label.Symbol.Value]
+			label = autRegisterName(&lts, name)
+		} else {
+			label = autFraLabel(t.Label)
+		}
+		trans = append(trans, autTrans{autId[t.Source], label, autId[t.Destination]})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("des (%d, %d, %d)\n", autId[0], len(trans), len(ids)))
+	for _, t := range trans {
+		buf.WriteString(fmt.Sprintf("(%d, %s, %d)\n", t.src, strconv.Quote(t.label), t.dst))
+	}
+	return buf.Bytes()
+}
+
+// Generate the sidecar file with the registers of the states of an LTS, or nil
+// for a plain LTS.
+func generateAutRegsFile(lts pifra.Lts) []byte {
+	if isPlainLts(lts) {
+		return nil
+	}
+	ids, autId := autStateIds(lts)
+	var buf bytes.Buffer
+	for _, id := range ids {
+		buf.WriteString(fmt.Sprintf("%d %s\n", autId[id], autRegsString(&lts, lts.States[id].Registers)))
+	}
+	return buf.Bytes()
+}
+
+// Write an LTS to a .aut file, and its registers to the sidecar file. A stale
+// sidecar file is removed when writing a plain LTS.
+func writeAutFile(name string, lts pifra.Lts) error {
+	if err := writeFile(name, generateAutFile(lts)); err != nil {
+		return err
+	}
+	regs := generateAutRegsFile(lts)
+	if regs == nil {
+		if err := os.Remove(name + autRegsExt); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeFile(name+autRegsExt, regs)
+}
+
+type autLine struct {
+	src   int
+	label string
+	dst   int
+}
+
+// Parse the header and the transitions of an Aldebaran file. Labels may be
+// quoted or not.
+func parseAut(r io.Reader) (initial int, nStates int, lines []autLine, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	header := false
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !header {
+			var nTrans int
+			if _, err = fmt.Sscanf(line, "des (%d, %d, %d)", &initial, &nTrans, &nStates); err != nil {
+				if _, err = fmt.Sscanf(strings.ReplaceAll(line, " ", ""), "des(%d,%d,%d)",
+					&initial, &nTrans, &nStates); err != nil {
+					err = fmt.Errorf("line %d: expected a des header: %s", lineNo, line)
+					return
+				}
+			}
+			lines = make([]autLine, 0, nTrans)
+			header = true
+			continue
+		}
+		first := strings.Index(line, ",")
+		last := strings.LastIndex(line, ",")
+		if !strings.HasPrefix(line, "(") || !strings.HasSuffix(line, ")") || first == last {
+			err = fmt.Errorf("line %d: expected a transition: %s", lineNo, line)
+			return
+		}
+		var l autLine
+		if l.src, err = strconv.Atoi(strings.TrimSpace(line[1:first])); err != nil {
+			err = fmt.Errorf("line %d: bad source state: %s", lineNo, line)
+			return
+		}
+		if l.dst, err = strconv.Atoi(strings.TrimSpace(line[last+1 : len(line)-1])); err != nil {
+			err = fmt.Errorf("line %d: bad destination state: %s", lineNo, line)
+			return
+		}
+		l.label = strings.TrimSpace(line[first+1 : last])
+		if strings.HasPrefix(l.label, "\"") {
+			if l.label, err = strconv.Unquote(l.label); err != nil {
+				err = fmt.Errorf("line %d: bad label: %s", lineNo, line)
+				return
+			}
+		}
+		if l.src < 0 || l.src >= nStates || l.dst < 0 || l.dst >= nStates {
+			err = fmt.Errorf("line %d: state out of range: %s", lineNo, line)
+			return
+		}
+		lines = append(lines, l)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if !header {
+		err = fmt.Errorf("missing des header")
+	}
+	return
+}
+
+func isAutTau(label string) bool {
+	return label == autTauLabel || label == "tau" || label == "τ"
+}
+
+// Parse a label of a FRA LTS.
+func parseAutFraLabel(label string) (pifra.Label, error) {
+	if isAutTau(label) {
+		return pifra.Label{}, nil
+	}
+	var typ1 pifra.SymbolType
+	var open, close string
+	if i := strings.Index(label, "("); i > 0 {
+		typ1, open, close = pifra.SymbolTypInput, "(", ")"
+	} else if i := strings.Index(label, "<"); i > 0 {
+		typ1, open, close = pifra.SymbolTypOutput, "<", ">"
+	} else {
+		return pifra.Label{}, fmt.Errorf("unknown label %s", label)
+	}
+	i := strings.Index(label, open)
+	if !strings.HasSuffix(label, close) {
+		return pifra.Label{}, fmt.Errorf("unknown label %s", label)
+	}
+	ch, err := strconv.Atoi(label[:i])
+	if err != nil {
+		return pifra.Label{}, fmt.Errorf("bad channel in label %s", label)
+	}
+	obj := label[i+1 : len(label)-1]
+	typ2 := pifra.SymbolTypKnown
+	if strings.HasSuffix(obj, "*") {
+		obj = strings.TrimSuffix(obj, "*")
+		if typ1 == pifra.SymbolTypInput {
+			typ2 = pifra.SymbolTypFreshInput
+		} else {
+			typ2 = pifra.SymbolTypFreshOutput
+		}
+	}
+	val, err := strconv.Atoi(obj)
+	if err != nil {
+		return pifra.Label{}, fmt.Errorf("bad object in label %s", label)
+	}
+	return pifra.Label{
+		Symbol:  pifra.Symbol{Type: typ1, Value: ch},
+		Symbol2: pifra.Symbol{Type: typ2, Value: val},
+	}, nil
+}
+
+// Parse the registers of a line of a sidecar file. Names that do not start
+// with '#' are free names of the model, and are recorded in namesMap.
+func parseAutRegs(fields []string, namesMap map[string]string) (pifra.Registers, error) {
+	regs := pifra.Registers{Registers: make(map[int]string)}
+	label := strings.Join(fields, " ")
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return regs, fmt.Errorf("bad register %s in %s", field, label)
+		}
+		idx, err := strconv.Atoi(parts[0])
+		if err != nil || idx < 1 {
+			return regs, fmt.Errorf("bad register %s in %s", field, label)
+		}
+		name := parts[1]
+		if !strings.HasPrefix(name, "#") {
+			namesMap[name] = name
+		}
+		regs.Registers[idx] = name
+		if idx > regs.Size {
+			regs.Size = idx
+		}
+	}
+	return regs, nil
+}
+
+// Read the sidecar file of a .aut file, with the registers by the numbers of
+// the states in the .aut file. Whether the file exists is returned as well.
+func readAutRegsFile(name string, nStates int, namesMap map[string]string) (map[int]pifra.Registers, bool, error) {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	regs := make(map[int]pifra.Registers, nStates)
+	for lineNo, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil || id < 0 || id >= nStates {
+			return nil, true, fmt.Errorf("%s: line %d: bad state: %s", name, lineNo+1, line)
+		}
+		r, err := parseAutRegs(fields[1:], namesMap)
+		if err != nil {
+			return nil, true, fmt.Errorf("%s: line %d: %s", name, lineNo+1, err.Error())
+		}
+		regs[id] = r
+	}
+	return regs, true, nil
+}
+
+// The process of an imported state. It is a call to an undeclared process
+// named after the state, with all the names of the registers as parameters, so
+// that none of the registers is taken as unused.
+func autStateProcess(id int, regs pifra.Registers) pifra.Element {
+	var idxs []int
+	for idx := range regs.Registers {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	proc := &pifra.ElemProcess{Name: "S" + strconv.Itoa(id)}
+	for _, idx := range idxs {
+		proc.Parameters = append(proc.Parameters, pifra.Name{Name: regs.Registers[idx], Type: pifra.Free})
+	}
+	return proc
+}
+
+// Read an LTS from an Aldebaran file. The initial state becomes state 0.
+func readAutFile(name string) (lts pifra.Lts, err error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer closeFile(file)
+	initial, nStates, lines, err := parseAut(file)
+	if err != nil {
+		err = fmt.Errorf("%s: %s", name, err.Error())
+		return
+	}
+	if initial < 0 || initial >= nStates {
+		err = fmt.Errorf("%s: initial state %d out of range", name, initial)
+		return
+	}
+	// Swap the initial state with state 0.
+	stateId := func(id int) int {
+		switch id {
+		case initial:
+			return 0
+		case 0:
+			return initial
+		}
+		return id
+	}
+
+	lts.FreeNamesMap = make(map[string]string)
+	lts.RegSizeReached = make(map[int]bool)
+	autRegs, fra, err := readAutRegsFile(name+autRegsExt, nStates, lts.FreeNamesMap)
+	if err != nil {
+		return
+	}
+	regs := make(map[int]pifra.Registers, nStates)
+	for id, r := range autRegs {
+		regs[stateId(id)] = r
+	}
+	if fra {
+		for _, l := range lines {
+			lab, err_ := parseAutFraLabel(l.label)
+			if err_ != nil {
+				err = fmt.Errorf("%s: %s", name, err_.Error())
+				return
+			}
+			lts.Transitions = append(lts.Transitions, pifra.Transition{
+				Source:      stateId(l.src),
+				Destination: stateId(l.dst),
+				Label:       lab,
+			})
+		}
+	} else {
+		// Every action gets a register, in the order of the names.
+		var actions []string
+		actionIdx := make(map[string]int)
+		for _, l := range lines {
+			if _, ok := actionIdx[l.label]; !ok && !isAutTau(l.label) {
+				actionIdx[l.label] = 0
+				actions = append(actions, l.label)
+			}
+		}
+		sort.Strings(actions)
+		shared := pifra.Registers{Size: len(actions), Registers: make(map[int]string)}
+		for i, action := range actions {
+			actionIdx[action] = i + 1
+			shared.Registers[i+1] = action
+			lts.FreeNamesMap[action] = action
+		}
+		for id := 0; id < nStates; id++ {
+			regs[id] = shared
+		}
+		for _, l := range lines {
+			var lab pifra.Label
+			if !isAutTau(l.label) {
+				idx := actionIdx[l.label]
+				lab = pifra.Label{
+					Symbol:  pifra.Symbol{Type: pifra.SymbolTypOutput, Value: idx},
+					Symbol2: pifra.Symbol{Type: pifra.SymbolTypKnown, Value: idx},
+				}
+			}
+			lts.Transitions = append(lts.Transitions, pifra.Transition{
+				Source:      stateId(l.src),
+				Destination: stateId(l.dst),
+				Label:       lab,
+			})
+		}
+	}
+
+	lts.States = make(map[int]pifra.Configuration, nStates)
+	for id := 0; id < nStates; id++ {
+		r, ok := regs[id]
+		if !ok {
+			r = pifra.Registers{Registers: make(map[int]string)}
+		}
+		lts.States[id] = pifra.Configuration{
+			Process:   autStateProcess(id, r),
+			Registers: r,
+		}
+	}
+	lts.StatesExplored = nStates
+	lts.StatesGenerated = nStates
+	return
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/yungene/pifra"
+)
+
+func writeTestAut(t *testing.T, dir string, name string, data string) pifra.Lts {
+	fileName := path.Join(dir, name)
+	if err := writeFile(fileName, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	lts, err := readAutFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lts
+}
+
+// Plain LTSs are matched on the names of their actions.
+func TestPlainAut(t *testing.T) {
+	dir := t.TempDir()
+	// a.(b + c) and a.b + a.c
+	left := writeTestAut(t, dir, "left.aut", `des (0, 3, 4)
+(0, "a", 1)
+(1, "b", 2)
+(1, "c", 3)
+`)
+	right := writeTestAut(t, dir, "right.aut", `des (0, 4, 5)
+(0, "a", 1)
+(1, "b", 2)
+(0, "a", 3)
+(3, "c", 4)
+`)
+	if status := checkBisim(left, right, left, right, -1, -1, false); status != ResultNotRelated {
+		t.Errorf("a.(b + c) and a.b + a.c were %d.", status)
+	}
+	if status := checkBisim(left, left, left, left, -1, -1, false); status != ResultRelated {
+		t.Errorf("a.(b + c) and itself were %d.", status)
+	}
+
+	// a.tau.b and a.b, with the initial state not being 0.
+	tau := writeTestAut(t, dir, "tau.aut", `des (2, 3, 4)
+(2, a, 0)
+(0, i, 1)
+(1, b, 3)
+`)
+	noTau := writeTestAut(t, dir, "notau.aut", `des (0, 2, 3)
+(0, "a", 1)
+(1, "b", 2)
+`)
+	if status := checkBisim(tau, noTau, tau, noTau, -1, -1, false); status != ResultNotRelated {
+		t.Errorf("a.tau.b and a.b were strongly %d.", status)
+	}
+	if status := checkBisim(tau, noTau, doWeakTransform(tau), doWeakTransform(noTau), -1, -1, false); status != ResultRelated {
+		t.Errorf("a.tau.b and a.b were weakly %d.", status)
+	}
+}
+
+// An LTS generated by pifra has to survive a round trip through a .aut file.
+func TestFraAutRoundTrip(t *testing.T) {
+	pwd := getPwd(t)
+	testFolder := path.Join(pwd, "test", "weak-bisimilar")
+	outFolder := path.Join(pwd, "test", "weak-bisimilar", "out")
+	defer cleanFolder(t, outFolder)
+
+	var testFiles []string = []string{"buffer-2x1", "milner-cycler-02"}
+	generateLts(t, testFolder, outFolder, testFiles, flags)
+	for _, testFile := range testFiles {
+		for i := 1; i < 3; i++ {
+			name := path.Join(outFolder, fmt.Sprintf("%s.%d", testFile, i))
+			lts, err := decodeLTS(name + ".gob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isPlainLts(lts) {
+				t.Errorf("%s was taken as a plain LTS.", name)
+			}
+			if err := writeAutFile(name+autExt, lts); err != nil {
+				t.Fatal(err)
+			}
+			// Other tools must only see the transitions of the LTS.
+			data, err := ioutil.ReadFile(name + autExt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, nStates, lines, err := parseAut(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nStates != len(lts.States) || len(lines) != len(lts.Transitions) {
+				t.Errorf("%s has %d states and %d transitions in the .aut file, expected %d and %d.",
+					name, nStates, len(lines), len(lts.States), len(lts.Transitions))
+			}
+			imported, err := readAutFile(name + autExt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(imported.States) != len(lts.States) || len(imported.Transitions) != len(lts.Transitions) {
+				t.Errorf("%s has %d states and %d transitions after the round trip, expected %d and %d.",
+					name, len(imported.States), len(imported.Transitions), len(lts.States), len(lts.Transitions))
+			}
+			if status := checkBisim(lts, imported, lts, imported, -1, -1, false); status != ResultRelated {
+				t.Errorf("%s was %d to its round trip.", name, status)
+			}
+		}
+		left, err := readAutFile(path.Join(outFolder, testFile+".1"+autExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := readAutFile(path.Join(outFolder, testFile+".2"+autExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := checkBisim(left, right, doWeakTransform(left), doWeakTransform(right), -1, -1, false); status != ResultRelated {
+			t.Errorf("Imported %s were not weakly bisimilar.", testFile)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the batch mode, `pisim22 run queries.json`, which runs
+// many checks from a query file:
+//
+//	{
+//	  "defaults": {"maxStates": 15000},
+//	  "checks": [
+//	    {"name": "buffer", "lts1": "buffer.1.pi", "lts2": "buffer.2.pi",
+//	     "weak": true, "gc": true, "n": 4, "expected": "bisimilar"}
+//	  ]
+//	}
+//
+// The paths are relative to the query file. The LTSs, and their weak
+// transforms, are generated once for all the checks that share them.
+
+const (
+	verdictBisimilar    = "bisimilar"
+	verdictNotBisimilar = "not-bisimilar"
+)
+
+// The options of a check. Options that are not given are taken from the
+// defaults of the file.
+type queryOptions struct {
+	Weak      *bool `json:"weak,omitempty"`
+	N         *int  `json:"n,omitempty"`
+	GC        *bool `json:"gc,omitempty"`
+	MaxStates *int  `json:"maxStates,omitempty"`
+}
+
+type queryCheck struct {
+	Name string `json:"name"`
+	Lts1 string `json:"lts1"`
+	Lts2 string `json:"lts2"`
+	queryOptions
+	// bisimilar or not-bisimilar, or empty if there is no expected verdict.
+	Expected string `json:"expected,omitempty"`
+}
+
+type queryFile struct {
+	Defaults queryOptions `json:"defaults"`
+	Checks   []queryCheck `json:"checks"`
+}
+
+// The options of a check with the defaults applied.
+type checkOptions struct {
+	weak      bool
+	n         int
+	gc        bool
+	maxStates int
+}
+
+func (o queryOptions) apply(opts checkOptions) checkOptions {
+	if o.Weak != nil {
+		opts.weak = *o.Weak
+	}
+	if o.N != nil {
+		opts.n = *o.N
+	}
+	if o.GC != nil {
+		opts.gc = *o.GC
+	}
+	if o.MaxStates != nil {
+		opts.maxStates = *o.MaxStates
+	}
+	return opts
+}
+
+func readQueryFile(name string) (queryFile, error) {
+	var qf queryFile
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return qf, err
+	}
+	if err := json.Unmarshal(data, &qf); err != nil {
+		return qf, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	names := make(map[string]bool)
+	for i, c := range qf.Checks {
+		if c.Name == "" {
+			return qf, fmt.Errorf("%s: check %d has no name", name, i+1)
+		}
+		if names[c.Name] {
+			return qf, fmt.Errorf("%s: check %s is given twice", name, c.Name)
+		}
+		names[c.Name] = true
+		if c.Lts1 == "" || c.Lts2 == "" {
+			return qf, fmt.Errorf("%s: check %s needs lts1 and lts2", name, c.Name)
+		}
+		if c.Expected != "" && c.Expected != verdictBisimilar && c.Expected != verdictNotBisimilar {
+			return qf, fmt.Errorf("%s: expected verdict of check %s is %q, not %s or %s",
+				name, c.Name, c.Expected, verdictBisimilar, verdictNotBisimilar)
+		}
+	}
+	return qf, nil
+}
+
+// ####
+// The LTSs shared by the checks.
+// ####
+
+type ltsStoreKey struct {
+	path      string
+	gc        bool
+	maxStates int
+}
+
+type ltsStore struct {
+	dir         string
+	lts         map[ltsStoreKey]pifra.Lts
+	weak        map[ltsStoreKey]pifra.Lts
+	sorts       map[ltsStoreKey]polyadicSorts
+	generated   int
+	transformed int
+}
+
+func newLtsStore(dir string) *ltsStore {
+	return &ltsStore{
+		dir:   dir,
+		lts:   make(map[ltsStoreKey]pifra.Lts),
+		weak:  make(map[ltsStoreKey]pifra.Lts),
+		sorts: make(map[ltsStoreKey]polyadicSorts),
+	}
+}
+
+func isLoadedLtsFile(name string) bool {
+	return isLtsFile(name) || filepath.Ext(name) == ".gob"
+}
+
+func (s *ltsStore) key(name string, opts checkOptions) ltsStoreKey {
+	if isLoadedLtsFile(name) {
+		// The options of pifra do not matter for a file that holds an LTS.
+		return ltsStoreKey{path: name}
+	}
+	return ltsStoreKey{name, opts.gc, opts.maxStates}
+}
+
+func (s *ltsStore) get(key ltsStoreKey) (pifra.Lts, error) {
+	if lts, ok := s.lts[key]; ok {
+		return lts, nil
+	}
+	name := key.path
+	if !isLoadedLtsFile(name) {
+		name = filepath.Join(s.dir, strconv.Itoa(len(s.lts))+".gob")
+		sorts, err := generatePifraLts(pifra.Flags{
+			InputFile:    key.path,
+			OutputFile:   name,
+			MaxStates:    key.maxStates,
+			RegisterSize: 1073741824,
+			DisableGC:    !key.gc,
+			Gob:          true,
+		})
+		if err != nil {
+			return pifra.Lts{}, err
+		}
+		s.sorts[key] = sorts
+		s.generated++
+	}
+	lts, err := loadLTS(name)
+	if err != nil {
+		return pifra.Lts{}, err
+	}
+	s.lts[key] = lts
+	return lts, nil
+}
+
+func (s *ltsStore) getWeak(key ltsStoreKey) (pifra.Lts, error) {
+	if lts, ok := s.weak[key]; ok {
+		return lts, nil
+	}
+	lts, err := s.get(key)
+	if err != nil {
+		return pifra.Lts{}, err
+	}
+	weak := doWeakTransform(lts)
+	s.transformed++
+	s.weak[key] = weak
+	return weak, nil
+}
+
+// The distinguishing path of a check, in the polyadic form of its models
+// rather than of the models given by the flags.
+func (s *ltsStore) pathString(keys []ltsStoreKey, state *CleavelandState) string {
+	path := polyadicPath(state, distinguishingPath(state), s.sorts[keys[0]], s.sorts[keys[1]])
+	return distinguishingPathToString(path)
+}
+
+// ####
+// Running the checks.
+// ####
+
+type queryResult struct {
+	Name     string
+	Opts     checkOptions
+	Expected string
+	Verdict  string
+	Err      error
+	Time     time.Duration
+}
+
+func (r queryResult) status() string {
+	switch {
+	case r.Err != nil:
+		return "ERROR"
+	case r.Expected == "":
+		return "-"
+	case r.Expected == r.Verdict:
+		return "ok"
+	}
+	return "FAIL"
+}
+
+func (r queryResult) failed() bool {
+	return r.status() == "ERROR" || r.status() == "FAIL"
+}
+
+func runQueryCheck(store *ltsStore, c queryCheck, opts checkOptions) (string, error) {
+	keys := []ltsStoreKey{store.key(c.Lts1, opts), store.key(c.Lts2, opts)}
+	var lts, weak [2]pifra.Lts
+	for i, key := range keys {
+		var err error
+		if lts[i], err = store.get(key); err != nil {
+			return "", err
+		}
+		weak[i] = lts[i]
+		if opts.weak {
+			if weak[i], err = store.getWeak(key); err != nil {
+				return "", err
+			}
+		}
+	}
+	rho, err := freeNamesRho(lts[0], lts[1], 0, 0)
+	if err != nil {
+		return "", err
+	}
+	n := opts.n
+	if n <= 0 {
+		n = maxInt(getMaxMinRegSize(lts[0]), getMaxMinRegSize(lts[1]))
+	}
+	// The mode of the check is given by its options, not by the flags.
+	var cacheKey string
+	if isCacheEnabled() {
+		cacheKey = bisimCacheKey(lts[0], lts[1], weak[0], weak[1], cacheModeOf(opts.weak, opts.gc), n, rho)
+		if entry, ok := loadCacheEntry(cacheKey); ok && !isVerbose() {
+			return verdictString(entry.Result), nil
+		}
+	}
+	state, _, res, err := preorderPair(lts[0], lts[1], weak[0], weak[1], 0, 0, rho, n, opts.gc)
+	if err != nil {
+		return "", err
+	}
+	if cacheKey != "" {
+		entry := cacheEntry{Result: res, Mode: cacheModeOf(opts.weak, opts.gc), N: n, Rho: rho, Created: time.Now()}
+		if err := storeCacheEntry(cacheKey, entry); err != nil {
+			fmt.Printf("Could not store the result in the cache: %s.\n", err.Error())
+		}
+	}
+	if isVerbose() {
+		fmt.Printf("%s for rho %s, N=%d.\n", verdictString(res), fmt.Sprint(rho), n)
+		if res == ResultNotRelated {
+			fmt.Printf("Distinguishing path:\n%s\n", store.pathString(keys, state))
+		}
+	}
+	return verdictString(res), nil
+}
+
+// Run all the checks of a query file.
+func runQueryFile(name string) ([]queryResult, *ltsStore, error) {
+	qf, err := readQueryFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, err := ioutil.TempDir("", "pisim22-run")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	store := newLtsStore(dir)
+
+	prevQuiet := quiet
+	quiet = !isVerbose()
+	defer func() { quiet = prevQuiet }()
+	base := filepath.Dir(name)
+	defaults := qf.Defaults.apply(checkOptions{n: -1, maxStates: 15000})
+	var results []queryResult
+	for _, c := range qf.Checks {
+		opts := c.queryOptions.apply(defaults)
+		c.Lts1 = resolvePath(base, c.Lts1)
+		c.Lts2 = resolvePath(base, c.Lts2)
+		if isVerbose() {
+			fmt.Printf("Running check %s.\n", c.Name)
+		}
+		start := time.Now()
+		verdict, err := runQueryCheck(store, c, opts)
+		results = append(results, queryResult{c.Name, opts, c.Expected, verdict, err, time.Since(start)})
+	}
+	return results, store, nil
+}
+
+func resolvePath(base string, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(base, name)
+}
+
+func printQueryResults(results []queryResult, store *ltsStore) int {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMODE\tN\tGC\tEXPECTED\tVERDICT\tTIME\tSTATUS")
+	failed := 0
+	for _, r := range results {
+		mode := "strong"
+		if r.Opts.weak {
+			mode = "weak"
+		}
+		n := "auto"
+		if r.Opts.n > 0 {
+			n = strconv.Itoa(r.Opts.n)
+		}
+		expected, verdict := r.Expected, r.Verdict
+		if expected == "" {
+			expected = "-"
+		}
+		if r.Err != nil {
+			verdict = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\t%s\n", r.Name, mode, n, r.Opts.gc,
+			expected, verdict, r.Time.Round(time.Millisecond), r.status())
+		if r.failed() {
+			failed++
+		}
+	}
+	w.Flush()
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf("Check %s failed: %s.\n", r.Name, r.Err.Error())
+		}
+	}
+	fmt.Printf("\n%d checks, %d failed. Generated %d LTSs and %d weak transforms.\n",
+		len(results), failed, store.generated, store.transformed)
+	return failed
+}
+
+// The run command.
+func runCommand(args []string) {
+	fs := newCommandFlagSet("run", "[flags] queries.json",
+		"Run the checks of a query file, and compare their verdicts with the expected ones.")
+	verboseFlag := fs.Bool("v", false, "Whether to be verbose.")
+	noCacheFlag := fs.Bool("no-cache", false, "Whether to bypass the result cache.")
+	cacheDirFlag := fs.String("cache-dir", "", "A path to the result cache. Defaults to pisim22 in the user cache directory.")
+	args = parseCommandFlags(fs, args)
+	if len(args) != 1 {
+		usageError(fs, fmt.Errorf("expected one query file as argument, not %d", len(args)))
+	}
+	verbose = *verboseFlag
+	useCache = !*noCacheFlag
+	cacheDir = *cacheDirFlag
+
+	results, store, err := runQueryFile(args[0])
+	check(err)
+	if printQueryResults(results, store) > 0 {
+		os.Exit(1)
+	}
+}
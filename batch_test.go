@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/yungene/pifra"
+)
+
+func TestRunQueryFile(t *testing.T) {
+	pwd := getPwd(t)
+	results, store, err := runQueryFile(path.Join(pwd, "test", "queries.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.status() != "ok" {
+			t.Errorf("Check %s was %s, expected %s: %v.", r.Name, r.Verdict, r.Expected, r.Err)
+		}
+	}
+	// The models of the checks that only differ in weak and n are shared.
+	if store.generated != 10 || store.transformed != 6 {
+		t.Errorf("Generated %d LTSs and %d weak transforms, expected 10 and 6.", store.generated, store.transformed)
+	}
+}
+
+func TestQueryFileErrors(t *testing.T) {
+	cases := map[string]string{
+		"syntax":   `{"checks": [`,
+		"name":     `{"checks": [{"lts1": "a.pi", "lts2": "b.pi"}]}`,
+		"twice":    `{"checks": [{"name": "a", "lts1": "a.pi", "lts2": "b.pi"}, {"name": "a", "lts1": "a.pi", "lts2": "b.pi"}]}`,
+		"lts":      `{"checks": [{"name": "a", "lts1": "a.pi"}]}`,
+		"expected": `{"checks": [{"name": "a", "lts1": "a.pi", "lts2": "b.pi", "expected": "yes"}]}`,
+	}
+	dir := t.TempDir()
+	for name, data := range cases {
+		fileName := path.Join(dir, name+".json")
+		if err := writeFile(fileName, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readQueryFile(fileName); err == nil {
+			t.Errorf("Expected an error for %s.", name)
+		}
+	}
+}
+
+// A check runs in the mode of its options, whatever the flags, and its path is
+// printed in the polyadic form of its own models.
+func TestRunQueryCheckMode(t *testing.T) {
+	pwd := getPwd(t)
+	prevWeak, prevGC, prevUseCache, prevQuiet := weakBisim, enableGC, useCache, quiet
+	defer func() { weakBisim, enableGC, useCache, quiet = prevWeak, prevGC, prevUseCache, prevQuiet }()
+	weakBisim, enableGC, useCache, quiet = false, true, false, true
+
+	store := newLtsStore(t.TempDir())
+	c := queryCheck{Lts1: path.Join(pwd, "test", "weak-bisimilar", "buffer-2x1.1.pi"),
+		Lts2: path.Join(pwd, "test", "weak-bisimilar", "buffer-2x1.2.pi")}
+	verdict, err := runQueryCheck(store, c, checkOptions{n: -1, maxStates: 15000, weak: true})
+	if err != nil || verdict != verdictBisimilar {
+		t.Errorf("The weak check was %s with the error %v.", verdict, err)
+	}
+	if weakBisim || !enableGC {
+		t.Errorf("The check changed the flags to weak %t and gc %t.", weakBisim, enableGC)
+	}
+
+	opts := checkOptions{n: -1, maxStates: 15000}
+	var keys []ltsStoreKey
+	var lts [2]pifra.Lts
+	for i := range lts {
+		keys = append(keys, store.key(path.Join(pwd, "test", "polyadic", fmt.Sprintf("swap.%d.pi", i+1)), opts))
+		if lts[i], err = store.get(keys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rho, err := freeNamesRho(lts[0], lts[1], 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _, res, err := preorderPair(lts[0], lts[1], lts[0], lts[1], 0, 0, rho, -1, false)
+	if err != nil || res != ResultNotRelated {
+		t.Fatalf("swap was %d with the error %v.", res, err)
+	}
+	expected := "1. left 0 -1(3●,1●)-> 13, matched by right 0 -1(3●,1●)-> 13.\n" +
+		"2. left 13 -2'<3,1>-> 33, which right cannot match.\n"
+	if s := store.pathString(keys, state); s != expected {
+		t.Errorf("The path is\n%s\nexpected\n%s", s, expected)
+	}
+}
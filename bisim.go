@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/yungene/pifra"
 )
@@ -20,6 +23,24 @@ func resetBisim() {
 	IC.resetBisim()
 }
 
+// Forget the relation and the pairs that are not related, to search again. The
+// configurations are kept.
+func (s *CleavelandState) resetSearch() {
+	notR = sync.Map{}
+	s.High = make(map[HLKey]int)
+	s.Low = make(map[HLKey]int)
+	s.HighTwo = make(map[HLKeyFINP]int)
+	s.LowTwo = make(map[HLKeyFINP]int)
+	s.G = gGraph{}
+	s.G.States = make(map[string]gVertex)
+	s.G.TransitionsSrcMap = make(map[string]map[string]*gTransition)
+	s.G.TransitionsDstMap = make(map[string]map[string]*gTransition)
+	s.G.TransitionsSet = make(map[string]*gTransition)
+	s.Failures = make(map[string]pairFailure)
+	s.NotROrder = make(map[string]int)
+	s.lastFailure = nil
+}
+
 // #############################################################################
 // ############################ SINGLE THREAD ##################################
 // #############################################################################
@@ -27,7 +48,6 @@ func checkBisim(leftLts pifra.Lts, rightLts pifra.Lts,
 	weakLeftLts pifra.Lts, weakRightLts pifra.Lts,
 	regSizeOverride int, debugSpecificFlag int, findAllFlag bool) ResultType {
 	resetBisim()
-	var res = ResultNotRelated
 	//counter := 0
 	if regSizeOverride > 0 {
 		setRegSize(regSizeOverride)
@@ -38,29 +58,85 @@ func checkBisim(leftLts pifra.Lts, rightLts pifra.Lts,
 	}
 
 	// FREE_NAMES: generate the required maximum mapping here
-	var initRho = make(map[int]int)
 	var startOrigConfLeft = leftLts.States[0]
 	var startOrigConfRight = rightLts.States[0]
-
-	// We have lts.FreeNamesMap which maps new names to original names
-	// we want inverse registers, to get the index by name, then for each free name,
-	// we get its new name on left and new name on right, we
-	var invRegLeft map[string]int
-	var invRegRight map[string]int
-	invRegLeft_, err_ := reverseMapIntString(startOrigConfLeft.Registers.Registers)
+	if isVerbose() {
+		fmt.Printf("Registers left: %s.\n", pifra.PrettyPrintRegister(startOrigConfLeft.Registers))
+		fmt.Printf("Left free names map: %s.\n", leftLts.FreeNamesMap)
+		fmt.Printf("Registers right: %s.\n", pifra.PrettyPrintRegister(startOrigConfRight.Registers))
+		fmt.Printf("Right free names map: %s.\n", rightLts.FreeNamesMap)
+	}
+	initRho, err_ := freeNamesRho(leftLts, rightLts, 0, 0)
 	if err_ != nil {
 		fmt.Printf("%s\n", err_.Error())
 		return ResultNotRelated
-	} else {
-		invRegLeft = invRegLeft_
 	}
 
-	invRegRight_, err_ := reverseMapIntString(startOrigConfRight.Registers.Registers)
+	var cacheKey string
+	if isCacheEnabled() {
+		cacheKey = bisimCacheKey(leftLts, rightLts, weakLeftLts, weakRightLts, cacheMode(), getRegSize(), initRho)
+		if entry, ok := loadCacheEntry(cacheKey); ok && entry.serves() {
+			printVerdict(entry.Result, initRho)
+			if entry.Result == ResultNotRelated && isVerbose() && !isQuiet() {
+				fmt.Printf("Distinguishing path:\n%s\n", pathStepsToString(entry.Path))
+			}
+			if !isQuiet() {
+				fmt.Printf("Result taken from the cache (stored %s).\n", entry.Created.Format(time.RFC3339))
+			}
+			if fn := getOutputResultName(); fn != "" {
+				check(writeResultFile(fn, cachedCheckResult(entry)))
+			}
+			return entry.Result
+		}
+	}
+
+	res, state, err_ := cleavelandBisim(leftLts, rightLts, weakLeftLts, weakRightLts, initRho)
 	if err_ != nil {
 		fmt.Printf("%s\n", err_.Error())
 		return ResultNotRelated
-	} else {
-		invRegRight = invRegRight_
+	}
+	if cacheKey != "" {
+		entry := cacheEntry{
+			Result:  res,
+			Mode:    cacheMode(),
+			N:       getRegSize(),
+			Rho:     initRho,
+			Created: time.Now(),
+		}
+		if res == ResultNotRelated {
+			entry.Path = toHtmlPath(checkPolyadicPath(state))
+		}
+		if isCacheRelation() || getOutputResultName() != "" {
+			entry.Relation = state.relatedPairs()
+			if entry.Relation == nil {
+				entry.Relation = []relatedPair{}
+			}
+		}
+		if err := storeCacheEntry(cacheKey, entry); err != nil {
+			fmt.Printf("Could not store the result in the cache: %s.\n", err.Error())
+		}
+	}
+	//fmt.Printf("\nTotal number of callback calls performed for these inputs for N=%d is %d.\n", getRegSize(), counter)
+	if isVerbose() {
+		fmt.Printf("N was chosen to be %d.\n", getRegSize())
+	}
+	return res
+}
+
+// The rho of a pair of states that relates the registers holding the same free
+// name of the programs.
+func freeNamesRho(leftLts pifra.Lts, rightLts pifra.Lts, leftId int, rightId int) (map[int]int, error) {
+	// We have lts.FreeNamesMap which maps new names to original names
+	// we want inverse registers, to get the index by name, then for each free name,
+	// we get its new name on left and new name on right, we
+	initRho := make(map[int]int)
+	invRegLeft, err := reverseMapIntString(leftLts.States[leftId].Registers.Registers)
+	if err != nil {
+		return nil, err
+	}
+	invRegRight, err := reverseMapIntString(rightLts.States[rightId].Registers.Registers)
+	if err != nil {
+		return nil, err
 	}
 
 	var allFreeNames = make(map[string]bool)
@@ -71,28 +147,13 @@ func checkBisim(leftLts pifra.Lts, rightLts pifra.Lts,
 		allFreeNames[origName] = true
 	}
 
-	var invFreeNamesLeft map[string]string
-	invFreeNamesLeft_, err_ := reverseMapStringString(leftLts.FreeNamesMap)
-	if err_ != nil {
-		fmt.Printf("%s\n", err_.Error())
-		return ResultNotRelated
-	} else {
-		invFreeNamesLeft = invFreeNamesLeft_
+	invFreeNamesLeft, err := reverseMapStringString(leftLts.FreeNamesMap)
+	if err != nil {
+		return nil, err
 	}
-	var invFreeNamesRight map[string]string
-	invFreeNamesRight_, err_ := reverseMapStringString(rightLts.FreeNamesMap)
-	if err_ != nil {
-		fmt.Printf("%s\n", err_.Error())
-		return ResultNotRelated
-	} else {
-		invFreeNamesRight = invFreeNamesRight_
-	}
-
-	if isVerbose() {
-		fmt.Printf("Registers left: %s.\n", pifra.PrettyPrintRegister(startOrigConfLeft.Registers))
-		fmt.Printf("Left free names map: %s.\n", leftLts.FreeNamesMap)
-		fmt.Printf("Registers right: %s.\n", pifra.PrettyPrintRegister(startOrigConfRight.Registers))
-		fmt.Printf("Right free names map: %s.\n", rightLts.FreeNamesMap)
+	invFreeNamesRight, err := reverseMapStringString(rightLts.FreeNamesMap)
+	if err != nil {
+		return nil, err
 	}
 	for freeName := range allFreeNames {
 		// find the new name in left LTS
@@ -106,17 +167,7 @@ func checkBisim(leftLts pifra.Lts, rightLts pifra.Lts,
 			}
 		}
 	}
-
-	res, err_ = cleavelandBisim(leftLts, rightLts, weakLeftLts, weakRightLts, initRho)
-	if err_ != nil {
-		fmt.Printf("%s\n", err_.Error())
-		return ResultNotRelated
-	}
-	//fmt.Printf("\nTotal number of callback calls performed for these inputs for N=%d is %d.\n", getRegSize(), counter)
-	if isVerbose() {
-		fmt.Printf("N was chosen to be %d.\n", getRegSize())
-	}
-	return res
+	return initRho, nil
 }
 
 // Bisimulation algorithm as per Cleaveland & Sokolsky 2001 paper.
@@ -125,57 +176,42 @@ func checkBisim(leftLts pifra.Lts, rightLts pifra.Lts,
 // The system 1 is referred to as "left" and the system 2 is referred to as "right".
 func cleavelandBisim(leftLts pifra.Lts, rightLts pifra.Lts,
 	weakLeftLts pifra.Lts, weakRightLts pifra.Lts,
-	initPerm map[int]int) (res ResultType, err error) {
+	initPerm map[int]int) (res ResultType, state *CleavelandState, err error) {
 	// SECTION 1: Create the global state.
 
-	var state *CleavelandState = NewCleavelandState(leftLts, rightLts, weakLeftLts, weakRightLts)
+	state = NewCleavelandState(leftLts, rightLts, weakLeftLts, weakRightLts)
 
 	// SECTION 2: Create the starting states, we assume they are both at index 0.
-	startRhoLeft := make(map[int]int)
-
-	startOrigConfLeft := leftLts.States[0]
-	startOrigConfRight := rightLts.States[0]
+	startStateLeft, startStateRight, err := state.addStartPair(0, 0, initPerm)
+	if err != nil {
+		return
+	}
+	if isDebug() {
+		fmt.Printf("Start states: %s, %s\n", startStateLeft, startStateRight)
+	}
 
-	for k := range initPerm {
-		startRhoLeft[k] = initPerm[k]
+	var report warmStartReport
+	if rel := getWarmStartRelation(); rel != nil {
+		report, err = seedRelation(state, *rel)
 	}
-	startStateLeft := FRAConfiguration{
-		Process:   startOrigConfLeft.Process,
-		Registers: startOrigConfLeft.Registers,
-		Label:     startOrigConfLeft.Label,
-		Rho:       startRhoLeft,
-		N:         getRegSize(),
+	if err == nil {
+		res, err = preorder(state, startStateLeft, startStateRight)
 	}
-	var startRhoRight map[int]int
-	revRho, err_ := reverseMap(startRhoLeft)
-	if err_ != nil {
-		err = err_
+	if err == errSearchStopped && getCheckpointName() != "" {
+		err = fmt.Errorf("%s, resume it with -warm-start %s", err.Error(), getCheckpointName())
+	}
+	if err != nil {
 		return
-	} else {
-		startRhoRight = revRho
 	}
-	startStateRight := FRAConfiguration{
-		Process:   startOrigConfRight.Process,
-		Registers: startOrigConfRight.Registers,
-		Label:     startOrigConfRight.Label,
-		Rho:       startRhoRight,
-		N:         getRegSize(),
+	printVerdict(res, initPerm)
+	if res == ResultNotRelated && isVerbose() && !isQuiet() {
+		fmt.Printf("Distinguishing path:\n%s\n", distinguishingPathToString(checkPolyadicPath(state)))
 	}
-
-	if isDebug() {
-		fmt.Printf("Start states: %s, %s\n", startStateLeft, startStateRight)
+	if rel := getWarmStartRelation(); rel != nil {
+		printWarmStartReport(state, report, len(rel.Pairs))
 	}
-	state.addNPState(startStateLeft, 0)
-	state.addNQState(startStateRight, 0)
-
-	res = preorder(state, startStateLeft, startStateRight)
-	if res == ResultRelated {
-		fmt.Printf("\n*** Systems are BISIMILAR for rho %s, N=%d.\n\n", fmt.Sprint(initPerm), getRegSize())
-		if isDebug() {
-			fmt.Printf("Graph is %s.\n", fmt.Sprint(state.G))
-		}
-	} else {
-		fmt.Printf("\n^^^ Systems are NOT bisimilar for rho %s, N=%d.\n\n", fmt.Sprint(initPerm), getRegSize())
+	if res == ResultRelated && isDebug() {
+		fmt.Printf("Graph is %s.\n", fmt.Sprint(state.G))
 	}
 
 	if isOutputGraph() {
@@ -186,26 +222,115 @@ func cleavelandBisim(leftLts pifra.Lts, rightLts pifra.Lts,
 		fmt.Printf("Bisimulation graph has %d states and %d transitions.\n", len(state.G.States), len(state.G.TransitionsSet))
 	}
 
+	if fn := getSaveRelationName(); fn != "" {
+		check(writeSavedRelation(fn, getSavedRelation(state)))
+	}
+
 	if fn := getOutputBisimLtsName(); fn != "" {
 		m := getBisimilarStates(state)
 		fmt.Print(bisimilarStatesToString(m))
-		data := generateBisimGraphVizFile(leftLts, rightLts, m)
+		data := generateBisimGraphVizFile(state, res)
 		check(writeFile(fn+".bisim"+".dot", data))
 		dataTex := generateBisimGraphVizTexFile(leftLts, rightLts, m)
 		check(writeFile(fn+".bisim.tex"+".dot", dataTex))
 	}
 
+	if fn := getOutputHtmlName(); fn != "" {
+		data, err := generateHtmlFile(state, res)
+		check(err)
+		check(writeFile(fn, data))
+	}
+
+	if fn := getOutputTexName(); fn != "" {
+		check(writeFile(fn, generateTexFile(state, res)))
+	}
+
+	if fn := getOutputResultName(); fn != "" {
+		check(writeResultFile(fn, getCheckResult(state, res, initPerm)))
+	}
+
 	return
 }
 
-func preorderGeneric(state *CleavelandState, nP FRAConfiguration, pId int,
-	nQ FRAConfiguration, qId int, isLeft bool) ResultType {
-	state.addNState(nP, pId, isLeft)
-	state.addNState(nQ, qId, !isLeft)
+// Create the configurations of a pair of states of the left and right LTS that
+// the search starts from, with the rho of the left state, and register them.
+func (s *CleavelandState) addStartPair(leftId int, rightId int, rho map[int]int) (nP FRAConfiguration, nQ FRAConfiguration, err error) {
+	startRhoLeft := make(map[int]int)
+	for k := range rho {
+		startRhoLeft[k] = rho[k]
+	}
+	startRhoRight, err := reverseMap(startRhoLeft)
+	if err != nil {
+		return
+	}
+	startOrigConfLeft := s.LeftLts.States[leftId]
+	startOrigConfRight := s.RightLts.States[rightId]
+	nP = FRAConfiguration{
+		Process:   startOrigConfLeft.Process,
+		Registers: startOrigConfLeft.Registers,
+		Label:     startOrigConfLeft.Label,
+		Rho:       startRhoLeft,
+		N:         getRegSize(),
+	}
+	nQ = FRAConfiguration{
+		Process:   startOrigConfRight.Process,
+		Registers: startOrigConfRight.Registers,
+		Label:     startOrigConfRight.Label,
+		Rho:       startRhoRight,
+		N:         getRegSize(),
+	}
+
+	s.canonicalisePair(&nP, &nQ, true)
+	s.StartKey = getFRAPairKey(nP, nQ)
+	s.StartPos = gamePosition{gVertex{nP, nQ}, leftId, rightId}
+	s.addNPState(nP, leftId)
+	s.addNQState(nQ, rightId)
+	return
+}
+
+// Run preorder on a pair of states of the left and right LTS with the given
+// rho, outside of a check of the whole systems. Returns the state of the search,
+// the pair that it started from and its verdict.
+func preorderPair(leftLts pifra.Lts, rightLts pifra.Lts,
+	weakLeftLts pifra.Lts, weakRightLts pifra.Lts,
+	leftId int, rightId int, rho map[int]int, regSizeOverride int, gc bool) (*CleavelandState, gVertex, ResultType, error) {
+	resetBisim()
+	if regSizeOverride > 0 {
+		setRegSize(regSizeOverride)
+	} else {
+		setRegSize(maxInt(getMaxMinRegSize(leftLts), getMaxMinRegSize(rightLts)))
+	}
+	state := NewCleavelandState(leftLts, rightLts, weakLeftLts, weakRightLts)
+	state.GC = gc
+	nP, nQ, err := state.addStartPair(leftId, rightId, rho)
+	if err != nil {
+		return nil, gVertex{}, ResultNotRelated, err
+	}
+	res, err := preorder(state, nP, nQ)
+	return state, gVertex{nP, nQ}, res, err
+}
+
+func printVerdict(res ResultType, initPerm map[int]int) {
+	if isQuiet() {
+		return
+	}
+	if res == ResultRelated {
+		fmt.Printf("\n*** Systems are BISIMILAR for rho %s, N=%d.\n\n", fmt.Sprint(initPerm), getRegSize())
+	} else {
+		fmt.Printf("\n^^^ Systems are NOT bisimilar for rho %s, N=%d.\n\n", fmt.Sprint(initPerm), getRegSize())
+	}
+}
+
+// Register the pair of states and push a preorder frame for it. The pair is
+// swapped back into the left-right order if it is being matched from the right.
+func pushPreorderGeneric(s *bisimStack, nP FRAConfiguration, pId int,
+	nQ FRAConfiguration, qId int, isLeft bool) {
+	s.state.addNState(nP, pId, isLeft)
+	s.state.addNState(nQ, qId, !isLeft)
 	if isLeft {
-		return preorder(state, nP, nQ)
+		s.push(newPreorderFrame(nP, nQ))
 	} else {
-		return preorder(state, nQ, nP)
+		s.push(newPreorderFrame(nQ, nP))
 	}
 }
 
@@ -213,232 +338,444 @@ func preorderGeneric(state *CleavelandState, nP FRAConfiguration, pId int,
 // ############################### PREORDER ####################################
 // #############################################################################
 
+var errSearchStopped = errors.New("the search was stopped before it found a verdict")
+
 // This corresponds to BISIM() in the report.
-func preorder(state *CleavelandState, nP FRAConfiguration, nQ FRAConfiguration) ResultType {
-	pairKey := getFRAPairKey(nP, nQ)
-	IC.enterToPreorder++
-	if isDebug() {
-		fmt.Printf("%d. preorder 0: %s.\n", stackDepth, pairKey)
+//
+// The search does not recurse. Each call of BISIM() and of MATCH_LEFT() or
+// MATCH_RIGHT() is a frame on an explicit work stack, see bisimStack.
+//
+// The search is depth-first. With -search deepening, it is an iterative
+// deepening: the search is run with a bound on its depth that doubles, until
+// the pairs are found not to be related or no pair was assumed at the bound.
+// Assuming a pair only makes the search more optimistic, so a negative verdict
+// is genuine, and tends to come with a shorter distinguishing path.
+func preorder(state *CleavelandState, nP FRAConfiguration, nQ FRAConfiguration) (ResultType, error) {
+	if getSearchStrategy() != searchDeepening {
+		res, _, err := preorderBounded(state, nP, nQ, 0)
+		return res, err
 	}
-	if _, ok := notR.Load(pairKey); ok {
-		return ResultNotRelated
+	for depth := 1; ; depth *= 2 {
+		res, cut, err := preorderBounded(state, nP, nQ, depth)
+		if err != nil || res == ResultNotRelated || !cut {
+			return res, err
+		}
+		if isVerbose() {
+			fmt.Printf("No verdict within depth %d, searching again.\n", depth)
+		}
+		state.resetSearch()
 	}
+}
 
-	var vertex gVertex = gVertex{nP, nQ}
-	var vertexKey string = gVertexToString(&vertex)
-	if _, ok := state.G.States[vertexKey]; ok {
-		return ResultRelated
+// Run the search with a bound on its depth, or 0. Returns whether a pair was
+// assumed at the bound, and errSearchStopped if the search was stopped.
+func preorderBounded(state *CleavelandState, nP FRAConfiguration, nQ FRAConfiguration,
+	maxDepth int) (ResultType, bool, error) {
+	s := newBisimStack(state)
+	s.maxDepth = maxDepth
+	s.pause = searchPause(s)
+	s.push(newPreorderFrame(nP, nQ))
+	if !s.run() {
+		return ResultNotRelated, s.cut, errSearchStopped
 	}
-	IC.preorderStackDepth++
-	IC.maxPreorderStackDepth = maxInt(IC.maxPreorderStackDepth, IC.preorderStackDepth)
-	state.G.States[vertexKey] = vertex
+	return s.result, s.cut, nil
+}
 
-	status := ResultRelated
-	if isDebug() {
-		fmt.Printf("%d. PREORDER: %s.\n", stackDepth, pairKey)
+// A transition of either system that still has to be matched by preorder.
+type preorderTrans struct {
+	lk      LabelsKey
+	transId int
+}
+
+const (
+	preorderEnter int = iota
+	preorderMatchLeft
+	preorderMatchRight
+	preorderReeval
+)
+
+// The frame of a single BISIM() call.
+type preorderFrame struct {
+	nP        FRAConfiguration
+	nQ        FRAConfiguration
+	pairKey   string
+	vertexKey string
+	pc        int
+	status    ResultType
+	// Whether a child frame was pushed and its verdict is awaited.
+	waiting bool
+
+	trans []preorderTrans
+	ti    int
+
+	// The pairs that need to be re-examined and the one currently being done.
+	A  []AKey
+	ai int
+	nR FRAConfiguration
+	nS FRAConfiguration
+
+	// Whether the pair is already in G and is only being re-verified, as it was
+	// seeded from an old relation.
+	reverify bool
+}
+
+func newPreorderFrame(nP FRAConfiguration, nQ FRAConfiguration) *preorderFrame {
+	return &preorderFrame{nP: nP, nQ: nQ, pc: preorderEnter}
+}
+
+func (f *preorderFrame) step(s *bisimStack, child ResultType) (ResultType, bool) {
+	state := s.state
+	switch f.pc {
+	case preorderEnter:
+		f.pairKey = getFRAPairKey(f.nP, f.nQ)
+		IC.enterToPreorder++
+		if isDebug() {
+			fmt.Printf("%d. preorder 0: %s.\n", stackDepth, f.pairKey)
+		}
+		if _, ok := notR.Load(f.pairKey); ok {
+			return ResultNotRelated, true
+		}
+
+		var vertex gVertex = gVertex{f.nP, f.nQ}
+		f.vertexKey = gVertexToString(&vertex)
+		if _, ok := state.G.States[f.vertexKey]; ok && !f.reverify {
+			return ResultRelated, true
+		}
+		if s.maxDepth > 0 && s.depth >= s.maxDepth {
+			state.G.States[f.vertexKey] = vertex
+			s.cut = true
+			return ResultRelated, true
+		}
+		s.depth++
+		if f.reverify {
+			IC.reverifiedPairs++
+		} else {
+			IC.newPairs++
+		}
+		IC.preorderStackDepth++
+		IC.maxPreorderStackDepth = maxInt(IC.maxPreorderStackDepth, IC.preorderStackDepth)
+		state.G.States[f.vertexKey] = vertex
+
+		f.status = ResultRelated
+		if isDebug() {
+			fmt.Printf("%d. PREORDER: %s.\n", stackDepth, f.pairKey)
+		}
+		// Match each a-derivative of p with some a-derivative of q.
+		// Here generate all a transitions from nP given nQ.
+		nPId, ok := state.NStateToId[getFRAConfigurationKey(f.nP, true)]
+		if !ok {
+			f.status = ResultNotRelated
+		}
+		f.trans = preorderTransitions(state.AdjLeft[state.RevMap[nPId]])
+		f.pc = preorderMatchLeft
+		fallthrough
+	case preorderMatchLeft:
+		if f.matchNext(s, child, true) {
+			return 0, false
+		}
+		qPId, ok := state.NStateToId[getFRAConfigurationKey(f.nQ, false)]
+		if !ok {
+			f.status = ResultNotRelated
+		}
+		f.trans = preorderTransitions(state.AdjRight[state.RevMap[qPId]])
+		f.ti = 0
+		f.pc = preorderMatchRight
+		fallthrough
+	case preorderMatchRight:
+		if f.matchNext(s, child, false) {
+			return 0, false
+		}
+		if f.status != ResultNotRelated {
+			break
+		}
+		if isDebug() {
+			fmt.Println("Starting processing A.")
+		}
+		f.markNotRelated(state, f.vertexKey)
+		f.pc = preorderReeval
+		fallthrough
+	case preorderReeval:
+		if f.reevalNext(s, child) {
+			return 0, false
+		}
 	}
-	// Match each a-derivative of p with some a-derivative of q.
-	// Here generate all a transitions from nP given nQ.
-	nPId, ok := state.NStateToId[getFRAConfigurationKey(nP, true)]
-	if !ok {
-		status = ResultNotRelated
+
+	if isDebug() {
+		fmt.Printf("%d. Return from preorder with key: %s, status: %d.\n",
+			stackDepth,
+			f.vertexKey, f.status)
 	}
-	pId := state.RevMap[nPId]
+	IC.fullExecutePreorder++
+	IC.preorderStackDepth--
+	s.depth--
+	return f.status, true
+}
 
-	var A []AKey
-	for lk := range state.AdjLeft[pId] {
-		for i := range state.AdjLeft[pId][lk] {
-			if status == ResultNotRelated {
-				break
-			}
-			status = ResultNotRelated
+// Push a frame matching the next transition of the left (or right) system.
+// Returns false once all the transitions are matched, or one could not be.
+func (f *preorderFrame) matchNext(s *bisimStack, child ResultType, isLeft bool) bool {
+	state := s.state
+	if f.waiting {
+		f.waiting = false
+		f.status = child
+		if f.status == ResultNotRelated {
 			if isDebug() {
-				fmt.Printf("%d. Checking next % s P transitions %s.\n",
+				fmt.Printf("%d. Was not able to find a match for state % s transitions %s.\n",
 					stackDepth,
-					pairKey, fmt.Sprint(state.AdjLeft[pId][lk][i]))
-			}
-			// We now build a new transition and a new destination nPDest
-			status = processDerivativesGeneric(state, nP, nQ, i, lk,
-				state.AdjLeft, state.AdjRight, state.WeakAdjLeft, state.WeakAdjRight,
-				state.High, state.HighTwo, &state.LeftLts,
-				&state.RightLts, true, GLabelOne)
-
-			if status == ResultNotRelated {
-				if isDebug() {
-					fmt.Printf("%d. Was not able to find a match for state % s P transitions %s.\n",
-						stackDepth,
-						pairKey, fmt.Sprint(state.AdjLeft[pId][lk][i]))
-					fmt.Println(state.G)
-				}
-				A = state.populateA(A, vertexKey)
+					f.pairKey, fmt.Sprint(f.trans[f.ti-1]))
+				fmt.Println(state.G)
 			}
+			state.recordFailure(f.vertexKey)
+			f.A = state.populateA(f.A, f.vertexKey)
 		}
 	}
-	qPId, ok := state.NStateToId[getFRAConfigurationKey(nQ, false)]
-	if !ok {
-		status = ResultNotRelated
+	if f.status == ResultNotRelated || f.ti >= len(f.trans) {
+		return false
 	}
-	qId := state.RevMap[qPId]
-	for lk := range state.AdjRight[qId] {
-		for i := range state.AdjRight[qId][lk] {
-			if status == ResultNotRelated {
-				break
-			}
-			status = ResultNotRelated
-			if isDebug() {
-				fmt.Printf("%d. Checking next % s Q transitions %s.\n",
-					stackDepth,
-					pairKey, fmt.Sprint(state.AdjRight[qId][lk][i]))
-			}
-			// We now build a new transition and a new destination nPDest
-			status = processDerivativesGeneric(state, nQ, nP, i, lk,
-				state.AdjRight, state.AdjLeft, state.WeakAdjRight, state.WeakAdjLeft,
-				state.Low, state.LowTwo, &state.RightLts,
-				&state.LeftLts, false, GLabelTwo)
-
-			if status == ResultNotRelated {
-				if isDebug() {
-					fmt.Printf("%d. Was not able to find a match for state % s Q transitions %s.\n",
-						stackDepth,
-						pairKey, fmt.Sprint(state.AdjRight[qId][lk][i]))
-					fmt.Println(state.G)
-				}
-				A = state.populateA(A, vertexKey)
-			}
-		}
+	t := f.trans[f.ti]
+	f.ti++
+	f.status = ResultNotRelated
+	if isDebug() {
+		fmt.Printf("%d. Checking next % s transitions %s.\n",
+			stackDepth,
+			f.pairKey, fmt.Sprint(t))
 	}
+	// We now build a new transition and a new destination nPDest
+	if isLeft {
+		s.push(newMatchFrame(state, f.nP, f.nQ, t.transId, t.lk,
+			state.AdjLeft, state.AdjRight, state.WeakAdjLeft, state.WeakAdjRight,
+			state.High, state.HighTwo, &state.LeftLts,
+			&state.RightLts, true, GLabelOne))
+	} else {
+		s.push(newMatchFrame(state, f.nQ, f.nP, t.transId, t.lk,
+			state.AdjRight, state.AdjLeft, state.WeakAdjRight, state.WeakAdjLeft,
+			state.Low, state.LowTwo, &state.RightLts,
+			&state.LeftLts, false, GLabelTwo))
+	}
+	f.waiting = true
+	return true
+}
 
-	if status == ResultNotRelated {
-		if isDebug() {
-			fmt.Println("Starting processing A.")
+// Push a frame re-examining the next pair in A. Returns false once A is empty.
+func (f *preorderFrame) reevalNext(s *bisimStack, child ResultType) bool {
+	state := s.state
+	if f.waiting {
+		f.waiting = false
+		f.status = child
+		if f.status == ResultNotRelated {
+			rsKey := gVertexToString(&gVertex{f.nR, f.nS})
+			state.recordFailure(rsKey)
+			f.A = state.populateA(f.A, rsKey)
+			f.markNotRelated(state, rsKey)
 		}
-		// remove the vertex
-		delete(state.G.States, vertexKey)
-		// remove both incoming and outgoing edges
-		state.removeIncidentEdges(vertexKey)
-		notR.Store(vertexKey, true)
+	}
+	for ; f.ai < len(f.A); f.ai++ {
+		IC.reevalA++
+		key := f.A[f.ai]
 		if isDebug() {
-			fmt.Printf("%d. Added to not R %s.\n",
-				stackDepth,
-				vertexKey)
+			fmt.Printf("Popped %s.\n", fmt.Sprint(key))
 		}
-		i := 0
-		for ; true; i++ {
-			if i >= len(A) {
-				break
-			}
-			IC.reevalA++
-			key := A[i]
-			if isDebug() {
-				fmt.Printf("Popped %s.\n", fmt.Sprint(key))
-			}
-			nRId, ok := state.NStateToId[key.NP]
-			if !ok {
-				continue
-			}
-			nR := state.States[nRId]
-			rId := state.RevMap[nRId]
-			nSId, ok := state.NStateToId[key.NQ]
-			if !ok {
-				continue
-			}
-			nS := state.States[nSId]
-			sId := state.RevMap[nSId]
-			if key.Type == GLabelOne {
-				var act string = fmt.Sprint(state.AdjLeft[rId][key.LabelsKey][key.TransId])
-				if key.KPrime == noKPrime {
-					var hlKey HLKey = HLKey{
-						Dest: getFRAConfigurationKey(nR, true),
-						Src:  getFRAConfigurationKey(nS, false),
-						Act:  act,
-					}
-					state.High[hlKey] += 1
-				} else {
-					var hlPrimeKey = HLKeyFINP{
-						Dest:   getFRAConfigurationKey(nR, true),
-						Src:    getFRAConfigurationKey(nS, false),
-						Act:    act,
-						KPrime: key.KPrime,
-					}
-					state.HighTwo[hlPrimeKey] += 1
-				}
-				status = processDerivativesGeneric(state, nR, nS, key.TransId, key.LabelsKey,
-					state.AdjLeft, state.AdjRight, state.WeakAdjLeft, state.WeakAdjRight,
-					state.High, state.HighTwo,
-					&state.LeftLts, &state.RightLts, true, GLabelOne)
-				if status == ResultNotRelated {
-					rsKey := gVertexToString(&gVertex{nR, nS})
-					A = state.populateA(A, rsKey)
-
-					delete(state.G.States, rsKey)
-					// remove both incoming and outgoing edges
-					state.removeIncidentEdges(rsKey)
-					notR.Store(rsKey, true)
-					if isDebug() {
-						fmt.Printf("%d. Added to not R %s.\n",
-							stackDepth,
-							rsKey)
-					}
+		nRId, ok := state.NStateToId[key.NP]
+		if !ok {
+			continue
+		}
+		nR := state.States[nRId]
+		rId := state.RevMap[nRId]
+		nSId, ok := state.NStateToId[key.NQ]
+		if !ok {
+			continue
+		}
+		nS := state.States[nSId]
+		sId := state.RevMap[nSId]
+		if key.Type == GLabelOne {
+			var act string = fmt.Sprint(state.AdjLeft[rId][key.LabelsKey][key.TransId])
+			if key.KPrime == noKPrime {
+				var hlKey HLKey = HLKey{
+					Dest: getFRAConfigurationKey(nR, true),
+					Src:  getFRAConfigurationKey(nS, false),
+					Act:  act,
 				}
-			} else if key.Type == GLabelTwo {
-				var act string = fmt.Sprint(state.AdjRight[sId][key.LabelsKey][key.TransId])
-
-				if key.KPrime == noKPrime {
-					var hlKey HLKey = HLKey{
-						Dest: getFRAConfigurationKey(nS, false),
-						Src:  getFRAConfigurationKey(nR, true),
-						Act:  act,
-					}
-					state.Low[hlKey] += 1
-				} else {
-					var hlPrimeKey = HLKeyFINP{
-						Dest:   getFRAConfigurationKey(nS, false),
-						Src:    getFRAConfigurationKey(nR, true),
-						Act:    act,
-						KPrime: key.KPrime,
-					}
-					state.LowTwo[hlPrimeKey] += 1
+				state.High[hlKey] += 1
+			} else {
+				var hlPrimeKey = HLKeyFINP{
+					Dest:   getFRAConfigurationKey(nR, true),
+					Src:    getFRAConfigurationKey(nS, false),
+					Act:    act,
+					KPrime: key.KPrime,
 				}
+				state.HighTwo[hlPrimeKey] += 1
+			}
+			s.push(newMatchFrame(state, nR, nS, key.TransId, key.LabelsKey,
+				state.AdjLeft, state.AdjRight, state.WeakAdjLeft, state.WeakAdjRight,
+				state.High, state.HighTwo,
+				&state.LeftLts, &state.RightLts, true, GLabelOne))
+		} else if key.Type == GLabelTwo {
+			var act string = fmt.Sprint(state.AdjRight[sId][key.LabelsKey][key.TransId])
 
-				status = processDerivativesGeneric(state, nS, nR, key.TransId, key.LabelsKey,
-					state.AdjRight, state.AdjLeft, state.WeakAdjRight, state.WeakAdjLeft,
-					state.Low, state.LowTwo, &state.RightLts,
-					&state.LeftLts, false, GLabelTwo)
-				if status == ResultNotRelated {
-					rsKey := gVertexToString(&gVertex{nR, nS})
-					A = state.populateA(A, rsKey)
-
-					delete(state.G.States, rsKey)
-					// remove both incoming and outgoing edges
-					state.removeIncidentEdges(rsKey)
-					notR.Store(rsKey, true)
-					if isDebug() {
-						fmt.Printf("%d. Added to not R %s.\n",
-							stackDepth,
-							rsKey)
-					}
+			if key.KPrime == noKPrime {
+				var hlKey HLKey = HLKey{
+					Dest: getFRAConfigurationKey(nS, false),
+					Src:  getFRAConfigurationKey(nR, true),
+					Act:  act,
 				}
+				state.Low[hlKey] += 1
+			} else {
+				var hlPrimeKey = HLKeyFINP{
+					Dest:   getFRAConfigurationKey(nS, false),
+					Src:    getFRAConfigurationKey(nR, true),
+					Act:    act,
+					KPrime: key.KPrime,
+				}
+				state.LowTwo[hlPrimeKey] += 1
 			}
-
+			s.push(newMatchFrame(state, nS, nR, key.TransId, key.LabelsKey,
+				state.AdjRight, state.AdjLeft, state.WeakAdjRight, state.WeakAdjLeft,
+				state.Low, state.LowTwo, &state.RightLts,
+				&state.LeftLts, false, GLabelTwo))
+		} else {
+			continue
 		}
+		f.nR = nR
+		f.nS = nS
+		f.ai++
+		f.waiting = true
+		return true
 	}
+	return false
+}
 
+// Remove the vertex from G together with its edges and add it to notR.
+func (f *preorderFrame) markNotRelated(state *CleavelandState, vertexKey string) {
+	delete(state.G.States, vertexKey)
+	// remove both incoming and outgoing edges
+	state.removeIncidentEdges(vertexKey)
+	notR.Store(vertexKey, true)
+	if _, ok := state.NotROrder[vertexKey]; !ok {
+		state.NotROrder[vertexKey] = len(state.NotROrder)
+	}
 	if isDebug() {
-		fmt.Printf("%d. Return from preorder with key: %s, status: %d.\n",
+		fmt.Printf("%d. Added to not R %s.\n",
 			stackDepth,
-			vertexKey, status)
+			vertexKey)
 	}
-	IC.fullExecutePreorder++
-	IC.preorderStackDepth--
-	return status
+}
+
+// All the transitions of a state, in a fixed order of label kinds.
+func preorderTransitions(adj map[LabelsKey][]pifra.Transition) []preorderTrans {
+	var res []preorderTrans
+	for _, lk := range sortedLabelsKeys(adj) {
+		for i := range adj[lk] {
+			res = append(res, preorderTrans{lk, i})
+		}
+	}
+	return res
 }
 
 // #############################################################################
 // ########################## PROCESS_DERIVATIVES ##############################
 // #############################################################################
 
+// A matchLoop runs over the transitions of the other system that could match
+// the transition being processed, starting from the position saved in a high
+// (or low) pointer, until one of them leads to a related pair.
+type matchLoop struct {
+	cands  []pifra.Transition
+	idx    int
+	status ResultType
+	// try builds the pair of derivatives for a candidate transition. It returns
+	// false if the candidate does not match and has to be skipped.
+	try func(trans2 pifra.Transition) (nPX FRAConfiguration, nQX FRAConfiguration, ok bool)
+	// done is called with the verdict for the pair built by try.
+	done func(res ResultType, nPX *FRAConfiguration, nQX *FRAConfiguration)
+
+	waiting bool
+	nPX     FRAConfiguration
+	nQX     FRAConfiguration
+	pairKey string
+	// The candidates that led to pairs that are not related.
+	failed []pairReply
+}
+
+func newMatchLoop(cands []pifra.Transition, start int) *matchLoop {
+	return &matchLoop{cands: cands, idx: start, status: ResultNotRelated}
+}
+
+// Push the preorder frame for the next candidate. Returns false once the loop
+// has finished.
+func (l *matchLoop) next(s *bisimStack, child ResultType, pXId int, isLeft bool) bool {
+	if l.waiting {
+		l.waiting = false
+		l.status = child
+		l.done(child, &l.nPX, &l.nQX)
+		if child == ResultNotRelated {
+			l.failed = append(l.failed, pairReply{l.cands[l.idx-1], l.pairKey})
+		}
+	}
+	for l.idx < len(l.cands) && l.status == ResultNotRelated {
+		trans2 := l.cands[l.idx]
+		l.idx++
+		nPX, nQX, ok := l.try(trans2)
+		if !ok {
+			continue
+		}
+		s.state.canonicalisePair(&nPX, &nQX, isLeft)
+		l.nPX = nPX
+		l.nQX = nQX
+		vertex := newGVertex(nPX, nQX, isLeft)
+		l.pairKey = gVertexToString(&vertex)
+		l.waiting = true
+		pushPreorderGeneric(s, nPX, pXId, nQX, trans2.Destination, isLeft)
+		return true
+	}
+	return false
+}
+
+const (
+	matchEnter int = iota
+	matchRule
+	matchFinpKPrimes
+)
+
+// The frame of a single MATCH_LEFT() or MATCH_RIGHT() call.
+type matchFrame struct {
+	state        *CleavelandState
+	nP           FRAConfiguration
+	nQ           FRAConfiguration
+	transId      int
+	labelsKey    LabelsKey
+	adjLeft      AdvAdj
+	adjRight     AdvAdj
+	weakAdjLeft  AdvAdj
+	weakAdjRight AdvAdj
+	high         map[HLKey]int
+	highTwo      map[HLKeyFINP]int
+	leftLts      *pifra.Lts
+	rightLts     *pifra.Lts
+	isLeft       bool
+	edgeLabel    gLabel
+
+	pc     int
+	status ResultType
+	trans  pifra.Transition
+	pXId   int
+	pX     pifra.Configuration
+	qId    int
+	hlKey  HLKey
+	loop   *matchLoop
+	// The rule that is applied, used for debug output only.
+	rule int
+
+	// FINP only. The edges to add once all kPrimes are matched as well.
+	edges   []gTransition
+	kPrimes []int
+	ki      int
+
+	// The replies that were tried and failed, for the distinguishing path.
+	replies []pairReply
+}
+
 // This corresponds to MATCH_LEFT() and MATCH_RIGHT() in the report.
-func processDerivativesGeneric(state *CleavelandState, nP FRAConfiguration,
+func newMatchFrame(state *CleavelandState, nP FRAConfiguration,
 	nQ FRAConfiguration, transId int, labelsKey LabelsKey,
 	adjLeft AdvAdj,
 	adjRight AdvAdj,
@@ -449,317 +786,167 @@ func processDerivativesGeneric(state *CleavelandState, nP FRAConfiguration,
 	leftLts *pifra.Lts,
 	rightLts *pifra.Lts,
 	isLeft bool,
-	edgeLabel gLabel) ResultType {
+	edgeLabel gLabel) *matchFrame {
+	return &matchFrame{
+		state:        state,
+		nP:           nP,
+		nQ:           nQ,
+		transId:      transId,
+		labelsKey:    labelsKey,
+		adjLeft:      adjLeft,
+		adjRight:     adjRight,
+		weakAdjLeft:  weakAdjLeft,
+		weakAdjRight: weakAdjRight,
+		high:         high,
+		highTwo:      highTwo,
+		leftLts:      leftLts,
+		rightLts:     rightLts,
+		isLeft:       isLeft,
+		edgeLabel:    edgeLabel,
+		pc:           matchEnter,
+	}
+}
 
+func (f *matchFrame) step(s *bisimStack, child ResultType) (ResultType, bool) {
+	switch f.pc {
+	case matchEnter:
+		if !f.enter() {
+			f.state.lastFailure = nil
+			return ResultNotRelated, true
+		}
+		f.pc = matchRule
+		fallthrough
+	case matchRule:
+		if f.loop != nil {
+			if f.loop.next(s, child, f.pXId, f.isLeft) {
+				return 0, false
+			}
+			f.status = f.loop.status
+			f.replies = append(f.replies, f.loop.failed...)
+		}
+		if f.status == ResultNotRelated {
+			if isDebug() && f.rule > 0 && f.rule < 4 {
+				fmt.Printf("Not related due to rule %d.\n", f.rule)
+			}
+		}
+		if f.rule != 4 || f.status != ResultRelated {
+			break
+		}
+		f.startKPrimes()
+		f.pc = matchFinpKPrimes
+		fallthrough
+	case matchFinpKPrimes:
+		if f.nextKPrime(s, child) {
+			return 0, false
+		}
+	}
+
+	if isDebug() {
+		fmt.Printf("%d. Exit  processDerivativeGeneric with %s, %s, %s, trans:%s. Status: %d\n",
+			stackDepth,
+			f.nP.String(), f.nQ.String(), fmt.Sprint(f.isLeft), fmt.Sprint(f.trans), f.status)
+	}
+	stackDepth--
+	f.state.lastFailure = nil
+	if f.status == ResultNotRelated {
+		IC.failPD++
+		failure := pairFailure{IsLeft: f.isLeft, Trans: f.trans, Replies: f.replies,
+			LeftId: f.trans.Source, RightId: f.qId, Rho: f.nP.Rho}
+		if !f.isLeft {
+			failure.LeftId, failure.RightId, failure.Rho = f.qId, f.trans.Source, f.nQ.Rho
+		}
+		f.state.lastFailure = &failure
+	}
+	return f.status, true
+}
+
+// Set up the loop for the NT rule matching the transition. Returns false if
+// the states are unknown.
+func (f *matchFrame) enter() bool {
 	IC.enterProcessDerivatives++
 
-	// var derivatives []FRAConfiguration
-	status := ResultNotRelated
-	nPId, ok := state.NStateToId[getFRAConfigurationKey(nP, isLeft)]
+	state := f.state
+	nP := f.nP
+	f.status = ResultNotRelated
+	nPId, ok := state.NStateToId[getFRAConfigurationKey(nP, f.isLeft)]
 	if !ok {
-		return ResultNotRelated
+		return false
 	}
 	pId := state.RevMap[nPId]
-	nQId, ok := state.NStateToId[getFRAConfigurationKey(nQ, !isLeft)]
+	nQId, ok := state.NStateToId[getFRAConfigurationKey(f.nQ, !f.isLeft)]
 	if !ok {
-		return ResultNotRelated
+		return false
 	}
-	qId := state.RevMap[nQId]
-	trans := adjLeft[pId][labelsKey][transId]
-	pXId := trans.Destination
-	pX := leftLts.States[pXId]
-	newLabel := trans.Label
-	newRho := nP.Rho
+	f.qId = state.RevMap[nQId]
+	trans := f.adjLeft[pId][f.labelsKey][f.transId]
+	f.trans = trans
+	f.pXId = trans.Destination
+	f.pX = f.leftLts.States[f.pXId]
 	stackDepth++
 
-	var hlKey HLKey = HLKey{
-		Dest: getFRAConfigurationKey(nP, isLeft),
-		Src:  getFRAConfigurationKey(nQ, !isLeft),
+	f.hlKey = HLKey{
+		Dest: getFRAConfigurationKey(nP, f.isLeft),
+		Src:  getFRAConfigurationKey(f.nQ, !f.isLeft),
 		Act:  fmt.Sprint(trans),
 	}
-	if _, ok := high[hlKey]; !ok {
-		high[hlKey] = 0
+	if _, ok := f.high[f.hlKey]; !ok {
+		f.high[f.hlKey] = 0
 	}
 	if isDebug() {
 		fmt.Printf("%d. Enter processDerivativeGeneric with %s, %s, %s, trans:%s. hlKey is %s.\n",
 			stackDepth,
-			nP.String(), nQ.String(), fmt.Sprint(isLeft), fmt.Sprint(trans),
-			fmt.Sprint(hlKey))
+			nP.String(), f.nQ.String(), fmt.Sprint(f.isLeft), fmt.Sprint(trans),
+			fmt.Sprint(f.hlKey))
 	}
 
 	if trans.Label.Symbol.Type == pifra.SymbolTypTau {
 		IC.tauRule++
 		// NT rule 1, TAU
-		var nPX, nQX FRAConfiguration
-		nPX = FRAConfiguration{
-			Process:   pX.Process,
-			Registers: pX.Registers,
-			Label:     newLabel,
-			Rho:       newRho,
-			N:         getRegSize(),
-		}
-		nLk := LabelsKey{pifra.SymbolTypTau, pifra.SymbolTypTau}
-		for idx := high[hlKey]; idx < len(weakAdjRight[qId][nLk]) && status == ResultNotRelated; idx++ {
-			trans2 := weakAdjRight[qId][nLk][idx]
-			// TODO: this check should be redundant in theory.
-			if trans2.Label.Symbol.Type == pifra.SymbolTypTau {
-				qXId := trans2.Destination
-				qX := rightLts.States[qXId]
-				revRho, err := reverseMap(newRho)
-				if err != nil {
-					// TODO: increment the high[hlKey] here as well
-					high[hlKey] += 1
-					continue
-				}
-				nQX = FRAConfiguration{
-					Process:   qX.Process,
-					Registers: qX.Registers,
-					Rho:       revRho,
-					N:         getRegSize(),
-				}
-
-				if enableGarbageCollection() {
-					// Reset the value of Rho in nPX that might have been changed by previous iteration.
-					nPX = FRAConfiguration{
-						Process:   pX.Process,
-						Registers: pX.Registers,
-						Label:     newLabel,
-						Rho:       newRho,
-						N:         getRegSize(),
-					}
-					err := fixGC(&nPX, &nQX)
-					if err != nil {
-						high[hlKey] += 1
-						continue
-					}
-				}
-
-				status = preorderGeneric(state, nPX, pXId, nQX, qXId, isLeft)
-				if status == ResultRelated {
-					state.createAndAddTransition(&nP, &nQ, &nPX, &nQX, isLeft, edgeLabel, transId,
-						noKPrime, labelsKey)
-				} else {
-					high[hlKey] += 1
-				}
-			}
-		}
-		if status == ResultNotRelated {
-			if isDebug() {
-				fmt.Printf("Not related due to rule 1.\n")
-			}
-		}
+		f.rule = 1
+		f.loop = f.knownLoop(pifra.SymbolTypTau, pifra.SymbolTypTau, 0, 0, trans.Label, nP.Rho)
 	} else if trans.Label.Symbol.Type == pifra.SymbolTypInput &&
 		trans.Label.Symbol2.Type == pifra.SymbolTypKnown {
 		// NT rules 2 and 3, INP1 and INP2
 		i := trans.Label.Symbol.Value
 		pi := nP.Rho[i]
 		j := trans.Label.Symbol2.Value
-		var nPX, nQX FRAConfiguration
 		// check if j is in domain of rho
 		if _, ok := nP.Rho[j]; ok {
 			IC.inp1Rule++
 			pj := nP.Rho[j]
 			// if in domain then rule 2, INP1
+			f.rule = 2
 			if isDebug() {
 				fmt.Printf("For nP=%s. The original transitions is %d%d, the translation is %d%d.\n", fmt.Sprint(nP), i, j, pi, pj)
 			}
-			newLabel = pifra.Label{
+			newLabel := pifra.Label{
 				Symbol:  pifra.Symbol{Type: pifra.SymbolTypInput, Value: pi},
 				Symbol2: pifra.Symbol{Type: pifra.SymbolTypKnown, Value: pj},
 			}
-			nPX = FRAConfiguration{
-				Process:   pX.Process,
-				Registers: pX.Registers,
-				Label:     newLabel,
-				Rho:       newRho,
-				N:         getRegSize(),
-			}
-			// Find a matching nQX, this is what SEARCH_HIGH should do
-			nLk := LabelsKey{pifra.SymbolTypInput, pifra.SymbolTypKnown}
-			for idx := high[hlKey]; idx < len(weakAdjRight[qId][nLk]) && status == ResultNotRelated; idx++ {
-				trans2 := weakAdjRight[qId][nLk][idx]
-				if isDebug() {
-					fmt.Println(trans2)
-				}
-				if trans2.Label.Symbol.Type == pifra.SymbolTypInput &&
-					trans2.Label.Symbol2.Type == pifra.SymbolTypKnown &&
-					trans2.Label.Symbol.Value == pi &&
-					trans2.Label.Symbol2.Value == pj {
-					qXId := trans2.Destination
-					qX := rightLts.States[qXId]
-					revRho, err := reverseMap(newRho)
-					if err != nil {
-						high[hlKey] += 1
-						continue
-					}
-					nQX = FRAConfiguration{
-						Process:   qX.Process,
-						Registers: qX.Registers,
-						Rho:       revRho,
-						N:         getRegSize(),
-					}
-
-					if enableGarbageCollection() {
-						nPX = FRAConfiguration{
-							Process:   pX.Process,
-							Registers: pX.Registers,
-							Label:     newLabel,
-							Rho:       newRho,
-							N:         getRegSize(),
-						}
-						err := fixGC(&nPX, &nQX)
-						if err != nil {
-							high[hlKey] += 1
-							continue
-						}
-					}
-
-					status = preorderGeneric(state, nPX, pXId, nQX, qXId, isLeft)
-					if status == ResultRelated {
-						state.createAndAddTransition(&nP, &nQ, &nPX, &nQX, isLeft, edgeLabel, transId,
-							noKPrime, labelsKey)
-						if isDebug() {
-							fmt.Printf("Related due to rule 2 for %s, %s, %s.\n",
-								fmt.Sprint(nPX), fmt.Sprint(nQX), fmt.Sprint(isLeft))
-						}
-					} else {
-						high[hlKey] += 1
-					}
-				}
-			}
-			if status == ResultNotRelated {
-				if isDebug() {
-					fmt.Printf("Not related due to rule 2.\n")
-				}
-			}
+			f.loop = f.knownLoop(pifra.SymbolTypInput, pifra.SymbolTypKnown, pi, pj, newLabel, nP.Rho)
 		} else {
 			IC.inp2Rule++
 			// else rule 3, INP2
-			nLk := LabelsKey{pifra.SymbolTypInput, pifra.SymbolTypFreshInput}
-			for idx := high[hlKey]; idx < len(weakAdjRight[qId][nLk]) && status == ResultNotRelated; idx++ {
-				trans2 := weakAdjRight[qId][nLk][idx]
-				if trans2.Label.Symbol.Type == pifra.SymbolTypInput &&
-					trans2.Label.Symbol2.Type == pifra.SymbolTypFreshInput &&
-					trans2.Label.Symbol.Value == pi {
-					k := trans2.Label.Symbol2.Value
-					qXId := trans2.Destination
-					qX := rightLts.States[qXId]
-					newRho = makeNewRho(nP.Rho, trans.Label.Symbol2.Value, k)
-					if isDebug() {
-						fmt.Println(newRho)
-					}
-					newLabel = pifra.Label{
-						Symbol:  pifra.Symbol{Type: pifra.SymbolTypInput, Value: pi},
-						Symbol2: pifra.Symbol{Type: pifra.SymbolTypKnown, Value: k},
-					}
-					nPX = FRAConfiguration{
-						Process:   pX.Process,
-						Registers: pX.Registers,
-						Label:     newLabel,
-						Rho:       newRho,
-						N:         getRegSize(),
-					}
-					revRho, err := reverseMap(newRho)
-					if err != nil {
-						high[hlKey] += 1
-						continue
-					}
-					nQX = FRAConfiguration{
-						Process:   qX.Process,
-						Registers: qX.Registers,
-						Rho:       revRho,
-						N:         getRegSize(),
-					}
-
-					if enableGarbageCollection() {
-						err := fixGC(&nPX, &nQX)
-						if err != nil {
-							high[hlKey] += 1
-							continue
-						}
-					}
-
-					status = preorderGeneric(state, nPX, pXId, nQX, qXId, isLeft)
-					if status == ResultRelated {
-						state.createAndAddTransition(&nP, &nQ, &nPX, &nQX, isLeft, edgeLabel, transId,
-							noKPrime, labelsKey)
-					} else {
-						high[hlKey] += 1
-					}
-				}
-			}
-			if status == ResultNotRelated {
-				if isDebug() {
-					fmt.Printf("Not related due to rule 3.\n")
-				}
-			}
+			f.rule = 3
+			f.loop = f.freshLoop(pifra.SymbolTypInput, pifra.SymbolTypFreshInput,
+				pifra.SymbolTypKnown, pi)
 		}
-
 	} else if trans.Label.Symbol.Type == pifra.SymbolTypOutput &&
 		trans.Label.Symbol2.Type == pifra.SymbolTypKnown {
 		IC.outRule++
 		// NT rule 5, OUT
+		f.rule = 5
 		i := trans.Label.Symbol.Value
 		pi := nP.Rho[i]
 		j := trans.Label.Symbol2.Value
-		var nPX, nQX FRAConfiguration
 		// check if j in in domain of rho
 		if _, ok := nP.Rho[j]; ok {
 			pj := nP.Rho[j]
-			newLabel = pifra.Label{
+			newLabel := pifra.Label{
 				Symbol:  pifra.Symbol{Type: pifra.SymbolTypOutput, Value: pi},
 				Symbol2: pifra.Symbol{Type: pifra.SymbolTypKnown, Value: pj},
 			}
-			nPX = FRAConfiguration{
-				Process:   pX.Process,
-				Registers: pX.Registers,
-				Label:     newLabel,
-				Rho:       newRho,
-				N:         getRegSize(),
-			}
-			nLk := LabelsKey{pifra.SymbolTypOutput, pifra.SymbolTypKnown}
-			for idx := high[hlKey]; idx < len(weakAdjRight[qId][nLk]) && status == ResultNotRelated; idx++ {
-				trans2 := weakAdjRight[qId][nLk][idx]
-				if trans2.Label.Symbol.Type == pifra.SymbolTypOutput &&
-					trans2.Label.Symbol2.Type == pifra.SymbolTypKnown &&
-					trans2.Label.Symbol.Value == pi &&
-					trans2.Label.Symbol2.Value == pj {
-					qXId := trans2.Destination
-					qX := rightLts.States[qXId]
-					revRho, err := reverseMap(newRho)
-					if err != nil {
-						high[hlKey] += 1
-						continue
-					}
-					nQX = FRAConfiguration{
-						Process:   qX.Process,
-						Registers: qX.Registers,
-						Rho:       revRho,
-						N:         getRegSize(),
-					}
-					if enableGarbageCollection() {
-						nPX = FRAConfiguration{
-							Process:   pX.Process,
-							Registers: pX.Registers,
-							Label:     newLabel,
-							Rho:       newRho,
-							N:         getRegSize(),
-						}
-						err := fixGC(&nPX, &nQX)
-						if err != nil {
-							high[hlKey] += 1
-							continue
-						}
-					}
-					status = preorderGeneric(state, nPX, pXId, nQX, qXId, isLeft)
-					if status == ResultRelated {
-						state.createAndAddTransition(&nP, &nQ, &nPX, &nQX, isLeft, edgeLabel, transId,
-							noKPrime, labelsKey)
-					} else {
-						high[hlKey] += 1
-					}
-				}
-			}
+			f.loop = f.knownLoop(pifra.SymbolTypOutput, pifra.SymbolTypKnown, pi, pj, newLabel, nP.Rho)
 		}
 	} else if trans.Label.Symbol.Type == pifra.SymbolTypInput &&
 		trans.Label.Symbol2.Type == pifra.SymbolTypFreshInput {
@@ -767,235 +954,247 @@ func processDerivativesGeneric(state *CleavelandState, nP FRAConfiguration,
 		// NT rule 4, FINP
 
 		// First half -> find the matching fresh transition, FINP.1
+		f.rule = 4
 		i := trans.Label.Symbol.Value
 		pi := nP.Rho[i]
-		var nPX, nQX FRAConfiguration
-		var edges []gTransition
-		nLk := LabelsKey{pifra.SymbolTypInput, pifra.SymbolTypFreshInput}
-		for idx := high[hlKey]; idx < len(weakAdjRight[qId][nLk]) && status == ResultNotRelated; idx++ {
-			trans2 := weakAdjRight[qId][nLk][idx]
-			if trans2.Label.Symbol.Type == pifra.SymbolTypInput &&
-				trans2.Label.Symbol2.Type == pifra.SymbolTypFreshInput &&
-				trans2.Label.Symbol.Value == pi {
-				if isDebug() {
-					fmt.Printf("Rule 4.1 trans2: %s\n", fmt.Sprint(trans2))
-				}
-				k := trans2.Label.Symbol2.Value
-				qXId := trans2.Destination
-				qX := rightLts.States[qXId]
-				newRho = makeNewRho(nP.Rho, trans.Label.Symbol2.Value, k)
-				newLabel = pifra.Label{
-					Symbol:  pifra.Symbol{Type: pifra.SymbolTypInput, Value: pi},
-					Symbol2: pifra.Symbol{Type: pifra.SymbolTypFreshInput, Value: k},
-				}
-				nPX = FRAConfiguration{
-					Process:   pX.Process,
-					Registers: pX.Registers,
-					Label:     newLabel,
-					Rho:       newRho,
-					N:         getRegSize(),
-				}
-				revRho, err := reverseMap(newRho)
-				if err != nil {
-					high[hlKey] += 1
-					continue
-				}
-				nQX = FRAConfiguration{
-					Process:   qX.Process,
-					Registers: qX.Registers,
-					Rho:       revRho,
-					N:         getRegSize(),
-				}
-				if enableGarbageCollection() {
-					err := fixGC(&nPX, &nQX)
-					if err != nil {
-						high[hlKey] += 1
-						continue
-					}
-				}
-				status = preorderGeneric(state, nPX, pXId, nQX, qXId, isLeft)
-				if status == ResultRelated {
-					var edge *gTransition = createEdge(&nP, &nQ, &nPX, &nQX, isLeft, edgeLabel, transId, noKPrime, labelsKey)
-					edges = append(edges, *edge)
-				} else {
-					high[hlKey] += 1
-				}
-			}
-		}
-		// new version of FINP.2
-		if status == ResultRelated {
-			var image = getImage(nP.Rho)
-			// for each item in range but not in image
-			var kPrimes = make(map[int]bool)
-			// Create kPrimes - indices of all the registers in nQ that are not empty, but
-			// which are not in the image of nP
-			for idx := range nQ.Registers.Registers {
-				// idx is assumed to always be a non-empty register.
-				if _, ok := image[idx]; !ok {
-					kPrimes[idx] = false
-				}
-			}
-
-			// check every kPrime for a matching transition
-			if isDebug() {
-				fmt.Printf("kPrimes are: %s.\n", fmt.Sprint(kPrimes))
-			}
-		kPrimesLoop:
-			for i := range kPrimes {
-				pj := i
-				var nPX2, nQX2 FRAConfiguration
-				// basically copy INP1 with modifications
-				newLabel = pifra.Label{
-					Symbol:  pifra.Symbol{Type: pifra.SymbolTypInput, Value: pi},
-					Symbol2: pifra.Symbol{Type: pifra.SymbolTypKnown, Value: pj},
-				}
-				newRho = makeNewRho(nP.Rho, trans.Label.Symbol2.Value, pj)
-				nPX2 = FRAConfiguration{
-					Process:   pX.Process,
-					Registers: pX.Registers,
-					Label:     newLabel,
-					Rho:       newRho,
-					N:         getRegSize(),
-				}
-				kStatus := ResultNotRelated
-				hlPrimeKey := HLKeyFINP{
-					Dest:   hlKey.Dest,
-					Src:    hlKey.Src,
-					Act:    hlKey.Act,
-					KPrime: pj,
-				}
-
-				if _, ok := highTwo[hlPrimeKey]; !ok {
-					highTwo[hlPrimeKey] = 0
-				}
-				nLk := LabelsKey{pifra.SymbolTypInput, pifra.SymbolTypKnown}
-				for idx := highTwo[hlPrimeKey]; idx < len(weakAdjRight[qId][nLk]) && kStatus == ResultNotRelated; idx++ {
-					trans2 := weakAdjRight[qId][nLk][idx]
-					if isDebug() {
-						fmt.Printf("Rule 4.2 trans2 preprocess: %s\n", fmt.Sprint(trans2))
-					}
-					if trans2.Label.Symbol.Type == pifra.SymbolTypInput &&
-						trans2.Label.Symbol2.Type == pifra.SymbolTypKnown &&
-						trans2.Label.Symbol.Value == pi &&
-						trans2.Label.Symbol2.Value == pj {
-						if isDebug() {
-							fmt.Printf("Rule 4.2 trans2: %s\n", fmt.Sprint(trans2))
-						}
-						qXId := trans2.Destination
-						qX := rightLts.States[qXId]
-						revRho, err := reverseMap(newRho)
-						if err != nil {
-							highTwo[hlPrimeKey]++
-							continue
-						}
-						nQX2 = FRAConfiguration{
-							Process:   qX.Process,
-							Registers: qX.Registers,
-							Rho:       revRho,
-							N:         getRegSize(),
-						}
-						if enableGarbageCollection() {
-							nPX2 = FRAConfiguration{
-								Process:   pX.Process,
-								Registers: pX.Registers,
-								Label:     newLabel,
-								Rho:       newRho,
-								N:         getRegSize(),
-							}
-							err := fixGC(&nPX2, &nQX2)
-							if err != nil {
-								highTwo[hlPrimeKey]++
-								continue
-							}
-						}
-						kStatus = preorderGeneric(state, nPX2, pXId, nQX2, qXId, isLeft)
-						if status == ResultRelated {
-							var edge *gTransition = createEdge(&nP, &nQ, &nPX2, &nQX2, isLeft, edgeLabel, transId, pj, labelsKey)
-							edges = append(edges, *edge)
-						} else {
-							highTwo[hlPrimeKey]++
-						}
-					}
-				}
-				if kStatus == ResultNotRelated {
-					// the current invocation to processDerivatives failed. Can remove
-					status = ResultNotRelated
-					break kPrimesLoop
-				}
-			}
-			if status == ResultRelated {
-				for ii := range edges {
-					state.addTransition(edges[ii])
-				}
-			}
-		}
-
+		f.loop = f.freshLoop(pifra.SymbolTypInput, pifra.SymbolTypFreshInput,
+			pifra.SymbolTypFreshInput, pi)
 	} else if trans.Label.Symbol.Type == pifra.SymbolTypOutput &&
 		trans.Label.Symbol2.Type == pifra.SymbolTypFreshOutput {
 		IC.foutRule++
 		// NT rule 6, FOUT
 		// find a matching transitions from the pair state
+		f.rule = 6
 		i := trans.Label.Symbol.Value
 		pi := nP.Rho[i]
-		var nPX, nQX FRAConfiguration
-		nLk := LabelsKey{pifra.SymbolTypOutput, pifra.SymbolTypFreshOutput}
-		for idx := high[hlKey]; idx < len(weakAdjRight[qId][nLk]) && status == ResultNotRelated; idx++ {
-			trans2 := weakAdjRight[qId][nLk][idx]
-			if trans2.Label.Symbol.Type == pifra.SymbolTypOutput &&
-				trans2.Label.Symbol2.Type == pifra.SymbolTypFreshOutput &&
-				trans2.Label.Symbol.Value == pi {
-				k := trans2.Label.Symbol2.Value
-				qXId := trans2.Destination
-				qX := rightLts.States[qXId]
-				newRho = makeNewRho(nP.Rho, trans.Label.Symbol2.Value, k)
-				newLabel = pifra.Label{
-					Symbol:  pifra.Symbol{Type: pifra.SymbolTypOutput, Value: pi},
-					Symbol2: pifra.Symbol{Type: pifra.SymbolTypFreshOutput, Value: k},
-				}
-				nPX = FRAConfiguration{
-					Process:   pX.Process,
-					Registers: pX.Registers,
-					Label:     newLabel,
-					Rho:       newRho,
-					N:         getRegSize(),
-				}
-				revRho, err := reverseMap(newRho)
-				if err != nil {
-					high[hlKey] += 1
-					continue
-				}
-				nQX = FRAConfiguration{
-					Process:   qX.Process,
-					Registers: qX.Registers,
-					Rho:       revRho,
-					N:         getRegSize(),
-				}
-				if enableGarbageCollection() {
-					err := fixGC(&nPX, &nQX)
-					if err != nil {
-						high[hlKey] += 1
-						continue
-					}
-				}
-				status = preorderGeneric(state, nPX, pXId, nQX, qXId, isLeft)
-				if status == ResultRelated {
-					state.createAndAddTransition(&nP, &nQ, &nPX, &nQX, isLeft, edgeLabel, transId,
-						noKPrime, labelsKey)
-				} else {
-					high[hlKey] += 1
-				}
+		f.loop = f.freshLoop(pifra.SymbolTypOutput, pifra.SymbolTypFreshOutput,
+			pifra.SymbolTypFreshOutput, pi)
+	}
+	return true
+}
+
+// The derivatives of an NT rule, where nP moves to pX with the given label and
+// rho, and nQ to qX, related by the inverse of rho. Returns false if rho is not
+// a bijection, or does not survive the garbage collection.
+func ntDerivatives(pX pifra.Configuration, qX pifra.Configuration,
+	label pifra.Label, rho map[int]int, gc bool) (FRAConfiguration, FRAConfiguration, bool) {
+	nPX := FRAConfiguration{
+		Process:   pX.Process,
+		Registers: pX.Registers,
+		Label:     label,
+		Rho:       rho,
+		N:         getRegSize(),
+	}
+	revRho, err := reverseMap(rho)
+	if err != nil {
+		return nPX, FRAConfiguration{}, false
+	}
+	nQX := FRAConfiguration{
+		Process:   qX.Process,
+		Registers: qX.Registers,
+		Rho:       revRho,
+		N:         getRegSize(),
+	}
+	if gc {
+		if err := fixGC(&nPX, &nQX); err != nil {
+			return nPX, nQX, false
+		}
+	}
+	return nPX, nQX, true
+}
+
+// Record a pair of related derivatives as an edge of G, or move the high
+// pointer past the candidate if they are not related.
+func (f *matchFrame) doneEdge(res ResultType, nPX *FRAConfiguration, nQX *FRAConfiguration) {
+	if res == ResultRelated {
+		f.state.createAndAddTransition(&f.nP, &f.nQ, nPX, nQX, f.isLeft, f.edgeLabel, f.transId,
+			noKPrime, f.labelsKey)
+		if isDebug() && f.rule == 2 {
+			fmt.Printf("Related due to rule 2 for %s, %s, %s.\n",
+				fmt.Sprint(*nPX), fmt.Sprint(*nQX), fmt.Sprint(f.isLeft))
+		}
+	} else {
+		f.high[f.hlKey] += 1
+	}
+}
+
+// The loop of the rules where the label is fully determined by rho: TAU, INP1
+// and OUT. The candidates have to carry exactly the label (t1 pi, t2 pj).
+func (f *matchFrame) knownLoop(t1 pifra.SymbolType, t2 pifra.SymbolType,
+	pi int, pj int, newLabel pifra.Label, newRho map[int]int) *matchLoop {
+	l := newMatchLoop(f.weakAdjRight[f.qId][LabelsKey{t1, t2}], f.high[f.hlKey])
+	l.try = func(trans2 pifra.Transition) (FRAConfiguration, FRAConfiguration, bool) {
+		if isDebug() && t1 == pifra.SymbolTypInput {
+			fmt.Println(trans2)
+		}
+		// TODO: this check should be redundant in theory.
+		if trans2.Label.Symbol.Type != t1 {
+			return FRAConfiguration{}, FRAConfiguration{}, false
+		}
+		if t1 != pifra.SymbolTypTau && (trans2.Label.Symbol2.Type != t2 ||
+			trans2.Label.Symbol.Value != pi ||
+			trans2.Label.Symbol2.Value != pj) {
+			return FRAConfiguration{}, FRAConfiguration{}, false
+		}
+		nPX, nQX, ok := ntDerivatives(f.pX, f.rightLts.States[trans2.Destination], newLabel, newRho, f.state.GC)
+		if !ok {
+			f.high[f.hlKey] += 1
+		}
+		return nPX, nQX, ok
+	}
+	l.done = f.doneEdge
+	return l
+}
+
+// The loop of the rules where the candidates carry a fresh name in register k,
+// which extends rho: INP2, FINP.1 and FOUT. The derivative of nP is labelled
+// with (t1 pi, newT2 k).
+func (f *matchFrame) freshLoop(t1 pifra.SymbolType, t2 pifra.SymbolType,
+	newT2 pifra.SymbolType, pi int) *matchLoop {
+	trans := f.trans
+	l := newMatchLoop(f.weakAdjRight[f.qId][LabelsKey{t1, t2}], f.high[f.hlKey])
+	l.try = func(trans2 pifra.Transition) (FRAConfiguration, FRAConfiguration, bool) {
+		if trans2.Label.Symbol.Type != t1 ||
+			trans2.Label.Symbol2.Type != t2 ||
+			trans2.Label.Symbol.Value != pi {
+			return FRAConfiguration{}, FRAConfiguration{}, false
+		}
+		if isDebug() && f.rule == 4 {
+			fmt.Printf("Rule 4.1 trans2: %s\n", fmt.Sprint(trans2))
+		}
+		k := trans2.Label.Symbol2.Value
+		newRho := makeNewRho(f.nP.Rho, trans.Label.Symbol2.Value, k)
+		if isDebug() && f.rule == 3 {
+			fmt.Println(newRho)
+		}
+		newLabel := pifra.Label{
+			Symbol:  pifra.Symbol{Type: t1, Value: pi},
+			Symbol2: pifra.Symbol{Type: newT2, Value: k},
+		}
+		nPX, nQX, ok := ntDerivatives(f.pX, f.rightLts.States[trans2.Destination], newLabel, newRho, f.state.GC)
+		if !ok {
+			f.high[f.hlKey] += 1
+		}
+		return nPX, nQX, ok
+	}
+	if f.rule == 4 {
+		l.done = func(res ResultType, nPX *FRAConfiguration, nQX *FRAConfiguration) {
+			if res == ResultRelated {
+				var edge *gTransition = createEdge(&f.nP, &f.nQ, nPX, nQX, f.isLeft, f.edgeLabel, f.transId, noKPrime, f.labelsKey)
+				f.edges = append(f.edges, *edge)
+			} else {
+				f.high[f.hlKey] += 1
 			}
 		}
+	} else {
+		l.done = f.doneEdge
+	}
+	return l
+}
+
+// The kPrimes of FINP.2: the registers of nQ that are not empty, but which are
+// not in the image of the rho of nP either.
+func freshInputKPrimes(nP FRAConfiguration, nQ FRAConfiguration) []int {
+	var kPrimes []int
+	var image = getImage(nP.Rho)
+	for idx := range nQ.Registers.Registers {
+		// idx is assumed to always be a non-empty register.
+		if _, ok := image[idx]; !ok {
+			kPrimes = append(kPrimes, idx)
+		}
+	}
+	sort.Ints(kPrimes)
+	return kPrimes
+}
 
-	} // else tau transition
+// Set up FINP.2. Every register of nQ that is not empty, but is not in the
+// image of rho either, has to be matched by a known input as well.
+func (f *matchFrame) startKPrimes() {
+	f.kPrimes = freshInputKPrimes(f.nP, f.nQ)
 	if isDebug() {
-		fmt.Printf("%d. Exit  processDerivativeGeneric with %s, %s, %s, trans:%s. Status: %d\n",
-			stackDepth,
-			nP.String(), nQ.String(), fmt.Sprint(isLeft), fmt.Sprint(trans), status)
+		fmt.Printf("kPrimes are: %s.\n", fmt.Sprint(f.kPrimes))
 	}
-	stackDepth--
-	if status == ResultNotRelated {
-		IC.failPD++
+	f.ki = 0
+	f.loop = nil
+}
+
+// Push the next candidate for the current kPrime of FINP.2. Returns false once
+// all kPrimes are matched, or one of them could not be.
+func (f *matchFrame) nextKPrime(s *bisimStack, child ResultType) bool {
+	trans := f.trans
+	pi := f.nP.Rho[trans.Label.Symbol.Value]
+	for {
+		if f.loop == nil {
+			if f.ki >= len(f.kPrimes) {
+				break
+			}
+			f.loop = f.kPrimeLoop(pi, f.kPrimes[f.ki])
+		}
+		if f.loop.next(s, child, f.pXId, f.isLeft) {
+			return true
+		}
+		if f.loop.status == ResultNotRelated {
+			// the current invocation to processDerivatives failed. Can remove
+			f.replies = append(f.replies, f.loop.failed...)
+			f.status = ResultNotRelated
+			return false
+		}
+		f.loop = nil
+		f.ki++
+	}
+	if f.status == ResultRelated {
+		for ii := range f.edges {
+			f.state.addTransition(f.edges[ii])
+		}
+	}
+	return false
+}
+
+// The loop of FINP.2 for a single kPrime pj. This is basically INP1 with
+// modifications.
+func (f *matchFrame) kPrimeLoop(pi int, pj int) *matchLoop {
+	newLabel := pifra.Label{
+		Symbol:  pifra.Symbol{Type: pifra.SymbolTypInput, Value: pi},
+		Symbol2: pifra.Symbol{Type: pifra.SymbolTypKnown, Value: pj},
+	}
+	newRho := makeNewRho(f.nP.Rho, f.trans.Label.Symbol2.Value, pj)
+	hlPrimeKey := HLKeyFINP{
+		Dest:   f.hlKey.Dest,
+		Src:    f.hlKey.Src,
+		Act:    f.hlKey.Act,
+		KPrime: pj,
+	}
+	if _, ok := f.highTwo[hlPrimeKey]; !ok {
+		f.highTwo[hlPrimeKey] = 0
+	}
+	nLk := LabelsKey{pifra.SymbolTypInput, pifra.SymbolTypKnown}
+	l := newMatchLoop(f.weakAdjRight[f.qId][nLk], f.highTwo[hlPrimeKey])
+	l.try = func(trans2 pifra.Transition) (FRAConfiguration, FRAConfiguration, bool) {
+		if isDebug() {
+			fmt.Printf("Rule 4.2 trans2 preprocess: %s\n", fmt.Sprint(trans2))
+		}
+		if trans2.Label.Symbol.Type != pifra.SymbolTypInput ||
+			trans2.Label.Symbol2.Type != pifra.SymbolTypKnown ||
+			trans2.Label.Symbol.Value != pi ||
+			trans2.Label.Symbol2.Value != pj {
+			return FRAConfiguration{}, FRAConfiguration{}, false
+		}
+		if isDebug() {
+			fmt.Printf("Rule 4.2 trans2: %s\n", fmt.Sprint(trans2))
+		}
+		nPX2, nQX2, ok := ntDerivatives(f.pX, f.rightLts.States[trans2.Destination], newLabel, newRho, f.state.GC)
+		if !ok {
+			f.highTwo[hlPrimeKey]++
+		}
+		return nPX2, nQX2, ok
+	}
+	l.done = func(res ResultType, nPX2 *FRAConfiguration, nQX2 *FRAConfiguration) {
+		if f.status == ResultRelated {
+			var edge *gTransition = createEdge(&f.nP, &f.nQ, nPX2, nQX2, f.isLeft, f.edgeLabel, f.transId, pj, f.labelsKey)
+			f.edges = append(f.edges, *edge)
+		} else {
+			f.highTwo[hlPrimeKey]++
+		}
 	}
-	return status
+	return l
 }
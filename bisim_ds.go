@@ -57,6 +57,23 @@ type CleavelandState struct {
 	LowTwo     map[HLKeyFINP]int
 	//A           []AKey
 	G gGraph
+	// The free names of the programs, for the symmetry reduction.
+	GlobalNamesLeft  map[string]bool
+	GlobalNamesRight map[string]bool
+	// Why the pairs were found not to be related, by vertex key, and the vertex
+	// key of the start pair. Used to build a distinguishing path.
+	Failures map[string]pairFailure
+	StartKey string
+	// The start pair, and the order in which the pairs were added to notR, by
+	// vertex key. A pair is only found not to be related from pairs that were
+	// added before it, so following the order ends a distinguishing path.
+	StartPos  gamePosition
+	NotROrder map[string]int
+	// The failure of the last match frame that finished, or nil if it found a
+	// match.
+	lastFailure *pairFailure
+	// Whether the registers of the derivatives are garbage collected.
+	GC bool
 }
 
 type ResultType int
@@ -192,6 +209,7 @@ func NewCleavelandState(leftLts pifra.Lts, rightLts pifra.Lts,
 	state.AdjRight = ToAdvAdjacency(rightLts)
 	state.WeakAdjLeft = ToAdvAdjacency(weakLeftLts)
 	state.WeakAdjRight = ToAdvAdjacency(weakRightLts)
+	state.GC = enableGarbageCollection()
 
 	// A set \hat{R} (notR) that stores all state pairs that have been determined
 	// to not be related.
@@ -235,9 +253,77 @@ func NewCleavelandState(leftLts pifra.Lts, rightLts pifra.Lts,
 	state.G.TransitionsDstMap = make(map[string]map[string]*gTransition)
 	state.G.TransitionsSet = make(map[string]*gTransition)
 
+	state.Failures = make(map[string]pairFailure)
+	state.NotROrder = make(map[string]int)
+	state.GlobalNamesLeft = globalNames(leftLts)
+	state.GlobalNamesRight = globalNames(rightLts)
+
 	return &state
 }
 
+// #############################################################################
+// ############################## WORK STACK ###################################
+// #############################################################################
+
+// A frame of the explicit work stack that drives preorder. step is called when
+// the frame is first pushed, and then again with the verdict of each child frame
+// that it pushes. It returns done once its own verdict is known, and must not
+// push anything in that case.
+type bisimFrame interface {
+	step(s *bisimStack, child ResultType) (res ResultType, done bool)
+}
+
+// The work stack replacing the recursion between BISIM() and MATCH_LEFT() or
+// MATCH_RIGHT(). Only the frame on top of the stack can make progress, so the
+// search is depth-first, but it can be paused between any two steps and then
+// resumed by calling run again. With a depth bound, the pairs below it are
+// assumed to be related, see preorder for the iterative deepening.
+type bisimStack struct {
+	state  *CleavelandState
+	frames []bisimFrame
+	// The verdict of the last frame popped off the stack. Once the stack is
+	// empty this is the verdict of the whole search.
+	result ResultType
+	steps  uint64
+	// If set, checked before every step. The search pauses if it returns true.
+	pause func() bool
+	// The bound on the number of BISIM() frames on the stack, or 0, the
+	// current number, and whether a pair was assumed at the bound.
+	maxDepth int
+	depth    int
+	cut      bool
+}
+
+func newBisimStack(state *CleavelandState) *bisimStack {
+	return &bisimStack{state: state, result: ResultNotRelated}
+}
+
+func (s *bisimStack) push(f bisimFrame) {
+	s.frames = append(s.frames, f)
+	if len(s.frames) > IC.maxWorkStackSize {
+		IC.maxWorkStackSize = len(s.frames)
+	}
+}
+
+// Step through the frames until the stack is empty or the search is paused.
+// Returns true if the search has finished.
+func (s *bisimStack) run() bool {
+	for len(s.frames) > 0 {
+		if s.pause != nil && s.pause() {
+			return false
+		}
+		top := len(s.frames) - 1
+		res, done := s.frames[top].step(s, s.result)
+		s.steps++
+		if done {
+			s.frames[top] = nil
+			s.frames = s.frames[:top]
+			s.result = res
+		}
+	}
+	return true
+}
+
 // pid is the state in original old LTS.
 func (s *CleavelandState) addNState(config FRAConfiguration, pid int, isLeft bool) uint64 {
 	key := getFRAConfigurationKey(config, isLeft)
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the persistent cache of bisimulation results.
+//
+// A result is stored in its own file, named after a hash of everything that
+// determines it: the content of both LTSs, the equivalence mode, N and the
+// initial rho.
+
+// Bump this whenever a change to the algorithm can change a stored result, or
+// the entries change.
+const cacheVersion = 2
+
+type cacheEntry struct {
+	Version int
+	Result  ResultType
+	Mode    string
+	N       int
+	Rho     map[int]int
+	Created time.Time
+	// The distinguishing path of a negative result, in the polyadic form, which
+	// is printed with -v and written with -output-result.
+	Path []htmlPathStep `json:",omitempty"`
+	// The related pairs of states, only stored with -cache-relation or
+	// -output-result. It is null if it was not stored.
+	Relation []relatedPair
+}
+
+// Whether an entry has everything the check asks for, i.e. the relation with
+// -output-result.
+func (entry cacheEntry) serves() bool {
+	return getOutputResultName() == "" || entry.Relation != nil
+}
+
+// The default location of the cache, under the user's cache directory.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "pisim22")
+}
+
+func getCacheDir() string {
+	if cacheDir == "" {
+		return defaultCacheDir()
+	}
+	return cacheDir
+}
+
+// A hash of the content of the LTS. It does not depend on the order of the
+// transitions, nor on anything that is not used by the bisimulation check.
+func ltsContentHash(lts pifra.Lts) string {
+	h := sha256.New()
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		conf := lts.States[id]
+		fmt.Fprintf(h, "s %d %t %s\n", id, lts.RegSizeReached[id], pifraStateKey(&conf))
+	}
+	var trans []string
+	for _, t := range lts.Transitions {
+		trans = append(trans, fmt.Sprint(t))
+	}
+	sort.Strings(trans)
+	for _, t := range trans {
+		fmt.Fprintf(h, "t %s\n", t)
+	}
+	var names []string
+	for k := range lts.FreeNamesMap {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(h, "f %s %s\n", k, lts.FreeNamesMap[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// The equivalence mode a result was computed in, as given by the flags.
+func cacheMode() string {
+	return cacheModeOf(isWeakBisim(), enableGarbageCollection())
+}
+
+func cacheModeOf(weak bool, gc bool) string {
+	var sb strings.Builder
+	if weak {
+		sb.WriteString("weak")
+	} else {
+		sb.WriteString("strong")
+	}
+	if gc {
+		sb.WriteString("+gc")
+	}
+	return sb.String()
+}
+
+// The key of a result. The weak LTSs are hashed as well if they differ from the
+// strong ones, so that the key tells the two modes apart even when checkBisim
+// is called directly. The weak transform adds a tau loop to every state, so
+// comparing the number of transitions is enough to tell.
+func bisimCacheKey(leftLts pifra.Lts, rightLts pifra.Lts,
+	weakLeftLts pifra.Lts, weakRightLts pifra.Lts, mode string, n int, rho map[int]int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "v%d\n%s\n%s\n", cacheVersion,
+		ltsContentHash(leftLts), ltsContentHash(rightLts))
+	if len(weakLeftLts.Transitions) != len(leftLts.Transitions) ||
+		len(weakRightLts.Transitions) != len(rightLts.Transitions) {
+		fmt.Fprintf(h, "%s\n%s\n",
+			ltsContentHash(weakLeftLts), ltsContentHash(weakRightLts))
+	}
+	fmt.Fprintf(h, "%s\n%d\n%s\n", mode, n, fmt.Sprint(rho))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func cacheEntryPath(key string) string {
+	return filepath.Join(getCacheDir(), key[:2], key+".json")
+}
+
+// Look up a stored result. A missing or unreadable entry is a miss.
+func loadCacheEntry(key string) (cacheEntry, bool) {
+	var entry cacheEntry
+	data, err := ioutil.ReadFile(cacheEntryPath(key))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Version != cacheVersion {
+		return entry, false
+	}
+	return entry, true
+}
+
+func storeCacheEntry(key string, entry cacheEntry) error {
+	entry.Version = cacheVersion
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that a concurrent reader never sees
+	// a partially written entry.
+	name := cacheEntryPath(key)
+	tmp := fmt.Sprintf("%s.%d.tmp", name, os.Getpid())
+	if err := writeFile(tmp, data); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// Remove all the stored results.
+func purgeCache() error {
+	return os.RemoveAll(getCacheDir())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/yungene/pifra"
+)
+
+func TestLtsContentHashIgnoresTransitionOrder(t *testing.T) {
+	var states = map[int]pifra.Configuration{
+		0: {Process: &pifra.ElemNil{}},
+		1: {Process: &pifra.ElemNil{}},
+	}
+	var trans1 = pifra.Transition{Source: 0, Destination: 1,
+		Label: pifra.Label{Symbol: pifra.Symbol{Type: pifra.SymbolTypTau}}}
+	var trans2 = pifra.Transition{Source: 1, Destination: 0,
+		Label: pifra.Label{Symbol: pifra.Symbol{Type: pifra.SymbolTypTau}}}
+	a := pifra.Lts{States: states, Transitions: []pifra.Transition{trans1, trans2}}
+	b := pifra.Lts{States: states, Transitions: []pifra.Transition{trans2, trans1}}
+	c := pifra.Lts{States: states, Transitions: []pifra.Transition{trans1}}
+	if ltsContentHash(a) != ltsContentHash(b) {
+		t.Errorf("Hash depends on the order of the transitions.")
+	}
+	if ltsContentHash(a) == ltsContentHash(c) {
+		t.Errorf("Hash does not depend on the transitions.")
+	}
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	oldDir := cacheDir
+	cacheDir = t.TempDir()
+	defer func() { cacheDir = oldDir }()
+
+	key := bisimCacheKey(pifra.Lts{}, pifra.Lts{}, pifra.Lts{}, pifra.Lts{}, cacheMode(), 2, map[int]int{1: 2})
+	if _, ok := loadCacheEntry(key); ok {
+		t.Fatalf("Found an entry in an empty cache.")
+	}
+	err := storeCacheEntry(key, cacheEntry{Result: ResultRelated, N: 2, Relation: []relatedPair{{0, 0, map[int]int{1: 1}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := loadCacheEntry(key)
+	if !ok || entry.Result != ResultRelated || len(entry.Relation) != 1 {
+		t.Errorf("Stored entry was not loaded back. Got %v.", entry)
+	}
+	if err := purgeCache(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadCacheEntry(key); ok {
+		t.Errorf("Found an entry after purging the cache.")
+	}
+}
+
+// A cached result is used with -v and -output-result, which are served from
+// the distinguishing path and the relation of the entry.
+func TestCacheServesPathAndResult(t *testing.T) {
+	oldDir, oldUse, oldVerbose, oldQuiet, oldResult := cacheDir, useCache, verbose, quiet, outputResultName
+	cacheDir, useCache, quiet = t.TempDir(), true, true
+	defer func() {
+		cacheDir, useCache, verbose, quiet, outputResultName = oldDir, oldUse, oldVerbose, oldQuiet, oldResult
+	}()
+
+	left := analyzeTestLts(t, "P(a) = a'<a>.0\nP(a)\n", 100)
+	right := analyzeTestLts(t, "P(a) = a'<a>.a'<a>.0\nP(a)\n", 100)
+	rho, err := freeNamesRho(left, right, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setRegSize(maxInt(getMaxMinRegSize(left), getMaxMinRegSize(right)))
+	key := bisimCacheKey(left, right, left, right, cacheMode(), getRegSize(), rho)
+
+	// The first check stores the path and, with -output-result, the relation.
+	outputResultName = path.Join(t.TempDir(), "result.json")
+	if res := checkBisim(left, right, left, right, -1, -1, false); res != ResultNotRelated {
+		t.Fatalf("The check was %d.", res)
+	}
+	first, err := ioutil.ReadFile(outputResultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := loadCacheEntry(key)
+	if !ok || len(entry.Path) == 0 || entry.Relation == nil {
+		t.Fatalf("The entry is %v, expected a path and a relation.", entry)
+	}
+	expected := "1. left 0 -1' 1-> 1, matched by right 0 -1' 1-> 1.\n" +
+		"2. right 1 -1' 1-> 2, which left cannot match.\n"
+	if s := pathStepsToString(entry.Path); s != expected {
+		t.Errorf("The stored path is\n%s\nexpected\n%s", s, expected)
+	}
+
+	// The second one is served from the entry, also with -v.
+	verbose = true
+	if err := os.Remove(outputResultName); err != nil {
+		t.Fatal(err)
+	}
+	entry.Created = entry.Created.Add(-time.Hour)
+	if err := storeCacheEntry(key, entry); err != nil {
+		t.Fatal(err)
+	}
+	if res := checkBisim(left, right, left, right, -1, -1, false); res != ResultNotRelated {
+		t.Errorf("The cached check was %d.", res)
+	}
+	second, err := ioutil.ReadFile(outputResultName)
+	if err != nil || string(first) != string(second) {
+		t.Errorf("The result from the cache is\n%s\nexpected\n%s", second, first)
+	}
+	if stored, _ := loadCacheEntry(key); !stored.Created.Equal(entry.Created) {
+		t.Errorf("The check was run again with -v, and the entry refreshed.")
+	}
+
+	// An entry without the relation cannot serve -output-result.
+	entry.Relation = nil
+	entry.Result = ResultRelated
+	if err := storeCacheEntry(key, entry); err != nil {
+		t.Fatal(err)
+	}
+	if res := checkBisim(left, right, left, right, -1, -1, false); res != ResultNotRelated {
+		t.Errorf("An entry without the relation was used with -output-result.")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with `pisim22 classify model...`, which sorts models into the
+// classes of strong and weak bisimilarity. Each LTS is generated once, and a
+// model is only checked against one model of each class, as bisimilarity is
+// transitive. The strong classes are merged into the weak ones, as strongly
+// bisimilar models are also weakly bisimilar.
+
+// The classes of the models under strong or weak bisimilarity.
+type classification struct {
+	weak bool
+	// The models of each class, and the class of each model.
+	classes [][]int
+	class   []int
+	// The checks that were run, and the first steps of the distinguishing paths
+	// of the pairs (i, j) with i < j that were checked and are not bisimilar.
+	checks int
+	firsts map[[2]int]*polyadicPathStep
+}
+
+func (c *classification) add(model int, class int) {
+	if class == len(c.classes) {
+		c.classes = append(c.classes, nil)
+	}
+	c.classes[class] = append(c.classes[class], model)
+	c.class[model] = class
+}
+
+func (c *classification) bisimilar(i int, j int) bool {
+	return c.class[i] == c.class[j]
+}
+
+type classifier struct {
+	names []string
+	store *ltsStore
+	opts  checkOptions
+}
+
+// Check two models, and return the first step of the distinguishing path if
+// they are not bisimilar, or nil if there is none.
+func (c *classifier) check(i int, j int, weak bool) (bool, *polyadicPathStep, error) {
+	opts := c.opts
+	opts.weak = weak
+	keys := []ltsStoreKey{c.store.key(c.names[i], opts), c.store.key(c.names[j], opts)}
+	var lts, weakLts [2]pifra.Lts
+	for k, key := range keys {
+		var err error
+		if lts[k], err = c.store.get(key); err != nil {
+			return false, nil, err
+		}
+		weakLts[k] = lts[k]
+		if weak {
+			if weakLts[k], err = c.store.getWeak(key); err != nil {
+				return false, nil, err
+			}
+		}
+	}
+	rho, err := freeNamesRho(lts[0], lts[1], 0, 0)
+	if err != nil {
+		return false, nil, err
+	}
+	state, _, res, err := preorderPair(lts[0], lts[1], weakLts[0], weakLts[1], 0, 0, rho, opts.n, opts.gc)
+	if err != nil || res == ResultRelated {
+		return true, nil, err
+	}
+	path := polyadicPath(state, distinguishingPath(state), c.store.sorts[keys[0]], c.store.sorts[keys[1]])
+	if isVerbose() {
+		fmt.Printf("%s and %s are not %s:\n%s\n", c.names[i], c.names[j], bisimilarityName(weak),
+			distinguishingPathToString(path))
+	}
+	if len(path) == 0 {
+		return false, nil, nil
+	}
+	return false, &path[0], nil
+}
+
+// Sort the models into classes, by checking each model against the first model
+// of the classes so far. With from, the models start in its classes, which are
+// then merged.
+func (c *classifier) classify(weak bool, from *classification) (*classification, error) {
+	res := &classification{
+		weak:   weak,
+		class:  make([]int, len(c.names)),
+		firsts: make(map[[2]int]*polyadicPathStep),
+	}
+	var groups [][]int
+	if from != nil {
+		groups = from.classes
+	} else {
+		for i := range c.names {
+			groups = append(groups, []int{i})
+		}
+	}
+	for _, group := range groups {
+		class := len(res.classes)
+		for k, other := range res.classes {
+			i, j := other[0], group[0]
+			related, first, err := c.check(i, j, weak)
+			if err != nil {
+				return nil, err
+			}
+			res.checks++
+			if related {
+				class = k
+				break
+			}
+			res.firsts[[2]int{i, j}] = first
+		}
+		for _, model := range group {
+			res.add(model, class)
+		}
+	}
+	return res, nil
+}
+
+// Check the pairs of models in different classes that were not checked, for
+// their distinguishing paths.
+func (c *classifier) distinguish(res *classification) (int, error) {
+	checks := 0
+	for i := range c.names {
+		for j := i + 1; j < len(c.names); j++ {
+			if _, ok := res.firsts[[2]int{i, j}]; ok || res.bisimilar(i, j) {
+				continue
+			}
+			_, first, err := c.check(i, j, res.weak)
+			if err != nil {
+				return checks, err
+			}
+			checks++
+			res.firsts[[2]int{i, j}] = first
+		}
+	}
+	return checks, nil
+}
+
+func bisimilarityName(weak bool) string {
+	if weak {
+		return "weakly bisimilar"
+	}
+	return "strongly bisimilar"
+}
+
+// The first step of the distinguishing path of a pair, as the number of the
+// model that takes it and its label in the names of the model.
+func (c *classifier) firstStepString(i int, j int, step *polyadicPathStep) string {
+	if step == nil {
+		return "-"
+	}
+	model := i
+	if !step.IsLeft {
+		model = j
+	}
+	lts, err := c.store.get(c.store.key(c.names[model], c.opts))
+	if err != nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%s", model+1, newPathNames(&lts).session(step.Trans))
+}
+
+func (c *classifier) printClasses(res *classification) {
+	title := "Strong"
+	if res.weak {
+		title = "Weak"
+	}
+	fmt.Printf("%s bisimilarity: %s.\n", title, plural(len(res.classes), "class"))
+	for k, class := range res.classes {
+		var names []string
+		class = append([]int(nil), class...)
+		sort.Ints(class)
+		for _, model := range class {
+			names = append(names, c.names[model])
+		}
+		fmt.Printf("  %d. %s\n", k+1, strings.Join(names, ", "))
+	}
+}
+
+// A matrix of the verdicts, with = for bisimilar models, and the first step of
+// a distinguishing path otherwise.
+func (c *classifier) printMatrix(res *classification) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "\tMODEL")
+	for j := range c.names {
+		fmt.Fprintf(w, "\t%d", j+1)
+	}
+	fmt.Fprintln(w)
+	for i, name := range c.names {
+		fmt.Fprintf(w, "%d\t%s", i+1, name)
+		for j := range c.names {
+			cell := "="
+			if !res.bisimilar(i, j) {
+				cell = c.firstStepString(minInt(i, j), maxInt(i, j), res.firsts[[2]int{minInt(i, j), maxInt(i, j)}])
+			}
+			fmt.Fprintf(w, "\t%s", cell)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+// The classify command.
+func classifyCommand(args []string) {
+	fs := newCommandFlagSet("classify", "[flags] model...",
+		"Sort models or LTS files into the classes of strong and weak bisimilarity.")
+	matrixFlag := fs.Bool("matrix", false, "Whether to print a matrix of the verdicts, with the first step of a distinguishing path of each pair.")
+	regSizeOverrideFlag := fs.Int("n", -1, "The override for the size of the register.")
+	verboseFlag := fs.Bool("v", false, "Whether to be verbose.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if len(args) < 2 {
+		usageError(fs, fmt.Errorf("expected at least two models or LTS files as arguments, not %d", len(args)))
+	}
+	if err := validateRegSize(*regSizeOverrideFlag); err != nil {
+		usageError(fs, err)
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+	verbose = *verboseFlag
+
+	dir, err := ioutil.TempDir("", "pisim22-classify")
+	check(err)
+	defer os.RemoveAll(dir)
+	prevQuiet := quiet
+	quiet = true
+	defer func() { quiet = prevQuiet }()
+	c := &classifier{
+		names: args,
+		store: newLtsStore(dir),
+		opts:  checkOptions{n: *regSizeOverrideFlag, gc: *pf.gc, maxStates: *pf.maxStates},
+	}
+	strong, err := c.classify(false, nil)
+	check(err)
+	weak, err := c.classify(true, strong)
+	check(err)
+	extra := 0
+	if *matrixFlag {
+		for _, res := range []*classification{strong, weak} {
+			checks, err := c.distinguish(res)
+			check(err)
+			extra += checks
+		}
+	}
+
+	pairs := len(args) * (len(args) - 1) / 2
+	for _, res := range []*classification{strong, weak} {
+		c.printClasses(res)
+		if *matrixFlag {
+			c.printMatrix(res)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Ran %d strong and %d weak checks for the %s, the others were skipped by transitivity.\n",
+		strong.checks, weak.checks, plural(pairs, "pair"))
+	if extra > 0 {
+		fmt.Printf("Ran another %s for the distinguishing paths.\n", plural(extra, "check"))
+	}
+	fmt.Printf("Generated %s and %s.\n", plural(c.store.generated, "LTS"),
+		plural(c.store.transformed, "weak transform"))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	dir := writeCorpus(t, map[string]string{
+		"tau.pi":   "P(a) = a(x).$c.(c'<c>.0 | c(y).P(a))\nP(a)\n",
+		"one.pi":   "P(a) = a(x).P(a)\nP(a)\n",
+		"two.pi":   "P(a) = a(x).a(y).P(a)\nP(a)\n",
+		"out.pi":   "P(a) = a'<a>.P(a)\nP(a)\n",
+		"tau-2.pi": "P(a) = a(x).$c.(c'<c>.0 | c(y).P(a))\nP(a)\n",
+	})
+	var names []string
+	for _, name := range []string{"tau.pi", "one.pi", "two.pi", "out.pi", "tau-2.pi"} {
+		names = append(names, filepath.Join(dir, name))
+	}
+	c := &classifier{names: names, store: newLtsStore(t.TempDir()), opts: checkOptions{n: -1, maxStates: 1000}}
+	strong, err := c.classify(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak, err := c.classify(true, strong)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		res     *classification
+		classes [][]int
+		checks  int
+	}{
+		// Each model is checked against one model of each class.
+		{strong, [][]int{{0, 4}, {1, 2}, {3}}, 6},
+		// Only the first models of the strong classes are checked.
+		{weak, [][]int{{0, 4, 1, 2}, {3}}, 2},
+	}
+	for _, tc := range cases {
+		if !reflect.DeepEqual(tc.res.classes, tc.classes) {
+			t.Errorf("The classes of weak=%t are %v, expected %v.", tc.res.weak, tc.res.classes, tc.classes)
+		}
+		if tc.res.checks != tc.checks {
+			t.Errorf("There were %d checks for weak=%t, expected %d.", tc.res.checks, tc.res.weak, tc.checks)
+		}
+	}
+	if s := c.firstStepString(0, 3, weak.firsts[[2]int{0, 3}]); s != "1:a(n1*)" {
+		t.Errorf("The first step of tau.pi and out.pi is %s.", s)
+	}
+
+	// The other pairs of different classes are checked for the matrix.
+	checks, err := c.distinguish(weak)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checks != 3 {
+		t.Errorf("There were %d checks for the matrix, expected 3.", checks)
+	}
+	if s := c.firstStepString(3, 4, weak.firsts[[2]int{3, 4}]); s != "4:a'<a>" {
+		t.Errorf("The first step of out.pi and tau-2.pi is %s.", s)
+	}
+	if c.store.generated != 5 {
+		t.Errorf("Generated %d LTSs, expected 5.", c.store.generated)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the commands of pisim22, `pisim22 <command> [flags]`.
+// Every command has its own flags, which are validated before anything is run.
+// The flags of check may also be given without a command, as before there were
+// commands.
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+func getCommands() []command {
+	return []command{
+		{"check", "Check whether two models or LTSs are bisimilar.", func(args []string) { checkCommand(args, false) }},
+		{"lts", "Write the LTS of a model, or convert an LTS file.", func(args []string) { ltsCommand(args, false) }},
+		{"weak", "Write the weak transform of the LTS of a model or an LTS file.", func(args []string) { ltsCommand(args, true) }},
+		{"run", "Run the checks of a query file.", runCommand},
+		{"mwb", "Run the eq and weq queries of a Mobility Workbench file.", mwbCommand},
+		{"repl", "Explore the LTSs of models and step through a check.", replCommand},
+		{"game", "Play the bisimulation game against the tool.", gameCommand},
+		{"serve", "Serve a JSON API for running checks as jobs.", serveCommand},
+		{"lsp", "Run a language server for pifra models.", lspCommand},
+		{"test", "Check a corpus of models against their expected verdicts.", testCommand},
+		{"analyze", "Find the deadlocks, tau-livelocks and reachable actions of a model.", analyzeCommand},
+		{"mc", "Check a modal mu-calculus formula on the LTS of a model.", mcCommand},
+		{"info", "Print statistics of the LTS of a model.", infoCommand},
+		{"quotient", "Write a smaller bisimilar LTS and pi-calculus process of a model.", func(args []string) { quotientCommand("quotient", args) }},
+		{"minimize", "Write the minimal bisimilar LTS of a model, as quotient does.", func(args []string) { quotientCommand("minimize", args) }},
+		{"verify", "Re-check a relation saved with -save-relation against two models.", verifyCommand},
+		{"classify", "Sort models into the classes of strong and weak bisimilarity.", classifyCommand},
+		{"help", "Show the flags of a command.", helpCommand},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range getCommands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: pisim22 <command> [flags] [arguments]\n\nCommands:\n")
+	for _, cmd := range getCommands() {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nThe flags of check may also be given without a command, e.g.\n")
+	fmt.Fprintf(w, "  pisim22 -lts1 a.pi -lts2 b.pi -w\n")
+	fmt.Fprintf(w, "\nRun 'pisim22 help <command>' for the flags of a command.\n")
+}
+
+// The help command.
+func helpCommand(args []string) {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return
+	}
+	cmd, ok := findCommand(args[0])
+	if !ok || cmd.name == "help" {
+		fmt.Fprintf(os.Stderr, "pisim22 help: unknown command %q\n\n", args[0])
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	cmd.run([]string{"-h"})
+}
+
+// A flag set of a command, with a usage that describes the command and lists
+// its flags.
+func newCommandFlagSet(name string, synopsis string, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pisim22 %s %s\n\n%s\n\nFlags:\n", name, synopsis, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parse the flags of a command, which may be given before or after its
+// arguments. Returns the arguments.
+func parseCommandFlags(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return rest
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+// Report an invalid use of a command and exit.
+func usageError(fs *flag.FlagSet, err error) {
+	fmt.Fprintf(fs.Output(), "pisim22 %s: %s\nRun 'pisim22 help %s' for usage.\n", fs.Name(), err.Error(), fs.Name())
+	os.Exit(2)
+}
+
+// ####
+// Flags shared by the commands.
+// ####
+
+// The flags passed on to pifra.
+type pifraFlagValues struct {
+	gc        *bool
+	maxStates *int
+}
+
+func addPifraFlags(fs *flag.FlagSet) pifraFlagValues {
+	return pifraFlagValues{
+		gc:        fs.Bool("gc", false, "Whether to enable garbage collection."),
+		maxStates: fs.Int("max-states", 15000, "Max states in an LTS."),
+	}
+}
+
+func (v pifraFlagValues) validate() error {
+	if *v.maxStates <= 0 {
+		return fmt.Errorf("-max-states has to be positive, not %d", *v.maxStates)
+	}
+	return nil
+}
+
+// The options of pifra. Also enables garbage collection in pisim22 with -gc.
+func (v pifraFlagValues) flags() pifra.Flags {
+	enableGC = *v.gc
+	return pifra.Flags{
+		MaxStates:    *v.maxStates,
+		RegisterSize: 1073741824,
+		DisableGC:    !enableGarbageCollection(),
+		Gob:          true,
+		Statistics:   isVerbose(),
+	}
+}
+
+func validateRegSize(n int) error {
+	if n != -1 && n <= 0 {
+		return fmt.Errorf("-n has to be positive, not %d", n)
+	}
+	return nil
+}
+
+// ####
+// Reading and writing LTSs.
+// ####
+
+// Load an LTS file, or generate the LTS of a model with pifra into dir.
+func loadOrGenerateLts(name string, dir string, flags pifra.Flags) (pifra.Lts, polyadicSorts, error) {
+	if isLoadedLtsFile(name) {
+		lts, err := loadLTS(name)
+		return lts, nil, err
+	}
+	outputFile := filepath.Join(dir, strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))+".gob")
+	flags.InputFile = name
+	flags.OutputFile = outputFile
+	sorts, err := generatePifraLts(flags)
+	if err != nil {
+		return pifra.Lts{}, nil, err
+	}
+	lts, err := decodeLTS(outputFile)
+	return lts, sorts, err
+}
+
+// The writers of the LTS formats, by extension.
+var ltsWriters = map[string]func(name string, lts pifra.Lts, sorts polyadicSorts) error{
+	".dot": func(name string, lts pifra.Lts, sorts polyadicSorts) error {
+		return writeFile(name, generateGraphVizFile(lts, sorts))
+	},
+	autExt: func(name string, lts pifra.Lts, sorts polyadicSorts) error {
+		return writeAutFile(name, lts)
+	},
+	jsonExt: func(name string, lts pifra.Lts, sorts polyadicSorts) error {
+		return writeJsonLts(name, lts)
+	},
+	".gob": func(name string, lts pifra.Lts, sorts polyadicSorts) error {
+		return encodeLTS(name, lts)
+	},
+	fraExt: func(name string, lts pifra.Lts, sorts polyadicSorts) error {
+		return writeFraFile(name, lts)
+	},
+}
+
+func ltsFormats() string {
+	var exts []string
+	for ext := range ltsWriters {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return strings.Join(exts, ", ")
+}
+
+func checkLtsFormat(name string) error {
+	if _, ok := ltsWriters[filepath.Ext(name)]; !ok {
+		return fmt.Errorf("unknown format of %s, expected one of %s", name, ltsFormats())
+	}
+	return nil
+}
+
+// Write an LTS in the format given by the extension of the file. With sorts,
+// the sessions of polyadic prefixes are collapsed in the DOT format.
+func writeLtsFile(name string, lts pifra.Lts, sorts polyadicSorts) error {
+	if err := checkLtsFormat(name); err != nil {
+		return err
+	}
+	return ltsWriters[filepath.Ext(name)](name, lts, sorts)
+}
+
+// ####
+// The commands.
+// ####
+
+// The lts and weak commands.
+func ltsCommand(args []string, weak bool) {
+	name := "lts"
+	description := "Write the LTS of a model generated by pifra, or convert an LTS file to another format."
+	if weak {
+		name = "weak"
+		description = "Write the weak transform of the LTS of a model or of an LTS file, as used by weak checks."
+	}
+	fs := newCommandFlagSet(name, "[flags] -o file model", description)
+	outFlag := fs.String("o", "", "[REQUIRED] A path to write the LTS to. The format is given by the extension: "+ltsFormats()+".")
+	verboseFlag := fs.Bool("v", false, "Whether to be verbose.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if len(args) != 1 {
+		usageError(fs, fmt.Errorf("expected one model or LTS file as argument, not %d", len(args)))
+	}
+	if *outFlag == "" {
+		usageError(fs, fmt.Errorf("-o is required"))
+	}
+	if err := checkLtsFormat(*outFlag); err != nil {
+		usageError(fs, err)
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+	verbose = *verboseFlag
+
+	dir, err := ioutil.TempDir("", "pisim22-lts")
+	check(err)
+	defer os.RemoveAll(dir)
+	lts, sorts, err := loadOrGenerateLts(args[0], dir, pf.flags())
+	check(err)
+	if weak {
+		weakLts := doWeakTransform(lts)
+		if isVerbose() {
+			fmt.Printf("Originally there were %d states and %d transitions. With weak tranform there are now %d states and %d transitions.\n",
+				len(lts.States), len(lts.Transitions), len(weakLts.States), len(weakLts.Transitions))
+		}
+		lts = weakLts
+	}
+	check(writeLtsFile(*outFlag, lts, sorts))
+}
+
+// The mwb command.
+func mwbCommand(args []string) {
+	fs := newCommandFlagSet("mwb", "[flags] file.mwb",
+		"Run the eq (strong) and weq (weak) queries of a file in the syntax of the Mobility Workbench.")
+	regSizeOverrideFlag := fs.Int("n", -1, "The override for the size of the register.")
+	verboseFlag := fs.Bool("v", false, "Whether to be verbose.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if len(args) != 1 {
+		usageError(fs, fmt.Errorf("expected one MWB file as argument, not %d", len(args)))
+	}
+	if err := validateRegSize(*regSizeOverrideFlag); err != nil {
+		usageError(fs, err)
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+	verbose = *verboseFlag
+	check(runMwbFile(args[0], pf.flags(), *regSizeOverrideFlag))
+}
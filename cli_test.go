@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCheckFlags(t *testing.T) {
+	cases := []struct {
+		args   []string
+		legacy bool
+		err    string
+	}{
+		{[]string{"-lts1", "a.pi", "-lts2", "b.pi", "-w"}, true, ""},
+		{[]string{"a.pi", "b.pi", "-w", "-n", "3"}, false, ""},
+		{[]string{"-purge-cache"}, false, ""},
+		{[]string{"-mwb", "a.mwb"}, true, ""},
+		{[]string{"-lts1", "a.pi"}, false, "-lts2 is required"},
+		{[]string{"a.pi"}, false, "expected two models"},
+		{[]string{"-lts1", "a.pi", "b.pi", "c.pi"}, false, "not both"},
+		{[]string{"-lts1", "a.pi", "-gob1", "a.gob", "-lts2", "b.pi"}, false, "cannot both be given"},
+		{[]string{"a.pi", "b.pi", "-n", "0"}, false, "-n has to be positive"},
+		{[]string{"a.pi", "b.pi", "-closure-algo", "3"}, false, "-closure-algo"},
+		{[]string{"a.pi", "b.pi", "-max-states", "0"}, false, "-max-states"},
+		{[]string{"a.pi", "b.pi", "-cache-relation", "-no-cache"}, false, "-cache-relation"},
+		{[]string{"a.pi", "b.pi", "-out", "x"}, true, "needs -w"},
+		{[]string{"a.pi", "b.pi", "-w", "-out", "x", "-output-html", "x.html"}, true, "-output-html"},
+		{[]string{"-mwb", "a.mwb", "a.pi", "b.pi"}, true, "-mwb"},
+		{[]string{"a.pi", "b.pi", "-watch", "-output-html", "x.html"}, false, ""},
+		{[]string{"a.pi", "b.pi", "-watch", "-output-aut", "x"}, false, "-output-aut"},
+		{[]string{"a.pi", "b.pi", "-w", "-watch", "-out", "x"}, true, "-watch"},
+		{[]string{"a.pi", "b.pi", "-watch", "-watch-interval", "0s"}, false, "-watch-interval"},
+		{[]string{"a.pi", "b.pi", "-search", "deepening", "-checkpoint", "x.json"}, false, ""},
+		{[]string{"a.pi", "b.pi", "-search", "random"}, false, "-search"},
+		{[]string{"a.pi", "b.pi", "-search", "deepening", "-warm-start", "x.json"}, false, "-warm-start"},
+		{[]string{"a.pi", "b.pi", "-checkpoint", "x.json", "-watch"}, false, "-watch"},
+		{[]string{"a.pi", "b.pi", "-checkpoint-interval", "0s"}, false, "-checkpoint-interval"},
+		{[]string{"a.pi", "b.pi", "-gc", "-warm-start", "x.json"}, false, "-warm-start"},
+		{[]string{"a.pi", "b.pi", "-gc", "-checkpoint", "x.json"}, false, "-gc"},
+		{[]string{"a.pi", "b.pi", "-gc", "-save-relation", "x.json"}, false, ""},
+	}
+	for _, c := range cases {
+		fs, v := newCheckFlagSet(c.legacy)
+		args := parseCommandFlags(fs, c.args)
+		err := v.validate(args)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%v gave the error: %s.", c.args, err.Error())
+		case c.err != "" && err == nil:
+			t.Errorf("%v gave no error, expected %q.", c.args, c.err)
+		case c.err != "" && !strings.Contains(err.Error(), c.err):
+			t.Errorf("%v gave the error %q, expected %q.", c.args, err.Error(), c.err)
+		}
+	}
+}
+
+// The lts command can write every format that can be read back.
+func TestWriteLtsFile(t *testing.T) {
+	pwd := getPwd(t)
+	dir := t.TempDir()
+	model := path.Join(pwd, "test", "weak-bisimilar", "buffer-2x1.1.pi")
+	lts, sorts, err := loadOrGenerateLts(model, dir, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []string{autExt, jsonExt, ".gob", fraExt, ".dot"} {
+		name := path.Join(dir, "out"+ext)
+		if err := writeLtsFile(name, lts, sorts); err != nil {
+			t.Fatal(err)
+		}
+		if ext == ".dot" {
+			continue
+		}
+		read, err := loadLTS(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read.States) != len(lts.States) || len(read.Transitions) != len(lts.Transitions) {
+			t.Errorf("%s has %d states and %d transitions, expected %d and %d.", ext,
+				len(read.States), len(read.Transitions), len(lts.States), len(lts.Transitions))
+		}
+	}
+	if err := writeLtsFile(path.Join(dir, "out.txt"), lts, sorts); err == nil {
+		t.Errorf("Expected an error for an unknown format.")
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// This is a file with `pisim22 test dir`, which runs the pairs of models
+// a.1.pi and a.2.pi found under dir, and compares their verdicts with the
+// expected ones. These are given in the header comments of a.1.pi:
+//
+//	# strong: not-bisimilar
+//	# weak: bisimilar
+//	# size: big
+//
+// or in manifest.json in dir, by the path of the pair without .1.pi:
+//
+//	{
+//	  "maxStates": 3000,
+//	  "models": {
+//	    "weak-bisimilar/buffer-2x1": {"strong": "not-bisimilar", "weak": "bisimilar"}
+//	  }
+//	}
+//
+// A header comment takes precedence over the manifest. The size is small or
+// big, and big pairs are only run with -big. A pair can also give n and
+// maxStates. The checks are run in parallel in child processes, as in
+// pisim22 serve, so that each of them can be given a timeout.
+
+const corpusManifestName = "manifest.json"
+
+const (
+	corpusSmall = "small"
+	corpusBig   = "big"
+)
+
+// The expected verdicts of a pair of models, and the options of its checks.
+type corpusExpectation struct {
+	Strong    string `json:"strong,omitempty"`
+	Weak      string `json:"weak,omitempty"`
+	Size      string `json:"size,omitempty"`
+	N         *int   `json:"n,omitempty"`
+	MaxStates *int   `json:"maxStates,omitempty"`
+}
+
+type corpusManifest struct {
+	// The default of maxStates.
+	MaxStates int                          `json:"maxStates,omitempty"`
+	Models    map[string]corpusExpectation `json:"models"`
+}
+
+func (e corpusExpectation) validate() error {
+	for _, v := range []string{e.Strong, e.Weak} {
+		if v != "" && v != verdictBisimilar && v != verdictNotBisimilar {
+			return fmt.Errorf("the expected verdict is %q, not %s or %s", v, verdictBisimilar, verdictNotBisimilar)
+		}
+	}
+	if e.Size != "" && e.Size != corpusSmall && e.Size != corpusBig {
+		return fmt.Errorf("the size is %q, not %s or %s", e.Size, corpusSmall, corpusBig)
+	}
+	if e.N != nil {
+		if err := validateRegSize(*e.N); err != nil {
+			return fmt.Errorf("n has to be positive, not %d", *e.N)
+		}
+	}
+	if e.MaxStates != nil && *e.MaxStates <= 0 {
+		return fmt.Errorf("maxStates has to be positive, not %d", *e.MaxStates)
+	}
+	return nil
+}
+
+// Read the manifest of a directory. A directory without one has an empty
+// manifest.
+func readCorpusManifest(dir string) (corpusManifest, error) {
+	m := corpusManifest{Models: make(map[string]corpusExpectation)}
+	name := filepath.Join(dir, corpusManifestName)
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	for pair, e := range m.Models {
+		if err := e.validate(); err != nil {
+			return m, fmt.Errorf("%s: %s: %s", name, pair, err.Error())
+		}
+	}
+	return m, nil
+}
+
+// Read the expectation from the header comments of a model, i.e. the lines
+// before its first line that is not a comment or blank.
+func readCorpusHeader(name string) (corpusExpectation, error) {
+	var e corpusExpectation
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return e, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, "#") {
+			break
+		}
+		parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(text, "#")), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "strong":
+			e.Strong = value
+		case "weak":
+			e.Weak = value
+		case "size":
+			e.Size = value
+		case "n", "maxStates":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return e, fmt.Errorf("%s:%d: %s is not a number", name, line, key)
+			}
+			if key == "n" {
+				e.N = &v
+			} else {
+				e.MaxStates = &v
+			}
+		}
+	}
+	if err := e.validate(); err != nil {
+		return e, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return e, nil
+}
+
+// Override the expectation with the fields of the header that are given.
+func (e corpusExpectation) merge(header corpusExpectation) corpusExpectation {
+	if header.Strong != "" {
+		e.Strong = header.Strong
+	}
+	if header.Weak != "" {
+		e.Weak = header.Weak
+	}
+	if header.Size != "" {
+		e.Size = header.Size
+	}
+	if header.N != nil {
+		e.N = header.N
+	}
+	if header.MaxStates != nil {
+		e.MaxStates = header.MaxStates
+	}
+	return e
+}
+
+// A pair of models, by its path without .1.pi relative to the directory, with
+// its expectation.
+type corpusPair struct {
+	Name string
+	corpusExpectation
+}
+
+// Find the pairs of models under a directory and their expectations. Every
+// pair of the manifest has to be found.
+func findCorpusPairs(dir string) ([]corpusPair, corpusManifest, error) {
+	m, err := readCorpusManifest(dir)
+	if err != nil {
+		return nil, m, err
+	}
+	var pairs []corpusPair
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || !strings.HasSuffix(name, ".1.pi") {
+			return err
+		}
+		base := strings.TrimSuffix(name, ".1.pi")
+		if _, err := os.Stat(base + ".2.pi"); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, base)
+		if err != nil {
+			return err
+		}
+		header, err := readCorpusHeader(name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		pairs = append(pairs, corpusPair{key, m.Models[key].merge(header)})
+		return nil
+	})
+	if err != nil {
+		return nil, m, err
+	}
+	found := make(map[string]bool)
+	for _, p := range pairs {
+		found[p.Name] = true
+	}
+	for key := range m.Models {
+		if !found[key] {
+			return nil, m, fmt.Errorf("%s: there are no models %s.1.pi and %s.2.pi", corpusManifestName, key, key)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Name < pairs[j].Name })
+	return pairs, m, nil
+}
+
+// ####
+// Running the checks.
+// ####
+
+type corpusOptions struct {
+	workers int
+	timeout time.Duration
+	big     bool
+	gc      bool
+	// The command that runs a check with the given arguments.
+	command func(ctx context.Context, args []string) *exec.Cmd
+}
+
+const (
+	corpusPass    = "ok"
+	corpusFail    = "FAIL"
+	corpusError   = "ERROR"
+	corpusTimeout = "TIMEOUT"
+	corpusSkip    = "skip"
+)
+
+// A check of a pair, strong or weak.
+type corpusCase struct {
+	Pair     corpusPair
+	Weak     bool
+	Expected string
+	Verdict  string
+	Status   string
+	// The error of a check, or why it was skipped.
+	Message string
+	Time    time.Duration
+}
+
+func (c corpusCase) mode() string {
+	if c.Weak {
+		return "weak"
+	}
+	return "strong"
+}
+
+func (c corpusCase) failed() bool {
+	return c.Status == corpusFail || c.Status == corpusError || c.Status == corpusTimeout
+}
+
+// The checks of the pairs. A pair without any expected verdict is skipped as
+// a whole.
+func corpusCases(pairs []corpusPair, big bool) []corpusCase {
+	var cases []corpusCase
+	for _, p := range pairs {
+		if p.Strong == "" && p.Weak == "" {
+			cases = append(cases, corpusCase{Pair: p, Status: corpusSkip, Message: "no expected verdict"})
+			continue
+		}
+		for _, weak := range []bool{false, true} {
+			c := corpusCase{Pair: p, Weak: weak, Expected: p.Strong}
+			if weak {
+				c.Expected = p.Weak
+			}
+			if c.Expected == "" {
+				continue
+			}
+			if p.Size == corpusBig && !big {
+				c.Status, c.Message = corpusSkip, "big, run with -big"
+			}
+			cases = append(cases, c)
+		}
+	}
+	return cases
+}
+
+// Run the checks of the pairs under a directory.
+func runCorpus(dir string, opts corpusOptions) ([]corpusCase, error) {
+	pairs, m, err := findCorpusPairs(dir)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempDir("", "pisim22-test")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	cases := corpusCases(pairs, opts.big)
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				runCorpusCase(&cases[i], dir, filepath.Join(tmp, strconv.Itoa(i)), m.MaxStates, opts)
+			}
+		}()
+	}
+	for i := range cases {
+		if cases[i].Status == "" {
+			work <- i
+		}
+	}
+	close(work)
+	wg.Wait()
+	return cases, nil
+}
+
+func runCorpusCase(c *corpusCase, dir string, tmp string, maxStates int, opts corpusOptions) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	q := queryOptions{Weak: &c.Weak, GC: &opts.gc, N: c.Pair.N, MaxStates: c.Pair.MaxStates}
+	if q.MaxStates == nil && maxStates > 0 {
+		q.MaxStates = &maxStates
+	}
+	base := filepath.Join(dir, filepath.FromSlash(c.Pair.Name))
+	resultFile := filepath.Join(tmp, "result.json")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		c.Status, c.Message = corpusError, err.Error()
+		return
+	}
+	start := time.Now()
+	cmd := opts.command(ctx, checkProcessArgs(q, base+".1.pi", base+".2.pi", resultFile))
+	result, err := runCheckProcess(cmd, resultFile, func(checkProgress) {})
+	c.Time = time.Since(start)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		c.Status, c.Message = corpusTimeout, fmt.Sprintf("the check took longer than %s", opts.timeout)
+	case err != nil:
+		c.Status, c.Message = corpusError, err.Error()
+	default:
+		c.Verdict = result.Verdict
+		c.Status = corpusPass
+		if c.Verdict != c.Expected {
+			c.Status = corpusFail
+			c.Message = fmt.Sprintf("expected %s, got %s", c.Expected, c.Verdict)
+		}
+	}
+}
+
+// Print the checks, and return how many of them failed.
+func printCorpusCases(cases []corpusCase) int {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMODE\tEXPECTED\tVERDICT\tTIME\tSTATUS")
+	counts := make(map[string]int)
+	for _, c := range cases {
+		mode, expected, verdict := c.mode(), c.Expected, c.Verdict
+		if expected == "" {
+			mode, expected = "-", "-"
+		}
+		if verdict == "" {
+			verdict = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Pair.Name, mode, expected, verdict,
+			c.Time.Round(time.Millisecond), c.Status)
+		counts[c.Status]++
+	}
+	w.Flush()
+	failed := 0
+	for _, c := range cases {
+		if c.failed() {
+			fmt.Printf("%s (%s): %s.\n", c.Pair.Name, c.mode(), c.Message)
+			failed++
+		}
+	}
+	fmt.Printf("\n%d checks: %d passed, %d failed, %d errors, %d timed out, %d skipped.\n", len(cases),
+		counts[corpusPass], counts[corpusFail], counts[corpusError], counts[corpusTimeout], counts[corpusSkip])
+	return failed
+}
+
+// ####
+// JUnit XML.
+// ####
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// The checks as a JUnit test suite. A check is named after its pair and
+// mode, and its class is the directory of the pair.
+func generateJUnitFile(name string, cases []corpusCase) ([]byte, error) {
+	suite := junitTestSuite{Name: name, Tests: len(cases)}
+	var total time.Duration
+	for _, c := range cases {
+		dir, base := filepath.Split(filepath.FromSlash(c.Pair.Name))
+		tc := junitTestCase{
+			Name:      fmt.Sprintf("%s (%s)", base, c.mode()),
+			ClassName: strings.Trim(filepath.ToSlash(dir), "/"),
+			Time:      junitSeconds(c.Time),
+		}
+		if c.Expected == "" {
+			tc.Name = base
+		}
+		switch c.Status {
+		case corpusFail:
+			tc.Failure = &junitMessage{c.Message}
+			suite.Failures++
+		case corpusError, corpusTimeout:
+			tc.Error = &junitMessage{c.Message}
+			suite.Errors++
+		case corpusSkip:
+			tc.Skipped = &junitMessage{c.Message}
+			suite.Skipped++
+		}
+		total += c.Time
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = junitSeconds(total)
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// The test command.
+func testCommand(args []string) {
+	fs := newCommandFlagSet("test", "[flags] dir",
+		"Check the pairs of models a.1.pi and a.2.pi under dir against the verdicts expected by their header comments or by dir/manifest.json.")
+	workersFlag := fs.Int("j", runtime.NumCPU(), "The number of checks that are run at the same time.")
+	timeoutFlag := fs.Duration("timeout", 2*time.Minute, "The timeout of each check.")
+	bigFlag := fs.Bool("big", false, "Whether to run the big pairs as well.")
+	gcFlag := fs.Bool("gc", false, "Whether to enable garbage collection.")
+	junitFlag := fs.String("junit", "", "A path to write the results to as JUnit XML.")
+	args = parseCommandFlags(fs, args)
+	if len(args) != 1 {
+		usageError(fs, fmt.Errorf("expected one directory as argument, not %d", len(args)))
+	}
+	if *workersFlag <= 0 {
+		usageError(fs, fmt.Errorf("-j has to be positive, not %d", *workersFlag))
+	}
+	if *timeoutFlag <= 0 {
+		usageError(fs, fmt.Errorf("-timeout has to be positive, not %s", *timeoutFlag))
+	}
+	exe, err := os.Executable()
+	check(err)
+	cases, err := runCorpus(args[0], corpusOptions{
+		workers: *workersFlag,
+		timeout: *timeoutFlag,
+		big:     *bigFlag,
+		gc:      *gcFlag,
+		command: func(ctx context.Context, args []string) *exec.Cmd {
+			return exec.CommandContext(ctx, exe, args...)
+		},
+	})
+	check(err)
+	failed := printCorpusCases(cases)
+	if *junitFlag != "" {
+		data, err := generateJUnitFile(filepath.Base(filepath.Clean(args[0])), cases)
+		check(err)
+		check(writeFile(*junitFlag, data))
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The lists of pairs of the tests are the ones of the manifest.
+func TestCorpusManifest(t *testing.T) {
+	for _, c := range []struct {
+		files []string
+		n     int
+	}{{bisim_files, 16}, {weak_bisim_files, 7}, {weak_bisim_big_files, 5}, {fully_not_bisim_files, 10}} {
+		if len(c.files) != c.n {
+			t.Errorf("The pairs %v are %d, expected %d.", c.files, len(c.files), c.n)
+		}
+	}
+}
+
+func writeCorpus(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// The verdicts of the pairs are compared with the ones of their headers and of
+// the manifest, and the header takes precedence.
+func TestCorpus(t *testing.T) {
+	dir := writeCorpus(t, map[string]string{
+		"manifest.json": `{"models": {
+			"a/same": {"strong": "bisimilar", "weak": "bisimilar"},
+			"a/tau": {"strong": "bisimilar", "weak": "bisimilar"},
+			"b/big": {"strong": "bisimilar", "size": "big"}
+		}}`,
+		"a/same.1.pi": "P(a) = a(x).P(a)\nP(a)\n",
+		"a/same.2.pi": "Q(a) = a(x).a(y).Q(a)\nQ(a)\n",
+		// The header overrides the strong verdict of the manifest.
+		"a/tau.1.pi":   "# strong: not-bisimilar\n\nP(a) = a(x).P(a)\nP(a)\n",
+		"a/tau.2.pi":   "Q(a) = a(x).$c.(c'<c>.0 | c(y).Q(a))\nQ(a)\n",
+		"a/wrong.1.pi": "# strong: bisimilar\na(x).0\n",
+		"a/wrong.2.pi": "b(x).0\n",
+		"a/none.1.pi":  "a(x).0\n",
+		"a/none.2.pi":  "a(x).0\n",
+		"b/big.1.pi":   "a(x).0\n",
+		"b/big.2.pi":   "a(x).0\n",
+	})
+	cases, err := runCorpus(dir, corpusOptions{workers: 2, timeout: time.Minute, command: testCheckCommand})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{}
+	for _, c := range cases {
+		statuses = append(statuses, c.Pair.Name+" "+c.mode()+" "+c.Status)
+	}
+	expected := "a/none strong skip,a/same strong ok,a/same weak ok,a/tau strong ok,a/tau weak ok,a/wrong strong FAIL,b/big strong skip"
+	if strings.Join(statuses, ",") != expected {
+		t.Errorf("The checks are %v, expected %s.", statuses, expected)
+	}
+
+	data, err := generateJUnitFile("corpus", cases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(data, &suite); err != nil {
+		t.Fatal(err)
+	}
+	if suite.Tests != 7 || suite.Failures != 1 || suite.Errors != 0 || suite.Skipped != 2 {
+		t.Errorf("The test suite is %+v.", suite)
+	}
+	if c := suite.Cases[5]; c.ClassName != "a" || c.Name != "wrong (strong)" || c.Failure == nil ||
+		c.Failure.Message != "expected bisimilar, got not-bisimilar" {
+		t.Errorf("The failed test case is %+v.", c)
+	}
+
+	cases, err = runCorpus(dir, corpusOptions{workers: 1, timeout: time.Nanosecond, big: true, command: testCheckCommand})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cases[len(cases)-1]; c.Pair.Name != "b/big" || c.Status != corpusTimeout {
+		t.Errorf("The big check is %+v, expected it to time out.", c)
+	}
+}
+
+func TestCorpusErrors(t *testing.T) {
+	cases := []struct {
+		files map[string]string
+		err   string
+	}{
+		{map[string]string{"manifest.json": `{"models": {"a": {"strong": "bisimilar"}}}`},
+			"there are no models a.1.pi and a.2.pi"},
+		{map[string]string{"manifest.json": `{"models": {"a": {"weak": "similar"}}}`, "a.1.pi": "0", "a.2.pi": "0"},
+			`the expected verdict is "similar"`},
+		{map[string]string{"a.1.pi": "# size: huge\n0", "a.2.pi": "0"}, `the size is "huge"`},
+		{map[string]string{"a.1.pi": "# n: two\n0", "a.2.pi": "0"}, "n is not a number"},
+	}
+	for _, c := range cases {
+		_, _, err := findCorpusPairs(writeCorpus(t, c.files))
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("The error of %v is %v, expected %q.", c.files, err, c.err)
+		}
+	}
+}
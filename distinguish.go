@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the distinguishing paths of negative verdicts. When a
+// pair of states is found not to be related, the search records the transition
+// that could not be matched, and the replies of the other system that were
+// tried. The distinguishing path is built from the moves whose replies all lead
+// to pairs in notR, along which the two systems can be told apart.
+
+// A reply of the other system to a transition, and the pair it led to.
+type pairReply struct {
+	Trans   pifra.Transition
+	PairKey string
+}
+
+// Why a pair of states is not related: the transition Trans of the left (or
+// right) system could not be matched by any of the replies.
+type pairFailure struct {
+	IsLeft  bool
+	Trans   pifra.Transition
+	Replies []pairReply
+	// The states of the pair in the left and right LTS, and its rho.
+	LeftId  int
+	RightId int
+	Rho     map[int]int
+}
+
+// Record the failure of the last match frame as the reason why the pair is not
+// related.
+func (s *CleavelandState) recordFailure(vertexKey string) {
+	if s.lastFailure == nil {
+		return
+	}
+	if _, ok := s.Failures[vertexKey]; !ok {
+		s.Failures[vertexKey] = *s.lastFailure
+	}
+}
+
+// A step of a distinguishing path. Trans is a transition of the left (or right)
+// LTS, and Reply is a transition of the weak (if weak) LTS of the other system,
+// or nil if it could not reply at all.
+type pathStep struct {
+	IsLeft bool
+	Trans  pifra.Transition
+	Reply  *pifra.Transition
+}
+
+// The distinguishing path from the start pair. Each step is a move of the
+// bisimulation game (see game.go) whose replies all lead to pairs that were
+// added to notR before the pair of the step, so that every reply loses and the
+// path ends. The recorded failure of a pair is tried first, as it usually is
+// such a move, then the other moves. Of the replies, the one to the pair that
+// was found not to be related first is followed. The path ends once a move
+// cannot be replied to.
+func distinguishingPath(state *CleavelandState) []pathStep {
+	var path []pathStep
+	g := &game{state: state}
+	pos := state.StartPos
+	for {
+		order, ok := state.NotROrder[pos.key()]
+		if !ok {
+			break
+		}
+		move, replies, ok := distinguishingMove(g, pos, order)
+		if !ok {
+			break
+		}
+		step := pathStep{IsLeft: move.isLeft, Trans: move.trans}
+		if len(replies) == 0 {
+			path = append(path, step)
+			break
+		}
+		reply := replies[0]
+		for _, r := range replies[1:] {
+			if state.NotROrder[r.next.key()] < state.NotROrder[reply.next.key()] {
+				reply = r
+			}
+		}
+		step.Reply = &reply.trans
+		path = append(path, step)
+		pos = reply.next
+	}
+	return path
+}
+
+// A move from the pair whose replies all lead to pairs added to notR before
+// order, and the replies.
+func distinguishingMove(g *game, pos gamePosition, order int) (gameMove, []gameReply, bool) {
+	moves := g.moves(pos)
+	if failure, ok := g.state.Failures[pos.key()]; ok {
+		// Try the recorded failure first.
+		var first []gameMove
+		for _, move := range moves {
+			if move.isLeft == failure.IsLeft && fmt.Sprint(move.trans) == fmt.Sprint(failure.Trans) {
+				first = append(first, move)
+			}
+		}
+		moves = append(first, moves...)
+	}
+	for _, move := range moves {
+		replies := g.replies(pos, move)
+		ok := true
+		for _, reply := range replies {
+			if o, found := g.state.NotROrder[reply.next.key()]; !found || o >= order {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return move, replies, true
+		}
+	}
+	return gameMove{}, nil, false
+}
+
+func systemName(isLeft bool) string {
+	if isLeft {
+		return "left"
+	}
+	return "right"
+}
+
+// The number of a system, as in lts1 and lts2.
+func systemNumber(isLeft bool) int {
+	if isLeft {
+		return 1
+	}
+	return 2
+}
+
+// The path in the polyadic form, see polyadicPath.
+func distinguishingPathToString(path []polyadicPathStep) string {
+	return pathStepsToString(toHtmlPath(path))
+}
+
+// Print the steps of a distinguishing path, as they are written to the results
+// and stored in the cache.
+func pathStepsToString(steps []htmlPathStep) string {
+	var sb strings.Builder
+	for i, step := range steps {
+		trans := step.Trans
+		sb.WriteString(fmt.Sprintf("%d. %s %d -%s-> %d", i+1, systemName(step.IsLeft),
+			trans.Source, trans.Label, trans.Destination))
+		if step.Reply == nil {
+			sb.WriteString(fmt.Sprintf(", which %s cannot match.\n", systemName(!step.IsLeft)))
+		} else {
+			reply := step.Reply
+			sb.WriteString(fmt.Sprintf(", matched by %s %d -%s-> %d.\n", systemName(!step.IsLeft),
+				reply.Source, reply.Label, reply.Destination))
+		}
+	}
+	return sb.String()
+}
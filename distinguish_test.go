@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestBisimGraphViz(t *testing.T) {
+	pwd := getPwd(t)
+	outFolder := t.TempDir()
+	prevName := outputBisimLtsName
+	defer func() { outputBisimLtsName = prevName }()
+
+	cases := []struct {
+		folder   string
+		testFile string
+		res      ResultType
+	}{
+		{"not-bisimilar", "jev-non-det-2", ResultNotRelated},
+		{"bisimilar", "jev-gc-3", ResultRelated},
+	}
+	for _, c := range cases {
+		testFolder := path.Join(pwd, "test", c.folder)
+		generateLts(t, testFolder, outFolder, []string{c.testFile}, flags)
+		left, err := decodeLTS(path.Join(outFolder, c.testFile+".1.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := decodeLTS(path.Join(outFolder, c.testFile+".2.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputBisimLtsName = path.Join(outFolder, c.testFile)
+		if status := checkBisim(left, right, left, right, -1, -1, false); status != c.res {
+			t.Fatalf("%s was %d, expected %d.", c.testFile, status, c.res)
+		}
+		data, err := ioutil.ReadFile(outputBisimLtsName + ".bisim.dot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dot := string(data)
+		if !strings.Contains(dot, "subgraph cluster_left {") || !strings.Contains(dot, "subgraph cluster_right {") {
+			t.Errorf("The graph of %s has no clusters.", c.testFile)
+		}
+		if strings.Contains(dot, "999999") {
+			t.Errorf("The graph of %s uses the old offset.", c.testFile)
+		}
+		highlighted := strings.Contains(dot, fmt.Sprintf("color=\"%s\",fontcolor=\"%s\",penwidth=2", attackColour, attackColour))
+		if c.res == ResultNotRelated {
+			// The left system takes a fresh input twice, which the right one
+			// cannot.
+			if !highlighted || !strings.Contains(dot, "not related") {
+				t.Errorf("The graph of %s has no distinguishing path.", c.testFile)
+			}
+		} else {
+			if highlighted {
+				t.Errorf("The graph of %s has a distinguishing path.", c.testFile)
+			}
+			if !strings.Contains(dot, "l0 -> r0 [dir=none") {
+				t.Errorf("The graph of %s does not relate the start states.", c.testFile)
+			}
+		}
+	}
+}
+
+// Whether every step of the path is a move whose replies all lead to pairs in
+// notR, and the last one cannot be replied to.
+func isDistinguishingPath(state *CleavelandState, path []pathStep) bool {
+	g := &game{state: state}
+	pos := state.StartPos
+	for i, step := range path {
+		var replies []gameReply
+		found := false
+		for _, move := range g.moves(pos) {
+			if move.isLeft == step.IsLeft && fmt.Sprint(move.trans) == fmt.Sprint(step.Trans) && g.isWinningMove(pos, move) {
+				replies, found = g.replies(pos, move), true
+				break
+			}
+		}
+		if !found || (step.Reply == nil) != (i == len(path)-1) || (step.Reply == nil) != (len(replies) == 0) {
+			return false
+		}
+		for _, reply := range replies {
+			if step.Reply != nil && fmt.Sprint(reply.trans) == fmt.Sprint(*step.Reply) {
+				pos = reply.next
+				break
+			}
+		}
+	}
+	return len(path) > 0
+}
+
+// The path is made of moves that win, even when the recorded failure of a pair
+// is not one.
+func TestDistinguishingPath(t *testing.T) {
+	left := analyzeTestLts(t, "P(a, b) = a'<a>.0 + b'<b>.0\nP(a, b)\n", 100)
+	right := analyzeTestLts(t, "P(a, b) = a'<a>.0 + b'<a>.0\nP(a, b)\n", 100)
+	rho, err := freeNamesRho(left, right, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _, res, err := preorderPair(left, right, left, right, 0, 0, rho, -1, false)
+	if err != nil || res != ResultNotRelated {
+		t.Fatalf("The check was %d with the error %v.", res, err)
+	}
+	if p := distinguishingPath(state); !isDistinguishingPath(state, p) {
+		t.Errorf("The path is not distinguishing:\n%s", distinguishingPathToString(polyadicPath(state, p, nil, nil)))
+	}
+
+	// Record the a'<a> output, which the right system matches, as the failure of
+	// the start pair.
+	g := &game{state: state}
+	var failure pairFailure
+	for _, move := range g.moves(state.StartPos) {
+		if move.isLeft && len(g.replies(state.StartPos, move)) > 0 {
+			reply := g.replies(state.StartPos, move)[0]
+			failure = pairFailure{IsLeft: true, Trans: move.trans,
+				Replies: []pairReply{{Trans: reply.trans, PairKey: reply.next.key()}}}
+		}
+	}
+	state.Failures[state.StartKey] = failure
+	p := distinguishingPath(state)
+	if !isDistinguishingPath(state, p) || fmt.Sprint(p[0].Trans) == fmt.Sprint(failure.Trans) {
+		t.Errorf("The path follows the recorded failure:\n%s", distinguishingPathToString(polyadicPath(state, p, nil, nil)))
+	}
+
+	pwd := getPwd(t)
+	outFolder := t.TempDir()
+	testFolder := path.Join(pwd, "test", "not-bisimilar")
+	for _, testFile := range []string{"jev-non-det-2", "jev-tau-1", "buffer-2x1-deadlock"} {
+		generateLts(t, testFolder, outFolder, []string{testFile}, flags)
+		left, err := decodeLTS(path.Join(outFolder, testFile+".1.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := decodeLTS(path.Join(outFolder, testFile+".2.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rho, err := freeNamesRho(left, right, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, _, _, err := preorderPair(left, right, left, right, 0, 0, rho, -1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := distinguishingPath(state); !isDistinguishingPath(state, p) {
+			t.Errorf("The path of %s is not distinguishing:\n%s", testFile, distinguishingPathToString(polyadicPath(state, p, nil, nil)))
+		}
+	}
+}
@@ -129,6 +129,21 @@ func getAdvAdjKey(trans *pifra.Transition) LabelsKey {
 	}
 }
 
+// The keys of an adjacency map of a single state, in a fixed order.
+func sortedLabelsKeys(adj map[LabelsKey][]pifra.Transition) []LabelsKey {
+	keys := make([]LabelsKey, 0, len(adj))
+	for lk := range adj {
+		keys = append(keys, lk)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].SymbolType1 != keys[j].SymbolType1 {
+			return keys[i].SymbolType1 < keys[j].SymbolType1
+		}
+		return keys[i].SymbolType2 < keys[j].SymbolType2
+	})
+	return keys
+}
+
 type AdvAdj = map[int]map[LabelsKey][]pifra.Transition
 
 func ToAdvAdjacency(lts pifra.Lts) AdvAdj {
@@ -213,7 +228,9 @@ func FRALtsGraphViz(lts FRALts) []byte {
 }
 
 // TODO: Copied from pifra, needs to be imported instead.
-func generateGraphVizFile(lts pifra.Lts) []byte {
+// With sorts, the sessions of the polyadic prefixes are printed as single
+// transitions.
+func generateGraphVizFile(lts pifra.Lts, sorts polyadicSorts) []byte {
 	var buf bytes.Buffer
 	type StateTmpl struct {
 		State int
@@ -231,10 +248,19 @@ func generateGraphVizFile(lts pifra.Lts) []byte {
 	transTmpl := template.Must(template.New("trans").Parse(ttmpl))
 
 	var states []int
-	for state := range lts.States {
-		states = append(states, state)
+	var transitions []polyadicTransition
+	if len(sorts) > 0 {
+		states, transitions = polyadicView(lts, sorts)
+	} else {
+		for state := range lts.States {
+			states = append(states, state)
+		}
+		sort.Ints(states)
+		for _, trans := range lts.Transitions {
+			transitions = append(transitions, polyadicTransition{trans.Source, trans.Destination,
+				trans.Label.PrettyPrintGraph()})
+		}
 	}
-	sort.Ints(states)
 	buf.WriteString("digraph {\n")
 	for _, id := range states {
 		conf := lts.States[id]
@@ -250,113 +276,293 @@ func generateGraphVizFile(lts pifra.Lts) []byte {
 		stateTmpl.Execute(&buf, node)
 	}
 	buf.WriteRune('\n')
-	for _, trans := range lts.Transitions {
+	for _, trans := range transitions {
 		transTmpl.Execute(&buf, TransTmpl{
 			Src:   trans.Source,
 			Dest:  trans.Destination,
-			Label: trans.Label.PrettyPrintGraph(),
+			Label: trans.Label,
 		})
 	}
 	buf.WriteString("}\n")
 	return buf.Bytes()
 }
 
-func generateBisimGraphVizFile(ltsLeft pifra.Lts, ltsRight pifra.Lts, m map[string]bisimPair) []byte {
-	var buf bytes.Buffer
-	buf.WriteString("digraph {\n")
+// The colours of the relation edges. The pairs related by the same rho share
+// a colour.
+var relationColours = []string{"#1f77b4", "#2ca02c", "#9467bd", "#8c564b",
+	"#e377c2", "#17becf", "#bcbd22", "#7f7f7f"}
 
-	var stateToIdLeft = make(map[string]int)
-	var stateToIdRight = make(map[string]int)
+const (
+	attackColour = "#d62728"
+	replyColour  = "#ff7f0e"
+)
 
-	type StateTmpl struct {
-		State int
-		Label string
-		Attrs string
+func rhoToString(rho map[int]int) string {
+	var keys []int
+	for k := range rho {
+		keys = append(keys, k)
 	}
-	type TransTmpl struct {
-		Src   int
-		Dest  int
-		Label string
+	sort.Ints(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%d→%d", k, rho[k]))
 	}
-	const stmpl = "    {{.State}} [{{.Attrs}}label=\"{{.Label}}\"]\n"
-	const ttmpl = "    {{.Src}} -> {{.Dest}} [label=\"{{ .Label}}\"]\n"
-	stateTmpl := template.Must(template.New("state").Parse(stmpl))
-	transTmpl := template.Must(template.New("trans").Parse(ttmpl))
+	return "{" + strings.Join(parts, ", ") + "}"
+}
 
-	const ttmp2 = "    {{.Src}} -> {{.Dest}} [style=dotted, arrowhead=\"none\"]\n"
-	transTmp2 := template.Must(template.New("trans2").Parse(ttmp2))
-	type TransTmp2 struct {
-		Src  int
-		Dest int
+// The id of the LTS state of a configuration in G.
+func (s *CleavelandState) ltsStateId(conf FRAConfiguration, isLeft bool) (int, bool) {
+	nId, ok := s.NStateToId[getFRAConfigurationKey(conf, isLeft)]
+	if !ok {
+		return 0, false
 	}
+	return s.RevMap[nId], true
+}
 
-	// LTS LEFT
-	var states []int
-	for state := range ltsLeft.States {
-		states = append(states, state)
+// A pair of states of the left and right LTS in the relation, with its rho.
+type relatedPair struct {
+	Left  int         `json:"left"`
+	Right int         `json:"right"`
+	Rho   map[int]int `json:"rho"`
+}
+
+// The pairs of LTS states in G, once for each rho, ordered by their states.
+func (s *CleavelandState) relatedPairs() []relatedPair {
+	var pairs []relatedPair
+	seen := make(map[string]bool)
+	for _, v := range s.G.States {
+		lid, ok1 := s.ltsStateId(v.A, true)
+		rid, ok2 := s.ltsStateId(v.B, false)
+		if !ok1 || !ok2 {
+			continue
+		}
+		key := fmt.Sprintf("%d %d %s", lid, rid, rhoToString(v.A.Rho))
+		if !seen[key] {
+			seen[key] = true
+			pairs = append(pairs, relatedPair{lid, rid, v.A.Rho})
+		}
 	}
-	sort.Ints(states)
-	for _, id := range states {
-		conf := ltsLeft.States[id]
-		stateToIdLeft[pifraStateKey(&conf)] = id
-		var label string = pifra.PrettyPrintRegister(conf.Registers) +
-			" ⊢\n" + pifra.PrettyPrintAst(conf.Process)
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Left != pairs[j].Left {
+			return pairs[i].Left < pairs[j].Left
+		}
+		if pairs[i].Right != pairs[j].Right {
+			return pairs[i].Right < pairs[j].Right
+		}
+		return rhoToString(pairs[i].Rho) < rhoToString(pairs[j].Rho)
+	})
+	return pairs
+}
 
-		var attrs string
-		if id == 0 {
-			attrs = attrs + "peripheries=2,"
+// The transitions that the weak transformation added to an LTS.
+func saturatedTransitions(lts pifra.Lts, weakLts pifra.Lts) []pifra.Transition {
+	strong := make(map[string]bool)
+	for _, trans := range lts.Transitions {
+		strong[fmt.Sprint(trans)] = true
+	}
+	var added []pifra.Transition
+	for _, trans := range weakLts.Transitions {
+		if !strong[fmt.Sprint(trans)] {
+			added = append(added, trans)
 		}
+	}
+	return added
+}
 
-		node := StateTmpl{State: id, Label: label, Attrs: attrs}
-		stateTmpl.Execute(&buf, node)
+// The position of a state in a drawing of its LTS: its distance from state 0,
+// and its place among the states at that distance.
+type ltsPosition struct {
+	Layer int
+	Pos   int
+}
+
+// Lay out the states of an LTS in layers by their distance from state 0.
+func ltsLayout(lts pifra.Lts) map[int]ltsPosition {
+	adj := make(map[int][]int)
+	for _, trans := range lts.Transitions {
+		adj[trans.Source] = append(adj[trans.Source], trans.Destination)
+	}
+	layer := map[int]int{0: 0}
+	queue := []int{0}
+	maxLayer := 0
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, dst := range adj[id] {
+			if _, ok := layer[dst]; !ok {
+				layer[dst] = layer[id] + 1
+				maxLayer = maxInt(maxLayer, layer[dst])
+				queue = append(queue, dst)
+			}
+		}
 	}
-	buf.WriteRune('\n')
-	for _, trans := range ltsLeft.Transitions {
-		transTmpl.Execute(&buf, TransTmpl{
-			Src:   trans.Source,
-			Dest:  trans.Destination,
-			Label: trans.Label.PrettyPrintGraph(),
-		})
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	layout := make(map[int]ltsPosition, len(ids))
+	count := make(map[int]int)
+	for _, id := range ids {
+		l, ok := layer[id]
+		if !ok {
+			// Not reachable, which can only happen with an imported LTS.
+			l = maxLayer + 1
+		}
+		layout[id] = ltsPosition{l, count[l]}
+		count[l]++
 	}
+	return layout
+}
 
-	// LTS RIGHT
-	var offset int = 999999
-	var states2 []int
-	for state := range ltsRight.States {
-		states2 = append(states2, state)
+// Draw both LTSs of a check, each in its own cluster, with the pairs of states
+// in the relation joined by edges coloured by their rho. For a weak check the
+// saturated transitions of the weak LTSs are dashed. If the systems are not
+// related, the distinguishing path is highlighted: the transitions that could
+// not be matched in red, and the replies that were followed in orange.
+func generateBisimGraphVizFile(state *CleavelandState, res ResultType) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("digraph {\n")
+
+	type StateTmpl struct {
+		State string
+		Label string
+		Attrs string
 	}
-	sort.Ints(states2)
-	for _, id := range states2 {
-		conf := ltsRight.States[id]
-		stateToIdRight[pifraStateKey(&conf)] = offset + id
-		var label string = pifra.PrettyPrintRegister(conf.Registers) +
-			" ⊢\n" + pifra.PrettyPrintAst(conf.Process)
+	type TransTmpl struct {
+		Src   string
+		Dest  string
+		Label string
+		Attrs string
+	}
+	const stmpl = "        {{.State}} [{{.Attrs}}label=\"{{.Label}}\"]\n"
+	const ttmpl = "        {{.Src}} -> {{.Dest}} [{{.Attrs}}label=\"{{ .Label}}\"]\n"
+	stateTmpl := template.Must(template.New("state").Parse(stmpl))
+	transTmpl := template.Must(template.New("trans").Parse(ttmpl))
 
-		var attrs string
-		if id == 0 {
-			attrs = attrs + "peripheries=2,"
+	var path []pathStep
+	if res == ResultNotRelated {
+		path = distinguishingPath(state)
+	}
+	// The highlighted transitions and states of each system.
+	highlight := map[bool]map[string]string{true: {}, false: {}}
+	onPath := map[bool]map[int]string{true: {}, false: {}}
+	for _, step := range path {
+		highlight[step.IsLeft][fmt.Sprint(step.Trans)] = attackColour
+		onPath[step.IsLeft][step.Trans.Source] = attackColour
+		onPath[step.IsLeft][step.Trans.Destination] = attackColour
+		if step.Reply != nil {
+			highlight[!step.IsLeft][fmt.Sprint(*step.Reply)] = replyColour
+			onPath[!step.IsLeft][step.Reply.Source] = replyColour
+			onPath[!step.IsLeft][step.Reply.Destination] = replyColour
 		}
+	}
 
-		node := StateTmpl{State: offset + id, Label: label, Attrs: attrs}
-		stateTmpl.Execute(&buf, node)
+	cluster := func(isLeft bool, lts pifra.Lts, weakLts pifra.Lts) {
+		prefix, name := "r", "lts2"
+		if isLeft {
+			prefix, name = "l", "lts1"
+		}
+		buf.WriteString(fmt.Sprintf("    subgraph cluster_%s {\n", systemName(isLeft)))
+		buf.WriteString(fmt.Sprintf("        label=\"%s\"\n", name))
+		var states []int
+		for id := range lts.States {
+			states = append(states, id)
+		}
+		sort.Ints(states)
+		for _, id := range states {
+			conf := lts.States[id]
+			var label string = pifra.PrettyPrintRegister(conf.Registers) +
+				" ⊢\n" + pifra.PrettyPrintAst(conf.Process)
+
+			var attrs string
+			if id == 0 {
+				attrs = attrs + "peripheries=2,"
+			}
+			if colour, ok := onPath[isLeft][id]; ok {
+				attrs = attrs + "color=\"" + colour + "\","
+			}
+			stateTmpl.Execute(&buf, StateTmpl{State: fmt.Sprintf("%s%d", prefix, id), Label: label, Attrs: attrs})
+		}
+		strong := make(map[string]bool)
+		drawn := make(map[string]bool)
+		draw := func(trans pifra.Transition, attrs string) {
+			key := fmt.Sprint(trans)
+			if drawn[key] {
+				return
+			}
+			drawn[key] = true
+			if colour, ok := highlight[isLeft][key]; ok {
+				attrs = attrs + "color=\"" + colour + "\",fontcolor=\"" + colour + "\",penwidth=2,"
+			}
+			transTmpl.Execute(&buf, TransTmpl{
+				Src:   fmt.Sprintf("%s%d", prefix, trans.Source),
+				Dest:  fmt.Sprintf("%s%d", prefix, trans.Destination),
+				Label: trans.Label.PrettyPrintGraph(),
+				Attrs: attrs,
+			})
+		}
+		for _, trans := range lts.Transitions {
+			strong[fmt.Sprint(trans)] = true
+			draw(trans, "")
+		}
+		if isWeakBisim() {
+			for _, trans := range weakLts.Transitions {
+				if !strong[fmt.Sprint(trans)] {
+					draw(trans, "style=dashed,color=gray50,fontcolor=gray50,")
+				}
+			}
+		}
+		buf.WriteString("    }\n")
+	}
+	cluster(true, state.LeftLts, state.WeakLeftLts)
+	cluster(false, state.RightLts, state.WeakRightLts)
+
+	// RELATION
+	type relEdge struct {
+		left  int
+		right int
+		rho   string
+	}
+	var edges []relEdge
+	seen := make(map[relEdge]bool)
+	var rhos []string
+	for _, v := range state.G.States {
+		lid, ok1 := state.ltsStateId(v.A, true)
+		rid, ok2 := state.ltsStateId(v.B, false)
+		if !ok1 || !ok2 {
+			continue
+		}
+		e := relEdge{lid, rid, rhoToString(v.A.Rho)}
+		if !seen[e] {
+			seen[e] = true
+			edges = append(edges, e)
+			rhos = append(rhos, e.rho)
+		}
 	}
-	buf.WriteRune('\n')
-	for _, trans := range ltsRight.Transitions {
-		transTmpl.Execute(&buf, TransTmpl{
-			Src:   offset + trans.Source,
-			Dest:  offset + trans.Destination,
-			Label: trans.Label.PrettyPrintGraph(),
-		})
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].left != edges[j].left {
+			return edges[i].left < edges[j].left
+		}
+		if edges[i].right != edges[j].right {
+			return edges[i].right < edges[j].right
+		}
+		return edges[i].rho < edges[j].rho
+	})
+	sort.Strings(rhos)
+	colours := make(map[string]string)
+	for _, rho := range rhos {
+		if _, ok := colours[rho]; !ok {
+			colours[rho] = relationColours[len(colours)%len(relationColours)]
+		}
 	}
-
-	// BISIM
-	for _, v := range m {
-		id1 := stateToIdLeft[pifraStateKey(&v.LeftState)]
-		id2 := stateToIdRight[pifraStateKey(&v.RightState)]
-		transTmp2.Execute(&buf, TransTmp2{
-			Src:  id1,
-			Dest: id2,
-		})
+	for _, e := range edges {
+		buf.WriteString(fmt.Sprintf("    l%d -> r%d [dir=none,style=dotted,constraint=false,color=\"%s\",fontcolor=\"%s\",label=\"%s\"]\n",
+			e.left, e.right, colours[e.rho], colours[e.rho], e.rho))
+	}
+	if res == ResultNotRelated {
+		buf.WriteString(fmt.Sprintf("    l0 -> r0 [dir=none,style=dashed,constraint=false,color=\"%s\",fontcolor=\"%s\",label=\"not related\"]\n",
+			attackColour, attackColour))
 	}
 
 	buf.WriteString("}\n")
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the text format of fresh-register automata (.fra):
+//
+//	# A comment.
+//	registers 2
+//	initial 0 1=a
+//	state 1 1=a 2=*
+//	0 1(2*) 1
+//	1 1<2> 0
+//	1 tau 0
+//
+// registers gives the number of registers, and initial the initial state with
+// its assignment. Every register is written as <index>=<name>, where the name
+// is a free name, shared with the other side of a check, or * for a name that
+// is private to the automaton. Registers that are not given are empty.
+//
+// The other lines are transitions, <source> <label> <destination>, with the
+// labels of the .aut files:
+//
+//	1(2)    input of the known name in register 2 on the channel in register 1
+//	1(2*)   input of a fresh name into register 2
+//	1<2>    output of the known name in register 2
+//	1<2*>   output of a fresh name, stored in register 2
+//	tau     internal action
+//
+// The registers of the other states follow from the initial assignment: a
+// fresh transition fills its register and the others keep the registers of
+// their source. A state line gives the registers of a state explicitly, which
+// is needed when they differ along two paths, e.g. when registers are emptied.
+
+const fraExt = ".fra"
+
+const (
+	fraRegistersKeyword = "registers"
+	fraInitialKeyword   = "initial"
+	fraStateKeyword     = "state"
+	fraPrivateName      = "*"
+	fraTauLabel         = "tau"
+)
+
+// Whether the file name refers to a .fra file.
+func isFraFile(name string) bool {
+	return strings.HasSuffix(name, fraExt)
+}
+
+// The assignment of a state, with the private names written as *.
+type fraAssignment map[int]string
+
+func (a fraAssignment) String() string {
+	var idxs []int
+	for idx := range a {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	var sb strings.Builder
+	for _, idx := range idxs {
+		sb.WriteString(fmt.Sprintf(" %d=%s", idx, a[idx]))
+	}
+	return sb.String()
+}
+
+func (a fraAssignment) equal(b fraAssignment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx, name := range a {
+		if b[idx] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// The registers of a state. A private name in register i is named #i, so the
+// names of a state are distinct.
+func (a fraAssignment) registers(n int) pifra.Registers {
+	regs := pifra.Registers{Size: n, Registers: make(map[int]string)}
+	for idx, name := range a {
+		if name == fraPrivateName {
+			name = "#" + strconv.Itoa(idx)
+		}
+		regs.Registers[idx] = name
+	}
+	return regs
+}
+
+func parseFraAssignment(fields []string, n int) (fraAssignment, error) {
+	a := make(fraAssignment)
+	names := make(map[string]bool)
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad register %s", field)
+		}
+		idx, err := strconv.Atoi(parts[0])
+		if err != nil || idx < 1 || idx > n {
+			return nil, fmt.Errorf("register %s is not between 1 and %d", parts[0], n)
+		}
+		if _, ok := a[idx]; ok {
+			return nil, fmt.Errorf("register %d is given twice", idx)
+		}
+		name := parts[1]
+		if name != fraPrivateName {
+			if !pifraNameRegexp.MatchString(name) {
+				return nil, fmt.Errorf("bad name %s in register %d", name, idx)
+			}
+			if names[name] {
+				return nil, fmt.Errorf("name %s is in two registers", name)
+			}
+			names[name] = true
+		}
+		a[idx] = name
+	}
+	return a, nil
+}
+
+func fraLabel(label pifra.Label) string {
+	if label.Symbol.Type == pifra.SymbolTypTau {
+		return fraTauLabel
+	}
+	return autFraLabel(label)
+}
+
+// Parse a .fra file into an FRA, and the free names of its registers. The
+// initial state becomes state 0.
+func parseFra(r io.Reader) (FRALts, map[string]string, error) {
+	fra := FRALts{States: make(map[int]FRAConfiguration)}
+	freeNames := make(map[string]string)
+	n := -1
+	initial := -1
+	assignments := make(map[int]fraAssignment)
+	ids := make(map[int]bool)
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		fail := func(format string, a ...interface{}) (FRALts, map[string]string, error) {
+			return fra, nil, fmt.Errorf("line %d: %s", lineNo, fmt.Sprintf(format, a...))
+		}
+		if fields[0] == fraRegistersKeyword {
+			if n >= 0 {
+				return fail("registers are given twice")
+			}
+			if len(fields) != 2 {
+				return fail("expected registers <number>")
+			}
+			var err error
+			if n, err = strconv.Atoi(fields[1]); err != nil || n < 0 {
+				return fail("bad number of registers %s", fields[1])
+			}
+			continue
+		}
+		if n < 0 {
+			return fail("the number of registers has to be given first")
+		}
+		if fields[0] == fraInitialKeyword || fields[0] == fraStateKeyword {
+			if len(fields) < 2 {
+				return fail("expected %s <state> <registers>", fields[0])
+			}
+			id, err := strconv.Atoi(fields[1])
+			if err != nil || id < 0 {
+				return fail("bad state %s", fields[1])
+			}
+			if fields[0] == fraInitialKeyword {
+				if initial >= 0 {
+					return fail("the initial state is given twice")
+				}
+				initial = id
+			}
+			if _, ok := assignments[id]; ok {
+				return fail("the registers of state %d are given twice", id)
+			}
+			a, err := parseFraAssignment(fields[2:], n)
+			if err != nil {
+				return fail("%s", err.Error())
+			}
+			assignments[id] = a
+			ids[id] = true
+			continue
+		}
+		if len(fields) != 3 {
+			return fail("expected a transition <source> <label> <destination>")
+		}
+		src, err1 := strconv.Atoi(fields[0])
+		dst, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || src < 0 || dst < 0 {
+			return fail("bad state in transition %s", line)
+		}
+		label, err := parseAutFraLabel(fields[1])
+		if err != nil {
+			return fail("%s", err.Error())
+		}
+		if label.Symbol.Type != pifra.SymbolTypTau &&
+			(label.Symbol.Value < 1 || label.Symbol.Value > n || label.Symbol2.Value < 1 || label.Symbol2.Value > n) {
+			return fail("label %s uses a register that is not between 1 and %d", fields[1], n)
+		}
+		fra.Transitions = append(fra.Transitions, pifra.Transition{Source: src, Destination: dst, Label: label})
+		ids[src] = true
+		ids[dst] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fra, nil, err
+	}
+	if initial < 0 {
+		return fra, nil, fmt.Errorf("missing initial state")
+	}
+
+	if err := inferFraAssignments(initial, assignments, fra.Transitions); err != nil {
+		return fra, nil, err
+	}
+	// Swap the initial state with state 0.
+	stateId := func(id int) int {
+		switch id {
+		case initial:
+			return 0
+		case 0:
+			return initial
+		}
+		return id
+	}
+	for id := range ids {
+		a := assignments[id]
+		for _, name := range a {
+			if name != fraPrivateName {
+				freeNames[name] = name
+			}
+		}
+		fra.States[stateId(id)] = FRAConfiguration{Registers: a.registers(n), N: n}
+	}
+	for i := range fra.Transitions {
+		fra.Transitions[i].Source = stateId(fra.Transitions[i].Source)
+		fra.Transitions[i].Destination = stateId(fra.Transitions[i].Destination)
+	}
+	return fra, freeNames, nil
+}
+
+// Fill in the assignments of the states without a state line, and check that
+// every transition only uses registers that are filled.
+func inferFraAssignments(initial int, assignments map[int]fraAssignment, transitions []pifra.Transition) error {
+	given := make(map[int]bool)
+	for id := range assignments {
+		given[id] = true
+	}
+	adj := make(map[int][]pifra.Transition)
+	for _, trans := range transitions {
+		adj[trans.Source] = append(adj[trans.Source], trans)
+	}
+	queue := []int{initial}
+	visited := map[int]bool{initial: true}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, trans := range adj[id] {
+			next := make(fraAssignment)
+			for idx, name := range assignments[id] {
+				next[idx] = name
+			}
+			if isFreshLabel(trans.Label) {
+				next[trans.Label.Symbol2.Value] = fraPrivateName
+			}
+			dst := trans.Destination
+			if a, ok := assignments[dst]; ok && !given[dst] {
+				if !a.equal(next) {
+					return fmt.Errorf("state %d has the registers {%s } and {%s } along two paths, give them with a state line",
+						dst, a.String(), next.String())
+				}
+			} else if !ok {
+				assignments[dst] = next
+			}
+			if !visited[dst] {
+				visited[dst] = true
+				queue = append(queue, dst)
+			}
+		}
+	}
+	for _, trans := range transitions {
+		src := assignments[trans.Source]
+		dst := assignments[trans.Destination]
+		label := trans.Label
+		if label.Symbol.Type == pifra.SymbolTypTau {
+			continue
+		}
+		used := []int{label.Symbol.Value}
+		if isFreshLabel(label) {
+			if _, ok := dst[label.Symbol2.Value]; !ok {
+				return fmt.Errorf("transition %d %s %d leaves register %d of state %d empty",
+					trans.Source, fraLabel(label), trans.Destination, label.Symbol2.Value, trans.Destination)
+			}
+		} else {
+			used = append(used, label.Symbol2.Value)
+		}
+		for _, idx := range used {
+			if _, ok := src[idx]; !ok {
+				return fmt.Errorf("transition %d %s %d uses register %d, which is empty in state %d",
+					trans.Source, fraLabel(label), trans.Destination, idx, trans.Source)
+			}
+		}
+	}
+	return nil
+}
+
+func isFreshLabel(label pifra.Label) bool {
+	return label.Symbol2.Type == pifra.SymbolTypFreshInput || label.Symbol2.Type == pifra.SymbolTypFreshOutput
+}
+
+// The LTS of an FRA. As for .aut files, the process of a state is a call to an
+// undeclared process named after the state.
+func fraToLts(fra FRALts, freeNames map[string]string) pifra.Lts {
+	lts := pifra.Lts{
+		States:         make(map[int]pifra.Configuration, len(fra.States)),
+		Transitions:    fra.Transitions,
+		FreeNamesMap:   freeNames,
+		RegSizeReached: make(map[int]bool),
+	}
+	for id, conf := range fra.States {
+		lts.States[id] = pifra.Configuration{
+			Process:   autStateProcess(id, conf.Registers),
+			Registers: conf.Registers,
+		}
+	}
+	lts.StatesExplored = len(lts.States)
+	lts.StatesGenerated = len(lts.States)
+	return lts
+}
+
+// The FRA of an LTS, with as many registers as the LTS uses.
+func ltsToFra(lts pifra.Lts) FRALts {
+	n := getMaxMinRegSize(lts)
+	for _, trans := range lts.Transitions {
+		n = maxInt(n, maxInt(trans.Label.Symbol.Value, trans.Label.Symbol2.Value))
+	}
+	fra := FRALts{States: make(map[int]FRAConfiguration, len(lts.States)), Transitions: lts.Transitions}
+	for id, conf := range lts.States {
+		fra.States[id] = FRAConfiguration{Process: conf.Process, Registers: conf.Registers, N: n}
+	}
+	return fra
+}
+
+// Generate the .fra representation of an FRA. The registers of every state are
+// written out, so that registers emptied by garbage collection are kept.
+func generateFraFile(fra FRALts, freeNames map[string]string) []byte {
+	var ids []int
+	for id := range fra.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	assignment := func(id int) fraAssignment {
+		a := make(fraAssignment)
+		for idx, name := range fra.States[id].Registers.Registers {
+			if orig, ok := freeNames[name]; ok {
+				a[idx] = orig
+			} else {
+				a[idx] = fraPrivateName
+			}
+		}
+		return a
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%s %d\n", fraRegistersKeyword, fra.States[0].N))
+	buf.WriteString(fmt.Sprintf("%s 0%s\n", fraInitialKeyword, assignment(0).String()))
+	for _, id := range ids {
+		if id != 0 {
+			buf.WriteString(fmt.Sprintf("%s %d%s\n", fraStateKeyword, id, assignment(id).String()))
+		}
+	}
+	for _, trans := range fra.Transitions {
+		buf.WriteString(fmt.Sprintf("%d %s %d\n", trans.Source, fraLabel(trans.Label), trans.Destination))
+	}
+	return buf.Bytes()
+}
+
+// Read an LTS from a .fra file.
+func readFraFile(name string) (pifra.Lts, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return pifra.Lts{}, err
+	}
+	defer closeFile(file)
+	fra, freeNames, err := parseFra(file)
+	if err != nil {
+		return pifra.Lts{}, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return fraToLts(fra, freeNames), nil
+}
+
+func writeFraFile(name string, lts pifra.Lts) error {
+	return writeFile(name, generateFraFile(ltsToFra(lts), lts.FreeNamesMap))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"testing"
+)
+
+// The hand-written cyclers only differ in the registers they forget, which
+// matters with garbage collection.
+func TestFraFile(t *testing.T) {
+	pwd := getPwd(t)
+	testFolder := path.Join(pwd, "test", "fra")
+	prevGC := enableGC
+	defer func() { enableGC = prevGC }()
+	enableGC = true
+
+	left, err := readFraFile(path.Join(testFolder, "cycler.1.fra"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left.States) != 2 || len(left.States[1].Registers.Registers) != 3 {
+		t.Errorf("cycler.1 has %d states, and %d registers in state 1, expected 2 and 3.",
+			len(left.States), len(left.States[1].Registers.Registers))
+	}
+	for i, res := range map[int]ResultType{2: ResultRelated, 3: ResultNotRelated} {
+		right, err := readFraFile(path.Join(testFolder, fmt.Sprintf("cycler.%d.fra", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := checkBisim(left, right, left, right, -1, -1, false); status != res {
+			t.Errorf("cycler.1 and cycler.%d were %d, expected %d.", i, status, res)
+		}
+	}
+}
+
+// An LTS generated by pifra has to survive a round trip through a .fra file.
+func TestFraRoundTrip(t *testing.T) {
+	pwd := getPwd(t)
+	testFolder := path.Join(pwd, "test", "weak-bisimilar")
+	outFolder := t.TempDir()
+
+	var testFiles []string = []string{"buffer-2x1", "milner-cycler-02"}
+	generateLts(t, testFolder, outFolder, testFiles, flags)
+	for _, testFile := range testFiles {
+		for i := 1; i < 3; i++ {
+			name := path.Join(outFolder, fmt.Sprintf("%s.%d", testFile, i))
+			lts, err := decodeLTS(name + ".gob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writeFraFile(name+fraExt, lts); err != nil {
+				t.Fatal(err)
+			}
+			imported, err := loadLTS(name + fraExt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(imported.States) != len(lts.States) || len(imported.Transitions) != len(lts.Transitions) {
+				t.Errorf("%s has %d states and %d transitions after the round trip, expected %d and %d.",
+					name, len(imported.States), len(imported.Transitions), len(lts.States), len(lts.Transitions))
+			}
+			if status := checkBisim(lts, imported, lts, imported, -1, -1, false); status != ResultRelated {
+				t.Errorf("%s was %d to its round trip.", name, status)
+			}
+		}
+	}
+}
+
+func TestFraFileErrors(t *testing.T) {
+	cases := map[string]string{
+		"no-registers": "initial 0\n",
+		"no-initial":   "registers 1\n0 tau 0\n",
+		"range":        "registers 1\ninitial 0 2=a\n",
+		"twice":        "registers 2\ninitial 0 1=a 2=a\n",
+		"label":        "registers 1\ninitial 0 1=a\n0 1[1] 0\n",
+		"empty":        "registers 2\ninitial 0 1=a\n0 1<2> 0\n",
+		"paths":        "registers 2\ninitial 0 1=a\n0 1(2*) 1\n0 tau 1\n",
+	}
+	dir := t.TempDir()
+	for name, data := range cases {
+		fileName := path.Join(dir, name+fraExt)
+		if err := writeFile(fileName, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readFraFile(fileName); err == nil {
+			t.Errorf("Expected an error for %s.", name)
+		}
+	}
+}
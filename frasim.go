@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/yungene/pifra"
 )
 
@@ -11,6 +13,14 @@ func isVerbose() bool {
 	return verbose
 }
 
+// Whether to leave out the verdict of checkBisim, when the caller prints its
+// own answer.
+var quiet bool = false
+
+func isQuiet() bool {
+	return quiet
+}
+
 var debug bool = false
 
 func isDebug() bool {
@@ -51,6 +61,12 @@ func enableGarbageCollection() bool {
 	return enableGC
 }
 
+var enableSymmetry bool = true
+
+func enableSymmetryReduction() bool {
+	return enableSymmetry
+}
+
 var outputGraph bool = false
 
 func isOutputGraph() bool {
@@ -63,12 +79,106 @@ func getOutputBisimLtsName() string {
 	return outputBisimLtsName
 }
 
+var outputHtmlName string = ""
+
+func getOutputHtmlName() string {
+	return outputHtmlName
+}
+
+var outputTexName string = ""
+
+func getOutputTexName() string {
+	return outputTexName
+}
+
+var outputResultName string = ""
+
+func getOutputResultName() string {
+	return outputResultName
+}
+
+// Whether to report the progress of a check on stderr.
+var progress bool = false
+
+func isReportProgress() bool {
+	return progress
+}
+
 var closureAlgorithmChoice int = 1
 
 func getClosureAlgortihmChoice() int {
 	return closureAlgorithmChoice
 }
 
+var useCache bool = false
+
+func isCacheEnabled() bool {
+	return useCache
+}
+
+var cacheDir string = ""
+
+var cacheRelation bool = false
+
+func isCacheRelation() bool {
+	return cacheRelation
+}
+
+var saveRelationName string = ""
+
+func getSaveRelationName() string {
+	return saveRelationName
+}
+
+var warmStartRelation *savedRelation
+
+func getWarmStartRelation() *savedRelation {
+	return warmStartRelation
+}
+
+// Where the search writes the relation found so far, and how often.
+var checkpointName string = ""
+var checkpointInterval time.Duration = time.Minute
+
+func getCheckpointName() string {
+	return checkpointName
+}
+
+// The order of the search, see preorder.
+const (
+	searchDfs       = "dfs"
+	searchDeepening = "deepening"
+)
+
+var searchStrategy string = searchDfs
+
+func getSearchStrategy() string {
+	return searchStrategy
+}
+
+// Closed to stop the search, e.g. on an interrupt.
+var stopSearch chan struct{}
+
+func isSearchStopped() bool {
+	select {
+	case <-stopSearch:
+		return true
+	default:
+		return false
+	}
+}
+
+// The sorts of the polyadic channels of the left and the right model.
+var polyadicSortsLeft polyadicSorts
+var polyadicSortsRight polyadicSorts
+
+func getPolyadicSorts(isLeft bool) polyadicSorts {
+	if isLeft {
+		return polyadicSortsLeft
+	}
+	return polyadicSortsRight
+}
+
 // CONSTANTS
 const NULL_REG = 0
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	rtdebug "runtime/debug"
 	"testing"
 
 	"github.com/yungene/pifra"
@@ -11,57 +12,34 @@ import (
 
 const BIG_TESTS_FLAG string = "PISIM_BIG_TESTS"
 
-var bisim_files = []string{
-	"jev-a1",
-	"jev-a2",
-	"jev-a3",
-	"jev-a4",
-	"jev-non-det",
-	"jev-finp2-1",
-	"jev-gc-2",
-	"jev-gc-3",
-	"jev-sangiorgi-open-bisim",
-	"jev-wc-1",
-	"milner-3-7",
-	"milner-5-14",
-	"sangiorgi-ex-1-4-11",
-	"sangiorgi-book-p65",
-	"jev-sangiorgi-fig-1-7",
-	"jev-vk-fin-st3",
-}
-
-var weak_bisim_files = []string{
-	"milner-6-14-2-weak",
-	"milner-cycler-02",
-	"milner-cycler-03",
-	"buffer-2x1",
-	"buffer-3",
-	"cleav-turner-choice",
-	"mwb-bool-not",
-}
-
-var weak_bisim_big_files = []string{
-	"milner-job-shop",
-	"milner-cycler-04",
-	"milner-cycler-05",
-	"handover-no-error",
-	//"handover-error-handl",
-	//"handover-impl",
-	"cleav-abp-jp",
-}
-
-// Examples that are neither strongly nor weakly bisimilar
-var fully_not_bisim_files = []string{
-	"jev-non-det-2",
-	"jev-diff-names-1",
-	"jev-tau-1",
-	"jev-tau-2",
-	"jev-vk-fin-st2",
-	"jev-ne-1",
-	"buffer-2x1-deadlock",
-	"milner-3-10",
-	"milner-6-12-1",
-	"cleav-abp-bv",
+// The pairs of models of the tests, and their expected verdicts, are the ones
+// of test/manifest.json, which `pisim22 test test` runs as well.
+var (
+	bisim_files          = manifestPairs("bisimilar", verdictBisimilar, verdictBisimilar, corpusSmall)
+	weak_bisim_files     = manifestPairs("weak-bisimilar", verdictNotBisimilar, verdictBisimilar, corpusSmall)
+	weak_bisim_big_files = manifestPairs("weak-bisimilar", verdictNotBisimilar, verdictBisimilar, corpusBig)
+	// Examples that are neither strongly nor weakly bisimilar
+	fully_not_bisim_files = manifestPairs("not-bisimilar", verdictNotBisimilar, verdictNotBisimilar, corpusSmall)
+)
+
+// The names of the pairs of the manifest in a folder of test with the given
+// verdicts and size.
+func manifestPairs(folder string, strong string, weak string, size string) []string {
+	pairs, _, err := findCorpusPairs("test")
+	if err != nil {
+		panic(err)
+	}
+	var names []string
+	for _, p := range pairs {
+		dir, name := path.Split(p.Name)
+		if p.Size == "" {
+			p.Size = corpusSmall
+		}
+		if dir == folder+"/" && p.Strong == strong && p.Weak == weak && p.Size == size {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 var flags = pifra.Flags{
@@ -179,6 +157,279 @@ func TestGC(t *testing.T) {
 	enableGC = false
 }
 
+// A relation saved from a weak check has to be refuted by a strong check that
+// starts from it, and has to be confirmed by the weak check again.
+func TestWarmStart(t *testing.T) {
+	pwd := getPwd(t)
+	testFolder := path.Join(pwd, "test", "weak-bisimilar")
+	outFolder := path.Join(pwd, "test", "weak-bisimilar", "out")
+	defer cleanFolder(t, outFolder)
+	relFile := path.Join(t.TempDir(), "relation.json")
+	defer func() {
+		saveRelationName = ""
+		warmStartRelation = nil
+	}()
+
+	var testFiles []string = []string{"buffer-2x1", "milner-cycler-02"}
+	generateLts(t, testFolder, outFolder, testFiles, flags)
+	for _, testFile := range testFiles {
+		left, err := decodeLTS(path.Join(outFolder, testFile+".1.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := decodeLTS(path.Join(outFolder, testFile+".2.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		leftWeak := doWeakTransform(left)
+		rightWeak := doWeakTransform(right)
+
+		saveRelationName = relFile
+		checkBisim(left, right, leftWeak, rightWeak, -1, -1, false)
+		saveRelationName = ""
+		rel, err := readSavedRelation(relFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		warmStartRelation = &rel
+		// The weak relation is ignored by a strong check.
+		if status := checkBisim(left, right, left, right, -1, -1, false); status != ResultNotRelated || IC.reverifiedPairs != 0 {
+			t.Errorf("Strong check warm-started from a weak relation was %d for %s, with %d pairs re-verified.", status, testFile, IC.reverifiedPairs)
+		}
+		if status := checkBisim(left, right, leftWeak, rightWeak, -1, -1, false); status != ResultRelated {
+			t.Errorf("Weak check warm-started from its own relation was %d for %s.", status, testFile)
+		}
+		warmStartRelation = nil
+	}
+}
+
+// After an edit of one process, only the pairs of its states are searched, and
+// the other pairs of the old relation are only re-verified.
+func TestWarmStartEdit(t *testing.T) {
+	cases := []struct {
+		src      string
+		edited   string
+		res      ResultType
+		unmapped int
+		broken   int
+		newPairs int
+	}{
+		// The state after R is now (0 + P), which is only in a new pair.
+		{"P(a, b) = a'<a>.Q(a, b)\nQ(a, b) = b'<b>.R(a, b)\nR(a, b) = a'<b>.P(a, b)\nP(a, b)\n",
+			"P(a, b) = a'<a>.Q(a, b)\nQ(a, b) = b'<b>.R(a, b)\nR(a, b) = a'<b>.(P(a, b) + 0)\nP(a, b)\n",
+			ResultRelated, 0, 0, 1},
+		// R outputs another name, which breaks the pair of R and the start pair,
+		// but not the pair of Q. The state of R has the same key.
+		{"P(a, b) = a'<a>.Q(a, b) + b'<b>.R(a, b)\nQ(a, b) = a'<a>.Q(a, b)\nR(a, b) = b'<b>.R(a, b)\nP(a, b)\n",
+			"P(a, b) = a'<a>.Q(a, b) + b'<b>.R(a, b)\nQ(a, b) = a'<a>.Q(a, b)\nR(a, b) = b'<a>.R(a, b)\nP(a, b)\n",
+			ResultNotRelated, 0, 2, 0},
+	}
+	for _, c := range cases {
+		left := analyzeTestLts(t, c.src, 100)
+		right := analyzeTestLts(t, c.src, 100)
+		edited := analyzeTestLts(t, c.edited, 100)
+		rho, err := freeNamesRho(left, right, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, _, _, err := preorderPair(left, right, left, right, 0, 0, rho, -1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel := getSavedRelation(state)
+
+		resetBisim()
+		setRegSize(maxInt(getMaxMinRegSize(left), getMaxMinRegSize(edited)))
+		state = NewCleavelandState(left, edited, left, edited)
+		nP, nQ, err := state.addStartPair(0, 0, rho)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := seedRelation(state, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res, err := preorder(state, nP, nQ); err != nil || res != c.res {
+			t.Errorf("The check of %q after the edit was %d, expected %d.", c.src, res, c.res)
+		}
+		broken := report.broken(state)
+		if len(report.Unmapped) != c.unmapped || len(broken) != c.broken {
+			t.Errorf("The edit of %q left %d pairs unmapped and broke %d, expected %d and %d.",
+				c.src, len(report.Unmapped), len(broken), c.unmapped, c.broken)
+		}
+		if IC.reverifiedPairs != len(report.Seeded) || IC.newPairs != c.newPairs {
+			t.Errorf("The edit of %q re-verified %d pairs and searched %d new ones, expected %d and %d.",
+				c.src, IC.reverifiedPairs, IC.newPairs, len(report.Seeded), c.newPairs)
+		}
+	}
+}
+
+// A relation saved for another mode or N is not used.
+func TestWarmStartIgnored(t *testing.T) {
+	lts := analyzeTestLts(t, "P(a) = a'<a>.P(a)\nP(a)\n", 100)
+	setRegSize(1)
+	for _, rel := range []savedRelation{{Mode: "weak", N: 1}, {Mode: "strong", N: 2}} {
+		rel.Pairs = []savedPair{{Left: "x", Right: "y"}}
+		report, err := seedRelation(NewCleavelandState(lts, lts, lts, lts), rel)
+		if err != nil || report.Ignored == "" || len(report.Unmapped) != 0 {
+			t.Errorf("The relation for %s with N=%d was not ignored.", rel.Mode, rel.N)
+		}
+	}
+}
+
+// A cycle of n states that each output a on a, as an LTS of the process
+// P0(a) = a'<a>.P1(a), ..., Pn-1(a) = a'<a>.P0(a), which pifra is slow to
+// generate for a large n.
+func cycleLts(t *testing.T, n int) pifra.Lts {
+	one := analyzeTestLts(t, "P(a) = a'<a>.P(a)\nP(a)\n", 10)
+	lts := pifra.Lts{
+		States:         make(map[int]pifra.Configuration),
+		RegSizeReached: make(map[int]bool),
+		StatesExplored: n,
+		FreeNamesMap:   one.FreeNamesMap,
+	}
+	for i := 0; i < n; i++ {
+		conf := one.States[0]
+		conf.Process = &pifra.ElemRoot{Next: &pifra.ElemProcess{
+			Name: fmt.Sprintf("P%d", i), Parameters: []pifra.Name{{Name: "#1"}}}}
+		lts.States[i] = conf
+		trans := one.Transitions[0]
+		trans.Source, trans.Destination = i, (i+1)%n
+		lts.Transitions = append(lts.Transitions, trans)
+	}
+	return lts
+}
+
+// The search goes as deep as the cycle, which overflowed a small stack when
+// preorder recursed.
+func TestDeepPreorder(t *testing.T) {
+	lts := cycleLts(t, 20000)
+	defer rtdebug.SetMaxStack(rtdebug.SetMaxStack(1 << 20))
+	if res := checkBisim(lts, lts, lts, lts, -1, -1, false); res != ResultRelated {
+		t.Errorf("A cycle is not bisimilar to itself.")
+	}
+	if IC.maxPreorderStackDepth != 20000 {
+		t.Errorf("The search went %d pairs deep, expected 20000.", IC.maxPreorderStackDepth)
+	}
+}
+
+// The iterative deepening gives the same verdicts, and finds a shorter
+// distinguishing path when there is one.
+func TestSearchDeepening(t *testing.T) {
+	defer func() { searchStrategy = searchDfs }()
+	cases := []struct {
+		left         string
+		right        string
+		res          ResultType
+		dfsLen       int
+		deepeningLen int
+	}{
+		// Both the a branch and the b branch tell the processes apart.
+		{"P(a, b) = a'<a>.a'<a>.a'<a>.a'<a>.a'<a>.0 + b'<b>.0\nP(a, b)\n",
+			"P(a, b) = a'<a>.a'<a>.a'<a>.a'<a>.a'<b>.0 + b'<a>.0\nP(a, b)\n", ResultNotRelated, 5, 1},
+		{"P(a) = a'<a>.a'<a>.P(a)\nP(a)\n", "P(a) = a'<a>.P(a)\nP(a)\n", ResultRelated, 0, 0},
+	}
+	for _, c := range cases {
+		left := analyzeTestLts(t, c.left, 100)
+		right := analyzeTestLts(t, c.right, 100)
+		rho, err := freeNamesRho(left, right, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, strategy := range []string{searchDfs, searchDeepening} {
+			searchStrategy = strategy
+			expected := c.dfsLen
+			if strategy == searchDeepening {
+				expected = c.deepeningLen
+			}
+			state, _, res, err := preorderPair(left, right, left, right, 0, 0, rho, -1, false)
+			if err != nil || res != c.res {
+				t.Errorf("The %s check of %q was %d, expected %d.", strategy, c.left, res, c.res)
+			} else if n := len(distinguishingPath(state)); n != expected {
+				t.Errorf("The %s check of %q has a distinguishing path of %d steps, expected %d.", strategy, c.left, n, expected)
+			}
+		}
+	}
+
+	pwd := getPwd(t)
+	for _, c := range []struct{ folder, testFile string }{{"bisimilar", "jev-a1"}, {"not-bisimilar", "jev-non-det-2"}} {
+		testFolder := path.Join(pwd, "test", c.folder)
+		outFolder := path.Join(testFolder, "out")
+		generateLts(t, testFolder, outFolder, []string{c.testFile}, flags)
+		left, err := decodeLTS(path.Join(outFolder, c.testFile+".1.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := decodeLTS(path.Join(outFolder, c.testFile+".2.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cleanFolder(t, outFolder)
+		searchStrategy = searchDfs
+		dfs := checkBisim(left, right, left, right, -1, -1, false)
+		searchStrategy = searchDeepening
+		if deepening := checkBisim(left, right, left, right, -1, -1, false); deepening != dfs {
+			t.Errorf("The deepening check of %s was %d, and the dfs check %d.", c.testFile, deepening, dfs)
+		}
+	}
+}
+
+// A stopped check writes its relation so far to the checkpoint, and can be
+// resumed from it.
+func TestCheckpoint(t *testing.T) {
+	lts := cycleLts(t, 100)
+	checkpointName = path.Join(t.TempDir(), "checkpoint.json")
+	stopSearch = make(chan struct{})
+	close(stopSearch)
+	defer func() {
+		checkpointName, stopSearch, warmStartRelation = "", nil, nil
+	}()
+	rho, err := freeNamesRho(lts, lts, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := preorderPair(lts, lts, lts, lts, 0, 0, rho, -1, false); err != errSearchStopped {
+		t.Fatalf("The stopped check gave the error %v.", err)
+	}
+	rel, err := readSavedRelation(checkpointName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rel.Checkpoint {
+		t.Errorf("The checkpoint is not marked as one.")
+	}
+	checkpointName, stopSearch = "", nil
+	warmStartRelation = &rel
+	if res := checkBisim(lts, lts, lts, lts, -1, -1, false); res != ResultRelated {
+		t.Errorf("The check resumed from the checkpoint was %d.", res)
+	}
+
+	// The re-verification of the pairs of a warm start is stopped as well,
+	// and writes the checkpoint.
+	state, _, _, err := preorderPair(lts, lts, lts, lts, 0, 0, rho, -1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := getSavedRelation(state)
+	if full.Checkpoint {
+		t.Errorf("The relation of a finished check is marked as a checkpoint.")
+	}
+	checkpointName = path.Join(t.TempDir(), "reverified.json")
+	stopSearch = make(chan struct{})
+	close(stopSearch)
+	resetBisim()
+	state = NewCleavelandState(lts, lts, lts, lts)
+	if _, _, err := state.addStartPair(0, 0, rho); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seedRelation(state, full); err != errSearchStopped {
+		t.Errorf("The stopped re-verification gave the error %v.", err)
+	}
+	if _, err := readSavedRelation(checkpointName); err != nil {
+		t.Errorf("The stopped re-verification wrote no checkpoint: %v.", err)
+	}
+}
+
 func cleanFolder(t *testing.T, outFolder string) {
 	if !t.Failed() {
 		os.RemoveAll(outFolder)
@@ -196,7 +447,7 @@ func generateLts(t *testing.T, testFolder string, outFolder string,
 			opts := flags
 			opts.OutputFile = outputPath
 			opts.InputFile = testPath
-			if err := pifra.OutputMode(opts); err != nil {
+			if _, err := generatePifraLts(opts); err != nil {
 				t.Error(err)
 			}
 		}
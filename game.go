@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the bisimulation game, `pisim22 game`. The attacker moves
+// along a transition of either system, and the defender has to reply with a
+// transition of the other system that matches it by the NT rules. The attacker
+// wins once a move cannot be replied to, and the defender wins if the play goes
+// on forever. The user plays one side, and the tool plays the other from the
+// relation and the notR set of a check of the two systems.
+
+// A position of the game: a pair of configurations of lts1 and lts2 as in G,
+// and the states of the LTSs that they are in.
+type gamePosition struct {
+	pair    gVertex
+	leftId  int
+	rightId int
+}
+
+func (pos gamePosition) key() string {
+	return gVertexToString(&pos.pair)
+}
+
+// A move of the attacker. For a fresh input, kPrime is the register of the
+// defender whose name is received, or gameFreshName for a name that is fresh to
+// both systems.
+type gameMove struct {
+	isLeft bool
+	trans  pifra.Transition
+	kPrime int
+}
+
+const gameFreshName = 0
+
+// A reply of the defender, and the position that it leads to.
+type gameReply struct {
+	trans pifra.Transition
+	next  gamePosition
+}
+
+type gameResult int
+
+const (
+	gameAttackerWins gameResult = iota
+	gameDefenderWins
+	gameQuit
+)
+
+type game struct {
+	state        *CleavelandState
+	startPair    gVertex
+	userAttacker bool
+	in           *bufio.Scanner
+	w            io.Writer
+	round        int
+	visited      map[string]bool
+}
+
+// The game command.
+func gameCommand(args []string) {
+	fs := newCommandFlagSet("game", "[flags] model1 model2",
+		"Play the bisimulation game on two models or LTS files, as the attacker or as the defender, against the tool.")
+	roleFlag := fs.String("role", "attacker", "The side that you play, attacker or defender.")
+	regSizeOverrideFlag := fs.Int("n", -1, "The override for the size of the register.")
+	weakBisimFlag := fs.Bool("w", false, "Whether to play the weak bisimulation game.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if len(args) != 2 {
+		usageError(fs, fmt.Errorf("expected two models as arguments, not %d", len(args)))
+	}
+	if *roleFlag != "attacker" && *roleFlag != "defender" {
+		usageError(fs, fmt.Errorf("-role is attacker or defender, not %q", *roleFlag))
+	}
+	if err := validateRegSize(*regSizeOverrideFlag); err != nil {
+		usageError(fs, err)
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+	weakBisim = *weakBisimFlag
+
+	dir, err := ioutil.TempDir("", "pisim22-game")
+	check(err)
+	defer os.RemoveAll(dir)
+	flags := pf.flags()
+	var ltss [2]pifra.Lts
+	for i, name := range args {
+		ltss[i], _, err = loadOrGenerateLts(name, filepath.Join(dir, strconv.Itoa(i)), flags)
+		check(err)
+	}
+	g, res, err := newGame(ltss[0], ltss[1], *regSizeOverrideFlag, *roleFlag == "attacker")
+	check(err)
+
+	verdict := "bisimilar"
+	if isWeakBisim() {
+		verdict = "weakly " + verdict
+	}
+	winner := "defender"
+	if res != ResultRelated {
+		verdict = "NOT " + verdict
+		winner = "attacker"
+	}
+	fmt.Printf("The systems are %s for rho %s, N=%d, so the %s can always win.\n",
+		verdict, rhoToString(g.start().pair.A.Rho), getRegSize(), winner)
+	g.play(g.start(), os.Stdin, os.Stdout)
+}
+
+// Check the start states of the LTSs, and set up a game on them.
+func newGame(left pifra.Lts, right pifra.Lts, regSizeOverride int, userAttacker bool) (*game, ResultType, error) {
+	weakLeft, weakRight := left, right
+	if isWeakBisim() {
+		weakLeft, weakRight = doWeakTransform(left), doWeakTransform(right)
+	}
+	rho, err := freeNamesRho(left, right, 0, 0)
+	if err != nil {
+		return nil, ResultNotRelated, err
+	}
+	state, start, res, err := preorderPair(left, right, weakLeft, weakRight, 0, 0, rho, regSizeOverride, enableGarbageCollection())
+	if err != nil {
+		return nil, ResultNotRelated, err
+	}
+	return &game{state: state, startPair: start, userAttacker: userAttacker}, res, nil
+}
+
+// The position of the start states.
+func (g *game) start() gamePosition {
+	return gamePosition{pair: g.startPair, leftId: 0, rightId: 0}
+}
+
+// ####
+// The moves.
+// ####
+
+// The moves of the attacker: the transitions of both states, where a fresh
+// input may receive a fresh name or any name of the defender that is not
+// related to a name of the attacker, as in FINP.
+func (g *game) moves(pos gamePosition) []gameMove {
+	var moves []gameMove
+	for _, isLeft := range []bool{true, false} {
+		lts, id, a, d := g.state.LeftLts, pos.leftId, pos.pair.A, pos.pair.B
+		if !isLeft {
+			lts, id, a, d = g.state.RightLts, pos.rightId, pos.pair.B, pos.pair.A
+		}
+		for _, trans := range lts.Transitions {
+			if trans.Source != id {
+				continue
+			}
+			moves = append(moves, gameMove{isLeft, trans, gameFreshName})
+			if trans.Label.Symbol2.Type == pifra.SymbolTypFreshInput {
+				for _, k := range freshInputKPrimes(a, d) {
+					moves = append(moves, gameMove{isLeft, trans, k})
+				}
+			}
+		}
+	}
+	return moves
+}
+
+// The replies of the defender to a move, by the NT rules. In a weak game they
+// are transitions of the weak LTS.
+func (g *game) replies(pos gamePosition, move gameMove) []gameReply {
+	state := g.state
+	aLts, dLts, weakAdj := state.LeftLts, state.RightLts, state.WeakAdjRight
+	a, dId := pos.pair.A, pos.rightId
+	if !move.isLeft {
+		aLts, dLts, weakAdj = state.RightLts, state.LeftLts, state.WeakAdjLeft
+		a, dId = pos.pair.B, pos.leftId
+	}
+	trans := move.trans
+	pi := a.Rho[trans.Label.Symbol.Value]
+	j := trans.Label.Symbol2.Value
+	pj, known := a.Rho[j]
+	label := func(t1 pifra.SymbolType, t2 pifra.SymbolType, v int) pifra.Label {
+		return pifra.Label{
+			Symbol:  pifra.Symbol{Type: t1, Value: pi},
+			Symbol2: pifra.Symbol{Type: t2, Value: v},
+		}
+	}
+
+	// The label kind of the replies, whether a reply fits, and the label and rho
+	// of the derivative of the attacker.
+	var lk LabelsKey
+	var fits func(trans2 pifra.Transition) bool
+	var derivative func(trans2 pifra.Transition) (pifra.Label, map[int]int)
+	fitsKnown := func(trans2 pifra.Transition) bool {
+		return trans2.Label.Symbol.Value == pi && trans2.Label.Symbol2.Value == pj
+	}
+	fitsFresh := func(trans2 pifra.Transition) bool {
+		return trans2.Label.Symbol.Value == pi
+	}
+	symbol1 := trans.Label.Symbol.Type
+	switch {
+	case symbol1 == pifra.SymbolTypTau:
+		// TAU
+		lk = LabelsKey{pifra.SymbolTypTau, pifra.SymbolTypTau}
+		fits = func(trans2 pifra.Transition) bool { return true }
+		derivative = func(trans2 pifra.Transition) (pifra.Label, map[int]int) { return trans.Label, a.Rho }
+	case trans.Label.Symbol2.Type == pifra.SymbolTypKnown && known:
+		// INP1 and OUT
+		lk, fits = LabelsKey{symbol1, pifra.SymbolTypKnown}, fitsKnown
+		derivative = func(trans2 pifra.Transition) (pifra.Label, map[int]int) {
+			return label(symbol1, pifra.SymbolTypKnown, pj), a.Rho
+		}
+	case trans.Label.Symbol2.Type == pifra.SymbolTypKnown && symbol1 == pifra.SymbolTypInput:
+		// INP2
+		lk, fits = LabelsKey{pifra.SymbolTypInput, pifra.SymbolTypFreshInput}, fitsFresh
+		derivative = func(trans2 pifra.Transition) (pifra.Label, map[int]int) {
+			k := trans2.Label.Symbol2.Value
+			return label(pifra.SymbolTypInput, pifra.SymbolTypKnown, k), makeNewRho(a.Rho, j, k)
+		}
+	case trans.Label.Symbol2.Type == pifra.SymbolTypKnown:
+		// An output of a name that the defender does not know cannot be matched.
+		return nil
+	case move.kPrime != gameFreshName:
+		// FINP.2
+		pj = move.kPrime
+		lk, fits = LabelsKey{pifra.SymbolTypInput, pifra.SymbolTypKnown}, fitsKnown
+		derivative = func(trans2 pifra.Transition) (pifra.Label, map[int]int) {
+			return label(pifra.SymbolTypInput, pifra.SymbolTypKnown, pj), makeNewRho(a.Rho, j, pj)
+		}
+	default:
+		// FINP.1 and FOUT
+		t2 := trans.Label.Symbol2.Type
+		lk, fits = LabelsKey{symbol1, t2}, fitsFresh
+		derivative = func(trans2 pifra.Transition) (pifra.Label, map[int]int) {
+			k := trans2.Label.Symbol2.Value
+			return label(symbol1, t2, k), makeNewRho(a.Rho, j, k)
+		}
+	}
+
+	var replies []gameReply
+	for _, trans2 := range weakAdj[dId][lk] {
+		if !fits(trans2) {
+			continue
+		}
+		l, rho := derivative(trans2)
+		nPX, nQX, ok := ntDerivatives(aLts.States[trans.Destination], dLts.States[trans2.Destination], l, rho, state.GC)
+		if !ok {
+			continue
+		}
+		state.canonicalisePair(&nPX, &nQX, move.isLeft)
+		next := gamePosition{newGVertex(nPX, nQX, move.isLeft), trans.Destination, trans2.Destination}
+		if !move.isLeft {
+			next.leftId, next.rightId = trans2.Destination, trans.Destination
+		}
+		replies = append(replies, gameReply{trans2, next})
+	}
+	return replies
+}
+
+func (g *game) isNotRelated(pos gamePosition) bool {
+	_, ok := notR.Load(pos.key())
+	return ok
+}
+
+func (g *game) isRelated(pos gamePosition) bool {
+	_, ok := g.state.G.States[pos.key()]
+	return ok
+}
+
+// Whether none of the replies leads to a position that can be related.
+func (g *game) isWinningMove(pos gamePosition, move gameMove) bool {
+	for _, reply := range g.replies(pos, move) {
+		if !g.isNotRelated(reply.next) {
+			return false
+		}
+	}
+	return true
+}
+
+// The move of the tool as the attacker. From a pair in notR it follows the
+// recorded failure, which leads to pairs found not to be related earlier, so
+// that the play ends. Otherwise it cannot win, and takes turns over the moves.
+func (g *game) attackerMove(pos gamePosition, moves []gameMove) gameMove {
+	if failure, ok := g.state.Failures[pos.key()]; ok {
+		for _, move := range moves {
+			if move.isLeft == failure.IsLeft && fmt.Sprint(move.trans) == fmt.Sprint(failure.Trans) &&
+				g.isWinningMove(pos, move) {
+				return move
+			}
+		}
+	}
+	if g.isNotRelated(pos) {
+		for _, move := range moves {
+			if g.isWinningMove(pos, move) {
+				return move
+			}
+		}
+	}
+	return moves[g.round%len(moves)]
+}
+
+// The reply of the tool as the defender: one that stays in the relation if
+// there is one.
+func (g *game) defenderReply(replies []gameReply) gameReply {
+	for _, reply := range replies {
+		if g.isRelated(reply.next) {
+			return reply
+		}
+	}
+	for _, reply := range replies {
+		if !g.isNotRelated(reply.next) {
+			return reply
+		}
+	}
+	return replies[0]
+}
+
+// ####
+// Playing.
+// ####
+
+func transitionToString(isLeft bool, trans pifra.Transition) string {
+	return fmt.Sprintf("lts%d %d -%s-> %d", systemNumber(isLeft),
+		trans.Source, trans.Label.PrettyPrintGraph(), trans.Destination)
+}
+
+func (g *game) moveToString(move gameMove) string {
+	str := transitionToString(move.isLeft, move.trans)
+	if move.trans.Label.Symbol2.Type == pifra.SymbolTypFreshInput {
+		if move.kPrime == gameFreshName {
+			str += ", receiving a fresh name"
+		} else {
+			str += fmt.Sprintf(", receiving the name in register %d of lts%d", move.kPrime, systemNumber(!move.isLeft))
+		}
+	}
+	return str
+}
+
+func (g *game) printPosition(pos gamePosition) {
+	fmt.Fprintf(g.w, "\nRound %d.\n", g.round+1)
+	for _, side := range []struct {
+		isLeft bool
+		id     int
+		conf   FRAConfiguration
+	}{{true, pos.leftId, pos.pair.A}, {false, pos.rightId, pos.pair.B}} {
+		fmt.Fprintf(g.w, "  lts%d is at state %d: %s %s\n", systemNumber(side.isLeft), side.id,
+			pifra.PrettyPrintRegister(side.conf.Registers), pifra.PrettyPrintAst(side.conf.Process))
+	}
+	fmt.Fprintf(g.w, "  rho: %s\n", rhoToString(pos.pair.A.Rho))
+}
+
+func (g *game) winner(attacker bool) string {
+	if attacker {
+		if g.userAttacker {
+			return "The attacker, you, wins."
+		}
+		return "The attacker, the tool, wins."
+	}
+	if g.userAttacker {
+		return "The defender, the tool, wins."
+	}
+	return "The defender, you, wins."
+}
+
+// Let the user choose one of n options. Returns false if the user quits.
+func (g *game) choose(prompt string, n int) (int, bool) {
+	for {
+		fmt.Fprintf(g.w, "%s [0-%d, q to quit]: ", prompt, n-1)
+		if !g.in.Scan() {
+			fmt.Fprintln(g.w)
+			return 0, false
+		}
+		line := strings.TrimSpace(g.in.Text())
+		if line == "q" || line == "quit" {
+			return 0, false
+		}
+		if k, err := strconv.Atoi(line); err == nil && k >= 0 && k < n {
+			return k, true
+		}
+		fmt.Fprintf(g.w, "%q is not one of the options.\n", line)
+	}
+}
+
+// Play from a position until one side wins or the user quits.
+func (g *game) play(pos gamePosition, in io.Reader, w io.Writer) gameResult {
+	g.in = bufio.NewScanner(in)
+	g.w = w
+	g.visited = make(map[string]bool)
+	for g.round = 0; ; g.round++ {
+		g.printPosition(pos)
+		if g.visited[pos.key()] {
+			fmt.Fprintf(w, "The play is back at a position it has been in, so it can go on forever. %s\n", g.winner(false))
+			return gameDefenderWins
+		}
+		g.visited[pos.key()] = true
+
+		moves := g.moves(pos)
+		if len(moves) == 0 {
+			fmt.Fprintf(w, "Neither state has a transition, so the attacker cannot move. %s\n", g.winner(false))
+			return gameDefenderWins
+		}
+		var move gameMove
+		if g.userAttacker {
+			for k, m := range moves {
+				fmt.Fprintf(w, "  [%d] %s\n", k, g.moveToString(m))
+			}
+			k, ok := g.choose("Your move", len(moves))
+			if !ok {
+				return gameQuit
+			}
+			move = moves[k]
+		} else {
+			move = g.attackerMove(pos, moves)
+			fmt.Fprintf(w, "The tool attacks with %s.\n", g.moveToString(move))
+		}
+
+		replies := g.replies(pos, move)
+		if len(replies) == 0 {
+			fmt.Fprintf(w, "lts%d cannot reply to it. %s\n", systemNumber(!move.isLeft), g.winner(true))
+			return gameAttackerWins
+		}
+		var reply gameReply
+		if g.userAttacker {
+			reply = g.defenderReply(replies)
+			fmt.Fprintf(w, "The tool replies with %s.\n", transitionToString(!move.isLeft, reply.trans))
+		} else {
+			for k, r := range replies {
+				fmt.Fprintf(w, "  [%d] %s\n", k, transitionToString(!move.isLeft, r.trans))
+			}
+			k, ok := g.choose("Your reply", len(replies))
+			if !ok {
+				return gameQuit
+			}
+			reply = replies[k]
+		}
+		pos = reply.next
+	}
+}
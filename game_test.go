@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// The tool wins the game against any play of the user on the side that cannot
+// win.
+func TestGame(t *testing.T) {
+	pwd := getPwd(t)
+	outFolder := t.TempDir()
+	prevWeak := weakBisim
+	defer func() { weakBisim = prevWeak }()
+
+	// The user plays by picking the options in turns, where the ones that do not
+	// exist are skipped.
+	input := strings.Repeat("2\n0\n1\n3\n", 50)
+	cases := []struct {
+		folder       string
+		testFile     string
+		weak         bool
+		userAttacker bool
+		result       gameResult
+	}{
+		{"not-bisimilar", "jev-non-det-2", false, false, gameAttackerWins},
+		{"not-bisimilar", "jev-tau-1", false, false, gameAttackerWins},
+		{"not-bisimilar", "jev-diff-names-1", false, false, gameAttackerWins},
+		{"not-bisimilar", "buffer-2x1-deadlock", true, false, gameAttackerWins},
+		{"weak-bisimilar", "buffer-2x1", false, false, gameAttackerWins},
+		{"weak-bisimilar", "buffer-2x1", true, true, gameDefenderWins},
+		{"bisimilar", "jev-finp2-1", false, true, gameDefenderWins},
+		{"bisimilar", "jev-non-det", false, true, gameDefenderWins},
+		{"bisimilar", "jev-gc-3", false, true, gameDefenderWins},
+	}
+	for _, c := range cases {
+		testFolder := path.Join(pwd, "test", c.folder)
+		generateLts(t, testFolder, outFolder, []string{c.testFile}, flags)
+		left, err := decodeLTS(path.Join(outFolder, c.testFile+".1.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := decodeLTS(path.Join(outFolder, c.testFile+".2.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		weakBisim = c.weak
+		g, _, err := newGame(left, right, -1, c.userAttacker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := g.play(g.start(), strings.NewReader(input), ioutil.Discard); res != c.result {
+			t.Errorf("The game on %s ended with %d, expected %d.", c.testFile, res, c.result)
+		}
+	}
+}
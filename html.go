@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the HTML viewer of a check, written with -output-html.
+// The page is a single file that works offline: the LTSs, the relation and the
+// distinguishing path are embedded as JSON, and drawn by the script in the page.
+
+type htmlState struct {
+	Id        int    `json:"id"`
+	Registers string `json:"registers"`
+	Process   string `json:"process"`
+	// The position of the state in the layout: its distance from state 0, and
+	// its place among the states at that distance.
+	Layer int `json:"layer"`
+	Pos   int `json:"pos"`
+}
+
+type htmlTransition struct {
+	Source      int    `json:"source"`
+	Destination int    `json:"destination"`
+	Label       string `json:"label"`
+	// Whether the transition was added by the weak transformation.
+	Weak bool `json:"weak,omitempty"`
+}
+
+type htmlSystem struct {
+	Name        string           `json:"name"`
+	States      []htmlState      `json:"states"`
+	Transitions []htmlTransition `json:"transitions"`
+}
+
+type htmlPair struct {
+	Left   int    `json:"left"`
+	Right  int    `json:"right"`
+	Rho    string `json:"rho"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// A step of a distinguishing path in the polyadic form. For the session of a
+// polyadic prefix, Steps and ReplySteps are the transitions of the LTSs that it
+// is made of.
+type htmlPathStep struct {
+	IsLeft     bool             `json:"isLeft"`
+	Trans      htmlTransition   `json:"trans"`
+	Reply      *htmlTransition  `json:"reply,omitempty"`
+	Steps      []htmlTransition `json:"steps,omitempty"`
+	ReplySteps []htmlTransition `json:"replySteps,omitempty"`
+}
+
+type htmlData struct {
+	Bisimilar bool           `json:"bisimilar"`
+	Weak      bool           `json:"weak"`
+	N         int            `json:"n"`
+	Left      htmlSystem     `json:"left"`
+	Right     htmlSystem     `json:"right"`
+	Related   []htmlPair     `json:"related"`
+	Unmatched []htmlPair     `json:"unmatched"`
+	Path      []htmlPathStep `json:"path"`
+}
+
+func toHtmlTransition(trans pifra.Transition) htmlTransition {
+	return htmlTransition{trans.Source, trans.Destination, trans.Label.PrettyPrintGraph(), false}
+}
+
+// The states and transitions of a system, laid out in layers by their distance
+// from state 0. For a weak check, the transitions added by the weak
+// transformation are included as well.
+func toHtmlSystem(name string, lts pifra.Lts, weakLts pifra.Lts) htmlSystem {
+	sys := htmlSystem{Name: name}
+	for _, trans := range lts.Transitions {
+		sys.Transitions = append(sys.Transitions, toHtmlTransition(trans))
+	}
+	if isWeakBisim() {
+		for _, trans := range saturatedTransitions(lts, weakLts) {
+			t := toHtmlTransition(trans)
+			t.Weak = true
+			sys.Transitions = append(sys.Transitions, t)
+		}
+	}
+
+	layout := ltsLayout(lts)
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		conf := lts.States[id]
+		sys.States = append(sys.States, htmlState{
+			Id:        id,
+			Registers: pifra.PrettyPrintRegister(conf.Registers),
+			Process:   pifra.PrettyPrintAst(conf.Process),
+			Layer:     layout[id].Layer,
+			Pos:       layout[id].Pos,
+		})
+	}
+	return sys
+}
+
+func getHtmlData(state *CleavelandState, res ResultType) htmlData {
+	data := htmlData{
+		Bisimilar: res == ResultRelated,
+		Weak:      isWeakBisim(),
+		N:         getRegSize(),
+		Left:      toHtmlSystem("lts1", state.LeftLts, state.WeakLeftLts),
+		Right:     toHtmlSystem("lts2", state.RightLts, state.WeakRightLts),
+		Related:   []htmlPair{},
+		Unmatched: []htmlPair{},
+		Path:      []htmlPathStep{},
+	}
+	seen := make(map[htmlPair]bool)
+	for _, pair := range state.relatedPairs() {
+		p := htmlPair{Left: pair.Left, Right: pair.Right, Rho: rhoToString(pair.Rho)}
+		seen[p] = true
+		data.Related = append(data.Related, p)
+	}
+	for _, f := range state.Failures {
+		p := htmlPair{Left: f.LeftId, Right: f.RightId, Rho: rhoToString(f.Rho)}
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		p.Reason = fmt.Sprintf("%s %d -%s-> %d cannot be matched", systemName(f.IsLeft),
+			f.Trans.Source, f.Trans.Label.PrettyPrintGraph(), f.Trans.Destination)
+		data.Unmatched = append(data.Unmatched, p)
+	}
+	sort.Slice(data.Unmatched, func(i, j int) bool {
+		a, b := data.Unmatched[i], data.Unmatched[j]
+		if a.Left != b.Left {
+			return a.Left < b.Left
+		}
+		if a.Right != b.Right {
+			return a.Right < b.Right
+		}
+		return a.Rho < b.Rho
+	})
+	if res == ResultNotRelated {
+		data.Path = toHtmlPath(checkPolyadicPath(state))
+	}
+	return data
+}
+
+func toHtmlPath(path []polyadicPathStep) []htmlPathStep {
+	steps := []htmlPathStep{}
+	for _, step := range path {
+		s := htmlPathStep{IsLeft: step.IsLeft, Trans: toHtmlSession(step.Trans)}
+		if len(step.Trans) > 1 {
+			s.Steps = toHtmlTransitions(step.Trans)
+		}
+		if len(step.Reply) > 0 {
+			reply := toHtmlSession(step.Reply)
+			s.Reply = &reply
+			if len(step.Reply) > 1 {
+				s.ReplySteps = toHtmlTransitions(step.Reply)
+			}
+		}
+		steps = append(steps, s)
+	}
+	return steps
+}
+
+func toHtmlSession(session []pifra.Transition) htmlTransition {
+	trans := sessionTransition(session)
+	return htmlTransition{trans.Source, trans.Destination, trans.Label, false}
+}
+
+func toHtmlTransitions(transitions []pifra.Transition) []htmlTransition {
+	var res []htmlTransition
+	for _, trans := range transitions {
+		res = append(res, toHtmlTransition(trans))
+	}
+	return res
+}
+
+// The page of a check, with its data embedded.
+func generateHtmlFile(state *CleavelandState, res ResultType) ([]byte, error) {
+	// json.Marshal escapes <, > and &, so the data cannot end the script.
+	data, err := json.Marshal(getHtmlData(state, res))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.Replace(htmlPage, "{{DATA}}", string(data), 1)), nil
+}
+
+const htmlPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pisim22</title>
+<style>
+body { margin: 0; font-family: sans-serif; font-size: 13px; display: flex; height: 100vh; }
+#graphs { flex: 1; display: flex; flex-direction: column; min-width: 0; }
+.pane { flex: 1; display: flex; flex-direction: column; border-bottom: 1px solid #ccc; min-height: 0; }
+.pane h2 { margin: 0; padding: 4px 8px; font-size: 14px; background: #f3f3f3; }
+.pane svg { flex: 1; width: 100%; cursor: grab; background: #fff; }
+#side { width: 380px; overflow-y: auto; border-left: 1px solid #ccc; padding: 8px; box-sizing: border-box; }
+#side h3 { margin: 12px 0 4px; font-size: 14px; }
+pre { white-space: pre-wrap; word-break: break-all; background: #f7f7f7; padding: 4px; margin: 2px 0; }
+.verdict-yes { color: #2ca02c; } .verdict-no { color: #d62728; }
+.node circle { fill: #fff; stroke: #333; stroke-width: 1.5; }
+.node text { text-anchor: middle; dominant-baseline: central; font-size: 11px; pointer-events: none; }
+.node.start circle { stroke-width: 3; }
+.node.current circle { fill: #ffe9a8; }
+.node.selected circle { stroke: #1f77b4; stroke-width: 3; }
+.edge path { fill: none; stroke: #555; }
+.edge text { font-size: 10px; fill: #333; }
+.edge.weak path { stroke: #aaa; stroke-dasharray: 4 3; }
+.edge.weak text { fill: #999; }
+.edge.attack path { stroke: #d62728; stroke-width: 2.5; } .edge.attack text { fill: #d62728; }
+.edge.reply path { stroke: #ff7f0e; stroke-width: 2.5; } .edge.reply text { fill: #ff7f0e; }
+.hidden { display: none; }
+button.trans { display: block; margin: 2px 0; font-family: monospace; text-align: left; }
+#pairs div { cursor: pointer; padding: 1px 2px; font-family: monospace; }
+#pairs div:hover { background: #eef; }
+.unmatched { color: #d62728; }
+</style>
+</head>
+<body>
+<div id="graphs">
+  <div class="pane"><h2>lts1</h2><svg id="svg-left"></svg></div>
+  <div class="pane"><h2>lts2</h2><svg id="svg-right"></svg></div>
+</div>
+<div id="side">
+  <div id="verdict"></div>
+  <label><input type="checkbox" id="show-weak" checked> Show the transitions added by the weak transformation</label>
+  <h3>Selected state</h3>
+  <div id="info">Click a state to see its registers and process.</div>
+  <h3>Step the systems</h3>
+  <div id="stepper"></div>
+  <h3>Distinguishing path</h3>
+  <div id="path"></div>
+  <h3>Pairs</h3>
+  <select id="filter">
+    <option value="all">All</option>
+    <option value="related">Related</option>
+    <option value="unmatched">Unmatched</option>
+  </select>
+  <div id="pairs"></div>
+</div>
+<script id="data" type="application/json">{{DATA}}</script>
+<script>
+"use strict";
+const data = JSON.parse(document.getElementById("data").textContent);
+const NS = "http://www.w3.org/2000/svg";
+const DX = 110, DY = 90, R = 16;
+const systems = { left: data.left, right: data.right };
+const current = { left: 0, right: 0 };
+const views = {};
+
+function el(tag, attrs, parent) {
+  const e = document.createElementNS(NS, tag);
+  for (const k in attrs) e.setAttribute(k, attrs[k]);
+  if (parent) parent.appendChild(e);
+  return e;
+}
+
+function text(s) { return document.createTextNode(s); }
+
+function pathKey(t) { return t.source + "," + t.destination + "," + t.label; }
+const attack = { left: new Set(), right: new Set() };
+const reply = { left: new Set(), right: new Set() };
+for (const step of data.path) {
+  const side = step.isLeft ? "left" : "right", other = step.isLeft ? "right" : "left";
+  (step.steps || [step.trans]).forEach(t => attack[side].add(pathKey(t)));
+  if (step.reply) (step.replySteps || [step.reply]).forEach(t => reply[other].add(pathKey(t)));
+}
+
+function draw(side) {
+  const sys = systems[side];
+  const svg = document.getElementById("svg-" + side);
+  const defs = el("defs", {}, svg);
+  const marker = el("marker", { id: "arrow-" + side, viewBox: "0 0 10 10", refX: 10, refY: 5,
+    markerWidth: 7, markerHeight: 7, orient: "auto-start-reverse" }, defs);
+  el("path", { d: "M 0 0 L 10 5 L 0 10 z", fill: "#555" }, marker);
+  const root = el("g", {}, svg);
+  const pos = {};
+  const width = {};
+  for (const s of sys.states) width[s.layer] = Math.max(width[s.layer] || 0, s.pos + 1);
+  let maxWidth = 0;
+  for (const l in width) maxWidth = Math.max(maxWidth, width[l]);
+  for (const s of sys.states) {
+    pos[s.id] = { x: 40 + (s.pos + (maxWidth - width[s.layer]) / 2) * DX, y: 40 + s.layer * DY };
+  }
+  // Transitions between the same states are bent apart.
+  const count = {};
+  const edges = el("g", {}, root);
+  for (const t of sys.transitions) {
+    const a = pos[t.source], b = pos[t.destination];
+    if (!a || !b) continue;
+    const pair = Math.min(t.source, t.destination) + "-" + Math.max(t.source, t.destination);
+    const n = count[pair] = (count[pair] || 0) + 1;
+    let cls = "edge";
+    if (t.weak) cls += " weak";
+    if (attack[side].has(pathKey(t))) cls += " attack";
+    else if (reply[side].has(pathKey(t))) cls += " reply";
+    const g = el("g", { "class": cls }, edges);
+    let d, lx, ly;
+    if (t.source === t.destination) {
+      const r = 10 + 6 * n;
+      d = "M " + (a.x - 8) + " " + (a.y - R + 2) + " C " + (a.x - r) + " " + (a.y - R - 2 * r) + " " +
+        (a.x + r) + " " + (a.y - R - 2 * r) + " " + (a.x + 8) + " " + (a.y - R + 2);
+      lx = a.x; ly = a.y - R - 1.5 * r;
+    } else {
+      const dx = b.x - a.x, dy = b.y - a.y, len = Math.sqrt(dx * dx + dy * dy);
+      const ux = dx / len, uy = dy / len;
+      const sign = t.source < t.destination ? 1 : -1;
+      const bend = sign * (n - 1) * 18;
+      const mx = (a.x + b.x) / 2 - uy * bend, my = (a.y + b.y) / 2 + ux * bend;
+      d = "M " + (a.x + ux * R) + " " + (a.y + uy * R) + " Q " + mx + " " + my + " " +
+        (b.x - ux * R) + " " + (b.y - uy * R);
+      lx = mx; ly = my - 3;
+    }
+    el("path", { d: d, "marker-end": "url(#arrow-" + side + ")" }, g);
+    el("text", { x: lx, y: ly }, g).appendChild(text(t.label));
+  }
+  const nodes = {};
+  for (const s of sys.states) {
+    const g = el("g", { "class": "node" + (s.id === 0 ? " start" : ""),
+      transform: "translate(" + pos[s.id].x + "," + pos[s.id].y + ")" }, root);
+    el("circle", { r: R }, g);
+    el("text", {}, g).appendChild(text(String(s.id)));
+    g.style.cursor = "pointer";
+    g.addEventListener("click", ev => { ev.stopPropagation(); select(side, s.id); });
+    nodes[s.id] = g;
+  }
+  views[side] = { svg: svg, root: root, nodes: nodes, pos: pos, tx: 0, ty: 0, k: 1 };
+  panZoom(side);
+}
+
+function applyView(v) {
+  v.root.setAttribute("transform", "translate(" + v.tx + "," + v.ty + ") scale(" + v.k + ")");
+}
+
+function panZoom(side) {
+  const v = views[side];
+  let drag = null;
+  v.svg.addEventListener("mousedown", ev => { drag = { x: ev.clientX - v.tx, y: ev.clientY - v.ty }; });
+  window.addEventListener("mouseup", () => { drag = null; });
+  window.addEventListener("mousemove", ev => {
+    if (!drag) return;
+    v.tx = ev.clientX - drag.x; v.ty = ev.clientY - drag.y; applyView(v);
+  });
+  v.svg.addEventListener("wheel", ev => {
+    ev.preventDefault();
+    const rect = v.svg.getBoundingClientRect();
+    const mx = ev.clientX - rect.left, my = ev.clientY - rect.top;
+    const f = ev.deltaY < 0 ? 1.15 : 1 / 1.15;
+    v.tx = mx - (mx - v.tx) * f; v.ty = my - (my - v.ty) * f; v.k *= f;
+    applyView(v);
+  }, { passive: false });
+}
+
+// Move the view so that the state is in the middle.
+function centre(side, id) {
+  const v = views[side], p = v.pos[id];
+  const rect = v.svg.getBoundingClientRect();
+  v.tx = rect.width / 2 - p.x * v.k; v.ty = rect.height / 2 - p.y * v.k;
+  applyView(v);
+}
+
+function state(side, id) { return systems[side].states.find(s => s.id === id); }
+
+let selected = null;
+function select(side, id) {
+  if (selected) views[selected.side].nodes[selected.id].classList.remove("selected");
+  selected = { side: side, id: id };
+  views[side].nodes[id].classList.add("selected");
+  const s = state(side, id);
+  const info = document.getElementById("info");
+  info.innerHTML = "";
+  info.appendChild(text(systems[side].name + ", state " + id));
+  const regs = document.createElement("pre"); regs.appendChild(text(s.registers)); info.appendChild(regs);
+  const proc = document.createElement("pre"); proc.appendChild(text(s.process)); info.appendChild(proc);
+}
+
+function pairsOf(left, right) {
+  const rel = data.related.filter(p => p.left === left && p.right === right);
+  const un = data.unmatched.filter(p => p.left === left && p.right === right);
+  return { rel: rel, un: un };
+}
+
+function renderStepper() {
+  for (const side of ["left", "right"]) {
+    for (const id in views[side].nodes) views[side].nodes[id].classList.remove("current");
+    views[side].nodes[current[side]].classList.add("current");
+  }
+  const div = document.getElementById("stepper");
+  div.innerHTML = "";
+  const p = pairsOf(current.left, current.right);
+  let status = "(" + current.left + ", " + current.right + ") ";
+  if (p.rel.length > 0) status += "is related for rho " + p.rel.map(x => x.rho).join(", ");
+  else if (p.un.length > 0) status += "is not related: " + p.un.map(x => x.reason).join("; ");
+  else status += "was not visited by the check";
+  const st = document.createElement("div");
+  st.className = p.rel.length > 0 ? "" : "unmatched";
+  st.appendChild(text(status));
+  div.appendChild(st);
+  const showWeak = document.getElementById("show-weak").checked;
+  for (const side of ["left", "right"]) {
+    const h = document.createElement("div");
+    h.appendChild(text(systems[side].name + " in state " + current[side] + ":"));
+    div.appendChild(h);
+    for (const t of systems[side].transitions) {
+      if (t.source !== current[side] || (t.weak && !showWeak)) continue;
+      const b = document.createElement("button");
+      b.className = "trans";
+      b.appendChild(text("-" + t.label + "-> " + t.destination + (t.weak ? " (weak)" : "")));
+      b.addEventListener("click", () => { current[side] = t.destination; centre(side, t.destination); renderStepper(); });
+      div.appendChild(b);
+    }
+  }
+  const reset = document.createElement("button");
+  reset.appendChild(text("Back to the start states"));
+  reset.addEventListener("click", () => goTo(0, 0));
+  div.appendChild(reset);
+}
+
+function goTo(left, right) {
+  current.left = left; current.right = right;
+  centre("left", left); centre("right", right);
+  renderStepper();
+}
+
+function renderPairs() {
+  const filter = document.getElementById("filter").value;
+  const div = document.getElementById("pairs");
+  div.innerHTML = "";
+  const add = (p, unmatched) => {
+    const d = document.createElement("div");
+    if (unmatched) d.className = "unmatched";
+    d.appendChild(text("(" + p.left + ", " + p.right + ") " + p.rho + (unmatched ? " " + p.reason : "")));
+    d.addEventListener("click", () => goTo(p.left, p.right));
+    div.appendChild(d);
+  };
+  if (filter !== "unmatched") data.related.forEach(p => add(p, false));
+  if (filter !== "related") data.unmatched.forEach(p => add(p, true));
+}
+
+function renderPath() {
+  const div = document.getElementById("path");
+  if (data.path.length === 0) {
+    div.appendChild(text(data.bisimilar ? "The systems are bisimilar." : "No path was recorded."));
+    return;
+  }
+  data.path.forEach((step, i) => {
+    const side = step.isLeft ? "lts1" : "lts2", other = step.isLeft ? "lts2" : "lts1";
+    let s = (i + 1) + ". " + side + " " + step.trans.source + " -" + step.trans.label + "-> " + step.trans.destination;
+    if (step.reply) s += ", " + other + " replies " + step.reply.source + " -" + step.reply.label + "-> " + step.reply.destination;
+    else s += ", which " + other + " cannot match";
+    const d = document.createElement("div");
+    d.appendChild(text(s));
+    div.appendChild(d);
+  });
+}
+
+function toggleWeak() {
+  const show = document.getElementById("show-weak").checked;
+  document.querySelectorAll(".edge.weak").forEach(e => e.classList.toggle("hidden", !show));
+  renderStepper();
+}
+
+const verdict = document.getElementById("verdict");
+verdict.className = data.bisimilar ? "verdict-yes" : "verdict-no";
+verdict.appendChild(text("The systems are " + (data.bisimilar ? "" : "NOT ") + (data.weak ? "weakly " : "") +
+  "bisimilar, N=" + data.n + "."));
+draw("left");
+draw("right");
+document.getElementById("show-weak").addEventListener("change", toggleWeak);
+document.getElementById("filter").addEventListener("change", renderPairs);
+renderPath();
+renderPairs();
+goTo(0, 0);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHtmlFile(t *testing.T) {
+	pwd := getPwd(t)
+	outFolder := t.TempDir()
+	prevName := outputHtmlName
+	defer func() { outputHtmlName = prevName }()
+
+	cases := []struct {
+		folder   string
+		testFile string
+		res      ResultType
+	}{
+		{"not-bisimilar", "jev-non-det-2", ResultNotRelated},
+		{"bisimilar", "jev-gc-3", ResultRelated},
+	}
+	for _, c := range cases {
+		testFolder := path.Join(pwd, "test", c.folder)
+		generateLts(t, testFolder, outFolder, []string{c.testFile}, flags)
+		left, err := decodeLTS(path.Join(outFolder, c.testFile+".1.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := decodeLTS(path.Join(outFolder, c.testFile+".2.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputHtmlName = path.Join(outFolder, c.testFile+".html")
+		if status := checkBisim(left, right, left, right, -1, -1, false); status != c.res {
+			t.Fatalf("%s was %d, expected %d.", c.testFile, status, c.res)
+		}
+		page, err := ioutil.ReadFile(outputHtmlName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The page must work offline.
+		if regexp.MustCompile(`<script[^>]*src=|<link[^>]*href=`).Match(page) {
+			t.Errorf("The page of %s loads external files.", c.testFile)
+		}
+		m := regexp.MustCompile(`(?s)<script id="data" type="application/json">(.*?)</script>`).FindSubmatch(page)
+		if m == nil {
+			t.Fatalf("The page of %s has no data.", c.testFile)
+		}
+		var data htmlData
+		if err := json.Unmarshal(m[1], &data); err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Left.States) != len(left.States) || len(data.Right.States) != len(right.States) {
+			t.Errorf("The page of %s has %d and %d states, expected %d and %d.", c.testFile,
+				len(data.Left.States), len(data.Right.States), len(left.States), len(right.States))
+		}
+		if data.Left.States[0].Process == "" || !strings.Contains(data.Left.States[0].Registers, "{") {
+			t.Errorf("The start state of %s has no process or registers.", c.testFile)
+		}
+		if c.res == ResultRelated {
+			if !data.Bisimilar || len(data.Related) == 0 || data.Related[0].Left != 0 || data.Related[0].Right != 0 {
+				t.Errorf("The page of %s does not relate the start states.", c.testFile)
+			}
+		} else if data.Bisimilar || len(data.Unmatched) == 0 || len(data.Path) == 0 {
+			t.Errorf("The page of %s has no unmatched pairs or distinguishing path.", c.testFile)
+		}
+	}
+}
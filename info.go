@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with `pisim22 info model`, which reports statistics of the
+// LTS of a model or of an LTS file, to predict whether a check on it is
+// feasible.
+
+type ltsInfo struct {
+	Name        string `json:"name"`
+	States      int    `json:"states"`
+	Transitions int    `json:"transitions"`
+	// The states pifra looked for the transitions of, as it stops at
+	// -max-states.
+	Explored int `json:"explored"`
+	// The transitions by the kind of their label, as in LabelsKey.
+	Labels map[string]int `json:"labels"`
+	// The largest register of a state, as in getMaxMinRegSize, and the
+	// average and the largest number of names in the registers of a state.
+	MaxRegister    int     `json:"maxRegister"`
+	AvgRegisters   float64 `json:"avgRegisters"`
+	MaxRegisters   int     `json:"maxRegisters"`
+	RegSizeReached int     `json:"regSizeReached"`
+	TauRatio       float64 `json:"tauRatio"`
+	// The strongly connected components of tau transitions with a cycle, and
+	// the number of states of the largest one.
+	TauSCCs       int `json:"tauSccs"`
+	LargestTauSCC int `json:"largestTauScc"`
+	// The number of transitions of a state.
+	AvgBranching float64 `json:"avgBranching"`
+	MaxBranching int     `json:"maxBranching"`
+	// The states without transitions.
+	NoTransitions int `json:"noTransitions"`
+	// The longest of the shortest paths from state 0.
+	Depth int `json:"depth"`
+	// The pairs of states related by the tau closure, and the number of
+	// transitions of the weak transform at most.
+	TauClosure      int `json:"tauClosure"`
+	WeakTransitions int `json:"weakTransitions"`
+}
+
+// The name of a kind of label.
+func labelsKeyName(lk LabelsKey) string {
+	switch {
+	case lk.SymbolType1 == pifra.SymbolTypTau:
+		return "tau"
+	case lk.SymbolType1 == pifra.SymbolTypInput && lk.SymbolType2 == pifra.SymbolTypKnown:
+		return "input"
+	case lk.SymbolType1 == pifra.SymbolTypInput && lk.SymbolType2 == pifra.SymbolTypFreshInput:
+		return "fresh input"
+	case lk.SymbolType1 == pifra.SymbolTypOutput && lk.SymbolType2 == pifra.SymbolTypKnown:
+		return "output"
+	case lk.SymbolType1 == pifra.SymbolTypOutput && lk.SymbolType2 == pifra.SymbolTypFreshOutput:
+		return "bound output"
+	}
+	return fmt.Sprintf("%d/%d", lk.SymbolType1, lk.SymbolType2)
+}
+
+func getLtsInfo(name string, lts pifra.Lts) ltsInfo {
+	info := ltsInfo{
+		Name:        name,
+		States:      len(lts.States),
+		Transitions: len(lts.Transitions),
+		Explored:    lts.StatesExplored,
+		Labels:      make(map[string]int),
+		MaxRegister: getMaxMinRegSize(lts),
+	}
+	if info.Explored == 0 || info.Explored > info.States {
+		info.Explored = info.States
+	}
+	tau := 0
+	for i := range lts.Transitions {
+		lk := getAdvAdjKey(&lts.Transitions[i])
+		info.Labels[labelsKeyName(lk)]++
+		if lk.SymbolType1 == pifra.SymbolTypTau {
+			tau++
+		}
+	}
+	if info.Transitions > 0 {
+		info.TauRatio = float64(tau) / float64(info.Transitions)
+	}
+
+	adj := ToAdjacency(lts)
+	registers := 0
+	for id, conf := range lts.States {
+		n := len(conf.Registers.Registers)
+		registers += n
+		if n > info.MaxRegisters {
+			info.MaxRegisters = n
+		}
+		if lts.RegSizeReached[id] {
+			info.RegSizeReached++
+		}
+		if len(adj[id]) > info.MaxBranching {
+			info.MaxBranching = len(adj[id])
+		}
+		if len(adj[id]) == 0 {
+			info.NoTransitions++
+		}
+	}
+	if info.States > 0 {
+		info.AvgRegisters = float64(registers) / float64(info.States)
+		info.AvgBranching = float64(info.Transitions) / float64(info.States)
+	}
+	info.Depth = ltsDepth(lts, adj)
+	info.TauSCCs, info.LargestTauSCC, info.TauClosure, info.WeakTransitions = tauClosureInfo(lts)
+	return info
+}
+
+func ltsDepth(lts pifra.Lts, adj map[int][]pifra.Transition) int {
+	if _, ok := lts.States[0]; !ok {
+		return 0
+	}
+	dist := map[int]int{0: 0}
+	queue := []int{0}
+	depth := 0
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, trans := range adj[id] {
+			if _, ok := dist[trans.Destination]; !ok {
+				dist[trans.Destination] = dist[id] + 1
+				depth = dist[id] + 1
+				queue = append(queue, trans.Destination)
+			}
+		}
+	}
+	return depth
+}
+
+// The tau SCCs and the size of the weak transform, by the tau closure of
+// dfsClosure. The weak transform has a transition for each transition s -a-> t
+// and each pair of states s' =tau=> s and t =tau=> t', and a tau transition
+// from each state to itself, less the ones that are the same.
+func tauClosureInfo(lts pifra.Lts) (sccs int, largest int, closure int, weak int) {
+	M, _, revDict := dfsClosure(lts)
+	pre := make([]int, len(M))
+	post := make([]int, len(M))
+	for i := range M {
+		for j := range M[i] {
+			if M[i][j] {
+				post[i]++
+				pre[j]++
+				closure++
+			}
+		}
+	}
+	weak = len(M)
+	for _, trans := range lts.Transitions {
+		weak += pre[revDict[trans.Source]] * post[revDict[trans.Destination]]
+	}
+
+	// A state is on a tau cycle if it has a tau transition to a state that
+	// reaches it back.
+	onCycle := make([]bool, len(M))
+	for _, trans := range lts.Transitions {
+		if trans.Label.Symbol.Type == pifra.SymbolTypTau && M[revDict[trans.Destination]][revDict[trans.Source]] {
+			onCycle[revDict[trans.Source]] = true
+		}
+	}
+	seen := make([]bool, len(M))
+	for i := range M {
+		if !onCycle[i] || seen[i] {
+			continue
+		}
+		size := 0
+		for j := range M {
+			if M[i][j] && M[j][i] {
+				seen[j] = true
+				size++
+			}
+		}
+		sccs++
+		if size > largest {
+			largest = size
+		}
+	}
+	return sccs, largest, closure, weak
+}
+
+func printLtsInfo(info ltsInfo) {
+	fmt.Printf("%s\n", info.Name)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  states\t%d\n", info.States)
+	if info.Explored < info.States {
+		fmt.Fprintf(w, "  explored\t%d (stopped at -max-states)\n", info.Explored)
+	}
+	fmt.Fprintf(w, "  transitions\t%d\n", info.Transitions)
+	var kinds []string
+	for kind := range info.Labels {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "    %s\t%d\n", kind, info.Labels[kind])
+	}
+	fmt.Fprintf(w, "  tau ratio\t%.2f\n", info.TauRatio)
+	fmt.Fprintf(w, "  tau SCCs\t%d (largest %d states)\n", info.TauSCCs, info.LargestTauSCC)
+	fmt.Fprintf(w, "  largest register\t%d\n", info.MaxRegister)
+	fmt.Fprintf(w, "  names per state\t%.2f average, %d max\n", info.AvgRegisters, info.MaxRegisters)
+	fmt.Fprintf(w, "  register size reached\t%d states\n", info.RegSizeReached)
+	fmt.Fprintf(w, "  branching\t%.2f average, %d max\n", info.AvgBranching, info.MaxBranching)
+	fmt.Fprintf(w, "  without transitions\t%d states\n", info.NoTransitions)
+	fmt.Fprintf(w, "  depth\t%d\n", info.Depth)
+	fmt.Fprintf(w, "  tau closure\t%d pairs\n", info.TauClosure)
+	fmt.Fprintf(w, "  weak transform\t%d states, at most %d transitions\n", info.States, info.WeakTransitions)
+	w.Flush()
+}
+
+// The info command.
+func infoCommand(args []string) {
+	fs := newCommandFlagSet("info", "[flags] model...",
+		"Print statistics of the LTSs of models or of LTS files.")
+	jsonFlag := fs.Bool("json", false, "Whether to print the statistics as JSON.")
+	verboseFlag := fs.Bool("v", false, "Whether to be verbose.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if len(args) == 0 {
+		usageError(fs, fmt.Errorf("expected at least one model or LTS file as argument"))
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+	verbose = *verboseFlag
+
+	dir, err := ioutil.TempDir("", "pisim22-info")
+	check(err)
+	defer os.RemoveAll(dir)
+	var infos []ltsInfo
+	for _, name := range args {
+		lts, _, err := loadOrGenerateLts(name, dir, pf.flags())
+		check(err)
+		infos = append(infos, getLtsInfo(name, lts))
+	}
+	if *jsonFlag {
+		data, err := json.MarshalIndent(infos, "", "  ")
+		check(err)
+		fmt.Printf("%s\n", data)
+		return
+	}
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println()
+		}
+		printLtsInfo(info)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	cases := []struct {
+		src       string
+		maxStates int
+		expected  ltsInfo
+	}{
+		// A tau after each input.
+		{"P(a) = a(x).$c.(c'<c>.0 | c(y).P(a))\nP(a)\n", 100, ltsInfo{
+			States: 4, Transitions: 7, Explored: 4,
+			Labels:      map[string]int{"input": 3, "fresh input": 2, "tau": 2},
+			MaxRegister: 2, MaxRegisters: 2, TauSCCs: 0, MaxBranching: 3,
+			NoTransitions: 0, Depth: 2, TauClosure: 6, WeakTransitions: 26,
+		}},
+		// A tau loop in state 0.
+		{"P(a) = a(x).0 + $c.(c'<c>.0 | c(y).P(a))\nP(a)\n", 100, ltsInfo{
+			States: 2, Transitions: 3, Explored: 2,
+			Labels:      map[string]int{"input": 1, "fresh input": 1, "tau": 1},
+			MaxRegister: 1, MaxRegisters: 1, TauSCCs: 1, LargestTauSCC: 1, MaxBranching: 3,
+			NoTransitions: 1, Depth: 1, TauClosure: 2, WeakTransitions: 5,
+		}},
+		// An infinite LTS that is cut at 10 states.
+		{"P(a) = $c.a'<c>.(P(a) | P(c))\nP(a)\n", 10, ltsInfo{
+			States: 11, Transitions: 55, Explored: 10,
+			Labels:      map[string]int{"bound output": 55},
+			MaxRegister: 11, MaxRegisters: 11, MaxBranching: 10,
+			NoTransitions: 1, Depth: 10, TauClosure: 11, WeakTransitions: 66,
+		}},
+	}
+	for _, c := range cases {
+		lts := analyzeTestLts(t, c.src, c.maxStates)
+		info := getLtsInfo("", lts)
+		info.AvgRegisters, info.AvgBranching, info.TauRatio = 0, 0, 0
+		if !reflect.DeepEqual(info, c.expected) {
+			t.Errorf("The statistics of %q are\n%+v, expected\n%+v.", c.src, info, c.expected)
+		}
+		// The projected size of the weak transform is an upper bound.
+		if n := len(doWeakTransform(lts).Transitions); n > info.WeakTransitions {
+			t.Errorf("The weak transform of %q has %d transitions, more than %d.", c.src, n, info.WeakTransitions)
+		}
+	}
+}
@@ -0,0 +1,1001 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with `pisim22 lsp`, a language server for pifra models that
+// talks JSON-RPC on stdin and stdout. It has:
+//
+//   - diagnostics for syntax errors and the errors of the pifra parser, and
+//     warnings for calls of undeclared processes and calls with the wrong
+//     number of names;
+//   - go to definition and hover on a process identifier;
+//   - a code action on a declaration that checks it against another declaration
+//     with the same number of parameters. The verdict is shown as a diagnostic
+//     on the declaration until the document changes, and replaces the verdict
+//     of an earlier run of the same check.
+//
+// Both pifra and the check keep their state in package globals, so every check
+// is run in a child process of pisim22, as the jobs of serve are. The checks
+// are run one at a time by a worker goroutine, while the requests are still
+// served, and a check is answered when it finishes.
+//
+// pifra does not give the positions of its errors, so the model is first parsed
+// by the outline parser below, which follows the grammar of pifra.
+
+// ####
+// The outline of a model.
+// ####
+
+// A declaration P(x,y) = ... or P = ...
+type piDecl struct {
+	name   piToken
+	params []string
+	// The offsets of the declaration.
+	start, end int
+}
+
+// A call of a process, P or P(x,y).
+type piCall struct {
+	name  piToken
+	arity int
+}
+
+// A problem in a model, between two offsets.
+type piProblem struct {
+	start, end int
+	warning    bool
+	msg        string
+}
+
+type piOutline struct {
+	decls []*piDecl
+	calls []piCall
+	// The offsets of the undeclared processes.
+	undecls  [][2]int
+	problems []piProblem
+}
+
+// The last declaration of a process, which is the one that pifra uses.
+func (o *piOutline) lookup(name string) *piDecl {
+	for i := len(o.decls) - 1; i >= 0; i-- {
+		if o.decls[i].name.text == name {
+			return o.decls[i]
+		}
+	}
+	return nil
+}
+
+func (o *piOutline) hasErrors() bool {
+	for _, p := range o.problems {
+		if !p.warning {
+			return true
+		}
+	}
+	return false
+}
+
+// The punctuation of the pifra lexer.
+const piPunct = "'[]$+()<>,!=|."
+
+type piSyntaxError struct {
+	tok piToken
+	msg string
+}
+
+func (e *piSyntaxError) Error() string {
+	return e.msg
+}
+
+type piParser struct {
+	toks    []piToken
+	pos     int
+	outline *piOutline
+}
+
+func describePiToken(t piToken) string {
+	if t.start == t.end {
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func (p *piParser) peek() piToken {
+	return p.toks[p.pos]
+}
+
+func (p *piParser) next() piToken {
+	t := p.toks[p.pos]
+	if p.pos < len(p.toks)-1 {
+		p.pos++
+	}
+	return t
+}
+
+func (p *piParser) atEOF() bool {
+	return p.pos == len(p.toks)-1
+}
+
+// The end of the last token that was read.
+func (p *piParser) prevEnd() int {
+	return p.toks[p.pos-1].end
+}
+
+func (p *piParser) accept(text string) bool {
+	if t := p.peek(); t.typ == piTokPunct && t.text == text {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *piParser) errorf(t piToken, format string, args ...interface{}) error {
+	return &piSyntaxError{t, fmt.Sprintf(format, args...)}
+}
+
+func (p *piParser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, found %s", text, describePiToken(t))
+	}
+	return nil
+}
+
+func isPiName(t piToken) bool {
+	return t.typ == piTokName && t.text != "0"
+}
+
+func (p *piParser) name() (piToken, error) {
+	t := p.next()
+	if !isPiName(t) {
+		return t, p.errorf(t, "expected a name, found %s", describePiToken(t))
+	}
+	return t, nil
+}
+
+// Read a list of names up to the closing bracket.
+func (p *piParser) names(close string) ([]string, error) {
+	var res []string
+	for {
+		t, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t.text)
+		if p.accept(close) {
+			return res, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Parse the outline of a model. Parsing stops at the first syntax error, as in
+// pifra, so the outline only has the declarations and calls before it.
+func parsePiOutline(src []byte) *piOutline {
+	o := &piOutline{}
+	toks := lexPi(src)
+	for _, t := range toks {
+		if t.typ == piTokPunct && !strings.Contains(piPunct, t.text) {
+			o.problems = append(o.problems, piProblem{t.start, t.end, false,
+				fmt.Sprintf("unexpected character %q", t.text)})
+		}
+	}
+	if len(o.problems) > 0 {
+		return o
+	}
+	p := &piParser{toks: append(toks, piToken{piTokPunct, "", len(src), len(src)}), outline: o}
+	for !p.atEOF() {
+		if err := p.stmt(); err != nil {
+			e := err.(*piSyntaxError)
+			o.problems = append(o.problems, piProblem{e.tok.start, e.tok.end, false, e.msg})
+			return o
+		}
+	}
+	o.check(len(src))
+	return o
+}
+
+// Try to read the head P(x,y) = or P = of a declaration.
+func (p *piParser) declHead() (*piDecl, bool) {
+	start := p.pos
+	t := p.next()
+	if !isPiName(t) {
+		p.pos = start
+		return nil, false
+	}
+	d := &piDecl{name: t, start: t.start}
+	if p.accept("(") {
+		params, err := p.names(")")
+		if err != nil {
+			p.pos = start
+			return nil, false
+		}
+		d.params = params
+	}
+	if !p.accept("=") {
+		p.pos = start
+		return nil, false
+	}
+	return d, true
+}
+
+// stmt := NAME [ '(' names ')' ] '=' elem | elem
+func (p *piParser) stmt() error {
+	if d, ok := p.declHead(); ok {
+		if err := p.elem(); err != nil {
+			return err
+		}
+		d.end = p.prevEnd()
+		p.outline.decls = append(p.outline.decls, d)
+		return nil
+	}
+	start := p.peek().start
+	if err := p.elem(); err != nil {
+		return err
+	}
+	p.outline.undecls = append(p.outline.undecls, [2]int{start, p.prevEnd()})
+	return nil
+}
+
+// elem := prefixed [ ('|' | '+') elem ]
+func (p *piParser) elem() error {
+	if err := p.prefixed(); err != nil {
+		return err
+	}
+	if p.accept("|") || p.accept("+") {
+		return p.elem()
+	}
+	return nil
+}
+
+// The prefixes take the rest of the process, as in pifra. The polyadic
+// prefixes of polyadic.go are accepted as well.
+func (p *piParser) prefixed() error {
+	t := p.next()
+	switch {
+	case t.typ == piTokName && t.text == "0":
+		return nil
+	case t.text == "(":
+		if err := p.elem(); err != nil {
+			return err
+		}
+		return p.expect(")")
+	case t.text == "$":
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		return p.continuation()
+	case t.text == "[":
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		p.accept("!")
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+		return p.elem()
+	case t.typ == piTokName:
+		output := p.accept("<")
+		if !output && p.accept("'") {
+			if err := p.expect("<"); err != nil {
+				return err
+			}
+			output = true
+		}
+		if output {
+			if _, err := p.names(">"); err != nil {
+				return err
+			}
+			return p.continuation()
+		}
+		arity := 0
+		if p.accept("(") {
+			names, err := p.names(")")
+			if err != nil {
+				return err
+			}
+			if p.accept(".") {
+				return p.elem()
+			}
+			arity = len(names)
+		}
+		p.outline.calls = append(p.outline.calls, piCall{t, arity})
+		return nil
+	}
+	return p.errorf(t, "expected a process, found %s", describePiToken(t))
+}
+
+// continuation := '.' elem
+func (p *piParser) continuation() error {
+	if err := p.expect("."); err != nil {
+		return err
+	}
+	return p.elem()
+}
+
+// Check the calls and the undeclared processes of a model without syntax
+// errors. pifra accepts calls of undeclared processes and calls with the wrong
+// number of names, but they cannot move, so they are only warnings.
+func (o *piOutline) check(size int) {
+	for _, d := range o.decls {
+		if o.lookup(d.name.text) != d {
+			o.problems = append(o.problems, piProblem{d.name.start, d.name.end, true,
+				fmt.Sprintf("this declaration of %s is replaced by a later one", d.name.text)})
+		}
+	}
+	for _, c := range o.calls {
+		d := o.lookup(c.name.text)
+		if d == nil {
+			o.problems = append(o.problems, piProblem{c.name.start, c.name.end, true,
+				fmt.Sprintf("process %s is not declared, so pifra takes the call as 0", c.name.text)})
+		} else if len(d.params) != c.arity {
+			o.problems = append(o.problems, piProblem{c.name.start, c.name.end, true,
+				fmt.Sprintf("process %s takes %d names, not %d, so pifra takes the call as 0", c.name.text, len(d.params), c.arity)})
+		}
+	}
+	if len(o.undecls) == 0 {
+		o.problems = append(o.problems, piProblem{size, size, false,
+			"there is no undeclared process to start from"})
+	}
+	for i := 1; i < len(o.undecls); i++ {
+		o.problems = append(o.problems, piProblem{o.undecls[i][0], o.undecls[i][1], false,
+			"there cannot be more than one undeclared process"})
+	}
+}
+
+// Parse a model with pifra, after the polyadic prefixes are translated.
+func parsePiProgram(src []byte) error {
+	encoded, _ := encodePolyadic(src)
+	_, err := pifra.InitProgram(encoded)
+	return err
+}
+
+// ####
+// The protocol.
+// ####
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+const (
+	lspError       = 1
+	lspWarning     = 2
+	lspInformation = 3
+)
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCodeActionCommand struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments"`
+}
+
+type lspCodeAction struct {
+	Title   string               `json:"title"`
+	Kind    string               `json:"kind"`
+	Command lspCodeActionCommand `json:"command"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspTextDocumentPosition struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Position     lspPosition     `json:"position"`
+}
+
+type lspRequest struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type lspResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type lspResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   lspResponseError `json:"error"`
+}
+
+type lspNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+const (
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+)
+
+// The command of the code action that checks two declarations.
+const lspCheckCommand = "pisim22.check"
+
+// Read a message with a Content-Length header.
+func readLspMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", v)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("a message has no Content-Length")
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+func writeLspMessage(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+// ####
+// The server.
+// ####
+
+type lspDoc struct {
+	uri     string
+	text    string
+	outline *piOutline
+	// The offsets of the starts of the lines.
+	lines []int
+	// The verdicts of the checks on the document, one per check.
+	verdicts []lspVerdict
+}
+
+type lspVerdict struct {
+	// The declarations and the mode of the check.
+	key        string
+	diagnostic lspDiagnostic
+}
+
+// Set the verdict of a check, replacing the one of an earlier run.
+func (d *lspDoc) setVerdict(key string, diagnostic lspDiagnostic) {
+	for i := range d.verdicts {
+		if d.verdicts[i].key == key {
+			d.verdicts[i].diagnostic = diagnostic
+			return
+		}
+	}
+	d.verdicts = append(d.verdicts, lspVerdict{key, diagnostic})
+}
+
+func newLspDoc(uri string, text string) *lspDoc {
+	d := &lspDoc{uri: uri, text: text, outline: parsePiOutline([]byte(text)), lines: []int{0}}
+	for i, r := range text {
+		if r == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	return d
+}
+
+// The position of an offset, in UTF-16 code units as in the protocol.
+func (d *lspDoc) position(offset int) lspPosition {
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	char := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		char++
+		if r >= 0x10000 {
+			char++
+		}
+	}
+	return lspPosition{line, char}
+}
+
+func (d *lspDoc) offset(pos lspPosition) int {
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	char := 0
+	for i, r := range d.text[d.lines[pos.Line]:] {
+		if char >= pos.Character || r == '\n' {
+			return d.lines[pos.Line] + i
+		}
+		char++
+		if r >= 0x10000 {
+			char++
+		}
+	}
+	return len(d.text)
+}
+
+func (d *lspDoc) span(start int, end int) lspRange {
+	return lspRange{d.position(start), d.position(end)}
+}
+
+func (d *lspDoc) diagnostics() []lspDiagnostic {
+	res := []lspDiagnostic{}
+	for _, p := range d.outline.problems {
+		severity := lspError
+		if p.warning {
+			severity = lspWarning
+		}
+		res = append(res, lspDiagnostic{d.span(p.start, p.end), severity, "pisim22", p.msg})
+	}
+	if !d.outline.hasErrors() {
+		if err := parsePiProgram([]byte(d.text)); err != nil {
+			res = append(res, lspDiagnostic{d.span(0, 0), lspError, "pifra", err.Error()})
+		}
+	}
+	for _, v := range d.verdicts {
+		res = append(res, v.diagnostic)
+	}
+	return res
+}
+
+// The declaration of the process identifier at an offset, if there is one.
+func (d *lspDoc) declAt(offset int) *piDecl {
+	at := func(t piToken) bool { return t.start <= offset && offset <= t.end }
+	for _, decl := range d.outline.decls {
+		if at(decl.name) {
+			return decl
+		}
+	}
+	for _, c := range d.outline.calls {
+		if at(c.name) {
+			return d.outline.lookup(c.name.text)
+		}
+	}
+	return nil
+}
+
+// The head of a declaration, P(x, y).
+func (decl *piDecl) head() string {
+	if len(decl.params) == 0 {
+		return decl.name.text
+	}
+	return decl.name.text + "(" + strings.Join(decl.params, ", ") + ")"
+}
+
+type lspServer struct {
+	out  io.Writer
+	docs map[string]*lspDoc
+	// The options of the checks.
+	regSizeOverride int
+	maxStates       int
+	// The command that runs a check with the given arguments.
+	command  func(ctx context.Context, args []string) *exec.Cmd
+	shutdown bool
+	// Guards docs and the verdicts of the documents, which the worker updates.
+	mu sync.Mutex
+	// Guards the writes to out.
+	outMu sync.Mutex
+	// The queue of the checks, and whether the worker finished them.
+	checks     chan func()
+	checksDone sync.WaitGroup
+}
+
+// The result of a request that is answered later, by the worker.
+type lspDeferred struct{}
+
+// The lsp command.
+func lspCommand(args []string) {
+	fs := newCommandFlagSet("lsp", "[flags]",
+		"Run a language server for pifra models on stdin and stdout.")
+	regSizeFlag := fs.Int("n", -1, "Override for the register size of the checks.")
+	maxStatesFlag := fs.Int("max-states", 15000, "The maximum number of states in the LTSs of the checks.")
+	args = parseCommandFlags(fs, args)
+	if len(args) != 0 {
+		usageError(fs, fmt.Errorf("expected no arguments, not %d", len(args)))
+	}
+	if *regSizeFlag != -1 {
+		if err := validateRegSize(*regSizeFlag); err != nil {
+			usageError(fs, err)
+		}
+	}
+	if *maxStatesFlag <= 0 {
+		usageError(fs, fmt.Errorf("-max-states has to be positive, not %d", *maxStatesFlag))
+	}
+	// Nothing but the protocol may be printed to stdout, so it is redirected
+	// to stderr.
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	exe, err := os.Executable()
+	check(err)
+	s := &lspServer{out: out, docs: make(map[string]*lspDoc),
+		regSizeOverride: *regSizeFlag, maxStates: *maxStatesFlag,
+		command: func(ctx context.Context, args []string) *exec.Cmd {
+			return exec.CommandContext(ctx, exe, args...)
+		}}
+	if err := s.serve(os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "pisim22 lsp: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// Serve until the exit notification. Returns an error if the input ends
+// without it, or if it comes before a shutdown request.
+func (s *lspServer) serve(in io.Reader) error {
+	s.checks = make(chan func(), 64)
+	s.checksDone.Add(1)
+	go func() {
+		defer s.checksDone.Done()
+		for run := range s.checks {
+			run()
+		}
+	}()
+	// The checks that are queued are finished, so that they are answered.
+	defer func() {
+		close(s.checks)
+		s.checksDone.Wait()
+	}()
+
+	r := bufio.NewReader(in)
+	for {
+		data, err := readLspMessage(r)
+		if err != nil {
+			return err
+		}
+		var req lspRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return err
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit before shutdown")
+			}
+			return nil
+		}
+		result, err := s.handle(req)
+		if _, ok := result.(lspDeferred); ok && err == nil {
+			continue
+		}
+		if err := s.respond(req, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// Answer a request, or report the error of a notification.
+func (s *lspServer) respond(req lspRequest, result interface{}, err error) error {
+	if req.ID == nil {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pisim22 lsp: %s: %s\n", req.Method, err.Error())
+		}
+		return nil
+	}
+	if err != nil {
+		code := lspInvalidParams
+		if e, ok := err.(*lspMethodError); ok {
+			code = e.code
+		}
+		return s.write(lspErrorResponse{"2.0", req.ID, lspResponseError{code, err.Error()}})
+	}
+	return s.write(lspResponse{"2.0", req.ID, result})
+}
+
+func (s *lspServer) write(v interface{}) error {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	return writeLspMessage(s.out, v)
+}
+
+type lspMethodError struct {
+	code   int
+	method string
+}
+
+func (e *lspMethodError) Error() string {
+	return fmt.Sprintf("method %s is not supported", e.method)
+}
+
+func (s *lspServer) handle(req lspRequest) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				// The whole document is sent on every change.
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"codeActionProvider":     true,
+				"executeCommandProvider": map[string]interface{}{"commands": []string{lspCheckCommand}},
+			},
+			"serverInfo": map[string]string{"name": "pisim22"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument   lspTextDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		delete(s.docs, params.TextDocument.URI)
+		s.mu.Unlock()
+		return nil, s.publish(params.TextDocument.URI, []lspDiagnostic{})
+	case "textDocument/definition":
+		doc, decl, err := s.declAt(req.Params)
+		if err != nil || decl == nil {
+			return nil, err
+		}
+		return lspLocation{doc.uri, doc.span(decl.name.start, decl.name.end)}, nil
+	case "textDocument/hover":
+		doc, decl, err := s.declAt(req.Params)
+		if err != nil || decl == nil {
+			return nil, err
+		}
+		body := strings.Join(strings.Fields(doc.text[decl.start:decl.end]), " ")
+		value := fmt.Sprintf("```\n%s\n```\nProcess %s with %d parameters, line %d.",
+			body, decl.name.text, len(decl.params), doc.position(decl.name.start).Line+1)
+		return map[string]interface{}{
+			"contents": map[string]string{"kind": "markdown", "value": value},
+			"range":    doc.span(decl.name.start, decl.name.end),
+		}, nil
+	case "textDocument/codeAction":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+			Range        lspRange        `json:"range"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return nil, fmt.Errorf("document %s is not open", params.TextDocument.URI)
+		}
+		return doc.checkActions(doc.offset(params.Range.Start)), nil
+	case "workspace/executeCommand":
+		var params struct {
+			Command   string            `json:"command"`
+			Arguments []json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if params.Command != lspCheckCommand {
+			return nil, fmt.Errorf("unknown command %s", params.Command)
+		}
+		return s.executeCheck(req, params.Arguments)
+	}
+	if req.ID == nil {
+		// Other notifications, such as initialized, are ignored.
+		return nil, nil
+	}
+	return nil, &lspMethodError{lspMethodNotFound, req.Method}
+}
+
+// Replace a document. The verdicts of the checks are dropped with the old
+// document, as are those of the checks that are still running on it.
+func (s *lspServer) update(uri string, text string) error {
+	doc := newLspDoc(uri, text)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs[uri] = doc
+	return s.publish(uri, doc.diagnostics())
+}
+
+func (s *lspServer) publish(uri string, diagnostics []lspDiagnostic) error {
+	return s.write(lspNotification{"2.0", "textDocument/publishDiagnostics",
+		map[string]interface{}{"uri": uri, "diagnostics": diagnostics}})
+}
+
+func (s *lspServer) declAt(params json.RawMessage) (*lspDoc, *piDecl, error) {
+	var p lspTextDocumentPosition
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, nil, err
+	}
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, nil, fmt.Errorf("document %s is not open", p.TextDocument.URI)
+	}
+	return doc, doc.declAt(doc.offset(p.Position)), nil
+}
+
+// ####
+// Checking two declarations.
+// ####
+
+// The actions on the declaration at an offset, one strong and one weak check
+// against each other declaration with as many parameters.
+func (d *lspDoc) checkActions(offset int) []lspCodeAction {
+	actions := []lspCodeAction{}
+	if d.outline.hasErrors() {
+		return actions
+	}
+	var left *piDecl
+	for _, decl := range d.outline.decls {
+		if decl.start <= offset && offset <= decl.end {
+			left = d.outline.lookup(decl.name.text)
+		}
+	}
+	if left == nil {
+		return actions
+	}
+	for _, right := range d.outline.decls {
+		if right == left || d.outline.lookup(right.name.text) != right || len(right.params) != len(left.params) {
+			continue
+		}
+		for _, weak := range []bool{false, true} {
+			title := fmt.Sprintf("Check %s against %s", left.name.text, right.name.text)
+			if weak {
+				title += " (weak)"
+			}
+			actions = append(actions, lspCodeAction{title, "source", lspCodeActionCommand{title, lspCheckCommand,
+				[]interface{}{d.uri, left.name.text, right.name.text, weak}}})
+		}
+	}
+	return actions
+}
+
+// Queue a check of two declarations. It is answered with its verdict when it
+// finishes, and the verdict is shown on the left declaration if the document
+// has not changed in the meantime.
+func (s *lspServer) executeCheck(req lspRequest, args []json.RawMessage) (interface{}, error) {
+	var uri, left, right string
+	var weak bool
+	if len(args) != 4 {
+		return nil, fmt.Errorf("%s takes a document, two processes and whether the check is weak", lspCheckCommand)
+	}
+	for i, v := range []interface{}{&uri, &left, &right, &weak} {
+		if err := json.Unmarshal(args[i], v); err != nil {
+			return nil, err
+		}
+	}
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, fmt.Errorf("document %s is not open", uri)
+	}
+	leftDecl, rightDecl := doc.outline.lookup(left), doc.outline.lookup(right)
+	if leftDecl == nil || rightDecl == nil || len(leftDecl.params) != len(rightDecl.params) {
+		return nil, fmt.Errorf("%s and %s are not declarations with as many parameters", left, right)
+	}
+	opts := queryOptions{Weak: &weak, MaxStates: &s.maxStates}
+	if s.regSizeOverride > 0 {
+		opts.N = &s.regSizeOverride
+	}
+	s.checks <- func() {
+		verdict, err := doc.checkDecls(leftDecl, rightDecl, opts, s.command)
+		if err != nil {
+			s.respond(req, nil, err)
+			return
+		}
+		mode := ""
+		if weak {
+			mode = "weakly "
+		}
+		msg := fmt.Sprintf("%s and %s are %sbisimilar.", leftDecl.head(), rightDecl.head(), mode)
+		if verdict != verdictBisimilar {
+			msg = fmt.Sprintf("%s and %s are NOT %sbisimilar.", leftDecl.head(), rightDecl.head(), mode)
+		}
+		s.mu.Lock()
+		if s.docs[uri] == doc {
+			doc.setVerdict(fmt.Sprintf("%s %s %t", left, right, weak), lspDiagnostic{
+				doc.span(leftDecl.name.start, leftDecl.name.end), lspInformation, "pisim22", msg})
+			err = s.publish(uri, doc.diagnostics())
+		}
+		s.mu.Unlock()
+		s.respond(req, msg, err)
+	}
+	return lspDeferred{}, nil
+}
+
+// Check two declarations in a child process, by calling each with the
+// parameters of the left one from a model with all the declarations of the
+// document.
+func (d *lspDoc) checkDecls(left *piDecl, right *piDecl, opts queryOptions,
+	command func(ctx context.Context, args []string) *exec.Cmd) (string, error) {
+	if d.outline.hasErrors() {
+		return "", fmt.Errorf("the document has errors")
+	}
+	dir, err := ioutil.TempDir("", "pisim22-lsp")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	var decls []string
+	for _, decl := range d.outline.decls {
+		decls = append(decls, d.text[decl.start:decl.end])
+	}
+	lts := []string{filepath.Join(dir, "left.pi"), filepath.Join(dir, "right.pi")}
+	for i, decl := range []*piDecl{left, right} {
+		call := decl.name.text
+		if len(left.params) > 0 {
+			call += "(" + strings.Join(left.params, ",") + ")"
+		}
+		src := strings.Join(append(decls, call), "\n") + "\n"
+		if err := writeFile(lts[i], []byte(src)); err != nil {
+			return "", err
+		}
+	}
+	resultFile := filepath.Join(dir, "result.json")
+	cmd := command(context.Background(), checkProcessArgs(opts, lts[0], lts[1], resultFile))
+	result, err := runCheckProcess(cmd, resultFile, func(checkProgress) {})
+	return result.Verdict, err
+}
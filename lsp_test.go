@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The outline parser finds errors in the models of the tests exactly when pifra
+// cannot parse them.
+func TestPiOutlineModels(t *testing.T) {
+	pwd := getPwd(t)
+	err := filepath.Walk(filepath.Join(pwd, "test"), func(name string, info os.FileInfo, err error) error {
+		if err != nil || filepath.Ext(name) != ".pi" {
+			return err
+		}
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		o := parsePiOutline(src)
+		if err := parsePiProgram(src); o.hasErrors() != (err != nil) {
+			t.Errorf("%s has problems %v, and pifra returned %v.", name, o.problems, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPiOutlineProblems(t *testing.T) {
+	cases := []struct {
+		src string
+		// The problem and the text it points at.
+		msg  string
+		text string
+	}{
+		{"a(x).b'<x>.0 ; c", `unexpected character ";"`, ";"},
+		{"a(x) b'<x>.0", "there cannot be more than one undeclared process", "b'<x>.0"},
+		{"a(x).b'<x>0", `expected ".", found "0"`, "0"},
+		{"P = a(x).0\nQ(y)", "process Q is not declared, so pifra takes the call as 0", "Q"},
+		{"P(u) = a(u).0\nP", "process P takes 1 names, not 0", "P"},
+		{"P = a(x).0\nP = b(x).0\nP", "this declaration of P is replaced by a later one", "P"},
+		{"P = a(x).0", "there is no undeclared process to start from", ""},
+		{"a(x).0\nb(x).0", "there cannot be more than one undeclared process", "b(x).0"},
+		{"(a(x).0 | b'<c>.0", `expected ")", found end of file`, ""},
+		{"$.a(x).0", `expected a name, found "."`, "."},
+	}
+	for _, c := range cases {
+		o := parsePiOutline([]byte(c.src))
+		found := false
+		for _, p := range o.problems {
+			if strings.Contains(p.msg, c.msg) && c.src[p.start:p.end] == c.text {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("The problems of %q are %v, expected %q at %q.", c.src, o.problems, c.msg, c.text)
+		}
+	}
+	// Comments run to the end of the line.
+	src := "# strong: bisimilar\nP(a) = a(x).P(a) # a loop\nP(b)\n"
+	if o := parsePiOutline([]byte(src)); len(o.problems) != 0 || o.lookup("P") == nil {
+		t.Errorf("The problems of %q are %v.", src, o.problems)
+	}
+}
+
+const lspTestModel = `Buf(i,o) = i(x).o'<x>.Buf(i,o)
+Buf2(i,o) = $m.(Buf(i,m) | Buf(m,o))
+Cell(i,o) = i(x).o'<x>.Cell(i,o)
+Buf2(a,b)
+`
+
+// Run a session of a client, whose messages are sent by send, and return the
+// responses by id and the published sets of diagnostics.
+func runLspSession(t *testing.T, session func(send func(method string, params interface{}))) (map[string]json.RawMessage, [][]lspDiagnostic) {
+	var in bytes.Buffer
+	id := 0
+	send := func(method string, params interface{}) {
+		id++
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		if method != "initialized" && method != "exit" && !strings.HasPrefix(method, "textDocument/did") {
+			msg["id"] = id
+		}
+		if err := writeLspMessage(&in, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session(send)
+
+	var out bytes.Buffer
+	s := &lspServer{out: &out, docs: make(map[string]*lspDoc), regSizeOverride: -1, maxStates: 15000,
+		command: testCheckCommand}
+	if err := s.serve(&in); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(&out)
+	var msgs []map[string]json.RawMessage
+	for {
+		data, err := readLspMessage(r)
+		if err != nil {
+			break
+		}
+		var msg map[string]json.RawMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	responses := make(map[string]json.RawMessage)
+	var diagnostics [][]lspDiagnostic
+	for _, msg := range msgs {
+		if id, ok := msg["id"]; ok {
+			if e, ok := msg["error"]; ok {
+				responses[string(id)] = e
+			} else {
+				responses[string(id)] = msg["result"]
+			}
+			continue
+		}
+		var params struct {
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		}
+		if err := json.Unmarshal(msg["params"], &params); err != nil {
+			t.Fatal(err)
+		}
+		diagnostics = append(diagnostics, params.Diagnostics)
+	}
+	return responses, diagnostics
+}
+
+// A session of a client that opens a model, looks up a process and checks
+// two declarations.
+func TestLsp(t *testing.T) {
+	uri := "file:///buf.pi"
+	doc := map[string]string{"uri": uri}
+	checkBufCell := map[string]interface{}{"command": lspCheckCommand,
+		"arguments": []interface{}{uri, "Buf", "Cell", false}}
+	responses, diagnostics := runLspSession(t, func(send func(method string, params interface{})) {
+		send("initialize", map[string]interface{}{})
+		send("initialized", map[string]interface{}{})
+		send("textDocument/didOpen", map[string]interface{}{"textDocument": map[string]string{"uri": uri, "text": lspTestModel}})
+		// The call of Buf on the second line.
+		send("textDocument/definition", map[string]interface{}{"textDocument": doc, "position": lspPosition{1, 17}})
+		send("textDocument/hover", map[string]interface{}{"textDocument": doc, "position": lspPosition{1, 17}})
+		send("textDocument/codeAction", map[string]interface{}{"textDocument": doc,
+			"range": lspRange{lspPosition{0, 0}, lspPosition{0, 0}}})
+		send("workspace/executeCommand", checkBufCell)
+		send("workspace/executeCommand", map[string]interface{}{"command": lspCheckCommand,
+			"arguments": []interface{}{uri, "Buf", "Buf2", true}})
+		send("workspace/executeCommand", checkBufCell)
+		send("textDocument/unknown", map[string]interface{}{})
+		send("shutdown", nil)
+		send("exit", nil)
+	})
+
+	var loc lspLocation
+	if err := json.Unmarshal(responses["4"], &loc); err != nil || loc.Range.Start != (lspPosition{0, 0}) {
+		t.Errorf("The definition of Buf is %s, expected the first line.", responses["4"])
+	}
+	var hover struct {
+		Contents struct {
+			Value string `json:"value"`
+		} `json:"contents"`
+	}
+	if err := json.Unmarshal(responses["5"], &hover); err != nil || !strings.Contains(hover.Contents.Value, "Buf(i,o) = i(x).o'<x>.Buf(i,o)") {
+		t.Errorf("The hover of Buf is %s.", responses["5"])
+	}
+	var actions []lspCodeAction
+	if err := json.Unmarshal(responses["6"], &actions); err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, a := range actions {
+		titles = append(titles, a.Title)
+	}
+	expected := "Check Buf against Buf2,Check Buf against Buf2 (weak),Check Buf against Cell,Check Buf against Cell (weak)"
+	if strings.Join(titles, ",") != expected {
+		t.Errorf("The code actions on Buf are %v, expected %s.", titles, expected)
+	}
+	if res := string(responses["7"]); res != `"Buf(i, o) and Cell(i, o) are bisimilar."` {
+		t.Errorf("The check of Buf and Cell returned %s.", res)
+	}
+	if res := string(responses["8"]); res != `"Buf(i, o) and Buf2(i, o) are NOT weakly bisimilar."` {
+		t.Errorf("The check of Buf and Buf2 returned %s.", res)
+	}
+	if res := string(responses["10"]); !strings.Contains(res, "-32601") {
+		t.Errorf("An unknown method returned %s.", res)
+	}
+
+	if res := string(responses["9"]); res != `"Buf(i, o) and Cell(i, o) are bisimilar."` {
+		t.Errorf("The second check of Buf and Cell returned %s.", res)
+	}
+
+	// The diagnostics of didOpen and of the three checks. The second check of
+	// Buf and Cell replaces the verdict of the first.
+	if len(diagnostics) != 4 {
+		t.Fatalf("There are %d sets of diagnostics, expected 4.", len(diagnostics))
+	}
+	if len(diagnostics[0]) != 0 {
+		t.Errorf("The model has diagnostics %v.", diagnostics[0])
+	}
+	if d := diagnostics[3]; len(d) != 2 || d[1].Severity != lspInformation || !strings.Contains(d[1].Message, "NOT weakly") {
+		t.Errorf("The diagnostics after the checks are %v.", d)
+	}
+}
+
+// A change of the document drops the verdicts, including the one of a check
+// that is still running.
+func TestLspChange(t *testing.T) {
+	uri := "file:///buf.pi"
+	doc := map[string]string{"uri": uri}
+	responses, diagnostics := runLspSession(t, func(send func(method string, params interface{})) {
+		send("initialize", map[string]interface{}{})
+		send("textDocument/didOpen", map[string]interface{}{"textDocument": map[string]string{"uri": uri, "text": lspTestModel}})
+		send("workspace/executeCommand", map[string]interface{}{"command": lspCheckCommand,
+			"arguments": []interface{}{uri, "Buf", "Cell", false}})
+		send("textDocument/didChange", map[string]interface{}{"textDocument": doc,
+			"contentChanges": []map[string]string{{"text": "Buf(i,o) = i(x).o'<x>.Bfu(i,o)\nBuf(a,b)\n"}}})
+		send("shutdown", nil)
+		send("exit", nil)
+	})
+	if res := string(responses["3"]); res != `"Buf(i, o) and Cell(i, o) are bisimilar."` {
+		t.Errorf("The check of Buf and Cell returned %s.", res)
+	}
+	d := diagnostics[len(diagnostics)-1]
+	if len(d) != 1 || !strings.HasPrefix(d[0].Message, "process Bfu is not declared") || d[0].Range.Start != (lspPosition{0, 22}) {
+		t.Errorf("The diagnostics after the change are %v.", d)
+	}
+}
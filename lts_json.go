@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with a stable JSON format for pifra.Lts, as an alternative to
+// the gob files of pifra. The format is described by lts.schema.json. It
+// mirrors the structures of pifra, with the names of the fields in lower camel
+// case and the enumerations written as strings:
+//
+//	{
+//	  "format": "pisim22-lts", "version": 1,
+//	  "states": [{"id": 0, "registers": {"size": 1, "registers": {"1": "#1"}},
+//	              "process": {"type": "input", "channel": {"name": "#1", "type": "free"},
+//	                          "input": {"name": "&1", "type": "bound"},
+//	                          "next": {"type": "nil"}}}, ...],
+//	  "transitions": [{"source": 0, "destination": 1,
+//	                   "label": {"symbol": {"type": "input", "value": 1},
+//	                             "symbol2": {"type": "freshInput", "value": 2}}}, ...],
+//	  "freeNamesMap": {"#1": "a"}
+//	}
+//
+// The start state has id 0.
+
+const ltsJsonFormat = "pisim22-lts"
+const ltsJsonVersion = 1
+
+const jsonExt = ".json"
+
+type jsonLts struct {
+	Format          string            `json:"format"`
+	Version         int               `json:"version"`
+	States          []jsonState       `json:"states"`
+	Transitions     []jsonTransition  `json:"transitions"`
+	FreeNamesMap    map[string]string `json:"freeNamesMap,omitempty"`
+	StatesExplored  int               `json:"statesExplored,omitempty"`
+	StatesGenerated int               `json:"statesGenerated,omitempty"`
+}
+
+type jsonState struct {
+	Id             int           `json:"id"`
+	Registers      jsonRegisters `json:"registers"`
+	Process        *jsonElement  `json:"process"`
+	Label          *jsonLabel    `json:"label,omitempty"`
+	RegSizeReached bool          `json:"regSizeReached,omitempty"`
+	Pretty         string        `json:"pretty,omitempty"`
+}
+
+type jsonRegisters struct {
+	Size      int            `json:"size"`
+	Registers map[int]string `json:"registers"`
+}
+
+type jsonTransition struct {
+	Source      int       `json:"source"`
+	Destination int       `json:"destination"`
+	Label       jsonLabel `json:"label"`
+}
+
+type jsonLabel struct {
+	Symbol  jsonSymbol `json:"symbol"`
+	Symbol2 jsonSymbol `json:"symbol2"`
+}
+
+type jsonSymbol struct {
+	Type  string `json:"type"`
+	Value int    `json:"value"`
+}
+
+type jsonName struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// An element of the AST of a process. Which fields are set depends on Type.
+type jsonElement struct {
+	Type       string       `json:"type"`
+	Channel    *jsonName    `json:"channel,omitempty"`
+	Output     *jsonName    `json:"output,omitempty"`
+	Input      *jsonName    `json:"input,omitempty"`
+	Inequality bool         `json:"inequality,omitempty"`
+	NameL      *jsonName    `json:"nameL,omitempty"`
+	NameR      *jsonName    `json:"nameR,omitempty"`
+	Restrict   *jsonName    `json:"restrict,omitempty"`
+	ProcessL   *jsonElement `json:"processL,omitempty"`
+	ProcessR   *jsonElement `json:"processR,omitempty"`
+	Name       string       `json:"name,omitempty"`
+	Parameters []jsonName   `json:"parameters,omitempty"`
+	Next       *jsonElement `json:"next,omitempty"`
+}
+
+var jsonSymbolTypes = map[pifra.SymbolType]string{
+	pifra.SymbolTypTau:         "tau",
+	pifra.SymbolTypInput:       "input",
+	pifra.SymbolTypOutput:      "output",
+	pifra.SymbolTypFreshInput:  "freshInput",
+	pifra.SymbolTypFreshOutput: "freshOutput",
+	pifra.SymbolTypKnown:       "known",
+}
+
+var jsonElementTypes = map[pifra.ElementType]string{
+	pifra.ElemTypNil:         "nil",
+	pifra.ElemTypOutput:      "output",
+	pifra.ElemTypInput:       "input",
+	pifra.ElemTypMatch:       "match",
+	pifra.ElemTypRestriction: "restriction",
+	pifra.ElemTypSum:         "sum",
+	pifra.ElemTypParallel:    "parallel",
+	pifra.ElemTypProcess:     "process",
+	pifra.ElemTypRoot:        "root",
+}
+
+// Whether the file name refers to a JSON LTS.
+func isJsonFile(name string) bool {
+	return strings.HasSuffix(name, jsonExt)
+}
+
+// #############################################################################
+// ################################ TO JSON ####################################
+// #############################################################################
+
+func toJsonName(n pifra.Name) *jsonName {
+	typ := "free"
+	if n.Type == pifra.Bound {
+		typ = "bound"
+	}
+	return &jsonName{Name: n.Name, Type: typ}
+}
+
+func toJsonElement(elem pifra.Element) *jsonElement {
+	if elem == nil {
+		return nil
+	}
+	res := &jsonElement{Type: jsonElementTypes[elem.Type()]}
+	switch e := elem.(type) {
+	case *pifra.ElemOutput:
+		res.Channel = toJsonName(e.Channel)
+		res.Output = toJsonName(e.Output)
+		res.Next = toJsonElement(e.Next)
+	case *pifra.ElemInput:
+		res.Channel = toJsonName(e.Channel)
+		res.Input = toJsonName(e.Input)
+		res.Next = toJsonElement(e.Next)
+	case *pifra.ElemEquality:
+		res.Inequality = e.Inequality
+		res.NameL = toJsonName(e.NameL)
+		res.NameR = toJsonName(e.NameR)
+		res.Next = toJsonElement(e.Next)
+	case *pifra.ElemRestriction:
+		res.Restrict = toJsonName(e.Restrict)
+		res.Next = toJsonElement(e.Next)
+	case *pifra.ElemSum:
+		res.ProcessL = toJsonElement(e.ProcessL)
+		res.ProcessR = toJsonElement(e.ProcessR)
+	case *pifra.ElemParallel:
+		res.ProcessL = toJsonElement(e.ProcessL)
+		res.ProcessR = toJsonElement(e.ProcessR)
+	case *pifra.ElemProcess:
+		res.Name = e.Name
+		for _, p := range e.Parameters {
+			res.Parameters = append(res.Parameters, *toJsonName(p))
+		}
+	case *pifra.ElemRoot:
+		res.Next = toJsonElement(e.Next)
+	}
+	return res
+}
+
+func toJsonLabel(l pifra.Label) jsonLabel {
+	return jsonLabel{
+		Symbol:  jsonSymbol{jsonSymbolTypes[l.Symbol.Type], l.Symbol.Value},
+		Symbol2: jsonSymbol{jsonSymbolTypes[l.Symbol2.Type], l.Symbol2.Value},
+	}
+}
+
+func ltsToJson(lts pifra.Lts) jsonLts {
+	res := jsonLts{
+		Format:          ltsJsonFormat,
+		Version:         ltsJsonVersion,
+		Transitions:     make([]jsonTransition, 0, len(lts.Transitions)),
+		FreeNamesMap:    lts.FreeNamesMap,
+		StatesExplored:  lts.StatesExplored,
+		StatesGenerated: lts.StatesGenerated,
+	}
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		conf := lts.States[id]
+		regs := conf.Registers.Registers
+		if regs == nil {
+			regs = make(map[int]string)
+		}
+		state := jsonState{
+			Id:             id,
+			Registers:      jsonRegisters{conf.Registers.Size, regs},
+			Process:        toJsonElement(conf.Process),
+			RegSizeReached: lts.RegSizeReached[id],
+		}
+		if conf.Process != nil {
+			state.Pretty = pifraStateKey(&conf)
+		}
+		if conf.Label != (pifra.Label{}) {
+			label := toJsonLabel(conf.Label)
+			state.Label = &label
+		}
+		res.States = append(res.States, state)
+	}
+	for _, t := range lts.Transitions {
+		res.Transitions = append(res.Transitions, jsonTransition{t.Source, t.Destination, toJsonLabel(t.Label)})
+	}
+	return res
+}
+
+func writeJsonLts(name string, lts pifra.Lts) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Bound names start with '&'.
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(ltsToJson(lts)); err != nil {
+		return err
+	}
+	return writeFile(name, buf.Bytes())
+}
+
+// #############################################################################
+// ############################### FROM JSON ###################################
+// #############################################################################
+
+func fromJsonName(n *jsonName) (pifra.Name, error) {
+	if n == nil {
+		return pifra.Name{}, fmt.Errorf("missing name")
+	}
+	switch n.Type {
+	case "free":
+		return pifra.Name{Name: n.Name, Type: pifra.Free}, nil
+	case "bound":
+		return pifra.Name{Name: n.Name, Type: pifra.Bound}, nil
+	}
+	return pifra.Name{}, fmt.Errorf("unknown type %q of name %s", n.Type, n.Name)
+}
+
+func fromJsonElement(e *jsonElement) (elem pifra.Element, err error) {
+	if e == nil {
+		return nil, fmt.Errorf("missing process")
+	}
+	// Collects the first error of the names and the subprocesses.
+	name := func(n *jsonName) pifra.Name {
+		res, err_ := fromJsonName(n)
+		if err == nil && err_ != nil {
+			err = fmt.Errorf("%s: %s", e.Type, err_.Error())
+		}
+		return res
+	}
+	sub := func(s *jsonElement) pifra.Element {
+		if err != nil {
+			return nil
+		}
+		res, err_ := fromJsonElement(s)
+		if err_ != nil {
+			err = err_
+		}
+		return res
+	}
+	switch e.Type {
+	case "nil":
+		elem = &pifra.ElemNil{}
+	case "output":
+		elem = &pifra.ElemOutput{Channel: name(e.Channel), Output: name(e.Output), Next: sub(e.Next)}
+	case "input":
+		elem = &pifra.ElemInput{Channel: name(e.Channel), Input: name(e.Input), Next: sub(e.Next)}
+	case "match":
+		elem = &pifra.ElemEquality{Inequality: e.Inequality, NameL: name(e.NameL), NameR: name(e.NameR),
+			Next: sub(e.Next)}
+	case "restriction":
+		elem = &pifra.ElemRestriction{Restrict: name(e.Restrict), Next: sub(e.Next)}
+	case "sum":
+		elem = &pifra.ElemSum{ProcessL: sub(e.ProcessL), ProcessR: sub(e.ProcessR)}
+	case "parallel":
+		elem = &pifra.ElemParallel{ProcessL: sub(e.ProcessL), ProcessR: sub(e.ProcessR)}
+	case "process":
+		proc := &pifra.ElemProcess{Name: e.Name}
+		for i := range e.Parameters {
+			proc.Parameters = append(proc.Parameters, name(&e.Parameters[i]))
+		}
+		elem = proc
+	case "root":
+		elem = &pifra.ElemRoot{Next: sub(e.Next)}
+	default:
+		return nil, fmt.Errorf("unknown process type %q", e.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return elem, nil
+}
+
+func fromJsonSymbol(s jsonSymbol) (pifra.Symbol, error) {
+	for typ, str := range jsonSymbolTypes {
+		if str == s.Type {
+			return pifra.Symbol{Type: typ, Value: s.Value}, nil
+		}
+	}
+	return pifra.Symbol{}, fmt.Errorf("unknown symbol type %q", s.Type)
+}
+
+func fromJsonLabel(l jsonLabel) (pifra.Label, error) {
+	s1, err := fromJsonSymbol(l.Symbol)
+	if err != nil {
+		return pifra.Label{}, err
+	}
+	s2, err := fromJsonSymbol(l.Symbol2)
+	if err != nil {
+		return pifra.Label{}, err
+	}
+	return pifra.Label{Symbol: s1, Symbol2: s2}, nil
+}
+
+func jsonToLts(j jsonLts) (lts pifra.Lts, err error) {
+	if j.Format != ltsJsonFormat {
+		return lts, fmt.Errorf("format is %q, expected %q", j.Format, ltsJsonFormat)
+	}
+	if j.Version != ltsJsonVersion {
+		return lts, fmt.Errorf("version is %d, expected %d", j.Version, ltsJsonVersion)
+	}
+	lts.States = make(map[int]pifra.Configuration, len(j.States))
+	lts.RegSizeReached = make(map[int]bool)
+	lts.FreeNamesMap = j.FreeNamesMap
+	if lts.FreeNamesMap == nil {
+		lts.FreeNamesMap = make(map[string]string)
+	}
+	lts.StatesExplored = j.StatesExplored
+	lts.StatesGenerated = j.StatesGenerated
+	for _, s := range j.States {
+		if _, ok := lts.States[s.Id]; ok {
+			return lts, fmt.Errorf("duplicate state %d", s.Id)
+		}
+		proc, err := fromJsonElement(s.Process)
+		if err != nil {
+			return lts, fmt.Errorf("state %d: %s", s.Id, err.Error())
+		}
+		conf := pifra.Configuration{
+			Process:   proc,
+			Registers: pifra.Registers{Size: s.Registers.Size, Registers: s.Registers.Registers},
+		}
+		if conf.Registers.Registers == nil {
+			conf.Registers.Registers = make(map[int]string)
+		}
+		if s.Label != nil {
+			if conf.Label, err = fromJsonLabel(*s.Label); err != nil {
+				return lts, fmt.Errorf("state %d: %s", s.Id, err.Error())
+			}
+		}
+		lts.States[s.Id] = conf
+		if s.RegSizeReached {
+			lts.RegSizeReached[s.Id] = true
+		}
+	}
+	if _, ok := lts.States[0]; !ok {
+		return lts, fmt.Errorf("missing the start state 0")
+	}
+	for i, t := range j.Transitions {
+		label, err := fromJsonLabel(t.Label)
+		if err != nil {
+			return lts, fmt.Errorf("transition %d: %s", i, err.Error())
+		}
+		_, ok1 := lts.States[t.Source]
+		_, ok2 := lts.States[t.Destination]
+		if !ok1 || !ok2 {
+			return lts, fmt.Errorf("transition %d: unknown state", i)
+		}
+		lts.Transitions = append(lts.Transitions, pifra.Transition{
+			Source:      t.Source,
+			Destination: t.Destination,
+			Label:       label,
+		})
+	}
+	return lts, nil
+}
+
+func readJsonLts(name string) (lts pifra.Lts, err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return
+	}
+	var j jsonLts
+	if err = json.Unmarshal(data, &j); err != nil {
+		return lts, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	if lts, err = jsonToLts(j); err != nil {
+		return lts, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return
+}
+
+// Write an LTS in the gob format of pifra, as read by decodeLTS.
+func encodeLTS(name string, lts pifra.Lts) error {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer closeFile(file)
+	return gob.NewEncoder(file).Encode(lts)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/yungene/pifra"
+)
+
+// An LTS generated by pifra has to be the same after a round trip through the
+// JSON format, and through the gob format written by encodeLTS.
+func TestJsonLtsRoundTrip(t *testing.T) {
+	pwd := getPwd(t)
+	testFolder := path.Join(pwd, "test", "bisimilar")
+	outFolder := path.Join(pwd, "test", "bisimilar", "out")
+	defer cleanFolder(t, outFolder)
+
+	var testFiles []string = []string{"jev-gc-3", "sangiorgi-ex-1-4-11", "jev-sangiorgi-open-bisim"}
+	generateLts(t, testFolder, outFolder, testFiles, flags)
+	for _, testFile := range testFiles {
+		for i := 1; i < 3; i++ {
+			name := path.Join(outFolder, fmt.Sprintf("%s.%d", testFile, i))
+			lts, err := decodeLTS(name + ".gob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writeJsonLts(name+jsonExt, lts); err != nil {
+				t.Fatal(err)
+			}
+			imported, err := readJsonLts(name + jsonExt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareLts(t, name, lts, imported)
+
+			if err := encodeLTS(name+".2.gob", imported); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodeLTS(name + ".2.gob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareLts(t, name, lts, decoded)
+		}
+	}
+}
+
+func compareLts(t *testing.T, name string, expected pifra.Lts, got pifra.Lts) {
+	if len(expected.States) != len(got.States) {
+		t.Fatalf("%s has %d states, expected %d.", name, len(got.States), len(expected.States))
+	}
+	for id := range expected.States {
+		conf1 := expected.States[id]
+		conf2 := got.States[id]
+		if pifraStateKey(&conf1) != pifraStateKey(&conf2) || conf1.Label != conf2.Label {
+			t.Errorf("State %d of %s is %s, expected %s.", id, name, pifraStateKey(&conf2), pifraStateKey(&conf1))
+		}
+		if expected.RegSizeReached[id] != got.RegSizeReached[id] {
+			t.Errorf("RegSizeReached of state %d of %s differs.", id, name)
+		}
+	}
+	if fmt.Sprint(expected.Transitions) != fmt.Sprint(got.Transitions) {
+		t.Errorf("Transitions of %s differ.", name)
+	}
+	if fmt.Sprint(expected.FreeNamesMap) != fmt.Sprint(got.FreeNamesMap) {
+		t.Errorf("FreeNamesMap of %s is %s, expected %s.", name,
+			fmt.Sprint(got.FreeNamesMap), fmt.Sprint(expected.FreeNamesMap))
+	}
+}
+
+func TestJsonLtsErrors(t *testing.T) {
+	cases := map[string]string{
+		"format":  `{"format": "other", "version": 1, "states": [], "transitions": []}`,
+		"start":   `{"format": "pisim22-lts", "version": 1, "states": [], "transitions": []}`,
+		"process": `{"format": "pisim22-lts", "version": 1, "states": [{"id": 0, "registers": {"registers": {}}, "process": {"type": "loop"}}], "transitions": []}`,
+		"symbol":  `{"format": "pisim22-lts", "version": 1, "states": [{"id": 0, "registers": {"registers": {}}, "process": {"type": "nil"}}], "transitions": [{"source": 0, "destination": 0, "label": {"symbol": {"type": "send"}, "symbol2": {"type": "tau"}}}]}`,
+	}
+	dir := t.TempDir()
+	for name, data := range cases {
+		fileName := path.Join(dir, name+jsonExt)
+		if err := writeFile(fileName, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readJsonLts(fileName); err == nil {
+			t.Errorf("Expected an error for %s.", name)
+		}
+	}
+}
@@ -0,0 +1,879 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with `pisim22 mc model formula`, which checks a formula of the
+// modal mu-calculus on the LTS of a model, or on its weak transform. The
+// formulas are:
+//
+//	f   ::= true | false | !f | f && f | f || f | (f)
+//	      | <act> f | [act] f | mu X. f | nu X. f | X
+//	act ::= - | tau | c(n) | c'<n> | !act
+//
+// where - is any action and !act any action but act. The channel c and the
+// name n of an action are a free name of the model or a name bound before, ?x
+// to match any name and bind it to x in the rest of the formula, or _ to match
+// any name. The name n may also be x*, to match only a fresh name and bind it
+// to x, so that c(x*) is a fresh input and c'<x*> a bound output. As in
+// analyze, the names are followed through the registers, so that a bound name
+// refers to the same name in the later actions. A # starts a comment.
+
+type mcKind int
+
+const (
+	mcTrue mcKind = iota
+	mcFalse
+	mcAnd
+	mcOr
+	mcDiamond
+	mcBox
+	mcMu
+	mcNu
+	mcVar
+)
+
+// A formula, with the negations pushed down to the constants and the actions.
+type mcFormula struct {
+	kind        mcKind
+	left, right *mcFormula
+	// The body of a modality or a fixpoint.
+	body *mcFormula
+	act  *mcAction
+	// The variable of a fixpoint, and the fixpoint of a variable.
+	name string
+	fix  *mcFormula
+	// The names in scope at a fixpoint, by slot.
+	scope []bool
+	// Whether a fixpoint has no free fixpoint variables, so that its value
+	// only depends on the state and the names.
+	closed bool
+	// The names of the slots, for printing.
+	slots *[]string
+}
+
+type mcNameKind int
+
+const (
+	mcAnyName mcNameKind = iota
+	// A name bound before, or a free name of the model.
+	mcRefName
+	// ?x
+	mcBindName
+	// x*
+	mcFreshName
+)
+
+type mcName struct {
+	kind mcNameKind
+	slot int
+}
+
+type mcAction struct {
+	not, any, tau, output bool
+	channel, arg          mcName
+}
+
+// ####
+// Parsing.
+// ####
+
+var mcTokenRegexp = regexp.MustCompile(`#[^\n]*|[A-Za-z0-9_]+|&&|\|\||\S`)
+
+type mcToken struct {
+	text string
+	pos  int
+}
+
+type mcBinding struct {
+	name string
+	slot int
+}
+
+type mcParser struct {
+	src    string
+	toks   []mcToken
+	pos    int
+	lts    pifra.Lts
+	slots  []string
+	free   map[string]int
+	names  []mcBinding
+	fixes  []*mcFormula
+	fixNeg map[*mcFormula]bool
+	// The fixpoints, and the bound slots in scope at each of them.
+	allFixes []*mcFormula
+	bound    map[*mcFormula][]int
+}
+
+// Parse a formula. The free names are the ones of the LTS.
+func parseMcFormula(src string, lts pifra.Lts) (*mcFormula, error) {
+	p := &mcParser{src: src, lts: lts, free: make(map[string]int), fixNeg: make(map[*mcFormula]bool),
+		bound: make(map[*mcFormula][]int)}
+	for _, loc := range mcTokenRegexp.FindAllStringIndex(src, -1) {
+		if text := src[loc[0]:loc[1]]; !strings.HasPrefix(text, "#") {
+			p.toks = append(p.toks, mcToken{text, loc[0]})
+		}
+	}
+	f, err := p.or(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, p.errorf("unexpected %q", p.peek())
+	}
+	for _, fix := range p.allFixes {
+		fix.scope = make([]bool, len(p.slots))
+		for _, slot := range p.free {
+			fix.scope[slot] = true
+		}
+		for _, slot := range p.bound[fix] {
+			fix.scope[slot] = true
+		}
+	}
+	return f, nil
+}
+
+func (p *mcParser) errorf(format string, args ...interface{}) error {
+	pos := len(p.src)
+	if p.pos < len(p.toks) {
+		pos = p.toks[p.pos].pos
+	}
+	line := strings.Count(p.src[:pos], "\n") + 1
+	column := pos - strings.LastIndex(p.src[:pos], "\n")
+	return fmt.Errorf("%d:%d: %s", line, column, fmt.Sprintf(format, args...))
+}
+
+func (p *mcParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos].text
+	}
+	return ""
+}
+
+func (p *mcParser) accept(text string) bool {
+	if p.peek() == text && p.pos < len(p.toks) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *mcParser) expect(text string) error {
+	if !p.accept(text) {
+		if p.pos >= len(p.toks) {
+			return p.errorf("expected %q, found the end of the formula", text)
+		}
+		return p.errorf("expected %q, found %q", text, p.peek())
+	}
+	return nil
+}
+
+func (p *mcParser) ident() (string, error) {
+	t := p.peek()
+	if !mcIdentRegexp.MatchString(t) {
+		if t == "" {
+			return "", p.errorf("expected a name, found the end of the formula")
+		}
+		return "", p.errorf("expected a name, found %q", t)
+	}
+	p.pos++
+	return t, nil
+}
+
+var mcIdentRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func (p *mcParser) newFormula(kind mcKind) *mcFormula {
+	return &mcFormula{kind: kind, slots: &p.slots}
+}
+
+// The formulas are parsed under an odd (neg) or even number of negations, and
+// each negation is pushed down as it is parsed.
+func (p *mcParser) or(neg bool) (*mcFormula, error) {
+	return p.binary(neg, "||", mcOr, mcAnd, p.and)
+}
+
+func (p *mcParser) and(neg bool) (*mcFormula, error) {
+	return p.binary(neg, "&&", mcAnd, mcOr, p.unary)
+}
+
+func (p *mcParser) binary(neg bool, op string, kind mcKind, negKind mcKind,
+	operand func(bool) (*mcFormula, error)) (*mcFormula, error) {
+	left, err := operand(neg)
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(op) {
+		right, err := operand(neg)
+		if err != nil {
+			return nil, err
+		}
+		f := p.newFormula(kind)
+		if neg {
+			f.kind = negKind
+		}
+		f.left, f.right = left, right
+		left = f
+	}
+	return left, nil
+}
+
+func (p *mcParser) unary(neg bool) (*mcFormula, error) {
+	t := p.peek()
+	switch t {
+	case "!":
+		p.pos++
+		return p.unary(!neg)
+	case "(":
+		p.pos++
+		f, err := p.or(neg)
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	case "true", "false":
+		p.pos++
+		f := p.newFormula(mcTrue)
+		if (t == "false") != neg {
+			f.kind = mcFalse
+		}
+		return f, nil
+	case "<", "[":
+		p.pos++
+		close, kind, negKind := ">", mcDiamond, mcBox
+		if t == "[" {
+			close, kind, negKind = "]", mcBox, mcDiamond
+		}
+		depth := len(p.names)
+		act, err := p.action()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(close); err != nil {
+			return nil, err
+		}
+		body, err := p.unary(neg)
+		p.names = p.names[:depth]
+		if err != nil {
+			return nil, err
+		}
+		f := p.newFormula(kind)
+		if neg {
+			f.kind = negKind
+		}
+		f.act, f.body = act, body
+		return f, nil
+	case "mu", "nu":
+		p.pos++
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("."); err != nil {
+			return nil, err
+		}
+		f := p.newFormula(mcMu)
+		if (t == "nu") != neg {
+			f.kind = mcNu
+		}
+		f.name, f.closed = name, true
+		for _, b := range p.names {
+			p.bound[f] = append(p.bound[f], b.slot)
+		}
+		p.allFixes = append(p.allFixes, f)
+		p.fixes = append(p.fixes, f)
+		p.fixNeg[f] = neg
+		f.body, err = p.or(neg)
+		p.fixes = p.fixes[:len(p.fixes)-1]
+		return f, err
+	case "":
+		return nil, p.errorf("expected a formula, found the end of the formula")
+	}
+	if !mcIdentRegexp.MatchString(t) {
+		return nil, p.errorf("expected a formula, found %q", t)
+	}
+	name := t
+	p.pos++
+	for i := len(p.fixes) - 1; i >= 0; i-- {
+		fix := p.fixes[i]
+		if fix.name != name {
+			continue
+		}
+		if p.fixNeg[fix] != neg {
+			p.pos--
+			return nil, p.errorf("%s occurs under an odd number of negations", name)
+		}
+		for _, inner := range p.fixes[i+1:] {
+			inner.closed = false
+		}
+		f := p.newFormula(mcVar)
+		f.name, f.fix = name, fix
+		return f, nil
+	}
+	p.pos--
+	return nil, p.errorf("%s is not a fixpoint variable", name)
+}
+
+func (p *mcParser) action() (*mcAction, error) {
+	a := &mcAction{}
+	a.not = p.accept("!")
+	switch {
+	case p.accept("-"):
+		a.any = true
+	case p.accept("tau"):
+		a.tau = true
+	default:
+		var bindings []mcBinding
+		var err error
+		a.channel, err = p.name(false, &bindings)
+		if err != nil {
+			return nil, err
+		}
+		close := ")"
+		if p.accept("'") {
+			a.output, close = true, ">"
+			err = p.expect("<")
+		} else {
+			err = p.expect("(")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if a.arg, err = p.name(true, &bindings); err != nil {
+			return nil, err
+		}
+		if err := p.expect(close); err != nil {
+			return nil, err
+		}
+		if a.not && len(bindings) > 0 {
+			return nil, p.errorf("a negated action cannot bind %s", bindings[0].name)
+		}
+		p.names = append(p.names, bindings...)
+	}
+	return a, nil
+}
+
+// Read a name of an action. The names it binds are in scope after the action.
+func (p *mcParser) name(isArg bool, bindings *[]mcBinding) (mcName, error) {
+	if p.accept("_") {
+		return mcName{kind: mcAnyName}, nil
+	}
+	kind := mcRefName
+	if p.accept("?") {
+		kind = mcBindName
+	}
+	name, err := p.ident()
+	if err != nil {
+		return mcName{}, err
+	}
+	if kind == mcRefName && isArg && p.accept("*") {
+		kind = mcFreshName
+	}
+	if kind != mcRefName {
+		slot := len(p.slots)
+		p.slots = append(p.slots, name)
+		*bindings = append(*bindings, mcBinding{name, slot})
+		return mcName{kind, slot}, nil
+	}
+	for i := len(p.names) - 1; i >= 0; i-- {
+		if p.names[i].name == name {
+			return mcName{kind, p.names[i].slot}, nil
+		}
+	}
+	if slot, ok := p.free[name]; ok {
+		return mcName{kind, slot}, nil
+	}
+	if !isLtsFreeName(p.lts, name) {
+		p.pos--
+		return mcName{}, p.errorf("%s is neither a free name of the model nor a bound name", name)
+	}
+	slot := len(p.slots)
+	p.slots = append(p.slots, name)
+	p.free[name] = slot
+	return mcName{kind, slot}, nil
+}
+
+// ####
+// Printing.
+// ####
+
+func (n mcName) string(slots []string) string {
+	switch n.kind {
+	case mcAnyName:
+		return "_"
+	case mcBindName:
+		return "?" + slots[n.slot]
+	case mcFreshName:
+		return slots[n.slot] + "*"
+	}
+	return slots[n.slot]
+}
+
+func (a *mcAction) string(slots []string) string {
+	var s string
+	switch {
+	case a.any:
+		s = "-"
+	case a.tau:
+		s = "tau"
+	case a.output:
+		s = fmt.Sprintf("%s'<%s>", a.channel.string(slots), a.arg.string(slots))
+	default:
+		s = fmt.Sprintf("%s(%s)", a.channel.string(slots), a.arg.string(slots))
+	}
+	if a.not {
+		return "!" + s
+	}
+	return s
+}
+
+// The formula, with parentheses around the operators and the fixpoints that
+// are not at the top.
+func (f *mcFormula) String() string {
+	return f.string(true)
+}
+
+func (f *mcFormula) string(top bool) string {
+	var s string
+	switch f.kind {
+	case mcTrue:
+		return "true"
+	case mcFalse:
+		return "false"
+	case mcVar:
+		return f.name
+	case mcAnd:
+		s = f.left.string(false) + " && " + f.right.string(false)
+	case mcOr:
+		s = f.left.string(false) + " || " + f.right.string(false)
+	case mcDiamond:
+		return "<" + f.act.string(*f.slots) + ">" + f.body.string(false)
+	case mcBox:
+		return "[" + f.act.string(*f.slots) + "]" + f.body.string(false)
+	case mcMu:
+		s = "mu " + f.name + ". " + f.body.string(false)
+	case mcNu:
+		s = "nu " + f.name + ". " + f.body.string(false)
+	}
+	if top {
+		return s
+	}
+	return "(" + s + ")"
+}
+
+// ####
+// Checking.
+// ####
+
+// The names of the slots are the registers that hold them, or -1.
+type mcPair struct {
+	state int
+	env   []int
+}
+
+func mcKey(state int, env []int) string {
+	return fmt.Sprint(state, env)
+}
+
+// The pairs of a fixpoint and their values, with the order in which they
+// changed.
+type mcFix struct {
+	pairs  []mcPair
+	index  map[string]int
+	values []bool
+	ranks  []int
+}
+
+func (fx *mcFix) add(state int, env []int, value bool) {
+	fx.index[mcKey(state, env)] = len(fx.pairs)
+	fx.pairs = append(fx.pairs, mcPair{state, env})
+	fx.values = append(fx.values, value)
+	fx.ranks = append(fx.ranks, math.MaxInt32)
+}
+
+type mcChecker struct {
+	lts     pifra.Lts
+	adj     map[int][]pifra.Transition
+	formula *mcFormula
+	slots   int
+	fix     map[*mcFormula]*mcFix
+	// The values of the closed fixpoints.
+	cache map[*mcFormula]map[string]bool
+}
+
+func newMcChecker(lts pifra.Lts, f *mcFormula) *mcChecker {
+	return &mcChecker{lts: lts, adj: ToAdjacency(lts), formula: f, slots: len(*f.slots),
+		fix: make(map[*mcFormula]*mcFix), cache: make(map[*mcFormula]map[string]bool)}
+}
+
+// The free names of the model in the registers of state 0.
+func (c *mcChecker) startEnv() []int {
+	env := make([]int, c.slots)
+	for i := range env {
+		env[i] = -1
+		for idx, internal := range c.lts.States[0].Registers.Registers {
+			if c.lts.FreeNamesMap[internal] == (*c.formula.slots)[i] {
+				env[i] = idx
+			}
+		}
+	}
+	return env
+}
+
+func (n mcName) matches(reg int, fresh bool, env []int) bool {
+	switch n.kind {
+	case mcRefName:
+		return !fresh && env[n.slot] >= 0 && env[n.slot] == reg
+	case mcFreshName:
+		return fresh
+	}
+	return true
+}
+
+func (a *mcAction) matches(trans pifra.Transition, env []int) bool {
+	l := trans.Label
+	var res bool
+	switch {
+	case a.any:
+		res = true
+	case a.tau:
+		res = l.Symbol.Type == pifra.SymbolTypTau
+	default:
+		res = l.Symbol.Type != pifra.SymbolTypTau && a.output == (l.Symbol.Type == pifra.SymbolTypOutput) &&
+			a.channel.matches(l.Symbol.Value, false, env) && a.arg.matches(l.Symbol2.Value, isFreshLabel(l), env)
+	}
+	return res != a.not
+}
+
+// The names after a transition. A name is lost when its register is dropped or
+// gets a fresh name.
+func (c *mcChecker) step(trans pifra.Transition, env []int) []int {
+	regs := c.lts.States[trans.Destination].Registers.Registers
+	next := make([]int, len(env))
+	for i, reg := range env {
+		next[i] = -1
+		if _, ok := regs[reg]; ok && !(isFreshLabel(trans.Label) && reg == trans.Label.Symbol2.Value) {
+			next[i] = reg
+		}
+	}
+	return next
+}
+
+// The names after a transition of an action, with the names it binds.
+func (c *mcChecker) stepAction(a *mcAction, trans pifra.Transition, env []int) []int {
+	next := c.step(trans, env)
+	regs := c.lts.States[trans.Destination].Registers.Registers
+	bind := func(n mcName, reg int) {
+		if n.kind == mcBindName || n.kind == mcFreshName {
+			next[n.slot] = -1
+			if _, ok := regs[reg]; ok {
+				next[n.slot] = reg
+			}
+		}
+	}
+	bind(a.channel, trans.Label.Symbol.Value)
+	bind(a.arg, trans.Label.Symbol2.Value)
+	return next
+}
+
+func restrictEnv(env []int, scope []bool) []int {
+	res := make([]int, len(env))
+	for i := range env {
+		res[i] = -1
+		if scope[i] {
+			res[i] = env[i]
+		}
+	}
+	return res
+}
+
+func (c *mcChecker) eval(f *mcFormula, state int, env []int) bool {
+	switch f.kind {
+	case mcTrue:
+		return true
+	case mcFalse:
+		return false
+	case mcAnd:
+		return c.eval(f.left, state, env) && c.eval(f.right, state, env)
+	case mcOr:
+		return c.eval(f.left, state, env) || c.eval(f.right, state, env)
+	case mcDiamond, mcBox:
+		for _, trans := range c.adj[state] {
+			if !f.act.matches(trans, env) {
+				continue
+			}
+			if c.eval(f.body, trans.Destination, c.stepAction(f.act, trans, env)) == (f.kind == mcDiamond) {
+				return f.kind == mcDiamond
+			}
+		}
+		return f.kind == mcBox
+	case mcVar:
+		fx := c.fix[f.fix]
+		env = restrictEnv(env, f.fix.scope)
+		if i, ok := fx.index[mcKey(state, env)]; ok {
+			return fx.values[i]
+		}
+		// A pair the fixpoint has not reached yet starts from its initial
+		// value, and is computed by the next round.
+		fx.add(state, env, f.fix.kind == mcNu)
+		return f.fix.kind == mcNu
+	}
+	if v, ok := c.cache[f][mcKey(state, restrictEnv(env, f.scope))]; ok {
+		return v
+	}
+	return c.fixpoint(f, state, env)
+}
+
+// Compute a fixpoint on the pairs reached from a state, by rounds over them
+// until none of them changes.
+func (c *mcChecker) fixpoint(f *mcFormula, state int, env []int) bool {
+	fx := &mcFix{index: make(map[string]int)}
+	c.fix[f] = fx
+	fx.add(state, restrictEnv(env, f.scope), f.kind == mcNu)
+	rank := 0
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(fx.pairs); i++ {
+			n := len(fx.pairs)
+			if v := c.eval(f.body, fx.pairs[i].state, fx.pairs[i].env); v != fx.values[i] {
+				rank++
+				fx.values[i], fx.ranks[i] = v, rank
+				changed = true
+			}
+			if len(fx.pairs) > n {
+				changed = true
+			}
+		}
+	}
+	if f.closed {
+		if c.cache[f] == nil {
+			c.cache[f] = make(map[string]bool)
+		}
+		for i, p := range fx.pairs {
+			c.cache[f][mcKey(p.state, p.env)] = fx.values[i]
+		}
+	}
+	return fx.values[0]
+}
+
+// ####
+// Counterexamples.
+// ####
+
+const mcMaxExplanation = 200
+
+// A counterexample of a formula that does not hold in a state: a path from
+// state 0, with a line per step and per fixpoint it goes through.
+type mcExplainer struct {
+	c     *mcChecker
+	lines []string
+	seen  map[string]bool
+}
+
+func (e *mcExplainer) printf(indent string, format string, args ...interface{}) {
+	if len(e.lines) == mcMaxExplanation {
+		e.lines = append(e.lines, indent+"...")
+	}
+	if len(e.lines) < mcMaxExplanation {
+		e.lines = append(e.lines, indent+fmt.Sprintf(format, args...))
+	}
+}
+
+// The key of a fixpoint in a state, for the loops of a counterexample.
+func (e *mcExplainer) key(fix *mcFormula, state int, env []int) string {
+	return fmt.Sprintf("%p %s", fix, mcKey(state, restrictEnv(env, fix.scope)))
+}
+
+// Explain why f does not hold in the state. Of the transitions that break a
+// box, the one that left the greatest fixpoint nu first is followed, so that
+// the path gets closer to where its body does not hold.
+func (e *mcExplainer) explain(f *mcFormula, state int, env []int, names *pathNames, indent string, nu *mcFormula) {
+	if len(e.lines) > mcMaxExplanation {
+		return
+	}
+	c := e.c
+	switch f.kind {
+	case mcFalse:
+		e.printf(indent, "state %d does not satisfy false", state)
+	case mcAnd:
+		if !c.eval(f.left, state, env) {
+			e.explain(f.left, state, env, names, indent, nu)
+		} else {
+			e.explain(f.right, state, env, names, indent, nu)
+		}
+	case mcOr:
+		e.printf(indent, "state %d satisfies neither %s nor %s:", state, f.left.string(false), f.right.string(false))
+		e.explain(f.left, state, env, names.clone(), indent+"  ", nu)
+		e.explain(f.right, state, env, names.clone(), indent+"  ", nu)
+	case mcDiamond:
+		e.printf(indent, "state %d has no <%s> transition to a state that satisfies %s", state,
+			f.act.string(*f.slots), f.body.string(false))
+	case mcBox:
+		var next pifra.Transition
+		var nextEnv []int
+		best := -1
+		for _, trans := range c.adj[state] {
+			if !f.act.matches(trans, env) {
+				continue
+			}
+			env_ := c.stepAction(f.act, trans, env)
+			if c.eval(f.body, trans.Destination, env_) {
+				continue
+			}
+			rank := math.MaxInt32
+			if nu != nil {
+				if i, ok := c.fix[nu].index[mcKey(trans.Destination, restrictEnv(env_, nu.scope))]; ok {
+					rank = c.fix[nu].ranks[i]
+				}
+			}
+			if best < 0 || rank < best {
+				next, nextEnv, best = trans, env_, rank
+			}
+		}
+		if f.body.kind == mcFalse {
+			e.printf(indent, "%d -%s-> %d, which [%s]false rules out", state, names.step(next), next.Destination,
+				f.act.string(*f.slots))
+			return
+		}
+		e.printf(indent, "%d -%s-> %d", state, names.step(next), next.Destination)
+		e.explain(f.body, next.Destination, nextEnv, names, indent, nu)
+	case mcMu, mcNu:
+		e.printf(indent, "state %d does not satisfy %s", state, f.string(false))
+		e.seen[e.key(f, state, env)] = true
+		c.fixpoint(f, state, env)
+		if f.kind == mcNu {
+			nu = f
+		}
+		e.explain(f.body, state, env, names, indent, nu)
+	case mcVar:
+		key := e.key(f.fix, state, env)
+		if e.seen[key] {
+			if f.fix.kind == mcMu {
+				e.printf(indent, "state %d does not satisfy %s again, and the path can go on like this forever", state, f.name)
+			} else {
+				e.printf(indent, "state %d does not satisfy %s again", state, f.name)
+			}
+			return
+		}
+		e.seen[key] = true
+		if f.fix.kind == mcNu {
+			nu = f.fix
+		}
+		e.explain(f.fix.body, state, env, names, indent, nu)
+	}
+}
+
+// ####
+// The command.
+// ####
+
+type mcResult struct {
+	holds bool
+	// The reachable states, and the ones the formula holds in.
+	reachable int
+	states    []int
+	// The counterexample in state 0, if the formula does not hold.
+	counterexample []string
+}
+
+// Check a formula in state 0 and in the other reachable states, with the
+// names of the model in the registers along the first path to them.
+func checkMcFormula(lts pifra.Lts, f *mcFormula) mcResult {
+	var res mcResult
+	if _, ok := lts.States[0]; !ok {
+		return res
+	}
+	c := newMcChecker(lts, f)
+	start := c.startEnv()
+	envs := map[int][]int{0: start}
+	order := []int{0}
+	for i := 0; i < len(order); i++ {
+		for _, trans := range c.adj[order[i]] {
+			if _, ok := envs[trans.Destination]; !ok {
+				envs[trans.Destination] = c.step(trans, envs[order[i]])
+				order = append(order, trans.Destination)
+			}
+		}
+	}
+	res.reachable = len(order)
+	for _, id := range order {
+		if c.eval(f, id, envs[id]) {
+			res.states = append(res.states, id)
+		}
+	}
+	res.holds = len(res.states) > 0 && res.states[0] == 0
+	if !res.holds {
+		e := &mcExplainer{c: c, seen: make(map[string]bool)}
+		e.explain(f, 0, start, newPathNames(&lts), "  ", nil)
+		res.counterexample = e.lines
+	}
+	return res
+}
+
+// The mc command.
+func mcCommand(args []string) {
+	fs := newCommandFlagSet("mc", "[flags] model formula",
+		"Check a formula of the modal mu-calculus in the start state of the LTS of a model or of an LTS file.")
+	weakFlag := fs.Bool("w", false, "Whether to check the formula on the weak transform of the LTS.")
+	fileFlag := fs.String("f", "", "A path to read the formula from, instead of the second argument.")
+	statesFlag := fs.Bool("states", false, "Whether to list the reachable states the formula holds in.")
+	verboseFlag := fs.Bool("v", false, "Whether to be verbose.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if *fileFlag != "" && len(args) != 1 {
+		usageError(fs, fmt.Errorf("expected one model as argument with -f, not %d", len(args)))
+	}
+	if *fileFlag == "" && len(args) != 2 {
+		usageError(fs, fmt.Errorf("expected a model and a formula as arguments, not %d", len(args)))
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+	verbose = *verboseFlag
+	var src string
+	if *fileFlag != "" {
+		data, err := ioutil.ReadFile(*fileFlag)
+		check(err)
+		src = string(data)
+	} else {
+		src = args[1]
+	}
+
+	dir, err := ioutil.TempDir("", "pisim22-mc")
+	check(err)
+	defer os.RemoveAll(dir)
+	lts, _, err := loadOrGenerateLts(args[0], dir, pf.flags())
+	check(err)
+	if *weakFlag {
+		lts = doWeakTransform(lts)
+	}
+	f, err := parseMcFormula(src, lts)
+	if err != nil {
+		usageError(fs, err)
+	}
+	if isVerbose() {
+		fmt.Printf("Checking %s.\n", f)
+	}
+
+	res := checkMcFormula(lts, f)
+	if res.holds {
+		fmt.Printf("The formula holds in state 0.\n")
+	} else {
+		fmt.Printf("The formula does NOT hold in state 0:\n%s\n", strings.Join(res.counterexample, "\n"))
+	}
+	fmt.Printf("It holds in %d of the %d reachable states.\n", len(res.states), res.reachable)
+	if *statesFlag {
+		fmt.Printf("States: %s\n", strings.Trim(fmt.Sprint(res.states), "[]"))
+	}
+	// The exit status tells whether the formula holds, for scripts.
+	if !res.holds {
+		os.Exit(1)
+	}
+}
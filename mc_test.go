@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// A server that answers a request on the channel it is given, or loses it.
+const mcTestModel = "S(req) = req(r).(r'<r>.S(req) + $l.(l'<l>.0 | l(z).S(req)))\nS(req)\n"
+
+func TestMcParse(t *testing.T) {
+	lts := analyzeTestLts(t, mcTestModel, 3000)
+	cases := []struct {
+		src string
+		// The formula with the negations pushed down, or the error.
+		res string
+		err string
+	}{
+		{"true && false || true", "(true && false) || true", ""},
+		{"!(<req(?r)>[r'<_>]false)", "[req(?r)]<r'<_>>true", ""},
+		{"!mu X. <tau>X || !(nu Y. [-]Y) # a comment", "nu X. ([tau]X && (nu Y. [-]Y))", ""},
+		{"<!req(_)>true && [req(r*)]<r'<req>>true", "<!req(_)>true && [req(r*)]<r'<req>>true", ""},
+		{"mu X. !X", "", "1:8: X occurs under an odd number of negations"},
+		{"<a(_)>true", "", "1:2: a is neither a free name"},
+		{"<!req(?r)>true", "", "cannot bind r"},
+		{"[req(r)]true", "", "r is neither a free name"},
+		{"<req(?r)>true && <r'<r>>true", "", "r is neither a free name"},
+		{"mu X. Y", "", "Y is not a fixpoint variable"},
+		{"<req(_)true", "", `expected ">", found "true"`},
+		{"true &&", "", "expected a formula, found the end"},
+		{"true\nfalse", "", `2:1: unexpected "false"`},
+	}
+	for _, c := range cases {
+		f, err := parseMcFormula(c.src, lts)
+		switch {
+		case err != nil && (c.err == "" || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%q gave the error %q, expected %q.", c.src, err.Error(), c.err)
+		case err == nil && c.err != "":
+			t.Errorf("%q gave no error, expected %q.", c.src, c.err)
+		case err == nil && f.String() != c.res:
+			t.Errorf("%q was parsed as %q, expected %q.", c.src, f.String(), c.res)
+		}
+	}
+}
+
+func TestMc(t *testing.T) {
+	lts := analyzeTestLts(t, mcTestModel, 3000)
+	weak := doWeakTransform(lts)
+	cases := []struct {
+		src   string
+		weak  bool
+		holds bool
+		// The number of the reachable states it holds in, and a line of the
+		// counterexample.
+		states int
+		line   string
+	}{
+		// Deadlock freedom.
+		{"nu X. <->true && [-]X", false, true, 5, ""},
+		// A request may be answered, on the name that was received.
+		{"<req(?r)><r'<r>>true", false, true, 2, ""},
+		{"<req(r*)><r'<r>>true", false, true, 2, ""},
+		{"<req(r*)><req'<r>>true", false, false, 0, "state 0 has no <req(r*)> transition"},
+		// A request is not always answered, as it can be lost forever.
+		{"nu X. [req(?r)](mu Y. [!r'<_>]Y) && [-]X", false, false, 0, "does not satisfy Y again"},
+		{"nu X. [tau]false && [-]X", false, false, 0, "1 -τ-> 0, which [tau]false rules out"},
+		// The weak transform has a tau from each state to itself.
+		{"<tau>true", true, true, 5, ""},
+		// A lost request is followed by the next one only weakly.
+		{"<req(?r)><req(?s)>true", false, false, 0, "state 0 has no <req(?r)> transition"},
+		{"<req(?r)><req(?s)>true", true, true, 5, ""},
+	}
+	for _, c := range cases {
+		l := lts
+		if c.weak {
+			l = weak
+		}
+		f, err := parseMcFormula(c.src, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := checkMcFormula(l, f)
+		counterexample := strings.Join(res.counterexample, "\n")
+		if res.holds != c.holds || len(res.states) != c.states || res.reachable != 5 ||
+			(c.line != "" && !strings.Contains(counterexample, c.line)) {
+			t.Errorf("%q holds %t in %v of %d states, expected %t in %d, with the counterexample:\n%s",
+				c.src, res.holds, res.states, res.reachable, c.holds, c.states, counterexample)
+		}
+	}
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with a frontend for models written for the Mobility Workbench
+// (MWB). A file consists of agent definitions and queries:
+//
+//	agent Buf(i,o) = i(x).'o<x>.Buf(i,o)
+//	agent Sys(i,o) = (^m)(Buf(i,m) | Buf(m,o))
+//	weq Sys(i,o) Buf2(i,o)
+//
+// The agents are translated into pifra ASTs, and each eq (strong) or weq (weak)
+// query is checked with checkBisim on the LTSs that pifra generates for the two
+// agents. The supported agents are:
+//
+//	0             inaction
+//	'a<x,y>.P     output, 'a.P outputs a on itself
+//	a(x,y).P      input, a.P inputs a name that is not used
+//	t.P           the silent action
+//	(^x,y)P       restriction
+//	[x=y]P        match, [x#y]P is a mismatch
+//	P + Q, P | Q  sum and parallel composition
+//	A(x,y)        an agent, A<x,y> is accepted as well
+//
+// Polyadic prefixes are translated as in polyadic.go. A prefix without a
+// continuation is followed by 0. Comments are written
+// between (* and *). Other MWB commands are skipped.
+
+type mwbTokenType int
+
+const (
+	mwbTokEOF mwbTokenType = iota
+	mwbTokId
+	mwbTokPunct
+	mwbTokString
+)
+
+type mwbToken struct {
+	typ  mwbTokenType
+	text string
+	line int
+}
+
+func (t mwbToken) String() string {
+	if t.typ == mwbTokEOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func isMwbIdRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func lexMwb(src string) ([]mwbToken, error) {
+	var toks []mwbToken
+	line := 1
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' && i+1 < len(rs) && rs[i+1] == '*':
+			start := line
+			i += 2
+			for ; i+1 < len(rs) && !(rs[i] == '*' && rs[i+1] == ')'); i++ {
+				if rs[i] == '\n' {
+					line++
+				}
+			}
+			if i+1 >= len(rs) {
+				return nil, fmt.Errorf("line %d: unterminated comment", start)
+			}
+			i += 2
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' && rs[j] != '\n' {
+				j++
+			}
+			if j >= len(rs) || rs[j] != '"' {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			toks = append(toks, mwbToken{mwbTokString, string(rs[i+1 : j]), line})
+			i = j + 1
+		case isMwbIdRune(r):
+			j := i
+			for j < len(rs) && isMwbIdRune(rs[j]) {
+				j++
+			}
+			toks = append(toks, mwbToken{mwbTokId, string(rs[i:j]), line})
+			i = j
+		case strings.ContainsRune("'<>()[]=#.+|,^", r):
+			toks = append(toks, mwbToken{mwbTokPunct, string(r), line})
+			i++
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, r)
+		}
+	}
+	toks = append(toks, mwbToken{mwbTokEOF, "", line})
+	return toks, nil
+}
+
+// An agent definition.
+type mwbAgent struct {
+	Name   string
+	Params []string
+	Body   pifra.Element
+}
+
+// An eq or weq query.
+type mwbQuery struct {
+	Weak  bool
+	Line  int
+	Left  pifra.Element
+	Right pifra.Element
+	// The source of the query, for printing.
+	Text string
+}
+
+type mwbFile struct {
+	Agents  map[string]*mwbAgent
+	Order   []string
+	Queries []mwbQuery
+	// Commands that were skipped.
+	Skipped []string
+}
+
+var mwbKeywords = map[string]bool{
+	"agent": true,
+	"eq":    true,
+	"weq":   true,
+}
+
+// Other commands of MWB, which are skipped.
+var mwbOtherCommands = map[string]bool{
+	"input": true, "env": true, "clear": true, "eqd": true, "weqd": true,
+	"deadlocks": true, "size": true, "step": true, "help": true, "quit": true,
+	"set": true, "show": true, "print": true,
+}
+
+type mwbParser struct {
+	toks  []mwbToken
+	pos   int
+	used  map[string]bool
+	fresh int
+}
+
+func (p *mwbParser) peek() mwbToken {
+	return p.toks[p.pos]
+}
+
+func (p *mwbParser) next() mwbToken {
+	t := p.toks[p.pos]
+	if t.typ != mwbTokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *mwbParser) isPunct(text string) bool {
+	t := p.peek()
+	return t.typ == mwbTokPunct && t.text == text
+}
+
+func (p *mwbParser) accept(text string) bool {
+	if p.isPunct(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *mwbParser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("line %d: expected %q, found %s", t.line, text, t)
+	}
+	return nil
+}
+
+var pifraNameRegexp = regexp.MustCompile(`^_?[a-zA-Z0-9]+$`)
+
+// Read a name. Names have to be valid in pifra too.
+func (p *mwbParser) name() (string, error) {
+	t := p.next()
+	if t.typ != mwbTokId || mwbKeywords[t.text] {
+		return "", fmt.Errorf("line %d: expected a name, found %s", t.line, t)
+	}
+	if !pifraNameRegexp.MatchString(t.text) {
+		return "", fmt.Errorf("line %d: name %s is not supported by pifra", t.line, t.text)
+	}
+	return t.text, nil
+}
+
+// Read a list of names up to the closing bracket.
+func (p *mwbParser) names(close string) ([]string, error) {
+	var res []string
+	if p.accept(close) {
+		return res, nil
+	}
+	for {
+		n, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+		if p.accept(close) {
+			return res, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// A name that does not occur in the file.
+func (p *mwbParser) freshName(prefix string) string {
+	for {
+		p.fresh++
+		n := fmt.Sprintf("_%s%d", prefix, p.fresh)
+		if !p.used[n] {
+			p.used[n] = true
+			return n
+		}
+	}
+}
+
+// agent := sum { '|' sum }
+func (p *mwbParser) agent() (pifra.Element, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("|") {
+		right, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		left = &pifra.ElemParallel{ProcessL: left, ProcessR: right}
+	}
+	return left, nil
+}
+
+// sum := prefixed { '+' prefixed }
+func (p *mwbParser) sum() (pifra.Element, error) {
+	left, err := p.prefixed()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("+") {
+		right, err := p.prefixed()
+		if err != nil {
+			return nil, err
+		}
+		left = &pifra.ElemSum{ProcessL: left, ProcessR: right}
+	}
+	return left, nil
+}
+
+// The continuation of a prefix, which is 0 if there is no dot.
+func (p *mwbParser) continuation() (pifra.Element, error) {
+	if p.accept(".") {
+		return p.prefixed()
+	}
+	return &pifra.ElemNil{}, nil
+}
+
+func (p *mwbParser) prefixed() (pifra.Element, error) {
+	t := p.peek()
+	switch {
+	case p.accept("'"):
+		// Output.
+		ch, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		objs := []string{ch}
+		if p.accept("<") {
+			if objs, err = p.names(">"); err != nil {
+				return nil, err
+			}
+		}
+		next, err := p.continuation()
+		if err != nil {
+			return nil, err
+		}
+		switch len(objs) {
+		case 0:
+			return nil, fmt.Errorf("line %d: output on %s has no names", t.line, ch)
+		case 1:
+			return &pifra.ElemOutput{Channel: pifra.Name{Name: ch}, Output: pifra.Name{Name: objs[0]}, Next: next}, nil
+		}
+		return polyadicOutputElem(ch, objs, p.freshName("s"), next), nil
+	case p.accept("["):
+		l, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		inequality := false
+		if p.accept("#") {
+			inequality = true
+		} else if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		r, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		next, err := p.prefixed()
+		if err != nil {
+			return nil, err
+		}
+		return &pifra.ElemEquality{Inequality: inequality, NameL: pifra.Name{Name: l},
+			NameR: pifra.Name{Name: r}, Next: next}, nil
+	case p.accept("("):
+		if p.accept("^") {
+			names, err := p.names(")")
+			if err != nil {
+				return nil, err
+			}
+			next, err := p.prefixed()
+			if err != nil {
+				return nil, err
+			}
+			for i := len(names) - 1; i >= 0; i-- {
+				next = &pifra.ElemRestriction{Restrict: pifra.Name{Name: names[i]}, Next: next}
+			}
+			return next, nil
+		}
+		elem, err := p.agent()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return elem, nil
+	case t.typ == mwbTokId && t.text == "0":
+		p.next()
+		return &pifra.ElemNil{}, nil
+	case t.typ == mwbTokId && unicode.IsUpper([]rune(t.text)[0]):
+		// An agent.
+		p.next()
+		call := &pifra.ElemProcess{Name: t.text}
+		var args []string
+		var err error
+		if p.accept("(") {
+			args, err = p.names(")")
+		} else if p.accept("<") {
+			args, err = p.names(">")
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range args {
+			call.Parameters = append(call.Parameters, pifra.Name{Name: a})
+		}
+		return call, nil
+	case t.typ == mwbTokId && t.text == "t" && !p.isPunctAt(1, "("):
+		// The silent action, as a communication on a private channel.
+		p.next()
+		next, err := p.continuation()
+		if err != nil {
+			return nil, err
+		}
+		ch := p.freshName("t")
+		v := p.freshName("v")
+		return &pifra.ElemRestriction{
+			Restrict: pifra.Name{Name: ch},
+			Next: &pifra.ElemParallel{
+				ProcessL: &pifra.ElemOutput{Channel: pifra.Name{Name: ch}, Output: pifra.Name{Name: ch},
+					Next: &pifra.ElemNil{}},
+				ProcessR: &pifra.ElemInput{Channel: pifra.Name{Name: ch}, Input: pifra.Name{Name: v},
+					Next: next},
+			},
+		}, nil
+	case t.typ == mwbTokId && !mwbKeywords[t.text]:
+		// Input.
+		ch, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		var objs []string
+		if p.accept("(") {
+			if objs, err = p.names(")"); err != nil {
+				return nil, err
+			}
+		}
+		if len(objs) == 0 {
+			objs = []string{p.freshName("v")}
+		}
+		next, err := p.continuation()
+		if err != nil {
+			return nil, err
+		}
+		if len(objs) == 1 {
+			return &pifra.ElemInput{Channel: pifra.Name{Name: ch}, Input: pifra.Name{Name: objs[0]}, Next: next}, nil
+		}
+		return polyadicInputElem(ch, objs, p.freshName("s"), next), nil
+	}
+	return nil, fmt.Errorf("line %d: expected an agent, found %s", t.line, t)
+}
+
+func (p *mwbParser) isPunctAt(offset int, text string) bool {
+	if p.pos+offset >= len(p.toks) {
+		return false
+	}
+	t := p.toks[p.pos+offset]
+	return t.typ == mwbTokPunct && t.text == text
+}
+
+// The source text of the tokens from start up to the current token.
+func (p *mwbParser) text(start int) string {
+	var sb strings.Builder
+	for i := start; i < p.pos; i++ {
+		t := p.toks[i]
+		if i > start && t.typ == mwbTokId && p.toks[i-1].typ == mwbTokId {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(t.text)
+	}
+	return sb.String()
+}
+
+func parseMwb(src string) (*mwbFile, error) {
+	toks, err := lexMwb(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &mwbParser{toks: toks, used: make(map[string]bool)}
+	for _, t := range toks {
+		if t.typ == mwbTokId {
+			p.used[t.text] = true
+		}
+	}
+	file := &mwbFile{Agents: make(map[string]*mwbAgent)}
+	for p.peek().typ != mwbTokEOF {
+		t := p.next()
+		switch {
+		case t.typ == mwbTokId && t.text == "agent":
+			id := p.next()
+			if id.typ != mwbTokId || !unicode.IsUpper([]rune(id.text)[0]) {
+				return nil, fmt.Errorf("line %d: expected an agent identifier, found %s", id.line, id)
+			}
+			if _, ok := file.Agents[id.text]; ok {
+				return nil, fmt.Errorf("line %d: agent %s is defined twice", id.line, id.text)
+			}
+			a := &mwbAgent{Name: id.text}
+			if p.accept("(") {
+				if a.Params, err = p.names(")"); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			if a.Body, err = p.agent(); err != nil {
+				return nil, err
+			}
+			file.Agents[a.Name] = a
+			file.Order = append(file.Order, a.Name)
+		case t.typ == mwbTokId && (t.text == "eq" || t.text == "weq"):
+			q := mwbQuery{Weak: t.text == "weq", Line: t.line}
+			start := p.pos
+			if q.Left, err = p.agent(); err != nil {
+				return nil, err
+			}
+			left := p.text(start)
+			start = p.pos
+			if q.Right, err = p.agent(); err != nil {
+				return nil, err
+			}
+			q.Text = t.text + " " + left + " " + p.text(start)
+			file.Queries = append(file.Queries, q)
+		case t.typ == mwbTokId && mwbOtherCommands[t.text]:
+			// Skip the rest of the line.
+			for p.peek().typ != mwbTokEOF && p.peek().line == t.line {
+				p.next()
+			}
+			file.Skipped = append(file.Skipped, fmt.Sprintf("line %d: %s", t.line, t.text))
+		default:
+			return nil, fmt.Errorf("line %d: expected agent, eq or weq, found %s", t.line, t)
+		}
+	}
+	return file, file.checkCalls()
+}
+
+// Check that all the agents that are used are defined, with the right arity.
+func (f *mwbFile) checkCalls() error {
+	var check func(elem pifra.Element) error
+	check = func(elem pifra.Element) error {
+		switch e := elem.(type) {
+		case *pifra.ElemOutput:
+			return check(e.Next)
+		case *pifra.ElemInput:
+			return check(e.Next)
+		case *pifra.ElemEquality:
+			return check(e.Next)
+		case *pifra.ElemRestriction:
+			return check(e.Next)
+		case *pifra.ElemSum:
+			if err := check(e.ProcessL); err != nil {
+				return err
+			}
+			return check(e.ProcessR)
+		case *pifra.ElemParallel:
+			if err := check(e.ProcessL); err != nil {
+				return err
+			}
+			return check(e.ProcessR)
+		case *pifra.ElemProcess:
+			a, ok := f.Agents[e.Name]
+			if !ok {
+				return fmt.Errorf("agent %s is not defined", e.Name)
+			}
+			if len(a.Params) != len(e.Parameters) {
+				return fmt.Errorf("agent %s takes %d names, but is given %d", e.Name, len(a.Params), len(e.Parameters))
+			}
+		}
+		return nil
+	}
+	for _, name := range f.Order {
+		if err := check(f.Agents[name].Body); err != nil {
+			return fmt.Errorf("in agent %s: %s", name, err.Error())
+		}
+	}
+	for _, q := range f.Queries {
+		if err := check(q.Left); err != nil {
+			return fmt.Errorf("line %d: %s", q.Line, err.Error())
+		}
+		if err := check(q.Right); err != nil {
+			return fmt.Errorf("line %d: %s", q.Line, err.Error())
+		}
+	}
+	return nil
+}
+
+// The pifra program with all the agents, and root as the process to run.
+func (f *mwbFile) pifraProgram(root pifra.Element) string {
+	var sb strings.Builder
+	names := append([]string{}, f.Order...)
+	sort.Strings(names)
+	for _, name := range names {
+		a := f.Agents[name]
+		sb.WriteString(a.Name)
+		if len(a.Params) > 0 {
+			sb.WriteString("(" + strings.Join(a.Params, ", ") + ")")
+		}
+		sb.WriteString(" = " + pifra.PrettyPrintAst(a.Body) + "\n")
+	}
+	sb.WriteString(pifra.PrettyPrintAst(root) + "\n")
+	return sb.String()
+}
+
+// Generate the LTS of an agent with pifra.
+func (f *mwbFile) generateLts(dir string, name string, root pifra.Element, flags pifra.Flags) (pifra.Lts, error) {
+	piFile := path.Join(dir, name+".pi")
+	if err := writeFile(piFile, []byte(f.pifraProgram(root))); err != nil {
+		return pifra.Lts{}, err
+	}
+	opts := flags
+	opts.InputFile = piFile
+	opts.OutputFile = path.Join(dir, name+".gob")
+	if err := pifra.OutputMode(opts); err != nil {
+		return pifra.Lts{}, err
+	}
+	return decodeLTS(opts.OutputFile)
+}
+
+// Check query i of the file, with the LTSs generated in dir.
+func (f *mwbFile) checkQuery(dir string, i int, flags pifra.Flags, regSizeOverride int) (ResultType, error) {
+	q := f.Queries[i]
+	left, err := f.generateLts(dir, fmt.Sprintf("q%d.1", i), q.Left, flags)
+	if err != nil {
+		return ResultNotRelated, fmt.Errorf("line %d: %s", q.Line, err.Error())
+	}
+	right, err := f.generateLts(dir, fmt.Sprintf("q%d.2", i), q.Right, flags)
+	if err != nil {
+		return ResultNotRelated, fmt.Errorf("line %d: %s", q.Line, err.Error())
+	}
+	prevWeak := weakBisim
+	defer func() { weakBisim = prevWeak }()
+	weakBisim = q.Weak
+	weakLeft, weakRight := left, right
+	if q.Weak {
+		weakLeft = doWeakTransform(left)
+		weakRight = doWeakTransform(right)
+	}
+	return checkBisim(left, right, weakLeft, weakRight, regSizeOverride, -1, false), nil
+}
+
+func readMwbFile(name string) (*mwbFile, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := parseMwb(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return file, nil
+}
+
+// Run all the queries of an MWB file, and print MWB-like answers.
+func runMwbFile(name string, flags pifra.Flags, regSizeOverride int) error {
+	file, err := readMwbFile(name)
+	if err != nil {
+		return err
+	}
+	for _, s := range file.Skipped {
+		fmt.Printf("Skipped the unsupported command on %s.\n", s)
+	}
+	dir, err := ioutil.TempDir("", "pisim22-mwb")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	prevQuiet := quiet
+	quiet = !isVerbose()
+	defer func() { quiet = prevQuiet }()
+	for i, q := range file.Queries {
+		fmt.Printf("MWB>%s\n", q.Text)
+		res, err := file.checkQuery(dir, i, flags, regSizeOverride)
+		if err != nil {
+			return err
+		}
+		if res == ResultRelated {
+			fmt.Println("The two agents are equal.")
+		} else {
+			fmt.Println("The two agents are NOT equal.")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path"
+	"testing"
+)
+
+func TestMwbFile(t *testing.T) {
+	pwd := getPwd(t)
+	file, err := readMwbFile(path.Join(pwd, "test", "mwb", "buffers.mwb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Skipped) != 1 {
+		t.Errorf("Expected one skipped command, got %d.", len(file.Skipped))
+	}
+	expected := []ResultType{ResultRelated, ResultNotRelated, ResultRelated,
+		ResultNotRelated, ResultNotRelated, ResultRelated, ResultRelated}
+	if len(file.Queries) != len(expected) {
+		t.Fatalf("Expected %d queries, got %d.", len(expected), len(file.Queries))
+	}
+	dir := t.TempDir()
+	for i, q := range file.Queries {
+		res, err := file.checkQuery(dir, i, flags, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != expected[i] {
+			t.Errorf("%s was %d, expected %d.", q.Text, res, expected[i])
+		}
+	}
+}
+
+func TestMwbErrors(t *testing.T) {
+	cases := map[string]string{
+		"undefined": "eq A a.0",
+		"arity":     "agent A(x) = 'x\neq A(a,b) A(a)",
+		"twice":     "agent A = 0\nagent A = 0",
+		"output":    "eq 'a<> 0",
+		"name":      "eq a.0 b'.0",
+		"comment":   "(* open\neq a b",
+		"command":   "weqq a b",
+	}
+	for name, src := range cases {
+		if _, err := parseMwb(src); err == nil {
+			t.Errorf("Expected an error for %s.", name)
+		}
+	}
+}
@@ -7,129 +7,350 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
+	"os/signal"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/yungene/pifra"
 )
 
 func main() {
-	startTime := time.Now()
+	if len(os.Args) < 2 {
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	arg := os.Args[1]
+	switch {
+	case arg == "-h" || arg == "-help" || arg == "--help":
+		printUsage(os.Stdout)
+		return
+	case strings.HasPrefix(arg, "-"):
+		// The flags of check without a command.
+		checkCommand(os.Args[1:], true)
+		return
+	}
+	cmd, ok := findCommand(arg)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "pisim22: unknown command %q\n\n", arg)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	cmd.run(os.Args[2:])
+}
 
-	ltsFileNameFlag := flag.String("lts1", "", "[REQUIRED] A path to the LTS file.")
-	ltsFileName2Flag := flag.String("lts2", "", "[REQUIRED] A path to the LTS file.")
-	regSizeOverrideFlag := flag.Int("n", -1, "The override for the size of the register.")
-	verboseFlag := flag.Bool("v", false, "Whether to be verbose.")
-	debugFlag := flag.Bool("d", false, "Whether to print debug information.")
-	weakBisimFlag := flag.Bool("w", false, "Whether to do weak bisimulation.")
-	internalStatsFlag := flag.Bool("is", false, "Whether to show internal stats.")
-	outputGraphFlag := flag.Bool("output-graph", false, "Whether to print the produced graph.")
-	maxStatesFlag := flag.Int("max-states", 15000, "Max states in an LTS.")
-	closureAlgoFlag := flag.Int("closure-algo", 1, "Choice of closure algorithm. 1 for DFS, 2 ofr Floyd-Warshall.")
-	gob1FileNameFlag := flag.String("gob1", "", "A path to the gob file.")
-	gob2FileNameFlag := flag.String("gob2", "", "A path to the gob file.")
-	outFileNameFlag := flag.String("out", "", "A path to the output files.")
-	outBisimFileNameFlag := flag.String("output-bisim", "", "A path to the output bisim lts DOT file.")
-	garbageCollectionFlag := flag.Bool("gc", false, "Whether to enable garbage collection.")
-	flag.Parse()
-	verbose = *verboseFlag
-	debug = *debugFlag
-	weakBisim = *weakBisimFlag
-	internalStats = *internalStatsFlag
-	enableGC = *garbageCollectionFlag
-	outputGraph = *outputGraphFlag
-	outputBisimLtsName = *outBisimFileNameFlag
-
-	if *closureAlgoFlag == 1 || *closureAlgoFlag == 2 {
-		closureAlgorithmChoice = *closureAlgoFlag
-	}
-
-	// regSize := *regSizeOverrideFlag
-	// if *regSizeOverrideFlag == -1 {
-	// 	regSize = 1073741824
-	// }
-
-	var flags = pifra.Flags{
-		MaxStates:    *maxStatesFlag,
-		RegisterSize: 1073741824,
-		DisableGC:    !enableGarbageCollection(),
-		Gob:          true,
-		Statistics:   isVerbose(),
-	}
-
-	pwd, err := os.Getwd()
-	check(err)
-	// TODO: Fix this. This is risky as it can delete more files than necessary.
-	// Proper temporary files should be used. Or better - no temporary files!s
-	outFolder := path.Join(pwd, "tmp")
-	defer func() {
-		os.RemoveAll(outFolder)
-	}()
+// The flags of the check command.
+type checkFlagValues struct {
+	lts1, lts2, gob1, gob2                         *string
+	regSizeOverride, closureAlgo                   *int
+	verbose, debug, weak, internalStats            *bool
+	outputGraph                                    *bool
+	outputBisim, outputHtml, outputTex             *string
+	outputResult                                   *string
+	progress, watch                                *bool
+	watchInterval                                  *time.Duration
+	outputAut, outputJson, outputGob, outputFra    *string
+	noSymmetry, noCache, purgeCache, cacheRelation *bool
+	cacheDir, saveRelation, warmStart              *string
+	checkpoint, search                             *string
+	checkpointInterval                             *time.Duration
+	pifra                                          pifraFlagValues
+	// Only accepted without a command, as they are now done by the weak and
+	// mwb commands.
+	out, mwb *string
+}
 
-	pifraTimeStart := time.Now()
-	if isVerbose() {
-		fmt.Printf("Generating an LTS for lts1.\n")
-	}
-	// TODO: Fix this to use a proper unique name.
-	// Or better, do not store the data structure in a file at all and export it
-	// directly from pifra.
-	outputPath1 := path.Join(outFolder, "lts1"+".gob")
-	if *gob1FileNameFlag == "" {
-		opts := flags
-		opts.OutputFile = outputPath1
-		opts.InputFile = *ltsFileNameFlag
-		err = pifra.OutputMode(opts)
+func newCheckFlagSet(legacy bool) (*flag.FlagSet, *checkFlagValues) {
+	fs := newCommandFlagSet("check", "[flags] [lts1 lts2]",
+		"Check whether two models or LTSs are bisimilar. They can be given as arguments or with -lts1 and -lts2.\n"+
+			"A model is a pi-calculus file for pifra, and an LTS is a .aut, .fra, .json or .gob file.")
+	v := &checkFlagValues{
+		lts1:               fs.String("lts1", "", "A path to the first model or LTS."),
+		lts2:               fs.String("lts2", "", "A path to the second model or LTS."),
+		gob1:               fs.String("gob1", "", "A path to the gob file of the first LTS."),
+		gob2:               fs.String("gob2", "", "A path to the gob file of the second LTS."),
+		regSizeOverride:    fs.Int("n", -1, "The override for the size of the register."),
+		closureAlgo:        fs.Int("closure-algo", 1, "Choice of closure algorithm. 1 for DFS, 2 for Floyd-Warshall."),
+		verbose:            fs.Bool("v", false, "Whether to be verbose."),
+		debug:              fs.Bool("d", false, "Whether to print debug information."),
+		weak:               fs.Bool("w", false, "Whether to do weak bisimulation."),
+		internalStats:      fs.Bool("is", false, "Whether to show internal stats."),
+		outputGraph:        fs.Bool("output-graph", false, "Whether to print the produced graph."),
+		outputBisim:        fs.String("output-bisim", "", "A path to the output bisim lts DOT file."),
+		outputHtml:         fs.String("output-html", "", "A path to an HTML file to view both LTSs and the relation in a browser."),
+		outputTex:          fs.String("output-tex", "", "A path to a standalone LaTeX file with both LTSs and the relation."),
+		outputResult:       fs.String("output-result", "", "A path to a JSON file with the verdict, the relation and the distinguishing path."),
+		progress:           fs.Bool("progress", false, "Whether to report the progress of the check on stderr, as lines of JSON."),
+		watch:              fs.Bool("watch", false, "Whether to re-run the check whenever one of the models changes."),
+		watchInterval:      fs.Duration("watch-interval", 500*time.Millisecond, "How often -watch looks at the models."),
+		outputAut:          fs.String("output-aut", "", "A path prefix to write both LTSs to in the Aldebaran (.aut) format."),
+		outputJson:         fs.String("output-json", "", "A path prefix to write both LTSs to in the JSON format."),
+		outputGob:          fs.String("output-gob", "", "A path prefix to write both LTSs to in the gob format of pifra."),
+		outputFra:          fs.String("output-fra", "", "A path prefix to write both LTSs to in the FRA (.fra) text format."),
+		noSymmetry:         fs.Bool("no-symmetry", false, "Whether to disable the symmetry reduction over register permutations."),
+		noCache:            fs.Bool("no-cache", false, "Whether to bypass the result cache."),
+		purgeCache:         fs.Bool("purge-cache", false, "Whether to remove all the cached results before running. Can be used on its own."),
+		cacheDir:           fs.String("cache-dir", "", "A path to the result cache. Defaults to pisim22 in the user cache directory."),
+		cacheRelation:      fs.Bool("cache-relation", false, "Whether to store the relation along with the cached result."),
+		saveRelation:       fs.String("save-relation", "", "A path to save the computed relation to, for a later -warm-start."),
+		warmStart:          fs.String("warm-start", "", "A path to a relation saved with -save-relation to start the check from."),
+		checkpoint:         fs.String("checkpoint", "", "A path to write the relation found so far to while checking, and on an interrupt, which stops the check. It can be resumed with -warm-start."),
+		checkpointInterval: fs.Duration("checkpoint-interval", time.Minute, "How often -checkpoint is written."),
+		search:             fs.String("search", searchDfs, "The order of the search, dfs or deepening. deepening runs the search again with a bound on its depth that doubles, which tends to find shorter distinguishing paths."),
+		pifra:              addPifraFlags(fs),
+	}
+	empty := ""
+	v.out, v.mwb = &empty, &empty
+	if legacy {
+		v.out = fs.String("out", "", "Deprecated, use pisim22 weak. A path prefix to write both weakly transformed LTSs to, instead of checking. Needs -w.")
+		v.mwb = fs.String("mwb", "", "Deprecated, use pisim22 mwb. A path to a file with agents and eq/weq queries in the syntax of the Mobility Workbench.")
+	}
+	return fs, v
+}
+
+// Validate the flags of a parsed check command. The models given as arguments
+// are moved to -lts1 and -lts2.
+func (v *checkFlagValues) validate(args []string) error {
+	if *v.mwb != "" {
+		if *v.lts1 != "" || *v.lts2 != "" || *v.gob1 != "" || *v.gob2 != "" || len(args) > 0 {
+			return fmt.Errorf("-mwb cannot be combined with other models")
+		}
+		return nil
+	}
+	switch len(args) {
+	case 0:
+	case 2:
+		if *v.lts1 != "" || *v.lts2 != "" || *v.gob1 != "" || *v.gob2 != "" {
+			return fmt.Errorf("the models have to be given either as arguments or with -lts1 and -lts2, not both")
+		}
+		*v.lts1, *v.lts2 = args[0], args[1]
+	default:
+		return fmt.Errorf("expected two models as arguments, not %d", len(args))
+	}
+	if *v.lts1 != "" && *v.gob1 != "" {
+		return fmt.Errorf("-lts1 and -gob1 cannot both be given")
+	}
+	if *v.lts2 != "" && *v.gob2 != "" {
+		return fmt.Errorf("-lts2 and -gob2 cannot both be given")
+	}
+	first := *v.lts1 != "" || *v.gob1 != ""
+	second := *v.lts2 != "" || *v.gob2 != ""
+	if !first && !second && *v.purgeCache {
+		return nil
+	}
+	if !first {
+		return fmt.Errorf("-lts1 is required")
+	}
+	if !second {
+		return fmt.Errorf("-lts2 is required")
+	}
+	if err := validateRegSize(*v.regSizeOverride); err != nil {
+		return err
+	}
+	if *v.closureAlgo != 1 && *v.closureAlgo != 2 {
+		return fmt.Errorf("-closure-algo has to be 1 or 2, not %d", *v.closureAlgo)
+	}
+	if err := v.pifra.validate(); err != nil {
+		return err
+	}
+	if *v.search != searchDfs && *v.search != searchDeepening {
+		return fmt.Errorf("-search has to be %s or %s, not %q", searchDfs, searchDeepening, *v.search)
+	}
+	if *v.search == searchDeepening && *v.warmStart != "" {
+		return fmt.Errorf("-search %s starts the search again at each depth, so it cannot be combined with -warm-start", searchDeepening)
+	}
+	if *v.pifra.gc {
+		// The states of a saved relation are not found again with -gc, see
+		// warm_start.go.
+		if *v.warmStart != "" {
+			return fmt.Errorf("the states of a relation are not reliably found again with -gc, so it cannot be combined with -warm-start")
+		}
+		if *v.checkpoint != "" {
+			return fmt.Errorf("a checkpoint is resumed with -warm-start, so -checkpoint cannot be combined with -gc")
+		}
+	}
+	if *v.checkpoint != "" && *v.watch {
+		return fmt.Errorf("-checkpoint stops the check on an interrupt, so it cannot be combined with -watch")
+	}
+	if *v.checkpointInterval <= 0 {
+		return fmt.Errorf("-checkpoint-interval has to be positive, not %s", *v.checkpointInterval)
+	}
+	if *v.cacheRelation && *v.noCache {
+		return fmt.Errorf("-cache-relation stores the relation in the cache, so it cannot be used with -no-cache")
+	}
+	if *v.out != "" {
+		if !*v.weak {
+			return fmt.Errorf("-out writes the weakly transformed LTSs and needs -w, use pisim22 weak -o file.dot model instead")
+		}
+		for name, value := range map[string]string{"-output-bisim": *v.outputBisim, "-output-html": *v.outputHtml,
+			"-output-tex": *v.outputTex, "-output-result": *v.outputResult, "-save-relation": *v.saveRelation, "-warm-start": *v.warmStart} {
+			if value != "" {
+				return fmt.Errorf("-out does not run the check, so it cannot be combined with %s", name)
+			}
+		}
+	}
+	if *v.watch {
+		if *v.out != "" {
+			return fmt.Errorf("-out does not run the check, so it cannot be combined with -watch")
+		}
+		for name, value := range map[string]string{"-output-aut": *v.outputAut, "-output-json": *v.outputJson,
+			"-output-gob": *v.outputGob, "-output-fra": *v.outputFra} {
+			if value != "" {
+				return fmt.Errorf("%s is only written for the first models, so it cannot be combined with -watch", name)
+			}
+		}
+		if *v.watchInterval <= 0 {
+			return fmt.Errorf("-watch-interval has to be positive, not %s", *v.watchInterval)
+		}
+	}
+	return nil
+}
+
+// The check command. Without a command, the deprecated -out and -mwb flags are
+// accepted as well.
+func checkCommand(args []string, legacy bool) {
+	startTime := time.Now()
+	fs, v := newCheckFlagSet(legacy)
+	if err := v.validate(parseCommandFlags(fs, args)); err != nil {
+		usageError(fs, err)
+	}
+	verbose = *v.verbose
+	debug = *v.debug
+	weakBisim = *v.weak
+	internalStats = *v.internalStats
+	enableSymmetry = !*v.noSymmetry
+	outputGraph = *v.outputGraph
+	outputBisimLtsName = *v.outputBisim
+	outputHtmlName = *v.outputHtml
+	outputTexName = *v.outputTex
+	outputResultName = *v.outputResult
+	progress = *v.progress
+	cacheDir = *v.cacheDir
+	cacheRelation = *v.cacheRelation
+	saveRelationName = *v.saveRelation
+	checkpointName = *v.checkpoint
+	checkpointInterval = *v.checkpointInterval
+	searchStrategy = *v.search
+	closureAlgorithmChoice = *v.closureAlgo
+	if *v.warmStart != "" {
+		rel, err := readSavedRelation(*v.warmStart)
 		check(err)
-	} else {
+		warmStartRelation = &rel
+	}
+	// The cache stores the verdict, the distinguishing path and the relation,
+	// so bypass it whenever anything else is asked for.
+	useCache = !*v.noCache && !debug && !internalStats && !outputGraph &&
+		outputBisimLtsName == "" && outputHtmlName == "" && outputTexName == "" &&
+		*v.out == "" && saveRelationName == "" && warmStartRelation == nil && !*v.watch && checkpointName == ""
+
+	if *v.purgeCache {
+		check(purgeCache())
 		if isVerbose() {
-			fmt.Println("Gob file 1 override is used. No generation done.")
+			fmt.Printf("Removed the result cache at %s.\n", getCacheDir())
+		}
+		if *v.lts1 == "" && *v.gob1 == "" && *v.mwb == "" {
+			return
 		}
-		outputPath1 = *gob1FileNameFlag
 	}
-	if isVerbose() {
-		fmt.Println()
+
+	flags := v.pifra.flags()
+	if *v.mwb != "" {
+		fmt.Fprintf(os.Stderr, "-mwb is deprecated, use pisim22 mwb.\n")
+		check(runMwbFile(*v.mwb, flags, *v.regSizeOverride))
+		return
 	}
-	if isVerbose() {
-		fmt.Printf("Generating an LTS for lts2.\n")
-	}
-	outputPath2 := path.Join(outFolder, "lts2"+".gob")
-	if *gob2FileNameFlag == "" {
-		opts := flags
-		opts.OutputFile = outputPath2
-		opts.InputFile = *ltsFileName2Flag
-		err = pifra.OutputMode(opts)
-		check(err)
-	} else {
+
+	outFolder, err := ioutil.TempDir("", "pisim22")
+	check(err)
+	defer os.RemoveAll(outFolder)
+
+	pifraTimeStart := time.Now()
+	reportProgress(checkProgress{Phase: "generating"})
+	loadSide := func(isLeft bool) (pifra.Lts, error) {
+		name, gobName := *v.lts1, *v.gob1
+		if !isLeft {
+			name, gobName = *v.lts2, *v.gob2
+		}
+		if isVerbose() {
+			fmt.Printf("Generating an LTS for lts%d.\n", systemNumber(isLeft))
+		}
+		if gobName != "" {
+			if isVerbose() {
+				fmt.Printf("Gob file %d override is used. No generation done.\n", systemNumber(isLeft))
+			}
+			name = gobName
+		}
+		dir := filepath.Join(outFolder, systemName(isLeft))
+		lts, sorts, err := loadOrGenerateLts(name, dir, flags)
+		if err != nil {
+			return lts, err
+		}
+		if isLeft {
+			polyadicSortsLeft = sorts
+		} else {
+			polyadicSortsRight = sorts
+		}
 		if isVerbose() {
-			fmt.Println("Gob file 2 override is used. No generation done.")
+			fmt.Println()
 		}
-		outputPath2 = *gob2FileNameFlag
+		return lts, nil
 	}
-	if isVerbose() {
-		fmt.Println()
+	if *v.watch {
+		names := [2]string{*v.lts1, *v.lts2}
+		for i, gobName := range []string{*v.gob1, *v.gob2} {
+			if gobName != "" {
+				names[i] = gobName
+			}
+		}
+		stop := make(chan struct{})
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			close(stop)
+		}()
+		newCheckWatcher(names, loadSide, *v.regSizeOverride).watch(*v.watchInterval, stop)
+		return
 	}
+	left, err := loadSide(true)
+	check(err)
+	right, err := loadSide(false)
+	check(err)
 	if isVerbose() {
 		fmt.Printf("Pifra took in total %s time.\n", time.Since(pifraTimeStart))
 	}
+	for i, lts := range []pifra.Lts{left, right} {
+		for _, out := range []struct {
+			prefix string
+			ext    string
+		}{{*v.outputAut, autExt}, {*v.outputJson, jsonExt}, {*v.outputGob, ".gob"}, {*v.outputFra, fraExt}} {
+			if out.prefix != "" {
+				check(writeLtsFile(fmt.Sprintf("%s.%d%s", out.prefix, i+1, out.ext), lts, nil))
+			}
+		}
+	}
+	if *v.out != "" {
+		fmt.Fprintf(os.Stderr, "-out is deprecated, use pisim22 weak.\n")
+		check(writeLtsFile(*v.out+"-out.1.dot", doWeakTransform(left), getPolyadicSorts(true)))
+		check(writeLtsFile(*v.out+"-out.2.dot", doWeakTransform(right), getPolyadicSorts(false)))
+		return
+	}
+	// An interrupt only stops the search once the LTSs are ready, so that it
+	// still kills pifra.
+	if checkpointName != "" {
+		stopSearch = make(chan struct{})
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			signal.Stop(interrupt)
+			close(stopSearch)
+		}()
+	}
 	bisimStartTime := time.Now()
 	var bisimAlgoStartTime time.Time
-	if isWeakBisim() && *outFileNameFlag != "" {
-		left, err := decodeLTS(outputPath1)
-		check(err)
-		weakLeft := doWeakTransform(left)
-		data := generateGraphVizFile(weakLeft)
-		check(writeFile(*outFileNameFlag+"-out.1"+".dot", data))
-
-		right, err := decodeLTS(outputPath2)
-		check(err)
-		weakRight := doWeakTransform(right)
-		data = generateGraphVizFile(weakRight)
-		check(writeFile(*outFileNameFlag+"-out.2"+".dot", data))
-	} else if isWeakBisim() {
-
-		left, err := decodeLTS(outputPath1)
-		check(err)
+	if isWeakBisim() {
+		reportProgress(checkProgress{Phase: "transforming"})
 		prevTime := time.Now()
 		weakLeft := doWeakTransform(left)
 		transTime := time.Since(prevTime)
@@ -138,8 +359,6 @@ func main() {
 				len(left.States), len(left.Transitions), len(weakLeft.States), len(weakLeft.Transitions))
 			fmt.Printf("Left translation took %s.\n", transTime)
 		}
-		right, err := decodeLTS(outputPath2)
-		check(err)
 		leftTime := time.Now()
 		weakRight := doWeakTransform(right)
 		transTime2 := time.Since(leftTime)
@@ -151,16 +370,12 @@ func main() {
 			fmt.Printf("In total, translation took %s.\n\n", elapsedTime)
 		}
 		bisimAlgoStartTime = time.Now()
-		checkBisim(left, right, weakLeft, weakRight, *regSizeOverrideFlag, -1, false)
+		reportProgress(checkProgress{Phase: "checking"})
+		checkBisim(left, right, weakLeft, weakRight, *v.regSizeOverride, -1, false)
 	} else {
-
-		left, err := decodeLTS(outputPath1)
-		check(err)
-
-		right, err := decodeLTS(outputPath2)
-		check(err)
 		bisimAlgoStartTime = time.Now()
-		checkBisim(left, right, left, right, *regSizeOverrideFlag, -1, false)
+		reportProgress(checkProgress{Phase: "checking"})
+		checkBisim(left, right, left, right, *v.regSizeOverride, -1, false)
 	}
 	fmt.Printf("Bisimulation algo took: %s.\n", time.Since(bisimAlgoStartTime))
 	fmt.Printf("Total bisimulation check took (transformation + bisimulation): %s.\n", time.Since(bisimStartTime))
@@ -189,6 +404,25 @@ func writeFile(name string, data []byte) error {
 	return ioutil.WriteFile(name, data, 0644)
 }
 
+// Whether the file name refers to an LTS rather than to a pi-calculus model.
+func isLtsFile(name string) bool {
+	return isAutFile(name) || isJsonFile(name) || isFraFile(name)
+}
+
+// Read an LTS from a gob file, a JSON file, an Aldebaran file or a .fra file.
+func loadLTS(name string) (pifra.Lts, error) {
+	if isFraFile(name) {
+		return readFraFile(name)
+	}
+	if isAutFile(name) {
+		return readAutFile(name)
+	}
+	if isJsonFile(name) {
+		return readJsonLts(name)
+	}
+	return decodeLTS(name)
+}
+
 func decodeLTS(name string) (lts pifra.Lts, err error) {
 	file, err := os.Open(name)
 	if err != nil {
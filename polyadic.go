@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the support for polyadic prefixes a'<x,y>.P and
+// a(x,y).P, which pifra does not have. They are translated into monadic
+// prefixes with the standard encoding through a private session channel:
+//
+//	a'<x1,...,xn>.P  =  $s.a'<s>.s'<x1>. ... .s'<xn>.P
+//	a(y1,...,yn).Q   =  a(s).s(y1). ... .s(yn).Q
+//
+// The arities of the channels (their sorts) are kept, so that the sessions can
+// be folded back into polyadic labels when the LTS is printed.
+
+// The arities of the channels that are only used polyadically, and always with
+// the same number of names.
+type polyadicSorts map[string]int
+
+type piTokenType int
+
+const (
+	piTokName piTokenType = iota
+	piTokPunct
+)
+
+type piToken struct {
+	typ        piTokenType
+	text       string
+	start, end int
+}
+
+var piTokenRegexp = regexp.MustCompile(`#[^\n]*|_?[a-zA-Z0-9]+|\S`)
+
+// Split a pifra model into tokens, with their offsets in the source. Comments
+// are left out.
+func lexPi(src []byte) []piToken {
+	var toks []piToken
+	for _, loc := range piTokenRegexp.FindAllIndex(src, -1) {
+		text := string(src[loc[0]:loc[1]])
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		typ := piTokPunct
+		if pifraNameRegexp.MatchString(text) {
+			typ = piTokName
+		}
+		toks = append(toks, piToken{typ, text, loc[0], loc[1]})
+	}
+	return toks
+}
+
+// Match a prefix channel open names... close . starting at toks[i], where open
+// may be preceded by an apostrophe for outputs. Returns the names and the index
+// of the dot, or -1 if there is no prefix.
+func matchPiPrefix(toks []piToken, i int, open string, close string, apostrophe bool) ([]string, int) {
+	j := i + 1
+	if apostrophe && j < len(toks) && toks[j].text == "'" {
+		j++
+	}
+	if j >= len(toks) || toks[j].text != open {
+		return nil, -1
+	}
+	var names []string
+	for j++; j < len(toks); j++ {
+		if toks[j].typ != piTokName {
+			return nil, -1
+		}
+		names = append(names, toks[j].text)
+		j++
+		if j < len(toks) && toks[j].text == close {
+			break
+		}
+		if j >= len(toks) || toks[j].text != "," {
+			return nil, -1
+		}
+	}
+	j++
+	if j >= len(toks) || toks[j].text != "." {
+		return nil, -1
+	}
+	return names, j
+}
+
+// Generate names that do not occur in a model.
+type freshNames struct {
+	used  map[string]bool
+	count int
+}
+
+func (f *freshNames) next(prefix string) string {
+	for {
+		f.count++
+		n := fmt.Sprintf("_%s%d", prefix, f.count)
+		if !f.used[n] {
+			f.used[n] = true
+			return n
+		}
+	}
+}
+
+// Record that a channel is used with the given arity.
+func addSort(arities map[string]map[int]bool, channel string, arity int) {
+	if arities[channel] == nil {
+		arities[channel] = make(map[int]bool)
+	}
+	arities[channel][arity] = true
+}
+
+func sortsOf(arities map[string]map[int]bool) polyadicSorts {
+	sorts := make(polyadicSorts)
+	for channel, set := range arities {
+		if len(set) != 1 {
+			continue
+		}
+		for arity := range set {
+			if arity > 1 {
+				sorts[channel] = arity
+			}
+		}
+	}
+	return sorts
+}
+
+// The comments of a model, from a # to the end of the line. They are an
+// extension of pisim22, as pifra does not have comments, so they are removed
+// before pifra parses the model.
+var piCommentRegexp = regexp.MustCompile(`#[^\n]*`)
+
+// Replace the comments of a model with spaces, so that the offsets stay the
+// same.
+func stripPiComments(src []byte) []byte {
+	return piCommentRegexp.ReplaceAllFunc(src, func(c []byte) []byte {
+		return bytes.Repeat([]byte(" "), len(c))
+	})
+}
+
+// Translate the polyadic prefixes of a pifra model into monadic ones, and
+// remove its comments. Returns the model unchanged if it has neither.
+func encodePolyadic(src []byte) ([]byte, polyadicSorts) {
+	src = stripPiComments(src)
+	toks := lexPi(src)
+	fresh := freshNames{used: make(map[string]bool)}
+	for _, t := range toks {
+		fresh.used[t.text] = true
+	}
+	arities := make(map[string]map[int]bool)
+	var out strings.Builder
+	last := 0
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.typ != piTokName {
+			continue
+		}
+		output := true
+		objs, dot := matchPiPrefix(toks, i, "<", ">", true)
+		if dot < 0 {
+			output = false
+			objs, dot = matchPiPrefix(toks, i, "(", ")", false)
+		}
+		if dot < 0 {
+			continue
+		}
+		addSort(arities, t.text, len(objs))
+		if len(objs) < 2 {
+			continue
+		}
+		out.Write(src[last:t.start])
+		s := fresh.next("s")
+		if output {
+			out.WriteString(fmt.Sprintf("$%s.%s'<%s>.", s, t.text, s))
+			for _, o := range objs {
+				out.WriteString(fmt.Sprintf("%s'<%s>.", s, o))
+			}
+		} else {
+			out.WriteString(fmt.Sprintf("%s(%s).", t.text, s))
+			for _, o := range objs {
+				out.WriteString(fmt.Sprintf("%s(%s).", s, o))
+			}
+		}
+		last = toks[dot].end
+		i = dot
+	}
+	if last == 0 {
+		return src, sortsOf(arities)
+	}
+	out.Write(src[last:])
+	return []byte(out.String()), sortsOf(arities)
+}
+
+// The AST of a polyadic output, for models that are not parsed by pifra.
+func polyadicOutputElem(channel string, objs []string, session string, next pifra.Element) pifra.Element {
+	for i := len(objs) - 1; i >= 0; i-- {
+		next = &pifra.ElemOutput{Channel: pifra.Name{Name: session}, Output: pifra.Name{Name: objs[i]}, Next: next}
+	}
+	return &pifra.ElemRestriction{
+		Restrict: pifra.Name{Name: session},
+		Next:     &pifra.ElemOutput{Channel: pifra.Name{Name: channel}, Output: pifra.Name{Name: session}, Next: next},
+	}
+}
+
+// The AST of a polyadic input.
+func polyadicInputElem(channel string, objs []string, session string, next pifra.Element) pifra.Element {
+	for i := len(objs) - 1; i >= 0; i-- {
+		next = &pifra.ElemInput{Channel: pifra.Name{Name: session}, Input: pifra.Name{Name: objs[i]}, Next: next}
+	}
+	return &pifra.ElemInput{Channel: pifra.Name{Name: channel}, Input: pifra.Name{Name: session}, Next: next}
+}
+
+// Generate the LTS of a model with pifra, translating its polyadic prefixes
+// first.
+func generatePifraLts(opts pifra.Flags) (polyadicSorts, error) {
+	src, err := ioutil.ReadFile(opts.InputFile)
+	if err != nil {
+		return nil, err
+	}
+	encoded, sorts := encodePolyadic(src)
+	if len(sorts) == 0 && string(encoded) == string(src) {
+		return sorts, pifra.OutputMode(opts)
+	}
+	monoFile := opts.OutputFile + ".mono.pi"
+	if err := writeFile(monoFile, encoded); err != nil {
+		return nil, err
+	}
+	defer os.Remove(monoFile)
+	if isVerbose() && len(sorts) > 0 {
+		fmt.Printf("Translated the polyadic prefixes of %s.\n", opts.InputFile)
+	}
+	opts.InputFile = monoFile
+	return sorts, pifra.OutputMode(opts)
+}
+
+// ####
+// Printing in the polyadic form.
+// ####
+
+// A transition of the polyadic view of an LTS.
+type polyadicTransition struct {
+	Source      int
+	Destination int
+	Label       string
+}
+
+// The arity of the channel in register reg of a state, or 1 if it is monadic.
+func channelArity(lts *pifra.Lts, conf *pifra.Configuration, reg int, sorts polyadicSorts) int {
+	name := conf.Registers.Registers[reg]
+	if orig, ok := lts.FreeNamesMap[name]; ok {
+		name = orig
+	}
+	if arity, ok := sorts[name]; ok {
+		return arity
+	}
+	return 1
+}
+
+func graphObject(symbol pifra.Symbol) string {
+	return strings.TrimSpace(pifra.PrettyPrintGraphSymbol(symbol))
+}
+
+// The view of an LTS in which every session of a polyadic prefix is a single
+// transition, as in the semantics of the polyadic pi-calculus. The states in
+// the middle of a session are left out. Returns the states of the view, which
+// are those reachable from state 0, and its transitions.
+func polyadicView(lts pifra.Lts, sorts polyadicSorts) ([]int, []polyadicTransition) {
+	adj := make(map[int][]pifra.Transition)
+	for _, t := range lts.Transitions {
+		adj[t.Source] = append(adj[t.Source], t)
+	}
+	var trans []polyadicTransition
+	visited := map[int]bool{0: true}
+	queue := []int{0}
+	visit := func(id int) {
+		if !visited[id] {
+			visited[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		src := queue[0]
+		queue = queue[1:]
+		conf := lts.States[src]
+		for _, t := range adj[src] {
+			sym := t.Label.Symbol
+			if sym.Type != pifra.SymbolTypOutput && sym.Type != pifra.SymbolTypInput {
+				trans = append(trans, sessionTransition([]pifra.Transition{t}))
+				visit(t.Destination)
+				continue
+			}
+			arity := channelArity(&lts, &conf, sym.Value, sorts)
+			if arity == 1 {
+				trans = append(trans, sessionTransition([]pifra.Transition{t}))
+				visit(t.Destination)
+				continue
+			}
+			// A session channel is always fresh, so the other transitions are
+			// not possible with polyadic prefixes.
+			if !isFreshLabel(t.Label) {
+				continue
+			}
+			for _, s := range followSession(adj, t.Destination, sym.Type, t.Label.Symbol2.Value, arity) {
+				trans = append(trans, sessionTransition(append([]pifra.Transition{t}, s...)))
+				visit(s[len(s)-1].Destination)
+			}
+		}
+	}
+	var states []int
+	for id := range visited {
+		states = append(states, id)
+	}
+	sort.Ints(states)
+	return states, trans
+}
+
+// All the ways to complete a session of n messages on the channel in register
+// reg, starting in the given state, as the transitions of the messages.
+func followSession(adj map[int][]pifra.Transition, start int, typ pifra.SymbolType, reg int, n int) [][]pifra.Transition {
+	sessions := [][]pifra.Transition{nil}
+	for i := 0; i < n; i++ {
+		var next [][]pifra.Transition
+		for _, s := range sessions {
+			end := start
+			if len(s) > 0 {
+				end = s[len(s)-1].Destination
+			}
+			for _, t := range adj[end] {
+				if t.Label.Symbol.Type != typ || t.Label.Symbol.Value != reg {
+					continue
+				}
+				next = append(next, append(append([]pifra.Transition{}, s...), t))
+			}
+		}
+		sessions = next
+	}
+	return sessions
+}
+
+// The transition of the polyadic view made of the transitions of a session:
+// the transition that sends or receives the session channel, and those of the
+// messages. A single transition is kept as it is.
+func sessionTransition(session []pifra.Transition) polyadicTransition {
+	first, last := session[0], session[len(session)-1]
+	if len(session) == 1 {
+		return polyadicTransition{first.Source, last.Destination, first.Label.PrettyPrintGraph()}
+	}
+	var objs []string
+	for _, t := range session[1:] {
+		objs = append(objs, graphObject(t.Label.Symbol2))
+	}
+	return polyadicTransition{first.Source, last.Destination,
+		sessionLabel(first.Label.Symbol, strings.TrimSpace(pifra.PrettyPrintGraphSymbol(first.Label.Symbol)), objs)}
+}
+
+// The label of a session in TeX.
+func sessionTexLabel(session []pifra.Transition) string {
+	first := session[0]
+	if len(session) == 1 {
+		return pifra.PrettyPrintTexGraphLabel(first.Label)
+	}
+	var objs []string
+	for _, t := range session[1:] {
+		objs = append(objs, pifra.PrettyPrintTexGraphSymbol(t.Label.Symbol2))
+	}
+	if first.Label.Symbol.Type == pifra.SymbolTypInput {
+		return pifra.PrettyPrintTexGraphSymbol(first.Label.Symbol) + "(" + strings.Join(objs, ", ") + ")"
+	}
+	return pifra.PrettyPrintTexGraphSymbol(first.Label.Symbol) + `\langle ` + strings.Join(objs, ", ") + `
+angle`
+}
+
+// A polyadic prefix on a channel, written as an output a'<x,y> or an input
+// a(x,y).
+func sessionLabel(channel pifra.Symbol, name string, objs []string) string {
+	if channel.Type == pifra.SymbolTypInput {
+		return name + "(" + strings.Join(objs, ",") + ")"
+	}
+	return name + "<" + strings.Join(objs, ",") + ">"
+}
+
+// ####
+// Distinguishing paths in the polyadic form.
+// ####
+
+// A step of a distinguishing path in the polyadic form. Trans and Reply are the
+// transitions of a session (see sessionTransition), or of a single step. Reply
+// is empty if the step could not be matched.
+type polyadicPathStep struct {
+	IsLeft bool
+	Trans  []pifra.Transition
+	Reply  []pifra.Transition
+}
+
+// The distinguishing path of a check, in the sorts of lts1 and lts2.
+func checkPolyadicPath(state *CleavelandState) []polyadicPathStep {
+	return polyadicPath(state, distinguishingPath(state), getPolyadicSorts(true), getPolyadicSorts(false))
+}
+
+// Fold the steps of the sessions of polyadic prefixes in a distinguishing path
+// into single steps. A session that the path leaves unfinished, as a message
+// cannot be matched, is completed in the LTS that takes it, so that its step is
+// the polyadic prefix that cannot be matched.
+func polyadicPath(state *CleavelandState, path []pathStep, sortsLeft polyadicSorts, sortsRight polyadicSorts) []polyadicPathStep {
+	var res []polyadicPathStep
+	for i := 0; i < len(path); {
+		lts, sorts := state.LeftLts, sortsLeft
+		if !path[i].IsLeft {
+			lts, sorts = state.RightLts, sortsRight
+		}
+		steps, arity := sessionSteps(path[i:], lts, sorts)
+		folded := polyadicPathStep{IsLeft: path[i].IsLeft}
+		for _, step := range steps {
+			folded.Trans = append(folded.Trans, step.Trans)
+			if step.Reply != nil {
+				folded.Reply = append(folded.Reply, *step.Reply)
+			}
+		}
+		if len(folded.Reply) < len(steps) {
+			folded.Reply = nil
+		}
+		if missing := arity + 1 - len(steps); arity > 1 && missing > 0 {
+			adj := make(map[int][]pifra.Transition)
+			for _, t := range lts.Transitions {
+				adj[t.Source] = append(adj[t.Source], t)
+			}
+			first, last := folded.Trans[0], folded.Trans[len(folded.Trans)-1]
+			completions := followSession(adj, last.Destination, first.Label.Symbol.Type, first.Label.Symbol2.Value, missing)
+			// A session is always finished, as its messages are the prefixes
+			// that follow the session channel.
+			if len(completions) > 0 {
+				folded.Trans = append(folded.Trans, completions[0]...)
+			}
+		}
+		res = append(res, folded)
+		i += len(steps)
+	}
+	return res
+}
+
+// The steps at the start of the path that make up the session of a polyadic
+// prefix, and the arity of its channel, or just the first step and arity 1.
+// The steps of a whole session are taken by the same system and answered by
+// a session of the other, and only the last step of the path may leave the
+// session unfinished.
+func sessionSteps(path []pathStep, lts pifra.Lts, sorts polyadicSorts) ([]pathStep, int) {
+	first := path[0]
+	sym := first.Trans.Label.Symbol
+	if (sym.Type != pifra.SymbolTypOutput && sym.Type != pifra.SymbolTypInput) || !isFreshLabel(first.Trans.Label) {
+		return path[:1], 1
+	}
+	conf := lts.States[first.Trans.Source]
+	arity := channelArity(&lts, &conf, sym.Value, sorts)
+	if arity == 1 {
+		return path[:1], 1
+	}
+	n := 1
+	for ; n < len(path) && n <= arity; n++ {
+		prev, step := path[n-1], path[n]
+		label := step.Trans.Label
+		if prev.Reply == nil || step.IsLeft != first.IsLeft || step.Trans.Source != prev.Trans.Destination ||
+			label.Symbol.Type != sym.Type || label.Symbol.Value != first.Trans.Label.Symbol2.Value {
+			break
+		}
+		if step.Reply != nil && (step.Reply.Source != prev.Reply.Destination ||
+			step.Reply.Label.Symbol.Value != first.Reply.Label.Symbol2.Value) {
+			break
+		}
+	}
+	if n == arity+1 || (n == len(path) && path[n-1].Reply == nil) {
+		return path[:n], arity
+	}
+	return path[:1], 1
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/yungene/pifra"
+)
+
+func TestEncodePolyadic(t *testing.T) {
+	src := "P(a, b) = a'<x, y>.b(u,v).P(a,b)\n$_s1.(P(a, b) | c<_s1>.c(z).0)\n"
+	expected := "P(a, b) = $_s2.a'<_s2>._s2'<x>._s2'<y>.b(_s3)._s3(u)._s3(v).P(a,b)\n$_s1.(P(a, b) | c<_s1>.c(z).0)\n"
+	encoded, sorts := encodePolyadic([]byte(src))
+	if string(encoded) != expected {
+		t.Errorf("Encoded to %s, expected %s.", encoded, expected)
+	}
+	if fmt.Sprint(sorts) != "map[a:2 b:2]" {
+		t.Errorf("Sorts are %s.", fmt.Sprint(sorts))
+	}
+
+	monadic := "a(x).b'<x>.0\n"
+	if encoded, _ := encodePolyadic([]byte(monadic)); string(encoded) != monadic {
+		t.Errorf("A monadic model was changed to %s.", encoded)
+	}
+}
+
+func TestPolyadicBisim(t *testing.T) {
+	pwd := getPwd(t)
+	testFolder := path.Join(pwd, "test", "polyadic")
+	outFolder := t.TempDir()
+	expected := map[string]ResultType{
+		"relay": ResultRelated,
+		"swap":  ResultNotRelated,
+	}
+	for testFile, res := range expected {
+		var lts [2]pifra.Lts
+		for i := 0; i < 2; i++ {
+			opts := flags
+			opts.InputFile = path.Join(testFolder, fmt.Sprintf("%s.%d.pi", testFile, i+1))
+			opts.OutputFile = path.Join(outFolder, fmt.Sprintf("%s.%d.gob", testFile, i+1))
+			sorts, err := generatePifraLts(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sorts["a"] != 2 {
+				t.Errorf("The sort of a in %s is %d.", opts.InputFile, sorts["a"])
+			}
+			if lts[i], err = decodeLTS(opts.OutputFile); err != nil {
+				t.Fatal(err)
+			}
+			// Every input on a is a single transition in the view.
+			_, trans := polyadicView(lts[i], sorts)
+			for _, tr := range trans {
+				if strings.HasPrefix(tr.Label, "1(") && strings.Count(tr.Label, ",") != 1 {
+					t.Errorf("Transition %s of %s is not polyadic.", tr.Label, opts.InputFile)
+				}
+			}
+		}
+		weakLeft, weakRight := doWeakTransform(lts[0]), doWeakTransform(lts[1])
+		if status := checkBisim(lts[0], lts[1], weakLeft, weakRight, -1, -1, false); status != res {
+			t.Errorf("%s was %d, expected %d.", testFile, status, res)
+		}
+	}
+}
+
+// The distinguishing path of swap is printed in the polyadic form, with the
+// unmatched session completed.
+func TestPolyadicPath(t *testing.T) {
+	pwd := getPwd(t)
+	dir := t.TempDir()
+	var lts [2]pifra.Lts
+	var sorts [2]polyadicSorts
+	for i := 0; i < 2; i++ {
+		var err error
+		name := path.Join(pwd, "test", "polyadic", fmt.Sprintf("swap.%d.pi", i+1))
+		if lts[i], sorts[i], err = loadOrGenerateLts(name, path.Join(dir, fmt.Sprint(i)), flags); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rho, err := freeNamesRho(lts[0], lts[1], 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _, res, err := preorderPair(lts[0], lts[1], lts[0], lts[1], 0, 0, rho, -1, false)
+	if err != nil || res != ResultNotRelated {
+		t.Fatalf("swap was %d with the error %v.", res, err)
+	}
+	p := polyadicPath(state, distinguishingPath(state), sorts[0], sorts[1])
+	expected := "1. left 0 -1(3●,1●)-> 13, matched by right 0 -1(3●,1●)-> 13.\n" +
+		"2. left 13 -2'<3,1>-> 33, which right cannot match.\n"
+	if s := distinguishingPathToString(p); s != expected {
+		t.Errorf("The path is\n%s\nexpected\n%s", s, expected)
+	}
+	steps := toHtmlPath(p)
+	if len(steps) != 2 || steps[1].Trans.Label != "2'<3,1>" || len(steps[0].Steps) != 3 || len(steps[0].ReplySteps) != 3 {
+		t.Errorf("The counterexample is %+v.", steps)
+	}
+	if s := newPathNames(&lts[0]).session(p[0].Trans); s != "a(n1*,n2*)" {
+		t.Errorf("The first step is %s with the names of the model.", s)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with `pisim22 quotient model`, which writes a quotient of an
+// LTS by the strong or weak bisimilarity of its states, and a pi-calculus
+// process with one process identifier per class of states modulo a permutation
+// of their registers. The classes are found by the checker, with preorderPair
+// on pairs of states of the LTS.
+
+// A class of states, given by its smallest state and the rho from the
+// registers of that state to those of a state of the class.
+type quotientClass struct {
+	rep int
+	rho map[int]int
+}
+
+// The most registers for which all the bijections between the registers of two
+// states are tried.
+const maxPermutedRegisters = 6
+
+// The classes of the states of an LTS under itself. A state is checked against
+// the smallest state of each class with preorderPair, first under the identity
+// on their registers and, with permute, then under the other bijections
+// between them. With weak, weak bisimilarity is checked. The states that pifra
+// did not explore are kept in classes of their own, and so are the states that
+// are only related through them.
+func checkedClasses(lts pifra.Lts, weak bool, permute bool) map[int]quotientClass {
+	weakLts := lts
+	if weak {
+		weakLts = doWeakTransform(lts)
+	}
+	adj := ToAdjacency(weakLts)
+	n := getMaxMinRegSize(lts)
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	classes := make(map[int]quotientClass, len(ids))
+	sigs := make(map[int]string, len(ids))
+	var reps []int
+	for _, id := range ids {
+		sigs[id] = stateSignature(lts, id, adj[id], permute)
+		for _, rep := range reps {
+			if sigs[rep] != sigs[id] {
+				continue
+			}
+			if rho, ok := relateStates(lts, weakLts, rep, id, n, permute); ok {
+				classes[id] = quotientClass{rep, rho}
+				break
+			}
+		}
+		if _, ok := classes[id]; !ok {
+			reps = append(reps, id)
+			classes[id] = quotientClass{id, identityRho(lts, id)}
+		}
+	}
+	return classes
+}
+
+// A key that two states have if they can be bisimilar. Without permute, it has
+// their registers and the labels of their transitions, and with permute only
+// the number of their registers and the types of the labels.
+func stateSignature(lts pifra.Lts, id int, transitions []pifra.Transition, permute bool) string {
+	regs := sortedRegisters(lts.States[id].Registers)
+	key := fmt.Sprint(regs)
+	if permute {
+		key = fmt.Sprint(len(regs))
+	}
+	if isUnexploredState(lts, id) {
+		return fmt.Sprintf("%s|%d", key, id)
+	}
+	seen := make(map[string]bool)
+	var labels []string
+	for _, t := range transitions {
+		label := labelKey(t.Label)
+		if permute && label != "tau" {
+			label = fmt.Sprintf("%v %v", t.Label.Symbol.Type, t.Label.Symbol2.Type)
+		}
+		if !seen[label] {
+			labels = append(labels, label)
+			seen[label] = true
+		}
+	}
+	sort.Strings(labels)
+	return key + "|" + strings.Join(labels, ",")
+}
+
+// Check two states of an LTS under the rhos of candidateRhos, and return the
+// first one under which they are bisimilar. A relation that pairs an
+// unexplored state with another state, or with itself under another rho, is
+// not taken.
+func relateStates(lts pifra.Lts, weakLts pifra.Lts, rep int, id int, n int, permute bool) (map[int]int, bool) {
+	for _, rho := range candidateRhos(lts, rep, id, permute) {
+		state, _, res, err := preorderPair(lts, lts, weakLts, weakLts, rep, id, rho, n, false)
+		check(err)
+		if res != ResultRelated {
+			continue
+		}
+		unexplored := false
+		for _, p := range state.relatedPairs() {
+			if (isUnexploredState(lts, p.Left) || isUnexploredState(lts, p.Right)) &&
+				(p.Left != p.Right || !isIdentity(p.Rho)) {
+				unexplored = true
+				break
+			}
+		}
+		if !unexplored {
+			return rho, true
+		}
+	}
+	return nil, false
+}
+
+// The rhos from the registers of rep to those of id: the identity if they have
+// the same registers and, with permute, the other bijections between them.
+func candidateRhos(lts pifra.Lts, rep int, id int, permute bool) []map[int]int {
+	from := sortedRegisters(lts.States[rep].Registers)
+	to := sortedRegisters(lts.States[id].Registers)
+	if len(from) != len(to) {
+		return nil
+	}
+	var rhos []map[int]int
+	if fmt.Sprint(from) == fmt.Sprint(to) {
+		rhos = append(rhos, identityRho(lts, rep))
+	}
+	if !permute || len(from) > maxPermutedRegisters {
+		return rhos
+	}
+	for _, perm := range generatePermutations(to) {
+		rho := make(map[int]int, len(from))
+		for i, reg := range from {
+			rho[reg] = perm[i]
+		}
+		if !isIdentity(rho) {
+			rhos = append(rhos, rho)
+		}
+	}
+	return rhos
+}
+
+func identityRho(lts pifra.Lts, id int) map[int]int {
+	rho := make(map[int]int)
+	for reg := range lts.States[id].Registers.Registers {
+		rho[reg] = reg
+	}
+	return rho
+}
+
+func isIdentity(rho map[int]int) bool {
+	for k, v := range rho {
+		if k != v {
+			return false
+		}
+	}
+	return true
+}
+
+// The classes of checkedClasses under the identity on the registers, numbered
+// by their smallest state, so that state 0 is in class 0. Labels refer to the
+// registers of their source, so the states of a class in an LTS have to be
+// bisimilar under the identity.
+func ltsClasses(lts pifra.Lts, weak bool) (map[int]int, int) {
+	classes := checkedClasses(lts, weak, false)
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	class := make(map[int]int, len(ids))
+	number := make(map[int]int)
+	for _, id := range ids {
+		rep := classes[id].rep
+		if _, ok := number[rep]; !ok {
+			number[rep] = len(number)
+		}
+		class[id] = number[rep]
+	}
+	return class, len(number)
+}
+
+func isUnexploredState(lts pifra.Lts, id int) bool {
+	return lts.StatesExplored < len(lts.States) && id >= lts.StatesExplored
+}
+
+// The tau transitions of pifra and of the weak transform differ in the second
+// symbol.
+func labelKey(label pifra.Label) string {
+	if label.Symbol.Type == pifra.SymbolTypTau {
+		return "tau"
+	}
+	return fmt.Sprint(label)
+}
+
+// The quotient of an LTS by the classes of ltsClasses. A class has the
+// configuration of its smallest state and the transitions of all its states.
+// With weak, the tau transitions within a class are dropped.
+func quotientLts(lts pifra.Lts, weak bool) pifra.Lts {
+	class, n := ltsClasses(lts, weak)
+	q := pifra.Lts{
+		States:          make(map[int]pifra.Configuration, n),
+		RegSizeReached:  make(map[int]bool),
+		StatesGenerated: n,
+		FreeNamesMap:    lts.FreeNamesMap,
+	}
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		c := class[id]
+		if _, ok := q.States[c]; ok {
+			continue
+		}
+		q.States[c] = lts.States[id]
+		if lts.RegSizeReached[id] {
+			q.RegSizeReached[c] = true
+		}
+		if !isUnexploredState(lts, id) {
+			q.StatesExplored++
+		}
+	}
+	visited := make(map[string]bool)
+	for _, t := range lts.Transitions {
+		newTrans := pifra.Transition{
+			Source:      class[t.Source],
+			Destination: class[t.Destination],
+			Label:       t.Label,
+		}
+		if weak && t.Label.Symbol.Type == pifra.SymbolTypTau && newTrans.Source == newTrans.Destination {
+			continue
+		}
+		key := fmt.Sprint(newTrans)
+		if !visited[key] {
+			q.Transitions = append(q.Transitions, newTrans)
+			visited[key] = true
+		}
+	}
+	return q
+}
+
+// The sizes of the quotient q of an LTS, as it is written. The transitions are
+// those of q, not of its weak transform.
+func quotientSummary(name string, lts pifra.Lts, q pifra.Lts) string {
+	return fmt.Sprintf("The %s of %s are in %s, with %s between them.", plural(len(lts.States), "state"),
+		name, plural(len(q.States), "class"), plural(len(q.Transitions), "transition"))
+}
+
+// ####
+// The pi-calculus process of a quotient.
+// ####
+
+// The states are in the classes of checkedClasses modulo a permutation of their
+// registers, and the process of class k is Pk, with a parameter rx for each
+// register x of state k. A call of a state of the class passes its names in the
+// order given by the rho of the state. A tau transition is a communication on a restricted name, and a bound
+// output is the output of a restricted name. The inputs on a channel are
+// matched against the names in the registers, so that an input prefix goes to
+// the class of each name that is received. The process cannot be given if a
+// transition uses a register that its state does not have, if a name is not
+// received by all the inputs on a channel, or if the LTS was not fully
+// explored.
+func generateQuotientProcess(lts pifra.Lts, weak bool) ([]byte, error) {
+	if lts.StatesExplored < len(lts.States) {
+		return nil, fmt.Errorf("the LTS was not fully explored")
+	}
+	classes := checkedClasses(lts, weak, true)
+	adj := ToAdjacency(lts)
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		if classes[id].rep != id {
+			continue
+		}
+		body, err := quotientProcessBody(lts, classes, id, adj[id])
+		if err != nil {
+			return nil, fmt.Errorf("state %d: %s", id, err.Error())
+		}
+		params := quotientProcessParams(lts, id)
+		if len(params) == 0 {
+			fmt.Fprintf(&buf, "P%d = %s\n", id, body)
+		} else {
+			fmt.Fprintf(&buf, "P%d(%s) = %s\n", id, strings.Join(params, ", "), body)
+		}
+	}
+	// The initial process takes the free names of the model.
+	var names []string
+	for _, reg := range sortedRegisters(lts.States[0].Registers) {
+		name := lts.States[0].Registers.Registers[reg]
+		if orig, ok := lts.FreeNamesMap[name]; ok {
+			name = orig
+		}
+		names = append(names, name)
+	}
+	buf.WriteString(quotientProcessCall(0, names) + "\n")
+	return buf.Bytes(), nil
+}
+
+func sortedRegisters(regs pifra.Registers) []int {
+	var res []int
+	for reg := range regs.Registers {
+		res = append(res, reg)
+	}
+	sort.Ints(res)
+	return res
+}
+
+func quotientProcessParams(lts pifra.Lts, id int) []string {
+	var params []string
+	for _, reg := range sortedRegisters(lts.States[id].Registers) {
+		params = append(params, fmt.Sprintf("r%d", reg))
+	}
+	return params
+}
+
+func quotientProcessCall(id int, args []string) string {
+	if len(args) == 0 {
+		return fmt.Sprintf("P%d", id)
+	}
+	return fmt.Sprintf("P%d(%s)", id, strings.Join(args, ", "))
+}
+
+// The call of the process of the destination of a transition, in the names of
+// the source. A fresh name of the label is the given bound name.
+func quotientProcessNext(lts pifra.Lts, classes map[int]quotientClass, trans pifra.Transition,
+	bound string) (string, error) {
+	src := lts.States[trans.Source].Registers.Registers
+	names := make(map[int]string)
+	for _, reg := range sortedRegisters(lts.States[trans.Destination].Registers) {
+		switch {
+		case isFreshLabel(trans.Label) && trans.Label.Symbol2.Value == reg:
+			names[reg] = bound
+		case src[reg] != "":
+			names[reg] = fmt.Sprintf("r%d", reg)
+		default:
+			return "", fmt.Errorf("state %d has no name for register %d of state %d",
+				trans.Source, reg, trans.Destination)
+		}
+	}
+	class := classes[trans.Destination]
+	var args []string
+	for _, reg := range sortedRegisters(lts.States[class.rep].Registers) {
+		args = append(args, names[class.rho[reg]])
+	}
+	return quotientProcessCall(class.rep, args), nil
+}
+
+func quotientProcessBody(lts pifra.Lts, classes map[int]quotientClass, id int,
+	transitions []pifra.Transition) (string, error) {
+	regs := lts.States[id].Registers.Registers
+	var terms []string
+	// The input transitions by their channel, and by the register of the name
+	// they receive, with 0 for a fresh name.
+	inputs := make(map[int]map[int][]pifra.Transition)
+	for _, trans := range transitions {
+		l := trans.Label
+		if l.Symbol.Type != pifra.SymbolTypTau {
+			if regs[l.Symbol.Value] == "" {
+				return "", fmt.Errorf("there is no register %d", l.Symbol.Value)
+			}
+			if l.Symbol2.Type == pifra.SymbolTypKnown && regs[l.Symbol2.Value] == "" {
+				return "", fmt.Errorf("there is no register %d", l.Symbol2.Value)
+			}
+		}
+		switch {
+		case l.Symbol.Type == pifra.SymbolTypTau:
+			next, err := quotientProcessNext(lts, classes, trans, "")
+			if err != nil {
+				return "", err
+			}
+			terms = append(terms, fmt.Sprintf("$t.(t'<t>.0 | t(y).%s)", next))
+		case l.Symbol.Type == pifra.SymbolTypOutput && l.Symbol2.Type == pifra.SymbolTypFreshOutput:
+			next, err := quotientProcessNext(lts, classes, trans, "n")
+			if err != nil {
+				return "", err
+			}
+			terms = append(terms, fmt.Sprintf("$n.r%d'<n>.%s", l.Symbol.Value, next))
+		case l.Symbol.Type == pifra.SymbolTypOutput:
+			next, err := quotientProcessNext(lts, classes, trans, "")
+			if err != nil {
+				return "", err
+			}
+			terms = append(terms, fmt.Sprintf("r%d'<r%d>.%s", l.Symbol.Value, l.Symbol2.Value, next))
+		case l.Symbol.Type == pifra.SymbolTypInput:
+			if inputs[l.Symbol.Value] == nil {
+				inputs[l.Symbol.Value] = make(map[int][]pifra.Transition)
+			}
+			reg := 0
+			if l.Symbol2.Type == pifra.SymbolTypKnown {
+				reg = l.Symbol2.Value
+			}
+			inputs[l.Symbol.Value][reg] = append(inputs[l.Symbol.Value][reg], trans)
+		}
+	}
+
+	var channels []int
+	for channel := range inputs {
+		channels = append(channels, channel)
+	}
+	sort.Ints(channels)
+	for _, channel := range channels {
+		byName := inputs[channel]
+		// Each name has to be received, as an input prefix receives them all.
+		names := append([]int{0}, sortedRegisters(lts.States[id].Registers)...)
+		prefixes := 0
+		for _, reg := range names {
+			if len(byName[reg]) == 0 {
+				if reg == 0 {
+					return "", fmt.Errorf("the inputs on register %d do not receive a fresh name", channel)
+				}
+				return "", fmt.Errorf("the inputs on register %d do not receive the name of register %d", channel, reg)
+			}
+			prefixes = maxInt(prefixes, len(byName[reg]))
+		}
+		// The i-th prefix goes to the i-th destination of each name, or to
+		// its last one. If all the names go to the same process, there is no
+		// need to match them. A match takes the rest of a sum, so the branches
+		// are in parentheses.
+		for i := 0; i < prefixes; i++ {
+			var branches []string
+			var fresh string
+			same := true
+			for _, reg := range names {
+				trans := byName[reg][minInt(i, len(byName[reg])-1)]
+				next, err := quotientProcessNext(lts, classes, trans, "y")
+				if err != nil {
+					return "", err
+				}
+				if reg == 0 {
+					fresh = next
+					continue
+				}
+				same = same && next == fresh
+				branches = append(branches, fmt.Sprintf("([y=r%d]%s)", reg, next))
+			}
+			if same {
+				terms = append(terms, fmt.Sprintf("r%d(y).%s", channel, fresh))
+				continue
+			}
+			var mismatches string
+			for _, reg := range names[1:] {
+				mismatches += fmt.Sprintf("[y!=r%d]", reg)
+			}
+			branches = append(branches, "("+mismatches+fresh+")")
+			terms = append(terms, fmt.Sprintf("r%d(y).(%s)", channel, strings.Join(branches, " + ")))
+		}
+	}
+	switch len(terms) {
+	case 0:
+		return "0", nil
+	case 1:
+		return terms[0], nil
+	}
+	for i := range terms {
+		terms[i] = "(" + terms[i] + ")"
+	}
+	return strings.Join(terms, " + "), nil
+}
+
+// The quotient command, which is also the minimize command: the classes of the
+// LTS are the largest under the identity on the registers, so the quotient is
+// the smallest LTS of the states of pifra.
+func quotientCommand(name string, args []string) {
+	fs := newCommandFlagSet(name, "[flags] model",
+		"Write a quotient of the LTS of a model or of an LTS file by the bisimilarity of its states under\n"+
+			"the identity on their registers, and a pi-calculus process with one process identifier per class\n"+
+			"of states modulo a permutation of their registers. The classes are found by the checker.")
+	outFlag := fs.String("o", "", "A path to write the quotient LTS to. The format is given by the extension: "+ltsFormats()+".")
+	piFlag := fs.String("pi", "", "A path to write the pi-calculus process of the quotient to.")
+	weakFlag := fs.Bool("w", false, "Whether to use weak bisimilarity.")
+	verboseFlag := fs.Bool("v", false, "Whether to be verbose.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if len(args) != 1 {
+		usageError(fs, fmt.Errorf("expected one model or LTS file as argument, not %d", len(args)))
+	}
+	if *outFlag == "" && *piFlag == "" {
+		usageError(fs, fmt.Errorf("at least one of -o and -pi is required"))
+	}
+	if *outFlag != "" {
+		if err := checkLtsFormat(*outFlag); err != nil {
+			usageError(fs, err)
+		}
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+	verbose = *verboseFlag
+
+	dir, err := ioutil.TempDir("", "pisim22-"+name)
+	check(err)
+	defer os.RemoveAll(dir)
+	lts, sorts, err := loadOrGenerateLts(args[0], dir, pf.flags())
+	check(err)
+	q := quotientLts(lts, *weakFlag)
+	fmt.Println(quotientSummary(args[0], lts, q))
+	if *outFlag != "" {
+		check(writeLtsFile(*outFlag, q, sorts))
+	}
+	if *piFlag != "" {
+		src, err := generateQuotientProcess(q, *weakFlag)
+		if err != nil {
+			check(fmt.Errorf("cannot give a pi-calculus process of the quotient: %s", err.Error()))
+		}
+		check(writeFile(*piFlag, src))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+)
+
+// The quotients are bisimilar to their LTSs, and so are the LTSs of their
+// processes.
+func TestQuotient(t *testing.T) {
+	cases := []struct {
+		src     string
+		weak    bool
+		classes int
+		process string
+	}{
+		{"P(a) = a'<a>.Q(a)\nQ(a) = a'<a>.P(a)\nP(a)\n", false, 1, "P0(r1) = r1'<r1>.P0(r1)\nP0(a)\n"},
+		// Without garbage collection, the name that was output stays in a
+		// register.
+		{"P(a) = $n.a'<n>.P(a)\nP(a)\n", false, 2,
+			"P0(r1) = $n.r1'<n>.P1(r1, n)\nP1(r1, r2) = $n.r1'<n>.P1(r1, n)\nP0(a)\n"},
+		// The input of a and of a fresh name go to different states, so the
+		// name that is received is matched.
+		{"P(a) = a(x).$c.(c'<c>.0 | c(y).P(a))\nP(a)\n", false, 4, ""},
+		{"P(a) = a(x).$c.(c'<c>.0 | c(y).P(a))\nP(a)\n", true, 2, ""},
+		{"P(a, b) = a(x).(x'<b>.P(a, b) + b'<b>.0)\nP(a, b)\n", false, 0, ""},
+		{"P(a, b) = a(x).(x'<b>.P(a, b) + b'<b>.0)\nP(a, b)\n", true, 0, ""},
+		// The states that differ by a swap of their registers are in
+		// different classes, but have the same process.
+		{swapModel, false, 10, ""},
+	}
+	for _, c := range cases {
+		lts := analyzeTestLts(t, c.src, 1000)
+		q := quotientLts(lts, c.weak)
+		if c.classes > 0 && len(q.States) != c.classes {
+			t.Errorf("The quotient of %q has %d states, expected %d.", c.src, len(q.States), c.classes)
+		}
+		weakLts, weakQ := lts, q
+		if c.weak {
+			weakLts, weakQ = doWeakTransform(lts), doWeakTransform(q)
+		}
+		if status := checkBisim(lts, q, weakLts, weakQ, -1, -1, false); status != ResultRelated {
+			t.Errorf("The quotient of %q is not bisimilar to its LTS.", c.src)
+		}
+
+		src, err := generateQuotientProcess(q, c.weak)
+		if err != nil {
+			t.Errorf("The quotient of %q has no process: %s.", c.src, err.Error())
+			continue
+		}
+		if c.process != "" && string(src) != c.process {
+			t.Errorf("The process of %q is\n%s, expected\n%s.", c.src, src, c.process)
+		}
+		regenerated := analyzeTestLts(t, string(src), 1000)
+		weakRegenerated := regenerated
+		if c.weak {
+			weakRegenerated = doWeakTransform(regenerated)
+		}
+		if status := checkBisim(lts, regenerated, weakLts, weakRegenerated, -1, -1, false); status != ResultRelated {
+			t.Errorf("The process of the quotient of %q is not bisimilar to it:\n%s", c.src, src)
+		}
+	}
+}
+
+const swapModel = "R(x, y) = x'<y>.0\nP(a, b) = a(x).b(y).R(x, y) + b(y).a(x).R(x, y)\nP(a, b)\n"
+
+// States 7 and 8 of the model output 2<1> and 2<2>, which states 6 and 5
+// output as 1<2> and 1<1>.
+func TestQuotientRegisterSwap(t *testing.T) {
+	lts := analyzeTestLts(t, swapModel, 1000)
+	classes := checkedClasses(lts, false, true)
+	swap := map[int]int{1: 2, 2: 1}
+	for id, rep := range map[int]int{7: 6, 8: 5} {
+		if c := classes[id]; c.rep != rep || fmt.Sprint(c.rho) != fmt.Sprint(swap) {
+			t.Errorf("State %d is in the class of %d under %v, expected %d under %v.", id, c.rep, c.rho, rep, swap)
+		}
+	}
+	src, err := generateQuotientProcess(quotientLts(lts, false), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range []string{"P6(r2, r1)", "P5(r2, r1)"} {
+		if !strings.Contains(string(src), call) {
+			t.Errorf("The process does not call %s:\n%s", call, src)
+		}
+	}
+	for _, def := range []string{"P7(", "P8("} {
+		if strings.Contains(string(src), def) {
+			t.Errorf("The process has %s:\n%s", def, src)
+		}
+	}
+}
+
+func TestQuotientProcessErrors(t *testing.T) {
+	lts := analyzeTestLts(t, "P(a) = $c.a'<c>.(P(a) | P(c))\nP(a)\n", 10)
+	if _, err := generateQuotientProcess(quotientLts(lts, false), false); err == nil ||
+		!strings.Contains(err.Error(), "not fully explored") {
+		t.Errorf("The error of an LTS that is cut is %v.", err)
+	}
+}
+
+// The summary of a weak quotient counts the transitions that are written, not
+// those of the weak transform.
+func TestQuotientSummary(t *testing.T) {
+	lts := analyzeTestLts(t, "P(a) = a(x).$c.(c'<c>.0 | c(y).P(a))\nP(a)\n", 1000)
+	q := quotientLts(lts, true)
+	name := path.Join(t.TempDir(), "q"+jsonExt)
+	if err := writeLtsFile(name, q, nil); err != nil {
+		t.Fatal(err)
+	}
+	written, err := loadLTS(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saturated := len(doWeakTransform(q).Transitions); saturated == len(written.Transitions) {
+		t.Fatalf("The weak transform of the quotient has as many transitions as it, %d.", saturated)
+	}
+	expected := fmt.Sprintf("The %d states of model.pi are in 2 classes, with %d transitions between them.",
+		len(lts.States), len(written.Transitions))
+	if s := quotientSummary("model.pi", lts, q); s != expected {
+		t.Errorf("The summary is %q, expected %q.", s, expected)
+	}
+}
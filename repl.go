@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the REPL, `pisim22 repl`, for exploring the LTSs of one
+// or two models and stepping through a check. The states of a system can be
+// listed and followed along their transitions, and preorder can be run on any
+// pair of states. The pairs that the last preorder put in G or in notR can then
+// be listed, together with the reason why they are (not) related.
+
+// A session of the REPL. With a single model, both systems are its LTS.
+type replSession struct {
+	ltss     [2]pifra.Lts
+	weakLtss [2]pifra.Lts
+	names    [2]string
+	sorts    [2]polyadicSorts
+	// The system that the commands apply to, 0 for lts1 and 1 for lts2, and the
+	// current state and the states visited before it in each system.
+	sys     int
+	current [2]int
+	history [2][]int
+	weak    bool
+	hasWeak bool
+
+	regSizeOverride int
+	// The state of the last preorder, and its pair and verdict.
+	state   *CleavelandState
+	res     ResultType
+	started relatedPair
+}
+
+type replAction struct {
+	name    string
+	args    string
+	summary string
+	run     func(r *replSession, w io.Writer, args []string) error
+}
+
+func getReplActions() []replAction {
+	return []replAction{
+		{"help", "", "List the commands.", (*replSession).help},
+		{"sys", "[1|2]", "Show or select the system that the commands apply to.", (*replSession).selectSystem},
+		{"states", "", "List the states of the system.", (*replSession).listStates},
+		{"state", "[id]", "Show the current state, or the state id.", (*replSession).showState},
+		{"regs", "[id]", "Show the registers of the current state, or of the state id.", (*replSession).showRegisters},
+		{"trans", "[id]", "List the transitions of the current state, or of the state id.", (*replSession).listTransitions},
+		{"goto", "id", "Make id the current state.", (*replSession).gotoState},
+		{"follow", "k", "Follow the k-th transition listed by trans.", (*replSession).follow},
+		{"back", "", "Go back to the state before the last goto or follow.", (*replSession).back},
+		{"weak", "[on|off]", "Apply the weak transform, or go back to the LTSs. Applies to trans, follow and preorder.", (*replSession).setWeak},
+		{"preorder", "p q [rho]", "Check whether state p of lts1 and state q of lts2 are related, e.g. preorder 0 0 1=1,2=3. By default rho relates the free names.", (*replSession).runPreorder},
+		{"g", "", "List the pairs in G after the last preorder.", (*replSession).listG},
+		{"notr", "", "List the pairs in notR after the last preorder.", (*replSession).listNotR},
+		{"why", "p q", "Explain why the pairs of the states p and q are (not) related.", (*replSession).why},
+		{"path", "", "Show the distinguishing path of the last preorder.", (*replSession).showPath},
+		{"quit", "", "Leave the REPL.", nil},
+	}
+}
+
+// The repl command.
+func replCommand(args []string) {
+	fs := newCommandFlagSet("repl", "[flags] model [model]",
+		"Explore the LTSs of one or two models or LTS files, and step through a check. With one model, both systems are its LTS.")
+	regSizeOverrideFlag := fs.Int("n", -1, "The override for the size of the register.")
+	weakBisimFlag := fs.Bool("w", false, "Whether to start with the weak transform applied.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if len(args) != 1 && len(args) != 2 {
+		usageError(fs, fmt.Errorf("expected one or two models as arguments, not %d", len(args)))
+	}
+	if err := validateRegSize(*regSizeOverrideFlag); err != nil {
+		usageError(fs, err)
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+
+	dir, err := ioutil.TempDir("", "pisim22-repl")
+	check(err)
+	defer os.RemoveAll(dir)
+	flags := pf.flags()
+	var ltss []pifra.Lts
+	var sorts []polyadicSorts
+	for i, name := range args {
+		lts, s, err := loadOrGenerateLts(name, filepath.Join(dir, strconv.Itoa(i)), flags)
+		check(err)
+		ltss = append(ltss, lts)
+		sorts = append(sorts, s)
+	}
+	if len(args) == 1 {
+		ltss = append(ltss, ltss[0])
+		sorts = append(sorts, sorts[0])
+		args = append(args, args[0])
+	}
+	r := newReplSession(ltss[0], ltss[1], *regSizeOverrideFlag)
+	r.names = [2]string{args[0], args[1]}
+	r.sorts = [2]polyadicSorts{sorts[0], sorts[1]}
+	if *weakBisimFlag {
+		check(r.setWeak(os.Stdout, []string{"on"}))
+	}
+	r.loop(os.Stdin, os.Stdout)
+}
+
+func newReplSession(left pifra.Lts, right pifra.Lts, regSizeOverride int) *replSession {
+	return &replSession{
+		ltss:            [2]pifra.Lts{left, right},
+		names:           [2]string{"lts1", "lts2"},
+		regSizeOverride: regSizeOverride,
+	}
+}
+
+// Read the commands from in until it ends or quit is given.
+func (r *replSession) loop(in io.Reader, w io.Writer) {
+	fmt.Fprintf(w, "lts1 is %s (%d states), lts2 is %s (%d states). Type help for the commands.\n",
+		r.names[0], len(r.ltss[0].States), r.names[1], len(r.ltss[1].States))
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(w, "lts%d:%d> ", r.sys+1, r.current[r.sys])
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			return
+		}
+		if r.exec(w, scanner.Text()) {
+			return
+		}
+	}
+}
+
+// Run a line of the REPL. Returns whether the REPL should end.
+func (r *replSession) exec(w io.Writer, line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	if fields[0] == "quit" || fields[0] == "exit" {
+		return true
+	}
+	for _, cmd := range getReplActions() {
+		if cmd.name == fields[0] {
+			if err := cmd.run(r, w, fields[1:]); err != nil {
+				fmt.Fprintf(w, "error: %s\n", err.Error())
+			}
+			return false
+		}
+	}
+	fmt.Fprintf(w, "error: unknown command %q, type help for the commands\n", fields[0])
+	return false
+}
+
+// ####
+// Arguments.
+// ####
+
+// The LTS that the commands apply to, with the weak transform if it is applied.
+func (r *replSession) lts() pifra.Lts {
+	if r.weak {
+		return r.weakLtss[r.sys]
+	}
+	return r.ltss[r.sys]
+}
+
+func parseStateId(lts pifra.Lts, arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a state", arg)
+	}
+	if _, ok := lts.States[id]; !ok {
+		return 0, fmt.Errorf("there is no state %d", id)
+	}
+	return id, nil
+}
+
+// The state given as the only argument, or the current state.
+func (r *replSession) stateArg(args []string) (int, error) {
+	switch len(args) {
+	case 0:
+		return r.current[r.sys], nil
+	case 1:
+		return parseStateId(r.ltss[r.sys], args[0])
+	}
+	return 0, fmt.Errorf("expected at most one state, not %d arguments", len(args))
+}
+
+// Parse a rho written as e.g. 1=2,3=1, or {} for the empty rho.
+func parseRho(s string) (map[int]int, error) {
+	rho := make(map[int]int)
+	s = strings.Trim(s, "{}")
+	if s == "" {
+		return rho, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.Split(part, "=")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q is not of the form i=j", part)
+		}
+		k, err1 := strconv.Atoi(kv[0])
+		v, err2 := strconv.Atoi(kv[1])
+		if err1 != nil || err2 != nil || k <= 0 || v <= 0 {
+			return nil, fmt.Errorf("%q does not relate two registers", part)
+		}
+		if _, ok := rho[k]; ok {
+			return nil, fmt.Errorf("register %d is related twice", k)
+		}
+		rho[k] = v
+	}
+	if _, err := reverseMap(rho); err != nil {
+		return nil, fmt.Errorf("rho %s is not injective", rhoToString(rho))
+	}
+	return rho, nil
+}
+
+// ####
+// Exploring the LTSs.
+// ####
+
+func (r *replSession) help(w io.Writer, args []string) error {
+	for _, cmd := range getReplActions() {
+		fmt.Fprintf(w, "  %-20s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.summary)
+	}
+	return nil
+}
+
+func (r *replSession) selectSystem(w io.Writer, args []string) error {
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || (n != 1 && n != 2) {
+			return fmt.Errorf("the system is 1 or 2, not %q", args[0])
+		}
+		r.sys = n - 1
+	}
+	fmt.Fprintf(w, "lts%d is %s, at state %d.\n", r.sys+1, r.names[r.sys], r.current[r.sys])
+	return nil
+}
+
+func (r *replSession) listStates(w io.Writer, args []string) error {
+	lts := r.ltss[r.sys]
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		conf := lts.States[id]
+		fmt.Fprintf(w, "%4d  %s %s\n", id, pifra.PrettyPrintRegister(conf.Registers), pifra.PrettyPrintAst(conf.Process))
+	}
+	return nil
+}
+
+func (r *replSession) showState(w io.Writer, args []string) error {
+	id, err := r.stateArg(args)
+	if err != nil {
+		return err
+	}
+	conf := r.ltss[r.sys].States[id]
+	fmt.Fprintf(w, "State %d of lts%d.\n", id, r.sys+1)
+	fmt.Fprintf(w, "  Registers: %s\n", pifra.PrettyPrintRegister(conf.Registers))
+	fmt.Fprintf(w, "  Process:   %s\n", pifra.PrettyPrintAst(conf.Process))
+	return nil
+}
+
+func (r *replSession) showRegisters(w io.Writer, args []string) error {
+	id, err := r.stateArg(args)
+	if err != nil {
+		return err
+	}
+	lts := r.ltss[r.sys]
+	regs := lts.States[id].Registers
+	var idxs []int
+	for i := range regs.Registers {
+		idxs = append(idxs, i)
+	}
+	sort.Ints(idxs)
+	if len(idxs) == 0 {
+		fmt.Fprintf(w, "All the registers of state %d are empty.\n", id)
+	}
+	for _, i := range idxs {
+		name := regs.Registers[i]
+		if orig, ok := lts.FreeNamesMap[name]; ok {
+			fmt.Fprintf(w, "  %d = %s (the free name %s)\n", i, name, orig)
+		} else {
+			fmt.Fprintf(w, "  %d = %s\n", i, name)
+		}
+	}
+	return nil
+}
+
+// The transitions of a state, in the order of the LTS. In weak mode, the
+// transitions added by the weak transform follow.
+func (r *replSession) transitions(id int) []pifra.Transition {
+	var res []pifra.Transition
+	for _, trans := range r.lts().Transitions {
+		if trans.Source == id {
+			res = append(res, trans)
+		}
+	}
+	return res
+}
+
+func (r *replSession) listTransitions(w io.Writer, args []string) error {
+	id, err := r.stateArg(args)
+	if err != nil {
+		return err
+	}
+	strong := make(map[string]bool)
+	for _, trans := range r.ltss[r.sys].Transitions {
+		strong[fmt.Sprint(trans)] = true
+	}
+	trans := r.transitions(id)
+	if len(trans) == 0 {
+		fmt.Fprintf(w, "State %d has no transitions.\n", id)
+	}
+	for k, t := range trans {
+		fmt.Fprintf(w, "  [%d] %d -%s-> %d", k, t.Source, t.Label.PrettyPrintGraph(), t.Destination)
+		if !strong[fmt.Sprint(t)] {
+			fmt.Fprintf(w, " (weak)")
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+func (r *replSession) moveTo(w io.Writer, id int) {
+	r.history[r.sys] = append(r.history[r.sys], r.current[r.sys])
+	r.current[r.sys] = id
+	conf := r.ltss[r.sys].States[id]
+	fmt.Fprintf(w, "At state %d: %s %s\n", id, pifra.PrettyPrintRegister(conf.Registers), pifra.PrettyPrintAst(conf.Process))
+}
+
+func (r *replSession) gotoState(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a state")
+	}
+	id, err := parseStateId(r.ltss[r.sys], args[0])
+	if err != nil {
+		return err
+	}
+	r.moveTo(w, id)
+	return nil
+}
+
+func (r *replSession) follow(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected the number of a transition")
+	}
+	trans := r.transitions(r.current[r.sys])
+	k, err := strconv.Atoi(args[0])
+	if err != nil || k < 0 || k >= len(trans) {
+		return fmt.Errorf("there is no transition [%s] from state %d", args[0], r.current[r.sys])
+	}
+	fmt.Fprintf(w, "Following %d -%s-> %d.\n", trans[k].Source, trans[k].Label.PrettyPrintGraph(), trans[k].Destination)
+	r.moveTo(w, trans[k].Destination)
+	return nil
+}
+
+func (r *replSession) back(w io.Writer, args []string) error {
+	h := r.history[r.sys]
+	if len(h) == 0 {
+		return fmt.Errorf("there is no state to go back to")
+	}
+	r.current[r.sys] = h[len(h)-1]
+	r.history[r.sys] = h[:len(h)-1]
+	fmt.Fprintf(w, "Back at state %d.\n", r.current[r.sys])
+	return nil
+}
+
+func (r *replSession) setWeak(w io.Writer, args []string) error {
+	on := !r.weak
+	if len(args) > 0 {
+		switch args[0] {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			return fmt.Errorf("expected on or off, not %q", args[0])
+		}
+	}
+	if on && !r.hasWeak {
+		for i, lts := range r.ltss {
+			r.weakLtss[i] = doWeakTransform(lts)
+			fmt.Fprintf(w, "lts%d. Originally there were %d states and %d transitions. With weak tranform there are now %d states and %d transitions.\n",
+				i+1, len(lts.States), len(lts.Transitions), len(r.weakLtss[i].States), len(r.weakLtss[i].Transitions))
+		}
+		r.hasWeak = true
+	}
+	r.weak = on
+	weakBisim = on
+	if on {
+		fmt.Fprintf(w, "The weak transform is applied, preorder checks weak bisimulation.\n")
+	} else {
+		fmt.Fprintf(w, "The weak transform is not applied, preorder checks strong bisimulation.\n")
+	}
+	return nil
+}
+
+// ####
+// Stepping through a check.
+// ####
+
+func (r *replSession) runPreorder(w io.Writer, args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return fmt.Errorf("expected two states and optionally a rho")
+	}
+	left, right := r.ltss[0], r.ltss[1]
+	p, err := parseStateId(left, args[0])
+	if err != nil {
+		return err
+	}
+	q, err := parseStateId(right, args[1])
+	if err != nil {
+		return err
+	}
+	var rho map[int]int
+	if len(args) == 3 {
+		rho, err = parseRho(args[2])
+	} else {
+		rho, err = freeNamesRho(left, right, p, q)
+	}
+	if err != nil {
+		return err
+	}
+
+	weakLeft, weakRight := left, right
+	if r.weak {
+		weakLeft, weakRight = r.weakLtss[0], r.weakLtss[1]
+	}
+	state, _, res, err := preorderPair(left, right, weakLeft, weakRight, p, q, rho, r.regSizeOverride, enableGarbageCollection())
+	if err != nil {
+		return err
+	}
+	r.state = state
+	r.started = relatedPair{p, q, rho}
+	r.res = res
+
+	verdict := "related"
+	if r.res != ResultRelated {
+		verdict = "NOT related"
+	}
+	fmt.Fprintf(w, "States %d and %d are %s for rho %s, N=%d.\n", p, q, verdict, rhoToString(rho), getRegSize())
+	fmt.Fprintf(w, "G has %d pairs and notR has %d.\n", len(state.G.States), len(r.notRKeys()))
+	return nil
+}
+
+func (r *replSession) checkPreorder() error {
+	if r.state == nil {
+		return fmt.Errorf("preorder has not been run yet")
+	}
+	return nil
+}
+
+// The pair of LTS states of a key of G or notR, with the rho of the left state.
+func (s *CleavelandState) vertexKeyPair(key string) (relatedPair, bool) {
+	i := strings.LastIndex(key, ",true>")
+	if i < 0 {
+		return relatedPair{}, false
+	}
+	lId, ok1 := s.NStateToId[key[:i+len(",true>")]]
+	rId, ok2 := s.NStateToId[key[i+len(",true>"):]]
+	if !ok1 || !ok2 {
+		return relatedPair{}, false
+	}
+	return relatedPair{s.RevMap[lId], s.RevMap[rId], s.States[lId].Rho}, true
+}
+
+func (r *replSession) notRKeys() []string {
+	var keys []string
+	notR.Range(func(k, v interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func pairToString(p relatedPair) string {
+	return fmt.Sprintf("(%d, %d, %s)", p.Left, p.Right, rhoToString(p.Rho))
+}
+
+func (r *replSession) listG(w io.Writer, args []string) error {
+	if err := r.checkPreorder(); err != nil {
+		return err
+	}
+	pairs := r.state.relatedPairs()
+	if len(pairs) == 0 {
+		fmt.Fprintf(w, "G is empty.\n")
+	}
+	for _, p := range pairs {
+		fmt.Fprintf(w, "  %s\n", pairToString(p))
+	}
+	return nil
+}
+
+// Why the pair of the key is not related, in one line.
+func (r *replSession) failureSummary(key string) string {
+	failure, ok := r.state.Failures[key]
+	if !ok {
+		return "no reason was recorded"
+	}
+	return fmt.Sprintf("%s %d -%s-> %d could not be matched by %d replies", systemName(failure.IsLeft),
+		failure.Trans.Source, failure.Trans.Label.PrettyPrintGraph(), failure.Trans.Destination, len(failure.Replies))
+}
+
+func (r *replSession) listNotR(w io.Writer, args []string) error {
+	if err := r.checkPreorder(); err != nil {
+		return err
+	}
+	keys := r.notRKeys()
+	if len(keys) == 0 {
+		fmt.Fprintf(w, "notR is empty.\n")
+	}
+	for _, key := range keys {
+		if p, ok := r.state.vertexKeyPair(key); ok {
+			fmt.Fprintf(w, "  %s: %s\n", pairToString(p), r.failureSummary(key))
+		}
+	}
+	return nil
+}
+
+func (r *replSession) why(w io.Writer, args []string) error {
+	if err := r.checkPreorder(); err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("expected two states")
+	}
+	p, err := parseStateId(r.ltss[0], args[0])
+	if err != nil {
+		return err
+	}
+	q, err := parseStateId(r.ltss[1], args[1])
+	if err != nil {
+		return err
+	}
+	state := r.state
+	found := false
+
+	var gKeys []string
+	for key := range state.G.States {
+		gKeys = append(gKeys, key)
+	}
+	sort.Strings(gKeys)
+	for _, key := range gKeys {
+		pair, ok := state.vertexKeyPair(key)
+		if !ok || pair.Left != p || pair.Right != q {
+			continue
+		}
+		found = true
+		fmt.Fprintf(w, "%s is in G. Its transitions are matched as follows:\n", pairToString(pair))
+		var lines []string
+		for _, edge := range state.G.TransitionsSet {
+			if edge.Destination != key {
+				continue
+			}
+			adj, src := state.AdjLeft, p
+			if edge.Label == GLabelTwo {
+				adj, src = state.AdjRight, q
+			}
+			trans := adj[src][edge.LabelsKey][edge.TransId]
+			line := fmt.Sprintf("  %s %d -%s-> %d", systemName(edge.Label == GLabelOne),
+				trans.Source, trans.Label.PrettyPrintGraph(), trans.Destination)
+			if dst, ok := state.vertexKeyPair(edge.Source); ok {
+				line += " leads to " + pairToString(dst)
+			}
+			lines = append(lines, line)
+		}
+		sort.Strings(lines)
+		if len(lines) == 0 {
+			lines = append(lines, "  Neither state has a transition.")
+		}
+		fmt.Fprintln(w, strings.Join(lines, "\n"))
+	}
+
+	for _, key := range r.notRKeys() {
+		pair, ok := state.vertexKeyPair(key)
+		if !ok || pair.Left != p || pair.Right != q {
+			continue
+		}
+		found = true
+		fmt.Fprintf(w, "%s is in notR", pairToString(pair))
+		failure, ok := state.Failures[key]
+		if !ok {
+			fmt.Fprintf(w, ", but no reason was recorded.\n")
+			continue
+		}
+		fmt.Fprintf(w, ": %s %d -%s-> %d could not be matched by %s.\n", systemName(failure.IsLeft),
+			failure.Trans.Source, failure.Trans.Label.PrettyPrintGraph(), failure.Trans.Destination, systemName(!failure.IsLeft))
+		if len(failure.Replies) == 0 {
+			fmt.Fprintf(w, "  It has no reply.\n")
+		}
+		for _, reply := range failure.Replies {
+			fmt.Fprintf(w, "  The reply %d -%s-> %d", reply.Trans.Source, reply.Trans.Label.PrettyPrintGraph(), reply.Trans.Destination)
+			if dst, ok := state.vertexKeyPair(reply.PairKey); ok {
+				fmt.Fprintf(w, " leads to %s", pairToString(dst))
+			}
+			fmt.Fprintf(w, ", which is not related.\n")
+		}
+	}
+
+	if !found {
+		fmt.Fprintf(w, "The last preorder did not reach a pair of the states %d and %d.\n", p, q)
+	}
+	return nil
+}
+
+func (r *replSession) showPath(w io.Writer, args []string) error {
+	if err := r.checkPreorder(); err != nil {
+		return err
+	}
+	if r.res == ResultRelated {
+		fmt.Fprintf(w, "The states %d and %d are related, there is no distinguishing path.\n", r.started.Left, r.started.Right)
+		return nil
+	}
+	fmt.Fprint(w, distinguishingPathToString(polyadicPath(r.state, distinguishingPath(r.state), r.sorts[0], r.sorts[1])))
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	pwd := getPwd(t)
+	outFolder := t.TempDir()
+	testFolder := path.Join(pwd, "test", "weak-bisimilar")
+	testFile := "buffer-2x1"
+	generateLts(t, testFolder, outFolder, []string{testFile}, flags)
+	left, err := decodeLTS(path.Join(outFolder, testFile+".1.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := decodeLTS(path.Join(outFolder, testFile+".2.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevWeak := weakBisim
+	defer func() { weakBisim = prevWeak }()
+
+	r := newReplSession(left, right, -1)
+	steps := []struct {
+		line   string
+		output string
+	}{
+		{"trans", "[0] 0 -"},
+		{"follow 0", "At state"},
+		{"back", "Back at state 0."},
+		{"preorder 0 0", "are NOT related"},
+		{"path", "which right cannot match"},
+		{"why 0 0", "is in notR"},
+		{"weak on", "checks weak bisimulation"},
+		{"preorder 0 0", "are related"},
+		{"why 0 0", "is in G"},
+		{"g", "(0, 0, {1→1, 2→2})"},
+		{"notr", "notR is empty."},
+		{"preorder 0 0 1=1,1=2", "error: register 1 is related twice"},
+		{"goto 1000", "error: there is no state 1000"},
+	}
+	for _, step := range steps {
+		var buf bytes.Buffer
+		if r.exec(&buf, step.line) {
+			t.Fatalf("%q ended the REPL.", step.line)
+		}
+		if !strings.Contains(buf.String(), step.output) {
+			t.Errorf("%q gave %q, expected it to contain %q.", step.line, buf.String(), step.output)
+		}
+	}
+	if !r.exec(&bytes.Buffer{}, "quit") {
+		t.Errorf("quit did not end the REPL.")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This is a file with the JSON result of a check, written with -output-result,
+// and the progress of a check, reported on stderr with -progress. Both are read
+// from the checks that pisim22 serve and pisim22 test run in child processes.
+
+type checkResult struct {
+	// bisimilar or not-bisimilar.
+	Verdict string `json:"verdict"`
+	Weak    bool   `json:"weak"`
+	N       int    `json:"n"`
+	// The rho of the start states.
+	Rho map[int]int `json:"rho"`
+	// The pairs of states in the relation. With a negative verdict, they are
+	// only related up to the part of the systems that was explored.
+	Relation []relatedPair `json:"relation"`
+	// The distinguishing path of a negative verdict, in the polyadic form.
+	Counterexample []htmlPathStep `json:"counterexample"`
+}
+
+func getCheckResult(state *CleavelandState, res ResultType, rho map[int]int) checkResult {
+	result := checkResult{
+		Verdict:        verdictBisimilar,
+		Weak:           isWeakBisim(),
+		N:              getRegSize(),
+		Rho:            rho,
+		Relation:       state.relatedPairs(),
+		Counterexample: []htmlPathStep{},
+	}
+	if result.Relation == nil {
+		result.Relation = []relatedPair{}
+	}
+	if res != ResultRelated {
+		result.Verdict = verdictNotBisimilar
+		result.Counterexample = toHtmlPath(checkPolyadicPath(state))
+	}
+	return result
+}
+
+// The result of a check that was taken from the cache.
+func cachedCheckResult(entry cacheEntry) checkResult {
+	result := checkResult{
+		Verdict:        verdictString(entry.Result),
+		Weak:           isWeakBisim(),
+		N:              entry.N,
+		Rho:            entry.Rho,
+		Relation:       entry.Relation,
+		Counterexample: entry.Path,
+	}
+	if result.Counterexample == nil {
+		result.Counterexample = []htmlPathStep{}
+	}
+	return result
+}
+
+func writeResultFile(name string, result checkResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(name, data)
+}
+
+// ####
+// Progress.
+// ####
+
+// The lines of progress on stderr start with progressPrefix, followed by the
+// progress as JSON.
+const progressPrefix = "progress "
+
+// How often the progress of the search is reported, and how many steps of the
+// search are taken between looking at the clock.
+const (
+	progressInterval = 500 * time.Millisecond
+	progressSteps    = 1024
+)
+
+type checkProgress struct {
+	// generating, transforming or checking.
+	Phase string `json:"phase"`
+	// The steps of the search, the pairs in G and the size of the work stack.
+	Steps     uint64 `json:"steps,omitempty"`
+	Pairs     int    `json:"pairs,omitempty"`
+	StackSize int    `json:"stackSize,omitempty"`
+}
+
+func reportProgress(p checkProgress) {
+	if !isReportProgress() {
+		return
+	}
+	data, err := json.Marshal(p)
+	check(err)
+	fmt.Fprintf(os.Stderr, "%s%s\n", progressPrefix, data)
+}
+
+// The pause function of the work stack of a search. It reports the progress,
+// writes the checkpoints, and stops the search once stopSearch is closed.
+func searchPause(s *bisimStack) func() bool {
+	var hooks []func() bool
+	if isReportProgress() {
+		hooks = append(hooks, progressReporter(s))
+	}
+	if getCheckpointName() != "" {
+		hooks = append(hooks, checkpointer(s))
+	} else if stopSearch != nil {
+		hooks = append(hooks, isSearchStopped)
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	return func() bool {
+		for _, hook := range hooks {
+			if hook() {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// A pause function of the work stack that reports the progress of the search
+// every progressInterval. It never pauses the search.
+func progressReporter(s *bisimStack) func() bool {
+	last := time.Now()
+	return func() bool {
+		if s.steps%progressSteps != 0 || time.Since(last) < progressInterval {
+			return false
+		}
+		last = time.Now()
+		reportProgress(checkProgress{Phase: "checking", Steps: s.steps,
+			Pairs: len(s.state.G.States), StackSize: len(s.frames)})
+		return false
+	}
+}
+
+// ####
+// Checks in a child process.
+// ####
+
+// The arguments of a check in a child process, which writes its result to
+// resultFile and reports its progress.
+func checkProcessArgs(opts queryOptions, lts1 string, lts2 string, resultFile string) []string {
+	args := []string{"check", "-no-cache", "-progress", "-output-result", resultFile}
+	if opts.Weak != nil && *opts.Weak {
+		args = append(args, "-w")
+	}
+	if opts.GC != nil && *opts.GC {
+		args = append(args, "-gc")
+	}
+	if opts.N != nil {
+		args = append(args, "-n", strconv.Itoa(*opts.N))
+	}
+	if opts.MaxStates != nil {
+		args = append(args, "-max-states", strconv.Itoa(*opts.MaxStates))
+	}
+	return append(args, lts1, lts2)
+}
+
+// Run a check in a child process, passing its progress on, and read its result.
+func runCheckProcess(cmd *exec.Cmd, resultFile string, progress func(checkProgress)) (checkResult, error) {
+	var result checkResult
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return result, err
+	}
+	if err := cmd.Start(); err != nil {
+		return result, err
+	}
+	// The progress is read from stderr, anything else is kept as the output.
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var p checkProgress
+		if strings.HasPrefix(line, progressPrefix) &&
+			json.Unmarshal([]byte(strings.TrimPrefix(line, progressPrefix)), &p) == nil {
+			progress(p)
+			continue
+		}
+		output.WriteString(line + "\n")
+	}
+	if err := cmd.Wait(); err != nil {
+		return result, fmt.Errorf("the check failed: %s", lastLines(output.String(), 5))
+	}
+	data, err := ioutil.ReadFile(resultFile)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// The last n lines of the output, or all of it if it is shorter.
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
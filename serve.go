@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This is a file with `pisim22 serve`, a local HTTP service that runs checks as
+// jobs:
+//
+//	POST   /jobs             submit a check, returns the job
+//	GET    /jobs             list the jobs
+//	GET    /jobs/{id}        the status and the progress of a job
+//	GET    /jobs/{id}/result the verdict, the relation and the counterexample
+//	POST   /jobs/{id}/cancel cancel a job, also DELETE /jobs/{id}
+//
+// The jobs are run by a bounded pool of workers. Both pifra and the check keep
+// their state in package globals, so every job is run as a check in a child
+// process of pisim22, which keeps concurrent jobs from interfering and lets a
+// running job be cancelled.
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// A model or LTS of a job. The extension of the name gives the format, as for
+// the files of check. A gob file is given in base64.
+type jobModel struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Base64  bool   `json:"base64,omitempty"`
+}
+
+type jobRequest struct {
+	Lts1 jobModel `json:"lts1"`
+	Lts2 jobModel `json:"lts2"`
+	queryOptions
+}
+
+// The status of a job, as returned by the API.
+type jobStatus struct {
+	Id        string         `json:"id"`
+	Status    string         `json:"status"`
+	Submitted time.Time      `json:"submitted"`
+	Started   *time.Time     `json:"started,omitempty"`
+	Finished  *time.Time     `json:"finished,omitempty"`
+	Progress  *checkProgress `json:"progress,omitempty"`
+	Verdict   string         `json:"verdict,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type job struct {
+	mu      sync.Mutex
+	status  jobStatus
+	request jobRequest
+	result  *checkResult
+	cancel  context.CancelFunc
+}
+
+func (j *job) getStatus() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+type jobServer struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	ids    []string
+	nextId int
+	queue  chan *job
+	// How long a finished job is kept, and how many finished jobs are kept at
+	// most. Zero keeps them all.
+	keep        time.Duration
+	maxFinished int
+	// A directory for the files of the jobs.
+	dir string
+	// The command that runs a check with the given arguments.
+	command func(ctx context.Context, args []string) *exec.Cmd
+}
+
+// The serve command.
+func serveCommand(args []string) {
+	fs := newCommandFlagSet("serve", "[flags]",
+		"Serve a JSON API for submitting checks as jobs, and for polling, cancelling and fetching their results.")
+	addrFlag := fs.String("addr", "localhost:8080", "The address to listen on.")
+	workersFlag := fs.Int("workers", runtime.NumCPU(), "The number of jobs that are run at the same time.")
+	queueFlag := fs.Int("queue", 100, "The number of jobs that can wait for a worker.")
+	keepFlag := fs.Duration("keep", time.Hour, "How long a finished job and its result are kept. Zero keeps them until the server stops.")
+	keepFinishedFlag := fs.Int("keep-finished", 1000, "The number of finished jobs that are kept at most. Zero keeps all of them.")
+	verboseFlag := fs.Bool("v", false, "Whether to log the jobs.")
+	args = parseCommandFlags(fs, args)
+	if len(args) != 0 {
+		usageError(fs, fmt.Errorf("expected no arguments, not %d", len(args)))
+	}
+	if *workersFlag <= 0 {
+		usageError(fs, fmt.Errorf("-workers has to be positive, not %d", *workersFlag))
+	}
+	if *queueFlag <= 0 {
+		usageError(fs, fmt.Errorf("-queue has to be positive, not %d", *queueFlag))
+	}
+	if *keepFlag < 0 {
+		usageError(fs, fmt.Errorf("-keep cannot be negative, not %s", *keepFlag))
+	}
+	if *keepFinishedFlag < 0 {
+		usageError(fs, fmt.Errorf("-keep-finished cannot be negative, not %d", *keepFinishedFlag))
+	}
+	verbose = *verboseFlag
+
+	exe, err := os.Executable()
+	check(err)
+	dir, err := ioutil.TempDir("", "pisim22-serve")
+	check(err)
+	defer os.RemoveAll(dir)
+	s := newJobServer(dir, *queueFlag, func(ctx context.Context, args []string) *exec.Cmd {
+		return exec.CommandContext(ctx, exe, args...)
+	})
+	s.keep, s.maxFinished = *keepFlag, *keepFinishedFlag
+	s.startWorkers(*workersFlag)
+
+	srv := &http.Server{Addr: *addrFlag, Handler: s.handler()}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	go func() {
+		<-stop
+		s.cancelAll()
+		srv.Shutdown(context.Background())
+	}()
+	fmt.Printf("Serving on %s with %d workers.\n", *addrFlag, *workersFlag)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+func newJobServer(dir string, queueSize int, command func(ctx context.Context, args []string) *exec.Cmd) *jobServer {
+	return &jobServer{
+		jobs:    make(map[string]*job),
+		queue:   make(chan *job, queueSize),
+		dir:     dir,
+		command: command,
+	}
+}
+
+func (s *jobServer) startWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range s.queue {
+				s.run(j)
+			}
+		}()
+	}
+}
+
+// Cancel all the jobs that have not finished.
+func (s *jobServer) cancelAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		s.cancelJob(j)
+	}
+}
+
+// ####
+// The API.
+// ####
+
+func (s *jobServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+	return mux
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeJsonError(w http.ResponseWriter, code int, err error) {
+	writeJson(w, code, map[string]string{"error": err.Error()})
+}
+
+func (s *jobServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		s.evict(time.Now())
+		statuses := []jobStatus{}
+		for _, id := range s.ids {
+			statuses = append(statuses, s.jobs[id].getStatus())
+		}
+		s.mu.Unlock()
+		writeJson(w, http.StatusOK, statuses)
+	case http.MethodPost:
+		var req jobRequest
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("invalid job: %s", err.Error()))
+			return
+		}
+		if err := req.validate(); err != nil {
+			writeJsonError(w, http.StatusBadRequest, err)
+			return
+		}
+		j, err := s.submit(req)
+		if err != nil {
+			writeJsonError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		status := j.getStatus()
+		w.Header().Set("Location", "/jobs/"+status.Id)
+		writeJson(w, http.StatusAccepted, status)
+	default:
+		writeJsonError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed on /jobs", r.Method))
+	}
+}
+
+func (s *jobServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	s.mu.Lock()
+	s.evict(time.Now())
+	j, ok := s.jobs[parts[0]]
+	s.mu.Unlock()
+	if !ok || len(parts) > 2 {
+		writeJsonError(w, http.StatusNotFound, fmt.Errorf("there is no %s", r.URL.Path))
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJson(w, http.StatusOK, j.getStatus())
+	case (action == "" && r.Method == http.MethodDelete) || (action == "cancel" && r.Method == http.MethodPost):
+		s.mu.Lock()
+		cancelled := s.cancelJob(j)
+		s.mu.Unlock()
+		if !cancelled {
+			writeJson(w, http.StatusConflict, j.getStatus())
+			return
+		}
+		writeJson(w, http.StatusOK, j.getStatus())
+	case action == "result" && r.Method == http.MethodGet:
+		j.mu.Lock()
+		result, status := j.result, j.status
+		j.mu.Unlock()
+		if result == nil {
+			writeJson(w, http.StatusConflict, status)
+			return
+		}
+		writeJson(w, http.StatusOK, result)
+	default:
+		writeJsonError(w, http.StatusNotFound, fmt.Errorf("there is no %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (m jobModel) validate(side string) error {
+	if m.Content == "" {
+		return fmt.Errorf("%s has no content", side)
+	}
+	ext := filepath.Ext(m.Name)
+	if ext != "" && ext != ".pi" && !isLoadedLtsFile(m.Name) {
+		return fmt.Errorf("%s has an unknown format %q, expected .pi, .aut, .fra, .json or .gob", side, ext)
+	}
+	if ext == ".gob" && !m.Base64 {
+		return fmt.Errorf("%s is a gob file, and has to be given in base64", side)
+	}
+	return nil
+}
+
+func (req jobRequest) validate() error {
+	if err := req.Lts1.validate("lts1"); err != nil {
+		return err
+	}
+	if err := req.Lts2.validate("lts2"); err != nil {
+		return err
+	}
+	if req.N != nil {
+		if err := validateRegSize(*req.N); err != nil {
+			return fmt.Errorf("n has to be positive, not %d", *req.N)
+		}
+	}
+	if req.MaxStates != nil && *req.MaxStates <= 0 {
+		return fmt.Errorf("maxStates has to be positive, not %d", *req.MaxStates)
+	}
+	return nil
+}
+
+// ####
+// The jobs.
+// ####
+
+func (s *jobServer) submit(req jobRequest) (*job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(time.Now())
+	s.nextId++
+	id := strconv.Itoa(s.nextId)
+	j := &job{request: req, status: jobStatus{Id: id, Status: jobQueued, Submitted: time.Now()}}
+	select {
+	case s.queue <- j:
+	default:
+		s.nextId--
+		return nil, fmt.Errorf("there are too many jobs waiting, try again later")
+	}
+	s.jobs[id] = j
+	s.ids = append(s.ids, id)
+	if isVerbose() {
+		fmt.Printf("Job %s: queued.\n", id)
+	}
+	return j, nil
+}
+
+// Forget the jobs that finished more than keep ago, and the ones that finished
+// first beyond the maxFinished last ones. Called with s.mu held.
+func (s *jobServer) evict(now time.Time) {
+	var finished []string
+	for _, id := range s.ids {
+		if f := s.jobs[id].getStatus().Finished; f != nil {
+			finished = append(finished, id)
+		}
+	}
+	sort.SliceStable(finished, func(a, b int) bool {
+		return s.jobs[finished[a]].getStatus().Finished.Before(*s.jobs[finished[b]].getStatus().Finished)
+	})
+	evicted := make(map[string]bool)
+	for k, id := range finished {
+		tooMany := s.maxFinished > 0 && len(finished)-k > s.maxFinished
+		tooOld := s.keep > 0 && now.Sub(*s.jobs[id].getStatus().Finished) > s.keep
+		if tooMany || tooOld {
+			evicted[id] = true
+		}
+	}
+	if len(evicted) == 0 {
+		return
+	}
+	ids := s.ids[:0]
+	for _, id := range s.ids {
+		if evicted[id] {
+			delete(s.jobs, id)
+			if isVerbose() {
+				fmt.Printf("Job %s: removed.\n", id)
+			}
+			continue
+		}
+		ids = append(ids, id)
+	}
+	s.ids = ids
+}
+
+// Cancel a job that has not finished. Returns false if it has.
+func (s *jobServer) cancelJob(j *job) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch j.status.Status {
+	case jobQueued:
+		now := time.Now()
+		j.status.Status = jobCancelled
+		j.status.Finished = &now
+	case jobRunning:
+		j.cancel()
+	default:
+		return false
+	}
+	return true
+}
+
+// Write a model of a job to its directory.
+func writeJobModel(dir string, side string, m jobModel) (string, error) {
+	ext := filepath.Ext(m.Name)
+	if ext == "" {
+		ext = ".pi"
+	}
+	data := []byte(m.Content)
+	if m.Base64 {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(m.Content); err != nil {
+			return "", fmt.Errorf("%s is not valid base64: %s", side, err.Error())
+		}
+	}
+	name := filepath.Join(dir, side+ext)
+	return name, writeFile(name, data)
+}
+
+// Run a job in a child process, and record its progress and its result.
+func (s *jobServer) run(j *job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	j.mu.Lock()
+	if j.status.Status != jobQueued {
+		j.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	j.status.Status = jobRunning
+	j.status.Started = &now
+	j.cancel = cancel
+	id, req := j.status.Id, j.request
+	j.mu.Unlock()
+	if isVerbose() {
+		fmt.Printf("Job %s: running.\n", id)
+	}
+
+	result, err := s.runCheck(ctx, id, req, func(p checkProgress) {
+		j.mu.Lock()
+		j.status.Progress = &p
+		j.mu.Unlock()
+	})
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	finished := time.Now()
+	j.status.Finished = &finished
+	switch {
+	case ctx.Err() != nil:
+		j.status.Status = jobCancelled
+	case err != nil:
+		j.status.Status = jobFailed
+		j.status.Error = err.Error()
+	default:
+		j.status.Status = jobDone
+		j.status.Verdict = result.Verdict
+		j.result = &result
+	}
+	if isVerbose() {
+		fmt.Printf("Job %s: %s.\n", id, j.status.Status)
+	}
+}
+
+func (s *jobServer) runCheck(ctx context.Context, id string, req jobRequest, progress func(checkProgress)) (checkResult, error) {
+	var result checkResult
+	dir := filepath.Join(s.dir, id)
+	defer os.RemoveAll(dir)
+	lts1, err := writeJobModel(dir, "lts1", req.Lts1)
+	if err != nil {
+		return result, err
+	}
+	lts2, err := writeJobModel(dir, "lts2", req.Lts2)
+	if err != nil {
+		return result, err
+	}
+	resultFile := filepath.Join(dir, "result.json")
+	return runCheckProcess(s.command(ctx, checkProcessArgs(req.queryOptions, lts1, lts2, resultFile)), resultFile, progress)
+}
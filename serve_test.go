@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+)
+
+// Not a test, the child process of the jobs in the tests of serve.
+func TestServeHelperProcess(t *testing.T) {
+	if os.Getenv("PISIM22_SERVE_HELPER") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	// Skip -- and the name of the command.
+	checkCommand(args[2:], false)
+	os.Exit(0)
+}
+
+// A check in a child process that is the test binary itself.
+func testCheckCommand(ctx context.Context, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=TestServeHelperProcess", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "PISIM22_SERVE_HELPER=1")
+	return cmd
+}
+
+func newTestJobServer(t *testing.T, workers int) *httptest.Server {
+	s := newJobServer(t.TempDir(), 10, testCheckCommand)
+	s.startWorkers(workers)
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(func() {
+		s.cancelAll()
+		ts.Close()
+	})
+	return ts
+}
+
+func testJobRequest(t *testing.T, folder string, testFile string, weak bool) []byte {
+	pwd := getPwd(t)
+	req := jobRequest{queryOptions: queryOptions{Weak: &weak}}
+	for i, m := range []*jobModel{&req.Lts1, &req.Lts2} {
+		m.Name = testFile + []string{".1.pi", ".2.pi"}[i]
+		data, err := ioutil.ReadFile(path.Join(pwd, "test", folder, m.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Content = string(data)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func doJson(t *testing.T, method string, url string, body []byte, code int, v interface{}) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != code {
+		data, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("%s %s returned %d, expected %d: %s", method, url, resp.StatusCode, code, data)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitForJob(t *testing.T, url string) jobStatus {
+	var status jobStatus
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		doJson(t, http.MethodGet, url, nil, http.StatusOK, &status)
+		if status.Status != jobQueued && status.Status != jobRunning {
+			return status
+		}
+	}
+	t.Fatalf("The job at %s did not finish.", url)
+	return status
+}
+
+// The jobs give the same verdicts as check, with a counterexample when the
+// systems are not bisimilar.
+func TestServe(t *testing.T) {
+	ts := newTestJobServer(t, 2)
+	cases := []struct {
+		folder   string
+		testFile string
+		weak     bool
+		verdict  string
+	}{
+		{"bisimilar", "jev-a1", false, verdictBisimilar},
+		{"not-bisimilar", "jev-non-det-2", false, verdictNotBisimilar},
+		{"weak-bisimilar", "buffer-2x1", true, verdictBisimilar},
+	}
+	ids := []string{}
+	for _, c := range cases {
+		var status jobStatus
+		doJson(t, http.MethodPost, ts.URL+"/jobs", testJobRequest(t, c.folder, c.testFile, c.weak), http.StatusAccepted, &status)
+		ids = append(ids, status.Id)
+	}
+	for i, c := range cases {
+		url := ts.URL + "/jobs/" + ids[i]
+		if status := waitForJob(t, url); status.Status != jobDone || status.Verdict != c.verdict {
+			t.Errorf("The job on %s ended as %s with %q, expected %s: %s", c.testFile, status.Status, status.Verdict, c.verdict, status.Error)
+			continue
+		}
+		var result checkResult
+		doJson(t, http.MethodGet, url+"/result", nil, http.StatusOK, &result)
+		if result.Verdict != c.verdict || result.Weak != c.weak {
+			t.Errorf("The result of %s is %s with weak %t, expected %s with weak %t.", c.testFile, result.Verdict, result.Weak, c.verdict, c.weak)
+		}
+		if (c.verdict == verdictNotBisimilar) != (len(result.Counterexample) > 0) {
+			t.Errorf("The result of %s has a counterexample of %d steps.", c.testFile, len(result.Counterexample))
+		}
+	}
+	var statuses []jobStatus
+	doJson(t, http.MethodGet, ts.URL+"/jobs", nil, http.StatusOK, &statuses)
+	if len(statuses) != len(cases) {
+		t.Errorf("There are %d jobs, expected %d.", len(statuses), len(cases))
+	}
+}
+
+func TestServeCancel(t *testing.T) {
+	// Without workers, the job stays in the queue.
+	ts := newTestJobServer(t, 0)
+	var status jobStatus
+	doJson(t, http.MethodPost, ts.URL+"/jobs", testJobRequest(t, "bisimilar", "jev-a1", false), http.StatusAccepted, &status)
+	url := ts.URL + "/jobs/" + status.Id
+	doJson(t, http.MethodGet, url+"/result", nil, http.StatusConflict, nil)
+	doJson(t, http.MethodPost, url+"/cancel", nil, http.StatusOK, &status)
+	if status.Status != jobCancelled {
+		t.Errorf("The job is %s after cancelling, expected %s.", status.Status, jobCancelled)
+	}
+	doJson(t, http.MethodDelete, url, nil, http.StatusConflict, nil)
+}
+
+func TestServeBadRequest(t *testing.T) {
+	ts := newTestJobServer(t, 0)
+	for _, body := range []string{
+		`{`,
+		`{"lts1": {"name": "a.pi", "content": "0"}}`,
+		`{"lts1": {"name": "a.txt", "content": "0"}, "lts2": {"name": "b.pi", "content": "0"}}`,
+		`{"lts1": {"name": "a.pi", "content": "0"}, "lts2": {"name": "b.pi", "content": "0"}, "n": 0}`,
+		`{"lts1": {"name": "a.pi", "content": "0"}, "lts2": {"name": "b.pi", "content": "0"}, "unknown": 1}`,
+	} {
+		doJson(t, http.MethodPost, ts.URL+"/jobs", []byte(body), http.StatusBadRequest, nil)
+	}
+	doJson(t, http.MethodGet, ts.URL+"/jobs/1", nil, http.StatusNotFound, nil)
+}
+
+// The finished jobs are forgotten after keep, and beyond maxFinished.
+func TestServeEvict(t *testing.T) {
+	s := newJobServer(t.TempDir(), 10, testCheckCommand)
+	s.keep, s.maxFinished = time.Hour, 2
+	var jobs []*job
+	for i := 0; i < 4; i++ {
+		j, err := s.submit(jobRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, j)
+	}
+	// Job 4 stays queued, and jobs 1 to 3 finish in order.
+	now := time.Now()
+	for i, j := range jobs[:3] {
+		s.cancelJob(j)
+		finished := now.Add(time.Duration(i-3) * time.Minute)
+		j.status.Finished = &finished
+	}
+	remaining := func() []string {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.evict(now)
+		return append([]string(nil), s.ids...)
+	}
+	if ids := remaining(); fmt.Sprint(ids) != "[2 3 4]" {
+		t.Errorf("The jobs are %v after evicting beyond 2 finished ones, expected [2 3 4].", ids)
+	}
+	now = now.Add(time.Hour - 90*time.Second)
+	if ids := remaining(); fmt.Sprint(ids) != "[3 4]" {
+		t.Errorf("The jobs are %v after an hour, expected [3 4].", ids)
+	}
+	if _, ok := s.jobs["2"]; ok {
+		t.Errorf("Job 2 was evicted from the ids but not from the jobs.")
+	}
+}
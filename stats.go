@@ -12,6 +12,7 @@ type ICounters struct {
 	fullExecutePreorder     int
 	preorderStackDepth      int
 	maxPreorderStackDepth   int
+	maxWorkStackSize        int
 	enterProcessDerivatives int
 	tauRule                 int
 	inp1Rule                int
@@ -21,6 +22,11 @@ type ICounters struct {
 	foutRule                int
 	reevalA                 int
 	failPD                  int
+	symmetricPairs          int
+	// The pairs that were searched, and the pairs seeded by a warm start that
+	// were re-verified.
+	newPairs        int
+	reverifiedPairs int
 }
 
 func (ic *ICounters) resetBisim() {
@@ -30,11 +36,15 @@ func (ic *ICounters) resetBisim() {
 func (ic *ICounters) String() string {
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("maxPreorderStackDepth: %d\n", IC.maxPreorderStackDepth))
+	sb.WriteString(fmt.Sprintf("maxWorkStackSize: %d\n", IC.maxWorkStackSize))
 	sb.WriteString(fmt.Sprintf("enterToPreorder: %d\n", IC.enterToPreorder))
 	sb.WriteString(fmt.Sprintf("fullExecutePreorder: %d\n", IC.fullExecutePreorder))
 	sb.WriteString(fmt.Sprintf("reevalA: %d\n", IC.reevalA))
 	sb.WriteString(fmt.Sprintf("enterProcessDerivatives: %d\n", IC.enterProcessDerivatives))
 	sb.WriteString(fmt.Sprintf("failPD: %d\n", IC.failPD))
+	sb.WriteString(fmt.Sprintf("symmetricPairs: %d\n", IC.symmetricPairs))
+	sb.WriteString(fmt.Sprintf("newPairs: %d\n", IC.newPairs))
+	sb.WriteString(fmt.Sprintf("reverifiedPairs: %d\n", IC.reverifiedPairs))
 
 	sb.WriteString(fmt.Sprintf("Rules stats: \n"))
 	sb.WriteString(fmt.Sprintf("\t tauRule: %d\n", IC.tauRule))
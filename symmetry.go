@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/yungene/pifra"
+)
+
+// Symmetry reduction over register permutations.
+//
+// A register whose name no longer occurs free in the process (it can only be
+// there when GC is disabled) is unobservable by itself: swapping the contents
+// of two such registers leaves the configuration unchanged up to a renaming of
+// names that the process does not use. Hence a pair (nP, nQ) is related under
+// rho iff it is related under tau . rho . sigma^-1, where sigma permutes the
+// dead registers of nP and tau permutes the dead registers of nQ. The orbit of
+// rho under such permutations is determined by:
+//   - the entries between live registers,
+//   - the live registers of nP mapped to some dead register of nQ,
+//   - the live registers of nQ mapped from some dead register of nP,
+//   - the number of entries between dead registers,
+// so we replace rho with the representative that assigns the dead registers in
+// ascending order. Symmetric pairs then share one G vertex and one notR entry.
+//
+// The bodies of the declared processes are not part of the LTS, so a process
+// call may use any of the free names of the program, i.e. the names in the
+// registers of the start state. Those are never dead while there is a call.
+
+// The names of the registers of the start state of an LTS.
+func globalNames(lts pifra.Lts) map[string]bool {
+	res := make(map[string]bool)
+	for _, name := range lts.States[0].Registers.Registers {
+		res[name] = true
+	}
+	return res
+}
+
+// The free names that occur in elem, and whether it calls a declared process.
+func occurringFreeNames(elem pifra.Element) (names map[string]bool, hasCall bool) {
+	names = make(map[string]bool)
+	addName := func(n pifra.Name) {
+		if n.Type == pifra.Free {
+			names[n.Name] = true
+		}
+	}
+	stack := []pifra.Element{elem}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch e := e.(type) {
+		case *pifra.ElemOutput:
+			addName(e.Channel)
+			addName(e.Output)
+			stack = append(stack, e.Next)
+		case *pifra.ElemInput:
+			addName(e.Channel)
+			addName(e.Input)
+			stack = append(stack, e.Next)
+		case *pifra.ElemEquality:
+			addName(e.NameL)
+			addName(e.NameR)
+			stack = append(stack, e.Next)
+		case *pifra.ElemRestriction:
+			addName(e.Restrict)
+			stack = append(stack, e.Next)
+		case *pifra.ElemSum:
+			stack = append(stack, e.ProcessL, e.ProcessR)
+		case *pifra.ElemParallel:
+			stack = append(stack, e.ProcessL, e.ProcessR)
+		case *pifra.ElemProcess:
+			hasCall = true
+			for _, n := range e.Parameters {
+				addName(n)
+			}
+		case *pifra.ElemRoot:
+			stack = append(stack, e.Next)
+		}
+	}
+	return
+}
+
+// The indices of the non-empty registers of conf whose names cannot occur in
+// its process any more, in ascending order.
+func deadRegisters(conf *FRAConfiguration, global map[string]bool) []int {
+	free, hasCall := occurringFreeNames(conf.Process)
+	var dead []int
+	for idx, name := range conf.Registers.Registers {
+		if !free[name] && !(hasCall && global[name]) {
+			dead = append(dead, idx)
+		}
+	}
+	sort.Ints(dead)
+	return dead
+}
+
+// Rewrite the rho of nP and nQ into the canonical representative of its orbit.
+// Returns true if rho was changed.
+func canonicaliseRho(nP *FRAConfiguration, nQ *FRAConfiguration,
+	globalP map[string]bool, globalQ map[string]bool) bool {
+	deadLeft := deadRegisters(nP, globalP)
+	deadRight := deadRegisters(nQ, globalQ)
+	if len(deadLeft) == 0 && len(deadRight) == 0 {
+		return false
+	}
+	isDeadLeft := make(map[int]bool, len(deadLeft))
+	for _, idx := range deadLeft {
+		isDeadLeft[idx] = true
+	}
+	isDeadRight := make(map[int]bool, len(deadRight))
+	for _, idx := range deadRight {
+		isDeadRight[idx] = true
+	}
+
+	rho := make(map[int]int, len(nP.Rho))
+	// Live registers of nP mapped to dead registers of nQ, and the other way.
+	var liveToDead []int
+	var deadToLive []int
+	deadToDead := 0
+	for l, r := range nP.Rho {
+		switch {
+		case !isDeadLeft[l] && !isDeadRight[r]:
+			rho[l] = r
+		case !isDeadLeft[l]:
+			liveToDead = append(liveToDead, l)
+		case !isDeadRight[r]:
+			deadToLive = append(deadToLive, r)
+		default:
+			deadToDead++
+		}
+	}
+	sort.Ints(liveToDead)
+	sort.Ints(deadToLive)
+
+	li, ri := 0, 0
+	for _, r := range deadToLive {
+		rho[deadLeft[li]] = r
+		li++
+	}
+	for _, l := range liveToDead {
+		rho[l] = deadRight[ri]
+		ri++
+	}
+	for i := 0; i < deadToDead; i++ {
+		rho[deadLeft[li]] = deadRight[ri]
+		li++
+		ri++
+	}
+
+	if mapsEqual(rho, nP.Rho) {
+		return false
+	}
+	revRho, err := reverseMap(rho)
+	if err != nil {
+		// Cannot happen, as rho was a bijection and so is the new one.
+		return false
+	}
+	nP.Rho = rho
+	nQ.Rho = revRho
+	return true
+}
+
+// Canonicalise a pair before it is looked up in G or notR, if enabled. The
+// representative does not depend on the side that nP comes from.
+func (s *CleavelandState) canonicalisePair(nP *FRAConfiguration, nQ *FRAConfiguration, isLeft bool) {
+	if !enableSymmetryReduction() {
+		return
+	}
+	globalP, globalQ := s.GlobalNamesLeft, s.GlobalNamesRight
+	if !isLeft {
+		globalP, globalQ = globalQ, globalP
+	}
+	if canonicaliseRho(nP, nQ, globalP, globalQ) {
+		IC.symmetricPairs++
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yungene/pifra"
+)
+
+// Rhos that differ only on dead registers have to get the same representative,
+// while the entries between live registers are kept.
+func TestCanonicaliseRho(t *testing.T) {
+	// #1 and #2 are used, #3, #4 and #5 are dead.
+	left := FRAConfiguration{
+		Process: &pifra.ElemOutput{
+			Channel: pifra.Name{Name: "#1", Type: pifra.Free},
+			Output:  pifra.Name{Name: "#2", Type: pifra.Free},
+			Next:    &pifra.ElemNil{},
+		},
+		Registers: pifra.Registers{Size: 5, Registers: map[int]string{
+			1: "#1", 2: "#2", 3: "#3", 4: "#4", 5: "#5"}},
+		N: 5,
+	}
+	// #1 is used, #2, #3 and #4 are dead.
+	right := FRAConfiguration{
+		Process: &pifra.ElemInput{
+			Channel: pifra.Name{Name: "#1", Type: pifra.Free},
+			Input:   pifra.Name{Name: "&1", Type: pifra.Bound},
+			Next:    &pifra.ElemNil{},
+		},
+		Registers: pifra.Registers{Size: 4, Registers: map[int]string{
+			1: "#1", 2: "#2", 3: "#3", 4: "#4"}},
+		N: 4,
+	}
+	rhos := []map[int]int{
+		{1: 1, 2: 4, 5: 2, 3: 3},
+		{1: 1, 2: 2, 3: 4, 4: 3},
+		{1: 1, 2: 3, 4: 2, 5: 4},
+	}
+	expected := fmt.Sprint(map[int]int{1: 1, 2: 2, 3: 3, 4: 4})
+	for _, rho := range rhos {
+		nP, nQ := left, right
+		nP.Rho = rho
+		revRho, err := reverseMap(rho)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nQ.Rho = revRho
+		canonicaliseRho(&nP, &nQ, nil, nil)
+		if fmt.Sprint(nP.Rho) != expected {
+			t.Errorf("Representative of %s was %s, expected %s.",
+				fmt.Sprint(rho), fmt.Sprint(nP.Rho), expected)
+		}
+		if fmt.Sprint(nQ.Rho) != expected {
+			t.Errorf("Inverse of the representative of %s was %s.",
+				fmt.Sprint(rho), fmt.Sprint(nQ.Rho))
+		}
+	}
+
+	// The free names of the program stay live while there is a process call.
+	call := right
+	call.Process = &pifra.ElemProcess{Name: "Q"}
+	if dead := deadRegisters(&call, map[string]bool{"#1": true, "#2": true}); fmt.Sprint(dead) != "[3 4]" {
+		t.Errorf("Dead registers with a call were %s, expected [3 4].", fmt.Sprint(dead))
+	}
+}
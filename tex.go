@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the LaTeX output of a check, written with -output-tex.
+// The document is standalone and compiles with plain pdflatex: both LTSs are
+// drawn with TikZ, their states are listed with their registers and processes,
+// and the relation is written out as a table of pairs with their rho.
+
+// The distances between the states of a TikZ figure, and the largest size of a
+// figure, in cm.
+const (
+	texStateDistanceX = 2.2
+	texStateDistanceY = 1.8
+	texMaxWidth       = 16.0
+	texMaxHeight      = 20.0
+)
+
+const texPreamble = `\documentclass{article}
+\usepackage[margin=2cm]{geometry}
+\usepackage{amsmath}
+\usepackage{amssymb}
+\usepackage{longtable}
+\usepackage{tikz}
+\usetikzlibrary{arrows.meta}
+\definecolor{attack}{HTML}{D62728}
+\definecolor{reply}{HTML}{FF7F0E}
+\tikzset{
+  state/.style={circle, draw, minimum size=7mm, inner sep=1pt},
+  start/.style={double},
+  trans/.style={-{Stealth}},
+  lbl/.style={auto, font=\scriptsize},
+  weak/.style={dashed, gray},
+  attack/.style={attack, thick},
+  reply/.style={reply, thick},
+}
+\begin{document}
+`
+
+func rhoToTex(rho map[int]int) string {
+	var keys []int
+	for k := range rho {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf(`%d \mapsto %d`, k, rho[k]))
+	}
+	return `\{` + strings.Join(parts, ", ") + `\}`
+}
+
+// The name of a state in the document: p for the left LTS, q for the right.
+func texStateName(isLeft bool, id int) string {
+	if isLeft {
+		return fmt.Sprintf("p_{%d}", id)
+	}
+	return fmt.Sprintf("q_{%d}", id)
+}
+
+func texNodeName(isLeft bool, id int) string {
+	if isLeft {
+		return fmt.Sprintf("l%d", id)
+	}
+	return fmt.Sprintf("r%d", id)
+}
+
+// Draw an LTS as a TikZ figure, laid out in layers by the distance from state
+// 0, and scaled down to fit on a page. The transitions of the distinguishing
+// path are highlighted with their style in path.
+func writeTexLts(buf *bytes.Buffer, lts pifra.Lts, weakLts pifra.Lts, isLeft bool, path map[string]string) {
+	layout := ltsLayout(lts)
+	width := make(map[int]int)
+	maxWidth, maxLayer := 1, 0
+	for _, p := range layout {
+		width[p.Layer] = maxInt(width[p.Layer], p.Pos+1)
+		maxWidth = maxInt(maxWidth, width[p.Layer])
+		maxLayer = maxInt(maxLayer, p.Layer)
+	}
+	scale := 1.0
+	if w := float64(maxWidth-1) * texStateDistanceX; w > texMaxWidth {
+		scale = texMaxWidth / w
+	}
+	if h := float64(maxLayer) * texStateDistanceY; h > texMaxHeight {
+		scale = math.Min(scale, texMaxHeight/h)
+	}
+
+	var ids []int
+	for id := range lts.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	buf.WriteString("\\begin{center}\n")
+	buf.WriteString(fmt.Sprintf("\\begin{tikzpicture}[scale=%.3f, every node/.style={transform shape}]\n", scale))
+	for _, id := range ids {
+		p := layout[id]
+		x := (float64(p.Pos) + float64(maxWidth-width[p.Layer])/2) * texStateDistanceX
+		y := float64(-p.Layer) * texStateDistanceY
+		style := "state"
+		if id == 0 {
+			style += ", start"
+		}
+		buf.WriteString(fmt.Sprintf("  \\node[%s] (%s) at (%.2f, %.2f) {$%s$};\n",
+			style, texNodeName(isLeft, id), x, y, texStateName(isLeft, id)))
+	}
+
+	// The transitions between the same two states that are drawn alike share an
+	// edge. Edges between the same two states are bent apart, and the loops of a
+	// state go around it.
+	type texEdge struct {
+		src, dst int
+		style    string
+		labels   []string
+	}
+	var edges []*texEdge
+	edgeOf := make(map[string]*texEdge)
+	addEdge := func(trans pifra.Transition, weak bool) {
+		style := "trans"
+		if weak {
+			style += ", weak"
+		}
+		if s, ok := path[fmt.Sprint(trans)]; ok {
+			style += ", " + s
+		}
+		key := fmt.Sprintf("%d %d %s", trans.Source, trans.Destination, style)
+		e, ok := edgeOf[key]
+		if !ok {
+			e = &texEdge{src: trans.Source, dst: trans.Destination, style: style}
+			edgeOf[key] = e
+			edges = append(edges, e)
+		}
+		e.labels = append(e.labels, pifra.PrettyPrintTexGraphLabel(trans.Label))
+	}
+	for _, trans := range lts.Transitions {
+		addEdge(trans, false)
+	}
+	if isWeakBisim() {
+		for _, trans := range saturatedTransitions(lts, weakLts) {
+			addEdge(trans, true)
+		}
+	}
+	between := make(map[[2]int]int)
+	for _, e := range edges {
+		between[[2]int{minInt(e.src, e.dst), maxInt(e.src, e.dst)}]++
+	}
+	count := make(map[[2]int]int)
+	loopSides := []string{"above", "below", "left", "right"}
+	for _, e := range edges {
+		n := count[[2]int{e.src, e.dst}]
+		count[[2]int{e.src, e.dst}]++
+		style := e.style
+		switch {
+		case e.src == e.dst:
+			style += fmt.Sprintf(", loop %s, looseness=%d", loopSides[n%len(loopSides)], 6+2*(n/len(loopSides)))
+		case between[[2]int{minInt(e.src, e.dst), maxInt(e.src, e.dst)}] > 1:
+			style += fmt.Sprintf(", bend left=%d", 15*(n+1))
+		}
+		buf.WriteString(fmt.Sprintf("  \\path (%s) edge[%s] node[lbl] {$%s$} (%s);\n",
+			texNodeName(isLeft, e.src), style, strings.Join(e.labels, ",\\; "), texNodeName(isLeft, e.dst)))
+	}
+	buf.WriteString("\\end{tikzpicture}\n")
+	buf.WriteString("\\end{center}\n\n")
+
+	buf.WriteString("\\begin{longtable}{l p{5cm} p{9cm}}\n")
+	buf.WriteString("State & Registers & Process \\\\\n\\hline\n\\endhead\n")
+	for _, id := range ids {
+		conf := lts.States[id]
+		buf.WriteString(fmt.Sprintf("$%s$ & $%s$ & $%s$ \\\\\n", texStateName(isLeft, id),
+			pifra.PrettyPrintTexRegister(conf.Registers), pifra.PrettyPrintTexAst(conf.Process)))
+	}
+	buf.WriteString("\\end{longtable}\n\n")
+}
+
+// The document of a check.
+func generateTexFile(state *CleavelandState, res ResultType) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(texPreamble)
+
+	verdict := "bisimilar"
+	if isWeakBisim() {
+		verdict = "weakly " + verdict
+	}
+	if res != ResultRelated {
+		verdict = "not " + verdict
+	}
+	buf.WriteString("\\section*{Verdict}\n\n")
+	buf.WriteString(fmt.Sprintf("The systems are %s, with $N = %d$.", verdict, getRegSize()))
+	if isWeakBisim() {
+		buf.WriteString(" The transitions added by the weak transformation are dashed.")
+	}
+	buf.WriteString("\n\n")
+
+	// The transitions of the distinguishing path, with their styles.
+	var path []polyadicPathStep
+	paths := map[bool]map[string]string{true: {}, false: {}}
+	if res == ResultNotRelated {
+		path = checkPolyadicPath(state)
+		for _, step := range path {
+			for _, trans := range step.Trans {
+				paths[step.IsLeft][fmt.Sprint(trans)] = "attack"
+			}
+			for _, trans := range step.Reply {
+				paths[!step.IsLeft][fmt.Sprint(trans)] = "reply"
+			}
+		}
+	}
+
+	buf.WriteString("\\section*{lts1}\n\n")
+	writeTexLts(&buf, state.LeftLts, state.WeakLeftLts, true, paths[true])
+	buf.WriteString("\\section*{lts2}\n\n")
+	writeTexLts(&buf, state.RightLts, state.WeakRightLts, false, paths[false])
+
+	buf.WriteString("\\section*{Relation}\n\n")
+	pairs := state.relatedPairs()
+	if len(pairs) == 0 {
+		buf.WriteString("No pairs are related.\n\n")
+	} else {
+		if res != ResultRelated {
+			buf.WriteString("The systems are not bisimilar, so the pairs are only related up to the part of the systems that was explored.\n\n")
+		}
+		buf.WriteString("\\begin{longtable}{l l l}\n")
+		buf.WriteString("lts1 & lts2 & $\\rho$ \\\\\n\\hline\n\\endhead\n")
+		for _, p := range pairs {
+			buf.WriteString(fmt.Sprintf("$%s$ & $%s$ & $%s$ \\\\\n",
+				texStateName(true, p.Left), texStateName(false, p.Right), rhoToTex(p.Rho)))
+		}
+		buf.WriteString("\\end{longtable}\n\n")
+	}
+
+	if len(path) > 0 {
+		buf.WriteString("\\section*{Distinguishing path}\n\n")
+		buf.WriteString("The transitions that could not be matched are drawn in red, and the replies in orange.\n\n")
+		buf.WriteString("\\begin{enumerate}\n")
+		step := func(isLeft bool, session []pifra.Transition) string {
+			return fmt.Sprintf("$%s \\xrightarrow{%s} %s$", texStateName(isLeft, session[0].Source),
+				sessionTexLabel(session), texStateName(isLeft, session[len(session)-1].Destination))
+		}
+		for _, s := range path {
+			if len(s.Reply) == 0 {
+				buf.WriteString(fmt.Sprintf("  \\item %s, which lts%d cannot match.\n",
+					step(s.IsLeft, s.Trans), systemNumber(!s.IsLeft)))
+			} else {
+				buf.WriteString(fmt.Sprintf("  \\item %s, matched by %s.\n",
+					step(s.IsLeft, s.Trans), step(!s.IsLeft, s.Reply)))
+			}
+		}
+		buf.WriteString("\\end{enumerate}\n\n")
+	}
+
+	buf.WriteString("\\end{document}\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestTexFile(t *testing.T) {
+	pwd := getPwd(t)
+	outFolder := t.TempDir()
+	prevName := outputTexName
+	defer func() { outputTexName = prevName }()
+
+	cases := []struct {
+		folder   string
+		testFile string
+		res      ResultType
+	}{
+		{"not-bisimilar", "jev-non-det-2", ResultNotRelated},
+		{"bisimilar", "jev-gc-3", ResultRelated},
+	}
+	for _, c := range cases {
+		testFolder := path.Join(pwd, "test", c.folder)
+		generateLts(t, testFolder, outFolder, []string{c.testFile}, flags)
+		left, err := decodeLTS(path.Join(outFolder, c.testFile+".1.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := decodeLTS(path.Join(outFolder, c.testFile+".2.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputTexName = path.Join(outFolder, c.testFile+".tex")
+		if status := checkBisim(left, right, left, right, -1, -1, false); status != c.res {
+			t.Fatalf("%s was %d, expected %d.", c.testFile, status, c.res)
+		}
+		data, err := ioutil.ReadFile(outputTexName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tex := string(data)
+		if !strings.HasPrefix(tex, `\documentclass{article}`) || !strings.HasSuffix(tex, "\\end{document}\n") {
+			t.Errorf("The document of %s is not standalone.", c.testFile)
+		}
+		if strings.Count(tex, `\begin{tikzpicture}`) != 2 {
+			t.Errorf("The document of %s does not draw both LTSs.", c.testFile)
+		}
+		// The braces that are not escaped have to be balanced.
+		braces := strings.NewReplacer(`\{`, "", `\}`, "").Replace(tex)
+		if strings.Count(braces, "{") != strings.Count(braces, "}") {
+			t.Errorf("The braces of the document of %s are not balanced.", c.testFile)
+		}
+		if c.res == ResultRelated {
+			if !strings.Contains(tex, `$p_{0}$ & $q_{0}$ & $\{`) {
+				t.Errorf("The relation of %s does not relate the start states.", c.testFile)
+			}
+		} else if !strings.Contains(tex, `\section*{Distinguishing path}`) || !strings.Contains(tex, "edge[trans, attack") {
+			t.Errorf("The document of %s has no distinguishing path.", c.testFile)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with `pisim22 verify relation model1 model2`, which re-checks
+// a relation saved with -save-relation against the LTSs of two models, with
+// the code of -warm-start. The relation is verified if all its pairs are found
+// and survive, and the check of the start states needs no pair outside of it,
+// i.e. it is a bisimulation that relates the start states.
+
+// The result of re-checking a saved relation.
+type verifyReport struct {
+	res  ResultType
+	warm warmStartReport
+	// The seeded pairs that did not survive the check.
+	broken []savedPair
+	// The pairs that the check needed which were not in the relation.
+	newPairs int
+}
+
+func (r verifyReport) verified() bool {
+	return r.res == ResultRelated && r.warm.Ignored == "" && len(r.warm.Unmapped) == 0 &&
+		len(r.broken) == 0 && r.newPairs == 0
+}
+
+// Seed a check of the start states of two LTSs with a saved relation, as with
+// -warm-start, and run it with the N of the relation. A checkpoint is not a
+// relation that can be verified, and neither is one saved with -gc, as in
+// seedRelation.
+func verifyRelation(leftLts pifra.Lts, rightLts pifra.Lts, weakLeftLts pifra.Lts, weakRightLts pifra.Lts,
+	rel savedRelation) (verifyReport, error) {
+	if rel.Checkpoint {
+		return verifyReport{}, fmt.Errorf("the relation is a checkpoint, whose pairs were not all verified, resume the check with -warm-start")
+	}
+	if strings.HasSuffix(rel.Mode, "+gc") {
+		return verifyReport{}, fmt.Errorf("the relation was saved with -gc, which a warm start does not support")
+	}
+	if n := maxInt(getMaxMinRegSize(leftLts), getMaxMinRegSize(rightLts)); rel.N < n {
+		return verifyReport{}, fmt.Errorf("the relation was saved for N=%d, but the LTSs need N=%d", rel.N, n)
+	}
+	rho, err := freeNamesRho(leftLts, rightLts, 0, 0)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	resetBisim()
+	setRegSize(rel.N)
+	state := NewCleavelandState(leftLts, rightLts, weakLeftLts, weakRightLts)
+	nP, nQ, err := state.addStartPair(0, 0, rho)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	warm, err := seedRelation(state, rel)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	res, err := preorder(state, nP, nQ)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	return verifyReport{res: res, warm: warm, broken: warm.broken(state), newPairs: IC.newPairs}, nil
+}
+
+func printVerifyReport(report verifyReport, total int) {
+	if report.verified() {
+		fmt.Printf("The relation is verified: its %s relate the start states and are a bisimulation.\n",
+			plural(total, "pair"))
+		return
+	}
+	fmt.Printf("The relation is NOT verified:\n")
+	if report.warm.Ignored != "" {
+		fmt.Printf("\tit was ignored, as %s.\n", report.warm.Ignored)
+		return
+	}
+	if report.res != ResultRelated {
+		fmt.Printf("\tthe start states are not bisimilar.\n")
+	}
+	if n := len(report.warm.Unmapped); n > 0 {
+		fmt.Printf("\t%d of %d pairs are not in the LTSs.\n", n, total)
+	}
+	if n := len(report.broken); n > 0 {
+		fmt.Printf("\t%d of %d pairs broke.\n", n, total)
+	}
+	if report.newPairs > 0 {
+		fmt.Printf("\tthe check needed %s that are not in it.\n", plural(report.newPairs, "pair"))
+	}
+	printSavedPairs("Not in the LTSs", report.warm.Unmapped)
+	printSavedPairs("Broken", report.broken)
+}
+
+// The verify command.
+func verifyCommand(args []string) {
+	fs := newCommandFlagSet("verify", "[flags] relation model1 model2",
+		"Re-check a relation saved with -save-relation against two models or LTS files, as -warm-start does.\n"+
+			"The relation is verified if it is a bisimulation that relates their start states, in the mode and for\n"+
+			"the N that it was saved for. The exit status is 0 if it is verified, and 1 otherwise.")
+	verboseFlag := fs.Bool("v", false, "Whether to be verbose.")
+	pf := addPifraFlags(fs)
+	args = parseCommandFlags(fs, args)
+	if len(args) != 3 {
+		usageError(fs, fmt.Errorf("expected a relation and two models or LTS files as arguments, not %d", len(args)))
+	}
+	if err := pf.validate(); err != nil {
+		usageError(fs, err)
+	}
+	if *pf.gc {
+		usageError(fs, fmt.Errorf("-gc cannot be given, as a warm start is not supported with -gc"))
+	}
+	verbose = *verboseFlag
+
+	rel, err := readSavedRelation(args[0])
+	check(err)
+	dir, err := ioutil.TempDir("", "pisim22-verify")
+	check(err)
+	defer os.RemoveAll(dir)
+	var lts, weakLts [2]pifra.Lts
+	for i, name := range args[1:] {
+		lts[i], _, err = loadOrGenerateLts(name, dir, pf.flags())
+		check(err)
+		weakLts[i] = lts[i]
+		if strings.HasPrefix(rel.Mode, "weak") {
+			weakLts[i] = doWeakTransform(lts[i])
+		}
+	}
+	report, err := verifyRelation(lts[0], lts[1], weakLts[0], weakLts[1], rel)
+	check(err)
+	printVerifyReport(report, len(rel.Pairs))
+	// The exit status tells whether the relation was verified, for scripts.
+	if !report.verified() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// A relation is verified against the models it was saved for, and not against
+// a model that was edited or one that needs more pairs.
+func TestVerifyRelation(t *testing.T) {
+	const src = "P(a) = a'<a>.a'<a>.P(a)\nP(a)\n"
+	cases := []struct {
+		right    string
+		verified bool
+		broken   int
+		newPairs int
+	}{
+		{"P(a) = a'<a>.P(a)\nP(a)\n", true, 0, 0},
+		{"P(a) = a'<a>.0\nP(a)\n", false, 2, 2},
+		// The relation is not closed between the model and itself.
+		{src, false, 0, 2},
+	}
+	left := analyzeTestLts(t, src, 100)
+	right := analyzeTestLts(t, cases[0].right, 100)
+	rho, err := freeNamesRho(left, right, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _, _, err := preorderPair(left, right, left, right, 0, 0, rho, -1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := getSavedRelation(state)
+
+	for _, c := range cases {
+		lts := analyzeTestLts(t, c.right, 100)
+		report, err := verifyRelation(left, lts, left, lts, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.verified() != c.verified || len(report.broken) != c.broken || report.newPairs != c.newPairs {
+			t.Errorf("The relation against %q was verified %t, with %d pairs broken and %d new, expected %t, %d and %d.",
+				c.right, report.verified(), len(report.broken), report.newPairs, c.verified, c.broken, c.newPairs)
+		}
+	}
+
+	checkpoint := rel
+	checkpoint.Checkpoint = true
+	gc := rel
+	gc.Mode += "+gc"
+	for _, c := range []struct {
+		name string
+		rel  savedRelation
+		err  string
+	}{{"a checkpoint", checkpoint, "checkpoint"}, {"saved with -gc", gc, "-gc"}} {
+		if _, err := verifyRelation(left, right, left, right, c.rel); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("The error of a relation %s is %v.", c.name, err)
+		}
+	}
+}
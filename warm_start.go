@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with the code to save a relation and to warm-start a check
+// from it after a model has been edited.
+//
+// The states of a saved relation are identified by pifraStateKey, so that they
+// can be found again in a newly generated LTS even if the state ids change.
+// With -gc, pifra drops the names that are no longer used from the registers,
+// so the keys depend on which names were collected, and a state of an edited
+// model need not be found again. A warm start is not supported with -gc.
+
+const savedRelationVersion = 1
+
+type savedPair struct {
+	Left  string
+	Right string
+	// Rho of the left configuration.
+	Rho map[int]int
+}
+
+type savedRelation struct {
+	Version int
+	Mode    string
+	N       int
+	// Whether the relation was written by -checkpoint while the search was
+	// running. Its pairs are only assumptions, so it is no proof that the
+	// systems are bisimilar, and can only be used to resume the check.
+	Checkpoint bool `json:",omitempty"`
+	Pairs      []savedPair
+}
+
+// The result of seeding a check with an old relation.
+type warmStartReport struct {
+	// Why the old relation was not used at all, if it was not.
+	Ignored string
+	// Whether the old relation is a checkpoint.
+	Checkpoint bool
+	// Pairs of the old relation whose states are gone from the new LTSs.
+	Unmapped []savedPair
+	// Pairs that were seeded, and the keys of their G vertices.
+	Seeded     []savedPair
+	SeededKeys []string
+}
+
+// The mode of the check of the state, as in cacheMode. The check is weak if the
+// weak LTSs differ from the strong ones, as in bisimCacheKey, so that the mode
+// is right even when checkBisim is called directly.
+func relationMode(state *CleavelandState) string {
+	mode := "strong"
+	if len(state.WeakLeftLts.Transitions) != len(state.LeftLts.Transitions) ||
+		len(state.WeakRightLts.Transitions) != len(state.RightLts.Transitions) {
+		mode = "weak"
+	}
+	if state.GC {
+		mode += "+gc"
+	}
+	return mode
+}
+
+func getSavedRelation(state *CleavelandState) savedRelation {
+	rel := savedRelation{
+		Version: savedRelationVersion,
+		Mode:    relationMode(state),
+		N:       getRegSize(),
+	}
+	for _, v := range state.G.States {
+		lconf := pifra.Configuration{Process: v.A.Process, Registers: v.A.Registers}
+		rconf := pifra.Configuration{Process: v.B.Process, Registers: v.B.Registers}
+		rel.Pairs = append(rel.Pairs, savedPair{
+			Left:  pifraStateKey(&lconf),
+			Right: pifraStateKey(&rconf),
+			Rho:   v.A.Rho,
+		})
+	}
+	sort.Slice(rel.Pairs, func(i, j int) bool {
+		a, b := rel.Pairs[i], rel.Pairs[j]
+		if a.Left != b.Left {
+			return a.Left < b.Left
+		}
+		if a.Right != b.Right {
+			return a.Right < b.Right
+		}
+		return fmt.Sprint(a.Rho) < fmt.Sprint(b.Rho)
+	})
+	return rel
+}
+
+func writeSavedRelation(name string, rel savedRelation) error {
+	data, err := json.MarshalIndent(rel, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(name, data)
+}
+
+func readSavedRelation(name string) (rel savedRelation, err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &rel)
+	if err == nil && rel.Version != savedRelationVersion {
+		err = fmt.Errorf("relation in %s has version %d, expected %d",
+			name, rel.Version, savedRelationVersion)
+	}
+	return
+}
+
+// A map from pifraStateKey to the id of the state in the LTS.
+func stateIdsByKey(lts pifra.Lts) map[string]int {
+	res := make(map[string]int, len(lts.States))
+	for id := range lts.States {
+		conf := lts.States[id]
+		res[pifraStateKey(&conf)] = id
+	}
+	return res
+}
+
+// Seed G with the pairs of an old relation that still exist in the new LTSs,
+// and then re-verify each of them.
+//
+// A seeded pair is only an assumption, just like a pair that is on the stack
+// of preorder. Re-verifying it matches every transition of both sides once,
+// which mostly finds the successors already in G. When a pair fails, it is
+// moved to notR and the pairs that relied on it are re-examined through A, as
+// usual. So only the pairs around an edit end up being searched again.
+//
+// A relation saved in another mode or for another N relates configurations
+// that do not occur in this check, so it is ignored.
+//
+// The re-verification pauses like the search, so it reports its progress, it
+// writes the checkpoints, and it returns errSearchStopped when it is stopped.
+func seedRelation(state *CleavelandState, rel savedRelation) (warmStartReport, error) {
+	report := warmStartReport{Checkpoint: rel.Checkpoint}
+	if mode := relationMode(state); rel.Mode != mode || rel.N != getRegSize() {
+		report.Ignored = fmt.Sprintf("it was saved for %s with N=%d, not for %s with N=%d",
+			rel.Mode, rel.N, mode, getRegSize())
+		return report, nil
+	}
+	leftIds := stateIdsByKey(state.LeftLts)
+	rightIds := stateIdsByKey(state.RightLts)
+
+	var seeded []gVertex
+	for _, pair := range rel.Pairs {
+		lid, ok1 := leftIds[pair.Left]
+		rid, ok2 := rightIds[pair.Right]
+		revRho, err := reverseMap(pair.Rho)
+		if !ok1 || !ok2 || err != nil {
+			report.Unmapped = append(report.Unmapped, pair)
+			continue
+		}
+		lconf := state.LeftLts.States[lid]
+		rconf := state.RightLts.States[rid]
+		nP := FRAConfiguration{
+			Process:   lconf.Process,
+			Registers: lconf.Registers,
+			Rho:       pair.Rho,
+			N:         getRegSize(),
+		}
+		nQ := FRAConfiguration{
+			Process:   rconf.Process,
+			Registers: rconf.Registers,
+			Rho:       revRho,
+			N:         getRegSize(),
+		}
+		state.canonicalisePair(&nP, &nQ, true)
+		state.addNPState(nP, lid)
+		state.addNQState(nQ, rid)
+		vertex := gVertex{nP, nQ}
+		key := gVertexToString(&vertex)
+		if _, ok := state.G.States[key]; ok {
+			continue
+		}
+		state.G.States[key] = vertex
+		seeded = append(seeded, vertex)
+		report.Seeded = append(report.Seeded, pair)
+		report.SeededKeys = append(report.SeededKeys, key)
+	}
+
+	for i := range seeded {
+		if _, ok := state.G.States[report.SeededKeys[i]]; !ok {
+			// Already refuted through another pair.
+			continue
+		}
+		s := newBisimStack(state)
+		s.pause = searchPause(s)
+		f := newPreorderFrame(seeded[i].A, seeded[i].B)
+		f.reverify = true
+		s.push(f)
+		if !s.run() {
+			return report, errSearchStopped
+		}
+	}
+	return report, nil
+}
+
+// A pause function of the work stack that writes the relation found so far to
+// the checkpoint file every checkpointInterval, and once more when the search
+// is stopped, which it then pauses. Any pair of the relation is only an
+// assumption of a warm start, so the check can be resumed from the file.
+func checkpointer(s *bisimStack) func() bool {
+	last := time.Now()
+	return func() bool {
+		stop := isSearchStopped()
+		if !stop && (s.steps%progressSteps != 0 || time.Since(last) < checkpointInterval) {
+			return false
+		}
+		last = time.Now()
+		rel := getSavedRelation(s.state)
+		rel.Checkpoint = true
+		if err := writeSavedRelation(getCheckpointName(), rel); err != nil {
+			fmt.Printf("Could not write the checkpoint: %s.\n", err.Error())
+		}
+		return stop
+	}
+}
+
+// The seeded pairs that did not survive the check.
+func (r *warmStartReport) broken(state *CleavelandState) []savedPair {
+	var res []savedPair
+	for i, key := range r.SeededKeys {
+		if _, ok := state.G.States[key]; !ok {
+			res = append(res, r.Seeded[i])
+		}
+	}
+	return res
+}
+
+func printWarmStartReport(state *CleavelandState, report warmStartReport, total int) {
+	if report.Ignored != "" {
+		fmt.Printf("Warm start: the old relation was ignored, as %s.\n", report.Ignored)
+		return
+	}
+	if report.Checkpoint {
+		fmt.Printf("Warm start: resuming from a checkpoint, whose pairs were not all verified.\n")
+	}
+	broken := report.broken(state)
+	fmt.Printf("Warm start: %d of %d pairs of the old relation were found in the new LTSs, %d of them broke.\n",
+		len(report.Seeded), total, len(broken))
+	printSavedPairs("No longer in the LTSs", report.Unmapped)
+	printSavedPairs("Broken", broken)
+}
+
+func printSavedPairs(title string, pairs []savedPair) {
+	const limit = 10
+	if len(pairs) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", title)
+	for i, pair := range pairs {
+		if i == limit && !isVerbose() {
+			fmt.Printf("\t... and %d more (use -v to see all).\n", len(pairs)-limit)
+			break
+		}
+		fmt.Printf("\t%s <---> %s, rho=%s\n", pair.Left, pair.Right, fmt.Sprint(pair.Rho))
+	}
+}
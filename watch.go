@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yungene/pifra"
+)
+
+// This is a file with -watch of check, which polls the two models of a check
+// and re-runs it whenever one of them changes. Only the LTS of a model that
+// changed is generated again, and the verdict and the statistics of each run
+// are compared with the ones of the previous run.
+
+// The statistics of a run of a check.
+type watchRun struct {
+	res                 ResultType
+	n                   int
+	states, transitions [2]int
+	// The sizes of the weak transforms, with -w.
+	weakStates, weakTransitions [2]int
+	// The pairs of states the preorder was entered for.
+	pairs int
+	time  time.Duration
+	// Whether the check was a weak one.
+	weak bool
+}
+
+type watchedFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+	hash    [sha256.Size]byte
+	// The error of the last poll, if the file could not be read.
+	err string
+}
+
+// Whether the content of the file changed since it was last seen. A file that
+// cannot be read is taken as unchanged, as editors may replace it on saving,
+// but a line is printed when it goes missing and when it is back.
+func (f *watchedFile) changed() bool {
+	info, err := os.Stat(f.name)
+	if err != nil {
+		f.failed(err)
+		return false
+	}
+	if info.Size() == f.size && info.ModTime().Equal(f.modTime) {
+		f.failed(nil)
+		return false
+	}
+	data, err := ioutil.ReadFile(f.name)
+	if err != nil {
+		f.failed(err)
+		return false
+	}
+	f.failed(nil)
+	f.size, f.modTime = info.Size(), info.ModTime()
+	hash := sha256.Sum256(data)
+	if hash == f.hash {
+		return false
+	}
+	f.hash = hash
+	return true
+}
+
+// Record the error of a poll, and print it if it is new.
+func (f *watchedFile) failed(err error) {
+	msg := ""
+	if os.IsNotExist(err) {
+		msg = "disappeared"
+	} else if err != nil {
+		msg = err.Error()
+	}
+	if msg == f.err {
+		return
+	}
+	if msg != "" {
+		fmt.Printf("%s %s, waiting for it.\n", f.name, msg)
+	} else if f.err != "" {
+		fmt.Printf("%s is back.\n", f.name)
+	}
+	f.err = msg
+}
+
+type checkWatcher struct {
+	files           [2]watchedFile
+	load            func(isLeft bool) (pifra.Lts, error)
+	regSizeOverride int
+	// Whether the check is a weak one, as given by -w when the watch started.
+	weakMode  bool
+	lts, weak [2]pifra.Lts
+	// Whether the LTS of a side is loaded, i.e. the last change of its model
+	// could be generated.
+	loaded [2]bool
+	last   *watchRun
+}
+
+func newCheckWatcher(names [2]string, load func(isLeft bool) (pifra.Lts, error), regSizeOverride int) *checkWatcher {
+	w := &checkWatcher{load: load, regSizeOverride: regSizeOverride, weakMode: isWeakBisim()}
+	for i, name := range names {
+		w.files[i].name = name
+	}
+	return w
+}
+
+// Load the LTS of a side, and its weak transform with -w.
+func (w *checkWatcher) reload(i int) error {
+	lts, err := w.load(i == 0)
+	if err != nil {
+		w.loaded[i] = false
+		return err
+	}
+	w.lts[i], w.weak[i], w.loaded[i] = lts, lts, true
+	if w.weakMode {
+		w.weak[i] = doWeakTransform(lts)
+	}
+	return nil
+}
+
+// Run the check on the loaded LTSs. The register size and the counters are
+// reset here rather than left to the check, so that a run never reports the
+// statistics of a previous one, e.g. when its verdict is taken from the cache.
+func (w *checkWatcher) run() watchRun {
+	n := w.regSizeOverride
+	if n <= 0 {
+		n = maxInt(getMaxMinRegSize(w.lts[0]), getMaxMinRegSize(w.lts[1]))
+	}
+	resetBisim()
+	start := time.Now()
+	res := checkBisim(w.lts[0], w.lts[1], w.weak[0], w.weak[1], n, -1, false)
+	run := watchRun{res: res, n: n, pairs: IC.enterToPreorder, time: time.Since(start), weak: w.weakMode}
+	for i := range w.lts {
+		run.states[i], run.transitions[i] = len(w.lts[i].States), len(w.lts[i].Transitions)
+		run.weakStates[i], run.weakTransitions[i] = len(w.weak[i].States), len(w.weak[i].Transitions)
+	}
+	return run
+}
+
+// Load the models that changed, and re-run the check if both can be loaded.
+// The first run prints its verdict as check does, and the later ones print
+// their differences to the previous run. Returns whether the check was run.
+func (w *checkWatcher) step() bool {
+	var changed []string
+	for i := range w.files {
+		if !w.files[i].changed() {
+			continue
+		}
+		changed = append(changed, w.files[i].name)
+		if err := w.reload(i); err != nil {
+			fmt.Printf("%s: %s\n", w.files[i].name, err.Error())
+		}
+	}
+	if len(changed) == 0 {
+		return false
+	}
+	if !w.loaded[0] || !w.loaded[1] {
+		fmt.Printf("Waiting for a change.\n")
+		return false
+	}
+	if w.last == nil {
+		run := w.run()
+		w.last = &run
+		return true
+	}
+	fmt.Printf("\n[%s] %s changed.\n", time.Now().Format("15:04:05"), strings.Join(changed, " and "))
+	prevQuiet := quiet
+	quiet = true
+	run := w.run()
+	quiet = prevQuiet
+	for _, line := range diffWatchRuns(*w.last, run) {
+		fmt.Printf("  %s\n", line)
+	}
+	w.last = &run
+	return true
+}
+
+// Check the models, and then poll them every interval until stop is closed.
+func (w *checkWatcher) watch(interval time.Duration, stop <-chan struct{}) {
+	w.step()
+	fmt.Printf("Watching %s and %s for changes.\n", w.files[0].name, w.files[1].name)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.step()
+		}
+	}
+}
+
+func verdictString(res ResultType) string {
+	if res == ResultRelated {
+		return verdictBisimilar
+	}
+	return verdictNotBisimilar
+}
+
+// The differences between two runs, one per line. The verdict and the time of
+// the check are always given, and the other statistics only if they changed.
+func diffWatchRuns(prev watchRun, cur watchRun) []string {
+	lines := []string{}
+	if prev.res != cur.res {
+		lines = append(lines, fmt.Sprintf("verdict: %s -> %s", verdictString(prev.res), verdictString(cur.res)))
+	} else {
+		lines = append(lines, fmt.Sprintf("verdict: %s (unchanged)", verdictString(cur.res)))
+	}
+	diff := func(name string, a int, b int) {
+		if a != b {
+			lines = append(lines, fmt.Sprintf("%s: %d -> %d (%+d)", name, a, b, b-a))
+		}
+	}
+	diff("N", prev.n, cur.n)
+	for i := range cur.states {
+		name := fmt.Sprintf("lts%d", i+1)
+		diff(name+" states", prev.states[i], cur.states[i])
+		diff(name+" transitions", prev.transitions[i], cur.transitions[i])
+		if cur.weak {
+			diff("weak "+name+" states", prev.weakStates[i], cur.weakStates[i])
+			diff("weak "+name+" transitions", prev.weakTransitions[i], cur.weakTransitions[i])
+		}
+	}
+	diff("pairs", prev.pairs, cur.pairs)
+	return append(lines, fmt.Sprintf("check time: %s -> %s",
+		prev.time.Round(time.Microsecond), cur.time.Round(time.Microsecond)))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yungene/pifra"
+)
+
+// The watcher re-runs the check only when the content of a model changes, and
+// waits while a model cannot be generated.
+func TestCheckWatcher(t *testing.T) {
+	pwd := getPwd(t)
+	dir := t.TempDir()
+	names := [2]string{filepath.Join(dir, "a.pi"), filepath.Join(dir, "b.pi")}
+	write := func(name string, src string) {
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, name := range names {
+		data, err := ioutil.ReadFile(path.Join(pwd, "test", "bisimilar", "jev-a1."+[]string{"1", "2"}[i]+".pi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(name, string(data))
+	}
+	loads := 0
+	load := func(isLeft bool) (pifra.Lts, error) {
+		loads++
+		lts, _, err := loadOrGenerateLts(names[systemNumber(isLeft)-1], filepath.Join(dir, systemName(isLeft)), flags)
+		return lts, err
+	}
+	prevUseCache, prevQuiet := useCache, quiet
+	defer func() { useCache, quiet = prevUseCache, prevQuiet }()
+	useCache, quiet = false, true
+
+	w := newCheckWatcher(names, load, -1)
+	if !w.step() || w.last.res != ResultRelated || loads != 2 {
+		t.Fatalf("The first run is %v after %d loads, expected a related run after 2.", w.last, loads)
+	}
+	if w.step() {
+		t.Errorf("The check was re-run without a change.")
+	}
+	// A new modification time without a new content is not a change.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(names[0], later, later); err != nil {
+		t.Fatal(err)
+	}
+	if w.step() {
+		t.Errorf("The check was re-run after a touch.")
+	}
+
+	first := *w.last
+	write(names[1], "P = b(x).a(x).0 + b(y).0\nP\n")
+	if !w.step() || w.last.res != ResultNotRelated || loads != 3 {
+		t.Fatalf("The run after a change is %v after %d loads, expected an unrelated run after 3.", w.last, loads)
+	}
+	lines := diffWatchRuns(first, *w.last)
+	if lines[0] != "verdict: bisimilar -> not-bisimilar" || !strings.HasPrefix(lines[len(lines)-1], "check time: ") {
+		t.Errorf("The differences of the runs are %v.", lines)
+	}
+	if lines := diffWatchRuns(first, first); len(lines) != 2 || lines[0] != "verdict: bisimilar (unchanged)" {
+		t.Errorf("The differences of a run to itself are %v.", lines)
+	}
+
+	write(names[0], "P = b(x).a(x).\n")
+	if w.step() || w.loaded[0] {
+		t.Errorf("The check was run with a model that cannot be generated.")
+	}
+	write(names[0], "P = b(x).a(x).0 + b(y).0\nP\n")
+	if !w.step() || w.last.res != ResultRelated {
+		t.Errorf("The run after fixing the model is %v, expected a related run.", w.last)
+	}
+
+	// A model that disappears is reported once, and is unchanged until it is
+	// back.
+	if err := os.Rename(names[1], names[1]+".bak"); err != nil {
+		t.Fatal(err)
+	}
+	if w.step() || w.files[1].err != "disappeared" {
+		t.Errorf("The missing model was recorded as %q.", w.files[1].err)
+	}
+	if err := os.Rename(names[1]+".bak", names[1]); err != nil {
+		t.Fatal(err)
+	}
+	if w.step() || w.files[1].err != "" {
+		t.Errorf("The model that is back was recorded as %q.", w.files[1].err)
+	}
+}